@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"secure-email-mvp/pkg/auth"
+	"secure-email-mvp/pkg/config"
+	"secure-email-mvp/pkg/health"
+	"secure-email-mvp/pkg/migrate"
+)
+
+// readinessChecks are what /readyz and the startup self-check verify: the
+// database answers, its schema matches this binary, and tokens can be signed
+func readinessChecks(db *sql.DB, migrations migrate.Source, keyring *auth.Keyring) []health.Check {
+	return []health.Check{
+		{Name: "database", Run: func(ctx context.Context) (string, error) {
+			return "", db.PingContext(ctx)
+		}},
+		{Name: "migrations", Run: func(ctx context.Context) (string, error) {
+			if err := migrations.Check(db); err != nil {
+				return "", err
+			}
+			status, err := migrations.Status(db)
+			if err != nil || len(status) == 0 {
+				return "", err
+			}
+			return fmt.Sprintf("schema version %d", status[len(status)-1].Version), nil
+		}},
+		{Name: "signing_keys", Run: func(ctx context.Context) (string, error) {
+			active := keyring.Active()
+			if active == nil {
+				return "", errors.New("no active signing key")
+			}
+			return fmt.Sprintf("active key %s, %d keys", active.ID, len(keyring.Keys())), nil
+		}},
+	}
+}
+
+// selfCheck logs every readiness check once before the API starts serving
+// and reports whether all of them passed
+func selfCheck(checker *health.Checker) bool {
+	report := checker.Run(context.Background())
+	for _, r := range report.Checks {
+		switch {
+		case r.OK && r.Detail != "":
+			slog.Info("Startup check passed", "check", r.Name, "detail", r.Detail)
+		case r.OK:
+			slog.Info("Startup check passed", "check", r.Name)
+		default:
+			slog.Error("Startup check failed", "check", r.Name, "error", r.Error)
+		}
+	}
+	return report.Ready()
+}
+
+// newServer applies the configured timeouts so slow clients cannot hold
+// connections open indefinitely
+func newServer(addr string, cfg config.ServerConfig, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+}
+
+// shutdown stops srv accepting connections and waits up to timeout for
+// in-flight requests, then closes whatever is left
+func shutdown(srv *http.Server, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Warn("Connections did not drain in time", "addr", srv.Addr, "error", err)
+		srv.Close()
+	}
+}
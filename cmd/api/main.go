@@ -1,129 +1,411 @@
 package main
 
 import (
-	"database/sql"
+	"context"
+	"crypto/tls"
 	"encoding/json"
-	"log"
+	"errors"
+	"log/slog"
+	"math"
 	"net/http"
 	"os"
-	"sync"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
+	"secure-email-mvp/pkg/audit"
 	"secure-email-mvp/pkg/auth"
+	"secure-email-mvp/pkg/auth/webauthn"
+	"secure-email-mvp/pkg/config"
+	"secure-email-mvp/pkg/health"
+	"secure-email-mvp/pkg/logging"
+	"secure-email-mvp/pkg/metrics"
+	"secure-email-mvp/pkg/ratelimit"
+	"secure-email-mvp/pkg/requestinfo"
+	"secure-email-mvp/pkg/store/driver"
 
 	"github.com/gorilla/mux"
-	"github.com/joho/godotenv"
-	_ "github.com/mattn/go-sqlite3"
 	"github.com/rs/cors"
 )
 
 type Server struct {
-	db         *sql.DB
-	rateLimits *sync.Map // IP -> attempt count
+	cfg      *config.Config
+	accounts *auth.Service
+	passkeys *webauthn.Service
+}
+
+// fatal logs msg with args at error level and exits
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
 
 func main() {
-	// Load .env
-	if err := godotenv.Load(); err != nil {
-		log.Fatal("Error loading .env:", err)
+	// Load and validate configuration from defaults, CONFIG_FILE, .env and the environment
+	cfg, err := config.Load("")
+	if err != nil {
+		fatal("Error loading configuration", "error", err)
+	}
+
+	// Structured JSON logging; the standard log package writes through it too
+	level, _ := logging.ParseLevel(cfg.Log.Level)
+	logOpts := logging.Options{
+		File:       cfg.Log.File,
+		Level:      level,
+		MaxSizeMB:  cfg.Log.MaxSizeMB,
+		MaxBackups: cfg.Log.MaxBackups,
+		Redact:     cfg.Log.Redact,
+		HashKey:    []byte(cfg.Log.HashKey),
 	}
+	logOut, err := logging.Open(logOpts)
+	if err != nil {
+		fatal("Error opening log", "error", err)
+	}
+	defer logOut.Close()
+	logger, err := logging.New(logOut, logOpts)
+	if err != nil {
+		fatal("Error configuring log", "error", err)
+	}
+	slog.SetDefault(logger)
 
-	// Connect to SQLite
-	dbPath := os.Getenv("SQLITE_DB")
-	if dbPath == "" {
-		dbPath = "/var/db/secure-email.db"
+	// Connect to SQLite or PostgreSQL, as configured
+	drv, err := driver.Lookup(cfg.Database.Driver)
+	if err != nil {
+		fatal("Error opening database", "error", err)
 	}
-	db, err := sql.Open("sqlite3", dbPath)
+	db, err := drv.Open(cfg.Database.Source())
 	if err != nil {
-		log.Fatal("Error opening database:", err)
+		fatal("Error connecting to database", "driver", drv.Name, "error", err)
 	}
 	defer db.Close()
 
-	// Test database connection
-	if err := db.Ping(); err != nil {
-		log.Fatal("Error connecting to database:", err)
+	// Apply pending migrations; refuses to start if the database is ahead of this binary
+	applied, err := drv.Migrations.Up(db)
+	if err != nil {
+		fatal("Error applying migrations", "error", err)
+	}
+	if applied > 0 {
+		slog.Info("Applied migrations", "driver", drv.Name, "count", applied)
+	}
+
+	// Load JWT signing keys and rotate them on schedule
+	keyring, err := auth.LoadKeyring(cfg.JWT.KeyDir)
+	if err != nil {
+		fatal("Error loading JWT signing keys", "error", err)
+	}
+	stopRotation := keyring.StartRotation(cfg.JWT.Rotation)
+	defer stopRotation()
+
+	// The audit log's HMAC key lives outside the database
+	auditKey, err := audit.LoadKey(cfg.Audit.KeyFile)
+	if err != nil {
+		fatal("Error loading audit key", "error", err)
+	}
+
+	// Handlers share the keyring, audit log, TOTP skew, login lockout and the
+	// Argon2 worker pool
+	accounts := auth.NewService(db, drv.Dialect, keyring, auditKey)
+	accounts.TOTPSkew = cfg.TOTP.Skew
+	accounts.Lockout.LockAfter = cfg.Lockout.After
+	accounts.Lockout.LockDuration = cfg.Lockout.Duration
+	accounts.Hash = auth.NewHashPool(cfg.Hash.Workers, cfg.Hash.Queue)
+
+	// Host the configured domain; once it exists the admin API owns its settings
+	created, err := accounts.EnsureDomain(&auth.Domain{Name: cfg.Accounts.Domain, SignUp: auth.SignUpOpen, MaxUsers: cfg.Accounts.MaxUsers})
+	if err != nil {
+		fatal("Error creating mail domain", "error", err)
+	}
+	if created {
+		slog.Info("Hosting mail domain", "domain", cfg.Accounts.Domain)
+	}
+
+	// Bootstrap administrators; roles are managed with /api/admin afterwards
+	if promoted, err := accounts.PromoteAdmins(cfg.Accounts.Admins); err != nil {
+		fatal("Error promoting administrators", "error", err)
+	} else if promoted > 0 {
+		slog.Info("Promoted users to admin", "count", promoted)
+	}
+
+	passkeys, err := webauthn.New(accounts, webauthn.Config{RPID: cfg.WebAuthn.RPID, RPDisplayName: "SecureEmail", RPOrigins: cfg.WebAuthn.RPOrigins})
+	if err != nil {
+		fatal("Error configuring passkeys", "error", err)
+	}
+
+	// Rate limit buckets live in memory or the database; forwarding headers
+	// are only believed from trusted proxies
+	proxies, err := ratelimit.ParseTrustedProxies(cfg.RateLimit.TrustedProxies)
+	if err != nil {
+		fatal("Invalid trusted proxies", "error", err)
+	}
+	var limits ratelimit.Store = ratelimit.NewMemoryStore()
+	if cfg.RateLimit.Store != "memory" {
+		limits = ratelimit.NewSQLStore(db, drv.Dialect.Rebind)
+	}
+	stopJanitor := ratelimit.StartJanitor(limits, time.Minute)
+	defer stopJanitor()
+
+	byIP := ratelimit.ByIP(proxies)
+	clientIP := func(r *http.Request) string {
+		ip, _ := byIP(r)
+		return ip
+	}
+	accounts.ClientIP = clientIP
+	ipLimit := ratelimit.Middleware(limits, ratelimit.Policy{Name: "ip", Limit: 300, Per: time.Minute}, byIP)
+	// Each unauthenticated auth endpoint has its own per-IP buckets, so a burst
+	// of refreshes cannot lock a client out of login
+	authLimit := func(name string, p config.RatePolicy, authActions map[string]string) func(http.Handler) http.Handler {
+		return ratelimit.Middleware(limits, ratelimit.Policy{Name: name, Limit: p.Requests, Per: p.Window, AuthActions: authActions}, byIP)
 	}
+	loginLimit := authLimit("login", cfg.RateLimit.Login, map[string]string{"/api/auth/login": audit.ActionLogin})
+	signUpLimit := authLimit("signup", cfg.RateLimit.SignUp, nil)
+	verifyTOTPLimit := authLimit("verify_totp", cfg.RateLimit.VerifyTOTP, nil)
+	refreshLimit := authLimit("refresh", cfg.RateLimit.Refresh, nil)
+	passkeyLimit := authLimit("passkey", cfg.RateLimit.Passkey, map[string]string{"/api/auth/passkey/begin": audit.ActionLoginPasskey})
+	accountLimit := ratelimit.Middleware(limits, ratelimit.Policy{Name: "account", Limit: 120, Per: time.Minute}, byAccount)
 
 	// Initialize server
-	srv := &Server{db: db, rateLimits: &sync.Map{}}
+	srv := &Server{cfg: cfg, accounts: accounts, passkeys: passkeys}
 
-	// Apply schema
-	schema, err := os.ReadFile("schema/users.sql")
+	// Pending sign-ups live in temp_totp; sweep expired ones periodically
+	repos := accounts.Store
+	stopSweeper := auth.StartPendingSweeper(repos.Pending, time.Minute)
+	defer stopSweeper()
+	stopSessionSweeper := auth.StartSessionSweeper(accounts, time.Hour)
+	defer stopSessionSweeper()
+	stopRetention, err := accounts.Audit.StartRetention(cfg.Audit.Retention, time.Hour)
 	if err != nil {
-		log.Fatal("Error reading schema:", err)
+		fatal("Error setting audit retention", "error", err)
 	}
-	if _, err := db.Exec(string(schema)); err != nil {
-		log.Fatal("Error applying schema:", err)
+	defer stopRetention()
+
+	// Prometheus metrics on the private admin listener
+	if err := registerMetrics(db, drv.Name, accounts.Hash, repos.Pending); err != nil {
+		fatal("Error registering metrics", "error", err)
+	}
+
+	// Readiness: database reachable, schema current, signing key loaded
+	checker := health.New(2*time.Second, readinessChecks(db, drv.Migrations, keyring)...)
+	if !selfCheck(checker) {
+		fatal("Startup self-check failed")
 	}
 
 	// Set up router
 	r := mux.NewRouter()
-	r.HandleFunc("/api/auth/login", srv.loginHandler).Methods("POST")
-	r.HandleFunc("/api/auth/signup", auth.SignUpHandler(db)).Methods("POST")
-	r.HandleFunc("/api/auth/verify-totp", auth.VerifyTotpHandler(db)).Methods("POST")
+	r.HandleFunc("/.well-known/jwks.json", auth.JWKSHandler(keyring)).Methods("GET")
+	r.Handle("/api/auth/login", loginLimit(http.HandlerFunc(srv.loginHandler))).Methods("POST")
+	r.Handle("/api/auth/signup", signUpLimit(auth.SignUpHandler(accounts, repos.Pending))).Methods("POST")
+	r.Handle("/api/auth/verify-totp", verifyTOTPLimit(auth.VerifyTotpHandler(accounts, repos.Pending))).Methods("POST")
+	r.Handle("/api/auth/refresh", refreshLimit(auth.RefreshHandler(accounts))).Methods("POST")
+	r.Handle("/api/auth/passkey/begin", passkeyLimit(webauthn.BeginLoginHandler(passkeys))).Methods("POST")
+
+	// Routes below require a valid bearer token
+	protected := r.PathPrefix("/api").Subrouter()
+	protected.Use(auth.RequireAuth(accounts))
+	protected.Use(accountLimit)
+	protected.HandleFunc("/auth/me", auth.MeHandler).Methods("GET")
+	protected.HandleFunc("/auth/logout", auth.LogoutHandler(accounts)).Methods("POST")
+	protected.HandleFunc("/sessions", auth.ListSessionsHandler(accounts)).Methods("GET")
+	protected.HandleFunc("/sessions/revoke-others", auth.RevokeOtherSessionsHandler(accounts)).Methods("POST")
+	protected.HandleFunc("/sessions/{id}", auth.RevokeSessionHandler(accounts)).Methods("DELETE")
+	protected.HandleFunc("/account", auth.DeleteAccountHandler(accounts)).Methods("DELETE")
+	protected.HandleFunc("/account/password", auth.ChangePasswordHandler(accounts)).Methods("POST")
+	protected.HandleFunc("/account/totp/reenroll", auth.ReenrollTOTPHandler(accounts)).Methods("POST")
+	protected.HandleFunc("/account/totp/reenroll/confirm", auth.ConfirmTOTPReenrollHandler(accounts)).Methods("POST")
+	protected.HandleFunc("/account/activity", auth.AccountActivityHandler(accounts)).Methods("GET")
+	protected.HandleFunc("/account/recovery-codes", auth.RecoveryCodesStatusHandler(accounts)).Methods("GET")
+	protected.HandleFunc("/account/recovery-codes", auth.RegenerateRecoveryCodesHandler(accounts)).Methods("POST")
+	protected.HandleFunc("/account/passkeys", webauthn.ListCredentialsHandler(passkeys)).Methods("GET")
+	protected.HandleFunc("/account/passkeys/register/begin", webauthn.BeginRegistrationHandler(passkeys)).Methods("POST")
+	protected.HandleFunc("/account/passkeys/register/finish", webauthn.FinishRegistrationHandler(passkeys)).Methods("POST")
+	protected.HandleFunc("/account/passkeys/{id}", webauthn.DeleteCredentialHandler(passkeys)).Methods("DELETE")
+
+	// Administration; each route needs a permission granted by the caller's role
+	admin := protected.PathPrefix("/admin").Subrouter()
+	can := func(p auth.Permission, h http.HandlerFunc) http.Handler { return auth.RequirePermission(p)(h) }
+	admin.Handle("/stats", can(auth.PermReadUsers, auth.UserStatsHandler(accounts))).Methods("GET")
+	admin.Handle("/users", can(auth.PermReadUsers, auth.ListUsersHandler(accounts))).Methods("GET")
+	admin.Handle("/users/{id}", can(auth.PermReadUsers, auth.GetUserHandler(accounts))).Methods("GET")
+	admin.Handle("/users/{id}/role", can(auth.PermManageUsers, auth.SetUserRoleHandler(accounts))).Methods("PUT")
+	admin.Handle("/users/{id}/disable", can(auth.PermManageUsers, auth.DisableUserHandler(accounts))).Methods("POST")
+	admin.Handle("/users/{id}/enable", can(auth.PermManageUsers, auth.EnableUserHandler(accounts))).Methods("POST")
+	admin.Handle("/users/{id}/reset-totp", can(auth.PermManageUsers, auth.ResetTOTPHandler(accounts))).Methods("POST")
+	admin.Handle("/domains", can(auth.PermReadDomains, auth.ListDomainsHandler(accounts))).Methods("GET")
+	admin.Handle("/domains", can(auth.PermManageDomains, auth.CreateDomainHandler(accounts))).Methods("POST")
+	admin.Handle("/domains/{name}", can(auth.PermManageDomains, auth.UpdateDomainHandler(accounts))).Methods("PATCH")
+	admin.Handle("/domains/{name}", can(auth.PermManageDomains, auth.DeleteDomainHandler(accounts))).Methods("DELETE")
+	admin.Handle("/invites", can(auth.PermManageInvites, auth.ListInvitesHandler(accounts))).Methods("GET")
+	admin.Handle("/invites", can(auth.PermManageInvites, auth.CreateInviteHandler(accounts))).Methods("POST")
+	admin.Handle("/invites/{id}", can(auth.PermManageInvites, auth.RevokeInviteHandler(accounts))).Methods("DELETE")
+	protected.Handle("/audit", can(auth.PermReadAudit, auth.AuditHandler(accounts))).Methods("GET")
+	protected.Handle("/audit/verify", can(auth.PermReadAudit, auth.AuditVerifyHandler(accounts))).Methods("GET")
 
 	// Apply middleware
-	r.Use(srv.rateLimitMiddleware)
+	r.Use(requestinfo.Route)
+	r.Use(ipLimit)
 	r.Use(srv.secureHeadersMiddleware)
 
 	c := cors.New(cors.Options{
-		AllowedOrigins: []string{"http://localhost:3000", "https://secure-email-mvp.netlify.app"},
-		AllowedMethods: []string{"POST"},
-		AllowedHeaders: []string{"Content-Type"},
+		AllowedOrigins: cfg.Server.CORSOrigins,
+		AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
+		AllowedHeaders: []string{"Content-Type", "Authorization"},
 	})
-	handler := c.Handler(r)
+	// Outermost, so preflights, unmatched routes and rate-limited requests are logged too
+	handler := logging.Middleware(logger, clientIP)(metrics.Middleware(c.Handler(r)))
 
-	// Start server
-	addr := ":8080"
-	log.Printf("Starting API on %s", addr)
-	if err := http.ListenAndServe(addr, handler); err != nil {
-		log.Fatal("Server error:", err)
+	// Probes skip logging, metrics and rate limits so they cannot be throttled
+	root := http.NewServeMux()
+	root.HandleFunc("/healthz", health.LiveHandler)
+	root.Handle("/readyz", checker.ReadyHandler(false))
+	root.Handle("/", handler)
+
+	// Optional HTTPS; the certificate is reloaded on change or SIGHUP
+	var tlsCfg *tls.Config
+	if cfg.TLS.Enabled() {
+		var stopTLS func()
+		tlsCfg, stopTLS, err = setupTLS(cfg.TLS)
+		if err != nil {
+			fatal("Error configuring TLS", "error", err)
+		}
+		defer stopTLS()
+	}
+
+	// Start servers; SIGINT or SIGTERM drains them before exiting
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	errs := make(chan error, 3)
+	public := newServer(cfg.Server.Addr, cfg.Server, root)
+	public.TLSConfig = tlsCfg
+	servers := []*http.Server{public}
+	go func() {
+		if tlsCfg != nil {
+			slog.Info("Starting API", "addr", cfg.Server.Addr, "tls", true)
+			errs <- public.ListenAndServeTLS("", "")
+			return
+		}
+		slog.Info("Starting API", "addr", cfg.Server.Addr, "tls", false)
+		errs <- public.ListenAndServe()
+	}()
+	if cfg.TLS.RedirectAddr != "" {
+		redirect := newServer(cfg.TLS.RedirectAddr, cfg.Server, redirectHandler(cfg.Server.Addr))
+		servers = append(servers, redirect)
+		go func() {
+			slog.Info("Redirecting HTTP to HTTPS", "addr", cfg.TLS.RedirectAddr)
+			errs <- redirect.ListenAndServe()
+		}()
+	}
+	if cfg.Admin.Addr != "" {
+		adminSrv := newServer(cfg.Admin.Addr, cfg.Server, adminHandler(checker))
+		servers = append(servers, adminSrv)
+		go func() {
+			slog.Info("Serving metrics and health checks", "addr", cfg.Admin.Addr)
+			errs <- adminSrv.ListenAndServe()
+		}()
+	}
+
+	select {
+	case err := <-errs:
+		fatal("Server error", "error", err)
+	case <-ctx.Done():
+	}
+	stop() // A second signal kills the process without waiting
+
+	// Fail readiness and keep serving while load balancers notice, then let
+	// in-flight requests finish and run the deferred cleanup. The admin
+	// listener goes last so it reports draining meanwhile.
+	checker.Drain()
+	if cfg.Server.PreStopDelay > 0 {
+		slog.Info("Shutting down; reporting draining before closing listeners", "delay", cfg.Server.PreStopDelay.String())
+		time.Sleep(cfg.Server.PreStopDelay)
 	}
+	slog.Info("Shutting down; draining connections", "timeout", cfg.Server.ShutdownTimeout.String())
+	for _, srv := range servers {
+		shutdown(srv, cfg.Server.ShutdownTimeout)
+	}
+	slog.Info("API stopped")
 }
 
 func (srv *Server) loginHandler(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
-		TOTPCode string `json:"totp_code"`
+		Email        string              `json:"email"`
+		Password     string              `json:"password"`
+		TOTPCode     string              `json:"totp_code"`
+		RecoveryCode string              `json:"recovery_code"`
+		Passkey      *webauthn.Assertion `json:"passkey"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, `{"error":"Invalid request"}`, http.StatusBadRequest)
 		return
 	}
 
-	// Authenticate user
-	token, userID, err := auth.Authenticate(srv.db, req.Email, req.Password, req.TOTPCode)
+	// Authenticate user. A passkey replaces the TOTP code, or on its own both
+	// password and TOTP; a recovery code stands in for a lost authenticator.
+	var (
+		tokens *auth.TokenPair
+		userID string
+		err    error
+	)
+	client := auth.ClientInfoFromRequest(r, srv.accounts.ClientIP)
+	useRecovery := req.TOTPCode == "" && req.RecoveryCode != ""
+	switch {
+	case req.Passkey != nil && req.Password == "":
+		tokens, userID, err = srv.passkeys.Login(r.Context(), *req.Passkey, client)
+	case req.Passkey != nil:
+		tokens, userID, err = srv.accounts.AuthenticateWithSecondFactor(r.Context(), req.Email, req.Password, client, srv.passkeys.SecondFactor(*req.Passkey))
+	case useRecovery:
+		tokens, userID, err = srv.accounts.AuthenticateWithRecoveryCode(r.Context(), req.Email, req.Password, req.RecoveryCode, client)
+	default:
+		tokens, userID, err = srv.accounts.Authenticate(r.Context(), req.Email, req.Password, req.TOTPCode, client)
+	}
 	if err != nil {
-		srv.logError(r, req.Email, "Authentication failed")
+		logging.FromContext(r.Context()).Warn("Login failed", "email", req.Email, "error", err)
+		if errors.Is(err, auth.ErrHashPoolFull) {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, `{"error":"Server busy, try again shortly"}`, http.StatusServiceUnavailable)
+			return
+		}
+		if errors.Is(err, auth.ErrAccountDisabled) {
+			http.Error(w, `{"error":"Account disabled"}`, http.StatusForbidden)
+			return
+		}
+		var locked *auth.LockoutError
+		if errors.As(err, &locked) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+			http.Error(w, `{"error":"Too many failed attempts"}`, http.StatusTooManyRequests)
+			return
+		}
 		http.Error(w, `{"error":"Invalid credentials"}`, http.StatusUnauthorized)
 		return
 	}
 
+	logging.SetUserID(r.Context(), userID)
+
 	// Respond with JWT
 	resp := struct {
-		Token  string `json:"token"`
-		UserID string `json:"user_id"`
-	}{Token: token, UserID: userID}
+		Token                  string `json:"token"`
+		RefreshToken           string `json:"refresh_token"`
+		ExpiresIn              int64  `json:"expires_in"`
+		UserID                 string `json:"user_id"`
+		RecoveryCodesRemaining *int   `json:"recovery_codes_remaining,omitempty"`
+	}{Token: tokens.AccessToken, RefreshToken: tokens.RefreshToken, ExpiresIn: tokens.ExpiresIn, UserID: userID}
+	if useRecovery {
+		if remaining, err := srv.accounts.RemainingRecoveryCodes(userID); err == nil {
+			resp.RecoveryCodesRemaining = &remaining
+		} else {
+			logging.FromContext(r.Context()).Error("Recovery code count failed", "user_id", userID, "error", err)
+		}
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
-func (srv *Server) rateLimitMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip := r.RemoteAddr
-		count, _ := srv.rateLimits.LoadOrStore(ip, 0)
-		if count.(int) >= 10 {
-			http.Error(w, `{"error":"Too many requests"}`, http.StatusTooManyRequests)
-			return
-		}
-		srv.rateLimits.Store(ip, count.(int)+1)
-		go func() {
-			time.Sleep(time.Minute)
-			srv.rateLimits.Delete(ip)
-		}()
-		next.ServeHTTP(w, r)
-	})
+// byAccount limits each signed-in user across all of their sessions
+func byAccount(r *http.Request) (string, bool) {
+	id, ok := auth.IdentityFromContext(r.Context())
+	if !ok {
+		return "", false
+	}
+	return id.UserID, true
 }
 
 func (srv *Server) secureHeadersMiddleware(next http.Handler) http.Handler {
@@ -136,18 +418,3 @@ func (srv *Server) secureHeadersMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
-
-func (srv *Server) logError(r *http.Request, email, msg string) {
-	logPath := os.Getenv("LOG_FILE")
-	if logPath == "" {
-		logPath = "/var/log/api.log"
-	}
-	f, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		log.Println("Error opening log file:", err)
-		return
-	}
-	defer f.Close()
-	logger := log.New(f, "", log.LstdFlags)
-	logger.Printf("Error: %s, Email: %s, IP: %s", msg, email, r.RemoteAddr)
-}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"secure-email-mvp/pkg/auth"
+	"secure-email-mvp/pkg/health"
+	"secure-email-mvp/pkg/metrics"
+	"secure-email-mvp/pkg/store"
+)
+
+// registerMetrics exports state owned by other packages: the database pool
+// labelled with its driver, the Argon2 hash pool and pending sign-ups
+func registerMetrics(db *sql.DB, driver string, pool *auth.HashPool, pending store.PendingEnrollments) error {
+	stat := func(f func(s auth.HashPoolStats) float64) func() float64 {
+		return func() float64 { return f(pool.Stats()) }
+	}
+	pendingCount := func() float64 {
+		n, err := pending.Count(time.Now())
+		if err != nil {
+			slog.Error("Counting pending enrollments failed", "error", err)
+		}
+		return float64(n)
+	}
+	return errors.Join(
+		metrics.RegisterDB(db, driver),
+		metrics.GaugeFunc("hash_pool_workers", "Argon2 hashes that may run at once.",
+			stat(func(s auth.HashPoolStats) float64 { return float64(s.Workers) })),
+		metrics.GaugeFunc("hash_pool_running", "Argon2 hashes in progress.",
+			stat(func(s auth.HashPoolStats) float64 { return float64(s.Running) })),
+		metrics.GaugeFunc("hash_pool_queued", "Callers waiting for an Argon2 worker.",
+			stat(func(s auth.HashPoolStats) float64 { return float64(s.Queued) })),
+		metrics.CounterFunc("hash_pool_completed_total", "Argon2 hashes completed.",
+			stat(func(s auth.HashPoolStats) float64 { return float64(s.Completed) })),
+		metrics.CounterFunc("hash_pool_rejected_total", "Callers turned away with 503 because the hash queue was full.",
+			stat(func(s auth.HashPoolStats) float64 { return float64(s.Rejected) })),
+		metrics.CounterFunc("hash_pool_canceled_total", "Callers that gave up while queued for a hash worker.",
+			stat(func(s auth.HashPoolStats) float64 { return float64(s.Canceled) })),
+		metrics.CounterFunc("hash_pool_wait_seconds_total", "Time completed hashes spent queued for a worker.",
+			stat(func(s auth.HashPoolStats) float64 { return s.WaitTime.Seconds() })),
+		metrics.GaugeFunc("pending_enrollments", "Sign-ups waiting for their first TOTP code.", pendingCount),
+	)
+}
+
+// adminHandler serves the operators' listener: /metrics, and health checks
+// with the details the public /readyz leaves out
+func adminHandler(checker *health.Checker) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/healthz", health.LiveHandler)
+	mux.Handle("/readyz", checker.ReadyHandler(true))
+	return mux
+}
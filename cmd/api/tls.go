@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"secure-email-mvp/pkg/certs"
+	"secure-email-mvp/pkg/config"
+	"secure-email-mvp/pkg/metrics"
+)
+
+// setupTLS loads the certificate and keeps it current: it is reloaded when
+// the files change and on SIGHUP
+func setupTLS(cfg config.TLSConfig) (*tls.Config, func(), error) {
+	reloader, err := certs.NewReloader(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	tlsCfg, err := certs.ServerConfig(reloader, cfg.ClientCAFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	stopWatch, err := reloader.Watch()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := metrics.GaugeFunc("tls_certificate_expiry_timestamp_seconds", "When the served TLS certificate expires, in Unix seconds.", func() float64 {
+		return float64(reloader.Leaf().NotAfter.Unix())
+	}); err != nil {
+		stopWatch()
+		return nil, nil, err
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-hup:
+				reloader.ReloadAndLog()
+			}
+		}
+	}()
+
+	leaf := reloader.Leaf()
+	slog.Info("Serving TLS certificate", "subject", leaf.Subject.CommonName, "expires", leaf.NotAfter.UTC().Format("2006-01-02"))
+	if cfg.ClientCAFile != "" {
+		slog.Info("Requiring client certificates", "ca_file", cfg.ClientCAFile)
+	}
+	return tlsCfg, func() {
+		signal.Stop(hup)
+		close(done)
+		stopWatch()
+	}, nil
+}
+
+// redirectHandler sends plain HTTP requests to the same host and path over
+// HTTPS on httpsAddr's port. 308 keeps the method and body, so API clients
+// that POST to http:// are not silently turned into GETs.
+func redirectHandler(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		} else {
+			host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		}
+		if host == "" {
+			http.Error(w, `{"error":"Use HTTPS"}`, http.StatusBadRequest)
+			return
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
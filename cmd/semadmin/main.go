@@ -0,0 +1,239 @@
+// Command semadmin manages users, signing keys, the audit log and the schema
+// of the database the API serves, using the same configuration as the API
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"secure-email-mvp/pkg/audit"
+	"secure-email-mvp/pkg/auth"
+	"secure-email-mvp/pkg/config"
+	"secure-email-mvp/pkg/migrate"
+	"secure-email-mvp/pkg/store/driver"
+)
+
+const usage = `Usage: semadmin [-config file] <command> [arguments]
+
+Users, addressed by email:
+  user create [-role user|admin|auditor] <email>   create an enrolled user and print their TOTP QR code
+  user list [-q text] [-domain name] [-role role] [-disabled]
+  user show <email>
+  user role <email> <role>
+  user disable <email>                             block sign-in and revoke all sessions
+  user enable <email>
+  user reset-totp <email>                          issue a new TOTP secret and print its QR code
+  unlock <email>                                   clear failed-login lockout
+
+JWT signing keys in JWT_KEY_DIR:
+  keys list
+  keys rotate                                      make a new key active; servers pick it up at their next check
+
+Database:
+  migrate up | down [steps] | status
+  stats                                            count users, roles and domains against their caps
+
+Security audit log:
+  audit list [-user id] [-action name] [-outcome success|failure] [-n count]
+  audit verify                                     check the hash chain; exits non-zero if it is broken
+`
+
+func main() {
+	flags := flag.NewFlagSet("semadmin", flag.ExitOnError)
+	configFile := flags.String("config", "", "YAML or TOML config file (default $CONFIG_FILE)")
+	flags.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flags.Parse(os.Args[1:])
+	args := flags.Args()
+	if len(args) == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		fatal("loading configuration: %v", err)
+	}
+
+	if args[0] == "keys" {
+		if err := runKeys(cfg, args[1:]); err != nil {
+			fatal("%v", err)
+		}
+		return
+	}
+
+	drv, err := driver.Lookup(cfg.Database.Driver)
+	if err != nil {
+		fatal("opening database: %v", err)
+	}
+	db, err := drv.Open(cfg.Database.Source())
+	if err != nil {
+		fatal("connecting to %s database: %v", drv.Name, err)
+	}
+	defer db.Close()
+
+	// Everything but migrate needs the schema this binary was built for
+	if args[0] != "migrate" {
+		if err := drv.Migrations.Check(db); err != nil {
+			fatal("%v (run semadmin migrate up)", err)
+		}
+	}
+
+	switch args[0] {
+	case "user":
+		err = runUser(newService(cfg, db, drv), args[1:])
+	case "unlock":
+		err = runUnlock(newService(cfg, db, drv), args[1:])
+	case "migrate":
+		err = runMigrate(db, drv.Migrations, args[1:])
+	case "stats":
+		err = runStats(newService(cfg, db, drv))
+	case "audit":
+		err = runAudit(cfg, db, drv, args[1:])
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fatal("%v", err)
+	}
+}
+
+func fatal(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "semadmin: "+format+"\n", args...)
+	os.Exit(1)
+}
+
+// newService returns the auth service on db, chaining audit events with the
+// API's key so changes made here verify alongside its own. The configured
+// domain is hosted just as the API would create it.
+func newService(cfg *config.Config, db *sql.DB, drv *driver.Driver) *auth.Service {
+	key, err := audit.LoadKey(cfg.Audit.KeyFile)
+	if err != nil {
+		fatal("loading audit key: %v", err)
+	}
+	accounts := auth.NewService(db, drv.Dialect, nil, key)
+	if _, err := accounts.EnsureDomain(&auth.Domain{Name: cfg.Accounts.Domain, SignUp: auth.SignUpOpen, MaxUsers: cfg.Accounts.MaxUsers}); err != nil {
+		fatal("creating mail domain: %v", err)
+	}
+	return accounts
+}
+
+func runUnlock(accounts *auth.Service, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: unlock <email>")
+	}
+	// Unknown addresses are locked out like real ones, so unlock them too
+	target := strings.ToLower(auth.NormalizeEmail(args[0]))
+	if u, err := accounts.GetUserByEmail(args[0]); err == nil {
+		target = u.ID
+	}
+	cleared, err := accounts.UnlockAccount(args[0])
+	if err := auditAdmin(accounts, audit.ActionAdminUnlock, target, "", err); err != nil {
+		return err
+	}
+	if cleared {
+		fmt.Printf("Unlocked %s\n", args[0])
+	} else {
+		fmt.Printf("%s has no failed logins\n", args[0])
+	}
+	return nil
+}
+
+func runMigrate(db *sql.DB, migrations migrate.Source, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate up|down [steps]|status")
+	}
+	switch args[0] {
+	case "up":
+		n, err := migrations.Up(db)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Applied %d migrations\n", n)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("invalid step count %q", args[1])
+			}
+		}
+		n, err := migrations.Down(db, steps)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Rolled back %d migrations\n", n)
+	case "status":
+		status, err := migrations.Status(db)
+		if err != nil {
+			return err
+		}
+		for _, m := range status {
+			state := "pending"
+			if m.Applied {
+				state = "applied " + m.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d %-30s %s\n", m.Version, m.Name, state)
+		}
+		if err := migrations.Check(db); err != nil {
+			fmt.Println(err)
+		}
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+	return nil
+}
+
+func runKeys(cfg *config.Config, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: keys list|rotate")
+	}
+	keyring, err := auth.LoadKeyring(cfg.JWT.KeyDir)
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "list":
+	case "rotate":
+		// Old keys are left for the servers' rotation loop to prune
+		key, err := keyring.Rotate()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Published JWT signing key %s; it signs from %s\n", key.ID, key.CreatedAt.Add(auth.KeyActivationDelay).Format(time.RFC3339))
+	default:
+		return fmt.Errorf("unknown keys command %q", args[0])
+	}
+
+	active := keyring.Active()
+	for _, key := range keyring.Keys() {
+		state := "verifying"
+		if key == active {
+			state = "active"
+		} else if key.CreatedAt.After(active.CreatedAt) {
+			state = "pending"
+		}
+		fmt.Printf("%-30s %s  %s\n", key.ID, key.CreatedAt.Format(time.RFC3339), state)
+	}
+	return nil
+}
+
+func runStats(accounts *auth.Service) error {
+	stats, err := accounts.GetUserStats()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Users:    %d (%d disabled)\n", stats.Users, stats.Disabled)
+	fmt.Printf("Roles:    %d user, %d admin, %d auditor\n",
+		stats.Roles[auth.RoleUser], stats.Roles[auth.RoleAdmin], stats.Roles[auth.RoleAuditor])
+	fmt.Println("Domains:")
+	for _, d := range stats.Domains {
+		fmt.Printf("  %-30s %-7s %d/%d users\n", d.Name, d.SignUp, d.Users, d.MaxUsers)
+	}
+	return nil
+}
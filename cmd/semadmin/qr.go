@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/boombuler/barcode/qr"
+)
+
+// quietZone is the light border, in modules, scanners need around a QR code
+const quietZone = 2
+
+// writeQR draws text as a QR code with Unicode half blocks, two modules per
+// line. Light modules are drawn so the code scans on dark terminals.
+func writeQR(w io.Writer, text string) error {
+	code, err := qr.Encode(text, qr.M, qr.Auto)
+	if err != nil {
+		return fmt.Errorf("QR code generation error: %v", err)
+	}
+	size := code.Bounds().Dx()
+	light := func(x, y int) bool {
+		if x < 0 || y < 0 || x >= size || y >= size {
+			return true
+		}
+		r, _, _, _ := code.At(x, y).RGBA()
+		return r > 0x7fff
+	}
+
+	var b strings.Builder
+	for y := -quietZone; y < size+quietZone; y += 2 {
+		for x := -quietZone; x < size+quietZone; x++ {
+			switch top, bottom := light(x, y), light(x, y+1); {
+			case top && bottom:
+				b.WriteString("█")
+			case top:
+				b.WriteString("▀")
+			case bottom:
+				b.WriteString("▄")
+			default:
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString("\n")
+	}
+	_, err = io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,458 @@
+// Package audit keeps an append-only, hash-chained log of security events
+// such as logins and sign-ups in the audit_events table. Each hash is an
+// HMAC under a key kept outside the database, so someone who can write to
+// the database still cannot rewrite the chain.
+package audit
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Outcomes of an event
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Actions recorded by this repository; other packages may add their own
+const (
+	ActionLogin             = "login"
+	ActionLoginRecoveryCode = "login.recovery_code"
+	ActionLoginSecondFactor = "login.second_factor"
+	ActionLoginPasskey      = "login.passkey"
+	ActionSignUp            = "signup"
+	ActionSignUpVerifyTOTP  = "signup.verify_totp"
+	ActionPrune             = "audit.prune" // Target is the ID of the oldest event kept
+	ActionAdminSetRole      = "admin.set_role"
+	ActionAdminDisableUser  = "admin.disable_user"
+	ActionAdminEnableUser   = "admin.enable_user"
+	ActionAdminResetTOTP    = "admin.reset_totp"
+	ActionAdminUnlock       = "admin.unlock" // Target is the user ID, or the email if no user has it
+)
+
+// KeySize is the length in bytes of a generated audit key
+const KeySize = 32
+
+// Page sizes for List
+const (
+	DefaultLimit = 50
+	MaxLimit     = 500
+)
+
+// genesisHash is the previous hash of the first event ever recorded
+var genesisHash = strings.Repeat("0", 64)
+
+// ErrInvalidEvent is returned when an event lacks an action or outcome
+var ErrInvalidEvent = errors.New("invalid audit event")
+
+// ChainError reports the first event at which the hash chain does not hold
+type ChainError struct {
+	ID     int64
+	Reason string
+}
+
+func (e *ChainError) Error() string {
+	return fmt.Sprintf("audit chain broken at event %d: %s", e.ID, e.Reason)
+}
+
+// Event is one entry in the audit log
+type Event struct {
+	ID         int64     `json:"id"`
+	Time       time.Time `json:"time"`
+	ActorID    string    `json:"actor_id,omitempty"`
+	ActorEmail string    `json:"actor_email,omitempty"`
+	Action     string    `json:"action"`
+	Target     string    `json:"target,omitempty"`
+	IP         string    `json:"ip,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	Outcome    string    `json:"outcome"`
+	Reason     string    `json:"reason,omitempty"`
+	PrevHash   string    `json:"prev_hash"`
+	Hash       string    `json:"hash"`
+}
+
+// digest hashes the event's fields onto prev with key. Fields are
+// length-prefixed so no two different events hash the same input.
+func (e *Event) digest(key []byte, prev string) string {
+	h := hmac.New(sha256.New, key)
+	fmt.Fprintf(h, "%s\n%d\n%d\n", prev, e.ID, e.Time.Unix())
+	for _, f := range []string{e.ActorID, e.ActorEmail, e.Action, e.Target, e.IP, e.UserAgent, e.Outcome, e.Reason} {
+		fmt.Fprintf(h, "%d:%s\n", len(f), f)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// LoadKey reads the audit key from path, creating a random one if the file
+// does not exist yet
+func LoadKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, fmt.Errorf("failed to create audit key directory: %v", err)
+		}
+		key := make([]byte, KeySize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("failed to generate audit key: %v", err)
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if os.IsExist(err) {
+			// Another instance created it first
+			return LoadKey(path)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to create audit key: %v", err)
+		}
+		_, err = f.WriteString(hex.EncodeToString(key) + "\n")
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to write audit key: %v", err)
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit key: %v", err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) < KeySize {
+		return nil, fmt.Errorf("audit key %s must be at least %d hex-encoded bytes", path, KeySize)
+	}
+	return key, nil
+}
+
+// Log is the audit log in a database, chained with a key
+type Log struct {
+	db     *sql.DB
+	key    []byte
+	rebind func(query string) string
+}
+
+// New returns the audit log in db keyed with key. rebind rewrites the ?
+// placeholders in its queries for the driver, such as sqlstore.Dollar for
+// PostgreSQL; nil keeps them. It panics if key is shorter than KeySize: a
+// log without its key would write a chain that never verifies.
+func New(db *sql.DB, key []byte, rebind func(query string) string) *Log {
+	if len(key) < KeySize {
+		panic(fmt.Sprintf("audit: key must be at least %d bytes", KeySize))
+	}
+	return &Log{db: db, key: key, rebind: rebind}
+}
+
+// bind rewrites query's placeholders for the driver
+func (l *Log) bind(query string) string {
+	if l.rebind == nil {
+		return query
+	}
+	return l.rebind(query)
+}
+
+// appendMu serializes writers in this process so each event links to the
+// one before it; the UNIQUE prev_hash constraint catches other processes
+var appendMu sync.Mutex
+
+const eventColumns = "id, created_at, actor_id, actor_email, action, target, ip, user_agent, outcome, reason, prev_hash, hash"
+
+func scanEvent(row interface{ Scan(...interface{}) error }) (Event, error) {
+	var e Event
+	var createdAt int64
+	var actorID, actorEmail, target, ip, userAgent, reason sql.NullString
+	err := row.Scan(&e.ID, &createdAt, &actorID, &actorEmail, &e.Action, &target, &ip, &userAgent, &e.Outcome, &reason, &e.PrevHash, &e.Hash)
+	if err != nil {
+		return e, err
+	}
+	e.Time = time.Unix(createdAt, 0)
+	e.ActorID, e.ActorEmail, e.Target = actorID.String, actorEmail.String, target.String
+	e.IP, e.UserAgent, e.Reason = ip.String, userAgent.String, reason.String
+	return e, nil
+}
+
+func nullable(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// Record appends e to the log, filling in its ID, time (if zero) and hashes
+func (l *Log) Record(e *Event) error {
+	if e.Action == "" || (e.Outcome != OutcomeSuccess && e.Outcome != OutcomeFailure) {
+		return ErrInvalidEvent
+	}
+	appendMu.Lock()
+	defer appendMu.Unlock()
+	tx, err := l.db.Begin()
+	if err != nil {
+		return fmt.Errorf("database error: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := l.append(tx, e); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("database commit error: %v", err)
+	}
+	return nil
+}
+
+// append links e to the newest event in tx; the caller holds appendMu
+func (l *Log) append(tx *sql.Tx, e *Event) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.Time = time.Unix(e.Time.Unix(), 0)
+
+	var lastID int64
+	prev := genesisHash
+	err := tx.QueryRow("SELECT id, hash FROM audit_events ORDER BY id DESC LIMIT 1").Scan(&lastID, &prev)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("database error: %v", err)
+	}
+	e.ID = lastID + 1
+	e.PrevHash = prev
+	e.Hash = e.digest(l.key, prev)
+
+	_, err = tx.Exec(l.bind("INSERT INTO audit_events ("+eventColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"),
+		e.ID, e.Time.Unix(), nullable(e.ActorID), nullable(e.ActorEmail), e.Action, nullable(e.Target),
+		nullable(e.IP), nullable(e.UserAgent), e.Outcome, nullable(e.Reason), e.PrevHash, e.Hash)
+	if err != nil {
+		return fmt.Errorf("database insert error: %v", err)
+	}
+	return nil
+}
+
+// Filter narrows List; zero fields match everything
+type Filter struct {
+	User    string // Events the user performed or that concern them
+	Actor   string
+	Target  string
+	Action  string
+	Outcome string
+	Since   time.Time
+	Until   time.Time
+	Before  int64 // Only events with a lower ID, to page backwards
+	Limit   int   // Defaults to DefaultLimit, at most MaxLimit
+}
+
+// List returns the events matching f, newest first
+func (l *Log) List(f Filter) ([]Event, error) {
+	var where []string
+	var args []interface{}
+	add := func(cond string, vals ...interface{}) {
+		where = append(where, cond)
+		args = append(args, vals...)
+	}
+	if f.User != "" {
+		add("(actor_id = ? OR target = ?)", f.User, f.User)
+	}
+	if f.Actor != "" {
+		add("actor_id = ?", f.Actor)
+	}
+	if f.Target != "" {
+		add("target = ?", f.Target)
+	}
+	if f.Action != "" {
+		add("action = ?", f.Action)
+	}
+	if f.Outcome != "" {
+		add("outcome = ?", f.Outcome)
+	}
+	if !f.Since.IsZero() {
+		add("created_at >= ?", f.Since.Unix())
+	}
+	if !f.Until.IsZero() {
+		add("created_at < ?", f.Until.Unix())
+	}
+	if f.Before > 0 {
+		add("id < ?", f.Before)
+	}
+	cond := ""
+	if len(where) > 0 {
+		cond = " WHERE " + strings.Join(where, " AND ")
+	}
+	if f.Limit <= 0 {
+		f.Limit = DefaultLimit
+	}
+	if f.Limit > MaxLimit {
+		f.Limit = MaxLimit
+	}
+
+	rows, err := l.db.Query(l.bind("SELECT "+eventColumns+" FROM audit_events"+cond+" ORDER BY id DESC LIMIT ?"), append(args, f.Limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+	events := []Event{}
+	for rows.Next() {
+		e, err := scanEvent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("database error: %v", err)
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// Verify walks the chain from the oldest remaining event and returns how many
+// events it checked. A *ChainError names the first event that was changed,
+// removed or inserted out of order. Once events have been pruned, the oldest
+// remaining one must be the one the latest audit.prune event names.
+func (l *Log) Verify() (int, error) {
+	rows, err := l.db.Query("SELECT " + eventColumns + " FROM audit_events ORDER BY id")
+	if err != nil {
+		return 0, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	checked := 0
+	var first, prev, pruned *Event
+	for rows.Next() {
+		e, err := scanEvent(rows)
+		if err != nil {
+			return checked, fmt.Errorf("database error: %v", err)
+		}
+		if prev == nil {
+			first = &e
+		} else {
+			if e.ID != prev.ID+1 {
+				return checked, &ChainError{ID: e.ID, Reason: fmt.Sprintf("follows event %d", prev.ID)}
+			}
+			if e.PrevHash != prev.Hash {
+				return checked, &ChainError{ID: e.ID, Reason: "previous hash does not match"}
+			}
+		}
+		if !hmac.Equal([]byte(e.digest(l.key, e.PrevHash)), []byte(e.Hash)) {
+			return checked, &ChainError{ID: e.ID, Reason: "contents do not match hash"}
+		}
+		if e.Action == ActionPrune {
+			pruned = &e
+		}
+		checked++
+		prev = &e
+	}
+	if err := rows.Err(); err != nil {
+		return checked, fmt.Errorf("database error: %v", err)
+	}
+
+	// The oldest event's predecessor is either the genesis hash or was
+	// pruned, which the latest prune record must account for
+	switch {
+	case first == nil:
+	case first.ID == 1:
+		if first.PrevHash != genesisHash {
+			return checked, &ChainError{ID: first.ID, Reason: "first event does not start the chain"}
+		}
+	case pruned == nil:
+		return checked, &ChainError{ID: first.ID, Reason: "earlier events removed without an audit.prune record"}
+	case pruned.Target != strconv.FormatInt(first.ID, 10):
+		return checked, &ChainError{ID: first.ID, Reason: fmt.Sprintf("audit.prune event %d kept event %s", pruned.ID, pruned.Target)}
+	}
+	return checked, nil
+}
+
+// SetRetention stores how old events must be before Prune may remove them.
+// The database refuses to delete younger events; zero refuses every delete.
+func (l *Log) SetRetention(retention time.Duration) error {
+	if _, err := l.db.Exec("DELETE FROM audit_retention"); err != nil {
+		return fmt.Errorf("database delete error: %v", err)
+	}
+	if retention <= 0 {
+		return nil
+	}
+	if _, err := l.db.Exec(l.bind("INSERT INTO audit_retention (id, seconds) VALUES (1, ?)"), int64(retention/time.Second)); err != nil {
+		return fmt.Errorf("database insert error: %v", err)
+	}
+	return nil
+}
+
+// Prune removes events older than before, oldest first, always keeping the
+// newest event so the chain continues. In the same transaction it records
+// how many it removed and which event is now the oldest. before must be at
+// least the stored retention in the past.
+func (l *Log) Prune(before time.Time) (int64, error) {
+	appendMu.Lock()
+	defer appendMu.Unlock()
+	tx, err := l.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("database error: %v", err)
+	}
+	defer tx.Rollback()
+
+	var removed int64
+	for {
+		res, err := tx.Exec(l.bind(`DELETE FROM audit_events
+			WHERE id = (SELECT MIN(id) FROM audit_events)
+			AND id < (SELECT MAX(id) FROM audit_events)
+			AND created_at < ?`), before.Unix())
+		if err != nil {
+			return removed, fmt.Errorf("database delete error: %v", err)
+		}
+		n, _ := res.RowsAffected()
+		if n == 0 {
+			break
+		}
+		removed += n
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+
+	var oldest int64
+	if err := tx.QueryRow("SELECT MIN(id) FROM audit_events").Scan(&oldest); err != nil {
+		return 0, fmt.Errorf("database error: %v", err)
+	}
+	err = l.append(tx, &Event{
+		Action:  ActionPrune,
+		Target:  strconv.FormatInt(oldest, 10),
+		Outcome: OutcomeSuccess,
+		Reason:  fmt.Sprintf("removed %d events before %s", removed, before.UTC().Format(time.RFC3339)),
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("database commit error: %v", err)
+	}
+	return removed, nil
+}
+
+// StartRetention stores retention and prunes events older than it every
+// interval until stopped. A zero retention keeps events forever.
+func (l *Log) StartRetention(retention, interval time.Duration) (stop func(), err error) {
+	if err := l.SetRetention(retention); err != nil {
+		return nil, err
+	}
+	if retention <= 0 {
+		return func() {}, nil
+	}
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				if _, err := l.Prune(now.Add(-retention)); err != nil {
+					slog.Error("Audit retention sweep failed", "error", err)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }, nil
+}
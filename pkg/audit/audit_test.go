@@ -0,0 +1,257 @@
+package audit
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"secure-email-mvp/pkg/migrate"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+func newTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal("Failed to open database:", err)
+	}
+	// Each connection to :memory: is a separate database
+	db.SetMaxOpenConns(1)
+	if _, err := migrate.Up(db); err != nil {
+		t.Fatal("Failed to migrate database:", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func newTestLog(t *testing.T) (*Log, *sql.DB) {
+	db := newTestDB(t)
+	return New(db, testKey, nil), db
+}
+
+func record(t *testing.T, l *Log, e Event) Event {
+	if err := l.Record(&e); err != nil {
+		t.Fatal("Record failed:", err)
+	}
+	return e
+}
+
+func TestRecordChainsEvents(t *testing.T) {
+	l, db := newTestLog(t)
+	first := record(t, l, Event{ActorEmail: "a@example.org", Action: ActionLogin, Outcome: OutcomeFailure, Reason: "invalid credentials", IP: "203.0.113.7"})
+	second := record(t, l, Event{ActorID: "user-1", Action: ActionLogin, Target: "user-1", Outcome: OutcomeSuccess, UserAgent: "curl/8"})
+
+	if first.ID != 1 || first.PrevHash != genesisHash {
+		t.Errorf("Expected first event to start the chain, got %d %q", first.ID, first.PrevHash)
+	}
+	if second.ID != 2 || second.PrevHash != first.Hash || second.Hash == first.Hash {
+		t.Errorf("Expected second event linked to the first, got %+v", second)
+	}
+	if n, err := l.Verify(); err != nil || n != 2 {
+		t.Errorf("Expected 2 events verified, got %d, %v", n, err)
+	}
+
+	// The chain only verifies under the key it was written with
+	var chainErr *ChainError
+	if _, err := New(db, []byte("another key of thirty-two bytes!"), nil).Verify(); !errors.As(err, &chainErr) || chainErr.ID != 1 {
+		t.Errorf("Expected verification with another key to fail at event 1, got %v", err)
+	}
+
+	if err := l.Record(&Event{Action: ActionLogin, Outcome: "maybe"}); err != ErrInvalidEvent {
+		t.Errorf("Expected ErrInvalidEvent for unknown outcome, got %v", err)
+	}
+}
+
+func TestAppendOnly(t *testing.T) {
+	l, db := newTestLog(t)
+	for i := 0; i < 3; i++ {
+		record(t, l, Event{Time: time.Now().Add(-48 * time.Hour), Action: ActionSignUp, Outcome: OutcomeSuccess})
+	}
+	if _, err := db.Exec("UPDATE audit_events SET outcome = 'failure' WHERE id = 2"); err == nil {
+		t.Error("Expected update rejected")
+	}
+	if _, err := db.Exec("DELETE FROM audit_events WHERE id = 2"); err == nil {
+		t.Error("Expected deleting from the middle rejected")
+	}
+	if _, err := db.Exec("DELETE FROM audit_events WHERE id = 3"); err == nil {
+		t.Error("Expected deleting the newest event rejected")
+	}
+	if _, err := db.Exec("DELETE FROM audit_events WHERE id = 1"); err == nil {
+		t.Error("Expected deleting the oldest event rejected without a retention")
+	}
+	l.SetRetention(72 * time.Hour)
+	if _, err := db.Exec("DELETE FROM audit_events WHERE id = 1"); err == nil {
+		t.Error("Expected deleting the oldest event rejected within the retention")
+	}
+	if n, err := l.Verify(); err != nil || n != 3 {
+		t.Errorf("Expected chain intact, got %d, %v", n, err)
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	l, db := newTestLog(t)
+	for i := 0; i < 4; i++ {
+		record(t, l, Event{ActorID: "user-1", Action: ActionLogin, Outcome: OutcomeFailure})
+	}
+	// Someone with direct database access can drop the triggers
+	if _, err := db.Exec("DROP TRIGGER audit_events_no_update; DROP TRIGGER audit_events_prune_oldest"); err != nil {
+		t.Fatal("Failed to drop triggers:", err)
+	}
+
+	db.Exec("UPDATE audit_events SET outcome = 'success' WHERE id = 3")
+	var chainErr *ChainError
+	if _, err := l.Verify(); !errors.As(err, &chainErr) || chainErr.ID != 3 {
+		t.Errorf("Expected edited event 3 reported, got %v", err)
+	}
+
+	db.Exec("DELETE FROM audit_events WHERE id = 3")
+	if _, err := l.Verify(); !errors.As(err, &chainErr) || chainErr.ID != 4 {
+		t.Errorf("Expected gap before event 4 reported, got %v", err)
+	}
+
+	// Removing the oldest events leaves no gap, but no prune record covers it
+	db.Exec("DELETE FROM audit_events WHERE id IN (3, 4)")
+	record(t, l, Event{ActorID: "user-1", Action: ActionLogin, Outcome: OutcomeFailure})
+	db.Exec("DELETE FROM audit_events WHERE id = 1")
+	if _, err := l.Verify(); !errors.As(err, &chainErr) || chainErr.ID != 2 {
+		t.Errorf("Expected unrecorded removal before event 2 reported, got %v", err)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	l, db := newTestLog(t)
+	if err := l.SetRetention(24 * time.Hour); err != nil {
+		t.Fatal("SetRetention failed:", err)
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	record(t, l, Event{Time: old, Action: ActionLogin, Outcome: OutcomeSuccess})
+	record(t, l, Event{Time: old, Action: ActionLogin, Outcome: OutcomeSuccess})
+	recent := record(t, l, Event{Action: ActionLogin, Outcome: OutcomeSuccess})
+
+	removed, err := l.Prune(time.Now().Add(-24 * time.Hour))
+	if err != nil || removed != 2 {
+		t.Fatalf("Expected 2 events pruned, got %d, %v", removed, err)
+	}
+	events, _ := l.List(Filter{})
+	if len(events) != 2 || events[0].Action != ActionPrune || events[0].Target != "3" || events[1].ID != recent.ID {
+		t.Errorf("Expected recent event and prune record naming it, got %+v", events)
+	}
+	if n, err := l.Verify(); err != nil || n != 2 {
+		t.Errorf("Expected pruned chain to verify, got %d, %v", n, err)
+	}
+
+	// The database refuses to prune events younger than the retention
+	if _, err := l.Prune(time.Now().Add(time.Hour)); err == nil {
+		t.Error("Expected pruning within the retention refused")
+	}
+	if n, err := l.Verify(); err != nil || n != 2 {
+		t.Errorf("Expected chain unchanged, got %d, %v", n, err)
+	}
+
+	// Removing the event the prune record names breaks the chain
+	db.Exec("DROP TRIGGER audit_events_prune_oldest")
+	db.Exec("DELETE FROM audit_events WHERE id = 3")
+	var chainErr *ChainError
+	if _, err := l.Verify(); !errors.As(err, &chainErr) || chainErr.ID != 4 {
+		t.Errorf("Expected removal after the prune record reported, got %v", err)
+	}
+}
+
+func TestPruneKeepsNewest(t *testing.T) {
+	l, db := newTestLog(t)
+	l.SetRetention(time.Hour)
+	for i := 0; i < 3; i++ {
+		record(t, l, Event{Time: time.Now().Add(-48 * time.Hour), Action: ActionLogin, Outcome: OutcomeSuccess})
+	}
+
+	// The newest event is kept even when everything is old
+	if removed, err := l.Prune(time.Now().Add(-time.Hour)); err != nil || removed != 2 {
+		t.Errorf("Expected all but the newest event pruned, got %d, %v", removed, err)
+	}
+	if n, err := l.Verify(); err != nil || n != 2 {
+		t.Errorf("Expected chain to continue after pruning, got %d, %v", n, err)
+	}
+	var n int
+	db.QueryRow("SELECT COUNT(*) FROM audit_events").Scan(&n)
+	if n != 2 {
+		t.Errorf("Expected newest event and prune record left, got %d", n)
+	}
+}
+
+func TestList(t *testing.T) {
+	l, _ := newTestLog(t)
+	record(t, l, Event{ActorEmail: "a@example.org", Action: ActionLogin, Target: "user-1", Outcome: OutcomeFailure})
+	record(t, l, Event{ActorID: "user-1", Action: ActionLogin, Target: "user-1", Outcome: OutcomeSuccess})
+	record(t, l, Event{ActorID: "admin-1", Action: "user.disable", Target: "user-2", Outcome: OutcomeSuccess})
+	record(t, l, Event{ActorID: "user-2", Action: ActionLogin, Target: "user-2", Outcome: OutcomeSuccess})
+
+	tests := []struct {
+		name   string
+		filter Filter
+		ids    []int64
+	}{
+		{"all", Filter{}, []int64{4, 3, 2, 1}},
+		{"user", Filter{User: "user-1"}, []int64{2, 1}},
+		{"actor", Filter{Actor: "admin-1"}, []int64{3}},
+		{"target", Filter{Target: "user-2"}, []int64{4, 3}},
+		{"action and outcome", Filter{Action: ActionLogin, Outcome: OutcomeSuccess}, []int64{4, 2}},
+		{"page", Filter{Before: 3, Limit: 1}, []int64{2}},
+		{"until", Filter{Until: time.Now().Add(-time.Hour)}, nil},
+	}
+	for _, tt := range tests {
+		events, err := l.List(tt.filter)
+		if err != nil {
+			t.Fatalf("%s: List failed: %v", tt.name, err)
+		}
+		var ids []int64
+		for _, e := range events {
+			ids = append(ids, e.ID)
+		}
+		if len(ids) != len(tt.ids) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.ids, ids)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != tt.ids[i] {
+				t.Errorf("%s: expected %v, got %v", tt.name, tt.ids, ids)
+				break
+			}
+		}
+	}
+}
+
+func TestLoadKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys", "audit.key")
+	key, err := LoadKey(path)
+	if err != nil || len(key) != KeySize {
+		t.Fatalf("Expected a new %d-byte key, got %d, %v", KeySize, len(key), err)
+	}
+	again, err := LoadKey(path)
+	if err != nil || !bytes.Equal(again, key) {
+		t.Errorf("Expected the same key reloaded, got %x, %v", again, err)
+	}
+
+	os.WriteFile(path, []byte("abcd\n"), 0600)
+	if _, err := LoadKey(path); err == nil {
+		t.Error("Expected a short key refused")
+	}
+}
+
+func TestNewRequiresKey(t *testing.T) {
+	for _, key := range [][]byte{nil, []byte("short")} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected New to panic with a %d-byte key", len(key))
+				}
+			}()
+			New(newTestDB(t), key, nil)
+		}()
+	}
+}
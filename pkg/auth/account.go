@@ -0,0 +1,339 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"secure-email-mvp/pkg/logging"
+	"secure-email-mvp/pkg/store"
+
+	"github.com/google/uuid"
+)
+
+// ErrInvalidCredentials is returned when a password or second factor does not match
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+	ConfirmPassword string `json:"confirm_password"`
+	TotpCode        string `json:"totp_code"`
+}
+
+type ReenrollTOTPRequest struct {
+	Password     string `json:"password"`
+	TotpCode     string `json:"totp_code"`
+	RecoveryCode string `json:"recovery_code"` // For users who lost the old authenticator
+}
+
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+	TotpCode string `json:"totp_code"`
+}
+
+// checkAccountPassword verifies the signed-in user's password and returns
+// their email and TOTP secret. Wrong passwords count against the account's
+// lockout as failed logins do, so a stolen token cannot be used to guess it.
+func (s *Service) checkAccountPassword(ctx context.Context, userID, password string) (string, string, error) {
+	user, err := s.Store.Users.ByID(userID)
+	if err != nil {
+		return "", "", err
+	}
+	if err := reserveLoginAttempt(s.Store.Failures, s.Lockout, user.Email, time.Now()); err != nil {
+		return "", "", err
+	}
+	if !ValidatePassword(password) {
+		return "", "", ErrInvalidCredentials
+	}
+	ok, _, err := s.Hash.VerifyPassword(ctx, password, user.Email, user.PasswordHash)
+	if err != nil {
+		if hashBusy(err) {
+			if rerr := releaseLoginAttempt(s.Store.Failures, user.Email); rerr != nil {
+				logging.FromContext(ctx).Error("Releasing login attempt failed", "user_id", userID, "error", rerr)
+			}
+		}
+		return "", "", fmt.Errorf("password verification error: %w", err)
+	}
+	if !ok {
+		return "", "", ErrInvalidCredentials
+	}
+	// The right password gives back the attempt without clearing earlier failures
+	if err := releaseLoginAttempt(s.Store.Failures, user.Email); err != nil {
+		logging.FromContext(ctx).Error("Releasing login attempt failed", "user_id", userID, "error", err)
+	}
+	return user.Email, user.TOTPSecret, nil
+}
+
+// checkAccountTOTP verifies the signed-in user's password and TOTP code
+func (s *Service) checkAccountTOTP(ctx context.Context, userID, password, totpCode string) (string, error) {
+	email, totpSecret, err := s.checkAccountPassword(ctx, userID, password)
+	if err != nil {
+		return "", err
+	}
+	if err := s.CheckTOTP(userID, totpSecret, totpCode); err != nil {
+		if err == ErrTOTPInvalid || err == ErrTOTPReplayed {
+			return "", ErrInvalidCredentials
+		}
+		return "", err
+	}
+	return email, nil
+}
+
+// replaceCredential applies update and revokes every session of the user in
+// one transaction, then starts a fresh session for the caller. Tokens issued
+// before the change stop working immediately.
+func (s *Service) replaceCredential(userID, email string, client ClientInfo, update func(repos *store.Store) error) (*TokenPair, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer tx.Rollback()
+
+	repos := s.Store.WithTx(tx)
+	if err := update(repos); err != nil {
+		return nil, err
+	}
+	if _, err := repos.Sessions.RevokeAll(userID, "", time.Now()); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("database commit error: %v", err)
+	}
+	return s.CreateSession(userID, email, client)
+}
+
+// accountError writes the response for a failed credential check
+func accountError(w http.ResponseWriter, r *http.Request, action string, err error) {
+	if err == ErrInvalidCredentials {
+		http.Error(w, `{"error":"Invalid credentials"}`, http.StatusUnauthorized)
+		return
+	}
+	var locked *LockoutError
+	if errors.As(err, &locked) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+		http.Error(w, `{"error":"Too many failed attempts"}`, http.StatusTooManyRequests)
+		return
+	}
+	if hashBusy(err) {
+		serverBusy(w)
+		logging.FromContext(r.Context()).Warn(action+" rejected", "error", err)
+		return
+	}
+	http.Error(w, `{"error":"Internal server error"}`, http.StatusInternalServerError)
+	logging.FromContext(r.Context()).Error(action+" failed", "error", err)
+}
+
+// ChangePasswordHandler sets a new password after checking the current one
+// and a TOTP code. Every session is revoked and a new token pair returned.
+// It must run behind RequireAuth.
+func ChangePasswordHandler(s *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := IdentityFromContext(r.Context())
+		if !ok {
+			unauthorized(w)
+			return
+		}
+
+		var req ChangePasswordRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !ValidateTOTP(req.TotpCode) {
+			http.Error(w, `{"error":"Invalid request"}`, http.StatusBadRequest)
+			return
+		}
+		if req.NewPassword != req.ConfirmPassword {
+			http.Error(w, `{"error":"Passwords do not match"}`, http.StatusBadRequest)
+			return
+		}
+		if !ValidatePassword(req.NewPassword) {
+			http.Error(w, `{"error":"Password must be 8–128 characters"}`, http.StatusBadRequest)
+			return
+		}
+
+		email, err := s.checkAccountTOTP(r.Context(), id.UserID, req.CurrentPassword, req.TotpCode)
+		if err != nil {
+			accountError(w, r, "Password change", err)
+			return
+		}
+
+		passwordHash, err := s.Hash.HashPassword(r.Context(), req.NewPassword)
+		if err != nil {
+			accountError(w, r, "Password change", err)
+			return
+		}
+		tokens, err := s.replaceCredential(id.UserID, email, s.clientInfo(r), func(repos *store.Store) error {
+			return repos.Users.SetPasswordHash(id.UserID, passwordHash)
+		})
+		if err != nil {
+			accountError(w, r, "Password change", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(tokens); err != nil {
+			logging.FromContext(r.Context()).Warn("Password change response failed", "error", err)
+		}
+		logging.FromContext(r.Context()).Info("Password changed", "user_id", id.UserID)
+	}
+}
+
+// ReenrollTOTPHandler starts replacing the user's TOTP secret. Like sign-up it
+// returns a temp ID and QR code; the old secret stays active until
+// ConfirmTOTPReenrollHandler receives a code from the new one. Either the
+// current TOTP code or a recovery code is accepted alongside the password.
+// It must run behind RequireAuth.
+func ReenrollTOTPHandler(s *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := IdentityFromContext(r.Context())
+		if !ok {
+			unauthorized(w)
+			return
+		}
+
+		var req ReenrollTOTPRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, `{"error":"Invalid request"}`, http.StatusBadRequest)
+			return
+		}
+		if !ValidateTOTP(req.TotpCode) && !ValidateRecoveryCode(req.RecoveryCode) {
+			http.Error(w, `{"error":"Invalid request"}`, http.StatusBadRequest)
+			return
+		}
+
+		var email string
+		var err error
+		if req.TotpCode != "" {
+			email, err = s.checkAccountTOTP(r.Context(), id.UserID, req.Password, req.TotpCode)
+		} else if email, _, err = s.checkAccountPassword(r.Context(), id.UserID, req.Password); err == nil {
+			if err = s.UseRecoveryCode(id.UserID, req.RecoveryCode); err == ErrRecoveryCodeInvalid {
+				err = ErrInvalidCredentials
+			}
+		}
+		if err != nil {
+			accountError(w, r, "TOTP re-enrollment", err)
+			return
+		}
+
+		issuer, err := s.totpIssuer(email)
+		if err != nil {
+			accountError(w, r, "TOTP re-enrollment", err)
+			return
+		}
+		totpSecret, totpQr, err := newTOTPEnrollment(issuer, email)
+		if err != nil {
+			accountError(w, r, "TOTP re-enrollment", err)
+			return
+		}
+
+		// Only the latest re-enrollment can be confirmed
+		tempID := uuid.New().String()
+		now := time.Now()
+		err = s.Store.Reenroll.Start(store.TOTPReenrollment{
+			TempID:     tempID,
+			UserID:     id.UserID,
+			TOTPSecret: totpSecret,
+			ExpiresAt:  now.Add(5 * time.Minute),
+		}, now)
+		if err != nil {
+			accountError(w, r, "TOTP re-enrollment", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(SignUpResponse{TempID: tempID, TotpQr: totpQr}); err != nil {
+			logging.FromContext(r.Context()).Warn("TOTP re-enrollment response failed", "error", err)
+		}
+		logging.FromContext(r.Context()).Info("TOTP re-enrollment initiated", "user_id", id.UserID)
+	}
+}
+
+// ConfirmTOTPReenrollHandler activates the new TOTP secret once the user
+// proves their authenticator holds it. Every session is revoked and a new
+// token pair returned. It must run behind RequireAuth.
+func ConfirmTOTPReenrollHandler(s *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := IdentityFromContext(r.Context())
+		if !ok {
+			unauthorized(w)
+			return
+		}
+
+		var req VerifyTotpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !ValidateTOTP(req.TotpCode) {
+			http.Error(w, `{"error":"Invalid request"}`, http.StatusBadRequest)
+			return
+		}
+
+		pending, err := s.Store.Reenroll.Load(req.TempID, id.UserID)
+		if err == store.ErrNotFound || (err == nil && !time.Now().Before(pending.ExpiresAt)) {
+			http.Error(w, `{"error":"Invalid or expired temp ID"}`, http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			accountError(w, r, "TOTP re-enrollment", err)
+			return
+		}
+		totpSecret := pending.TOTPSecret
+
+		step, err := s.matchTOTPStep(req.TotpCode, totpSecret)
+		if err != nil {
+			http.Error(w, `{"error":"Invalid TOTP code"}`, http.StatusBadRequest)
+			logging.FromContext(r.Context()).Warn("TOTP re-enrollment failed", "user_id", id.UserID, "error", err)
+			return
+		}
+
+		tokens, err := s.replaceCredential(id.UserID, id.Email, s.clientInfo(r), func(repos *store.Store) error {
+			// The confirming code's step is spent so it cannot be replayed at login
+			if err := repos.Users.SetTOTPSecret(id.UserID, totpSecret); err != nil {
+				return err
+			}
+			if _, err := repos.Users.AdvanceTOTPStep(id.UserID, step); err != nil {
+				return err
+			}
+			return repos.Reenroll.DeleteUser(id.UserID)
+		})
+		if err != nil {
+			accountError(w, r, "TOTP re-enrollment", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(tokens); err != nil {
+			logging.FromContext(r.Context()).Warn("TOTP re-enrollment response failed", "error", err)
+		}
+		logging.FromContext(r.Context()).Info("TOTP re-enrolled", "user_id", id.UserID)
+	}
+}
+
+// DeleteAccountHandler permanently deletes the caller's account after
+// checking their password and a TOTP code. It must run behind RequireAuth.
+func DeleteAccountHandler(s *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := IdentityFromContext(r.Context())
+		if !ok {
+			unauthorized(w)
+			return
+		}
+
+		var req DeleteAccountRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !ValidateTOTP(req.TotpCode) {
+			http.Error(w, `{"error":"Invalid request"}`, http.StatusBadRequest)
+			return
+		}
+
+		if _, err := s.checkAccountTOTP(r.Context(), id.UserID, req.Password, req.TotpCode); err != nil {
+			accountError(w, r, "Account deletion", err)
+			return
+		}
+		if err := s.Store.Users.Delete(id.UserID); err != nil {
+			accountError(w, r, "Account deletion", err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		logging.FromContext(r.Context()).Info("Account deleted", "user_id", id.UserID)
+	}
+}
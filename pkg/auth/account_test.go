@@ -0,0 +1,240 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pquerna/otp/totp"
+)
+
+// newAccountTestDB creates user-1 with password securepass123 and testTOTPSecret
+func newAccountTestDB(t *testing.T) *sql.DB {
+	db := newTestDB(t)
+	hash, _ := HashPassword("securepass123")
+	_, err := db.Exec("INSERT INTO users (id, email, password_hash, totp_secret) VALUES (?, ?, ?, ?)",
+		"user-1", "test@securesystem.email", hash, testTOTPSecret)
+	if err != nil {
+		t.Fatal("Failed to insert user:", err)
+	}
+	return db
+}
+
+func newAccountRouter(svc *Service) *mux.Router {
+	r := mux.NewRouter()
+	r.Use(RequireAuth(svc))
+	r.HandleFunc("/api/auth/me", MeHandler).Methods("GET")
+	r.HandleFunc("/api/account", DeleteAccountHandler(svc)).Methods("DELETE")
+	r.HandleFunc("/api/account/password", ChangePasswordHandler(svc)).Methods("POST")
+	r.HandleFunc("/api/account/totp/reenroll", ReenrollTOTPHandler(svc)).Methods("POST")
+	r.HandleFunc("/api/account/totp/reenroll/confirm", ConfirmTOTPReenrollHandler(svc)).Methods("POST")
+	return r
+}
+
+func doAccount(r http.Handler, method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req, _ := http.NewRequest(method, path, &buf)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr
+}
+
+// totpAt returns the code for the step offset steps from now; each step can only be used once
+func totpAt(secret string, offset int) string {
+	code, _ := totp.GenerateCode(secret, time.Now().Add(time.Duration(offset)*totpPeriod))
+	return code
+}
+
+func TestChangePasswordHandler(t *testing.T) {
+	db := newAccountTestDB(t)
+	svc := newTestService(db)
+	r := newAccountRouter(svc)
+	current, _ := svc.CreateSession("user-1", "test@securesystem.email", ClientInfo{})
+	other, _ := svc.CreateSession("user-1", "test@securesystem.email", ClientInfo{})
+
+	tests := []struct {
+		name   string
+		req    ChangePasswordRequest
+		status int
+	}{
+		{"Mismatched confirmation", ChangePasswordRequest{"securepass123", "newpass12345", "other12345", totpAt(testTOTPSecret, 0)}, http.StatusBadRequest},
+		{"Short password", ChangePasswordRequest{"securepass123", "short", "short", totpAt(testTOTPSecret, 0)}, http.StatusBadRequest},
+		{"Wrong current password", ChangePasswordRequest{"wrongpass123", "newpass12345", "newpass12345", totpAt(testTOTPSecret, 0)}, http.StatusUnauthorized},
+		{"Wrong TOTP", ChangePasswordRequest{"securepass123", "newpass12345", "newpass12345", "000000"}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.req.TotpCode == "000000" && totpAt(testTOTPSecret, 0) == "000000" {
+				t.Skip("Current code happens to be 000000")
+			}
+			if rr := doAccount(r, "POST", "/api/account/password", current.AccessToken, tt.req); rr.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, rr.Code)
+			}
+		})
+	}
+
+	rr := doAccount(r, "POST", "/api/account/password", current.AccessToken,
+		ChangePasswordRequest{"securepass123", "newpass12345", "newpass12345", totpAt(testTOTPSecret, 0)})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var fresh TokenPair
+	json.NewDecoder(rr.Body).Decode(&fresh)
+
+	// Every earlier token is invalidated; the new pair works
+	for name, token := range map[string]string{"current": current.AccessToken, "other": other.AccessToken} {
+		if rr := doAccount(r, "GET", "/api/auth/me", token, nil); rr.Code != http.StatusUnauthorized {
+			t.Errorf("Expected %s session token rejected, got %d", name, rr.Code)
+		}
+	}
+	if rr := doAccount(r, "GET", "/api/auth/me", fresh.AccessToken, nil); rr.Code != http.StatusOK {
+		t.Errorf("Expected new token accepted, got %d", rr.Code)
+	}
+
+	if _, _, err := svc.Authenticate(context.Background(), "test@securesystem.email", "newpass12345", totpAt(testTOTPSecret, 1), ClientInfo{}); err != nil {
+		t.Errorf("Expected login with new password, got %v", err)
+	}
+}
+
+func TestAccountPasswordLockout(t *testing.T) {
+	db := newAccountTestDB(t)
+	svc := newTestService(db)
+	svc.Lockout = LockoutPolicy{BackoffAfter: 2, BaseDelay: time.Minute, LockAfter: 3, LockDuration: time.Hour, ResetAfter: 24 * time.Hour}
+	r := newAccountRouter(svc)
+	session, _ := svc.CreateSession("user-1", "test@securesystem.email", ClientInfo{})
+
+	// A right password gives its attempt back
+	if totpAt(testTOTPSecret, 0) == "000000" {
+		t.Skip("Current code happens to be 000000")
+	}
+	doAccount(r, "DELETE", "/api/account", session.AccessToken, DeleteAccountRequest{"securepass123", "000000"})
+	if n := failureCount(db, "test@securesystem.email"); n != 0 {
+		t.Fatalf("Expected no failure counted for the right password, got %d", n)
+	}
+
+	// Wrong passwords on any account endpoint count as failed logins
+	doAccount(r, "POST", "/api/account/password", session.AccessToken, ChangePasswordRequest{"wrongpass123", "newpass12345", "newpass12345", totpAt(testTOTPSecret, 0)})
+	doAccount(r, "POST", "/api/account/totp/reenroll", session.AccessToken, ReenrollTOTPRequest{Password: "wrongpass123", TotpCode: totpAt(testTOTPSecret, 0)})
+	if n := failureCount(db, "test@securesystem.email"); n != 2 {
+		t.Fatalf("Expected 2 failures, got %d", n)
+	}
+	rr := doAccount(r, "DELETE", "/api/account", session.AccessToken, DeleteAccountRequest{"securepass123", totpAt(testTOTPSecret, 0)})
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected 429 with Retry-After while backing off, got %d", rr.Code)
+	}
+	var locked *LockoutError
+	if _, _, err := svc.Authenticate(context.Background(), "test@securesystem.email", "securepass123", totpAt(testTOTPSecret, 0), ClientInfo{}); !errors.As(err, &locked) {
+		t.Errorf("Expected login to share the backoff, got %v", err)
+	}
+}
+
+func TestReenrollTOTP(t *testing.T) {
+	db := newAccountTestDB(t)
+	svc := newTestService(db)
+	r := newAccountRouter(svc)
+	session, _ := svc.CreateSession("user-1", "test@securesystem.email", ClientInfo{})
+
+	if rr := doAccount(r, "POST", "/api/account/totp/reenroll", session.AccessToken,
+		ReenrollTOTPRequest{Password: "wrongpass123", TotpCode: totpAt(testTOTPSecret, 0)}); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for wrong password, got %d", rr.Code)
+	}
+
+	// A recovery code stands in for the lost authenticator
+	codes, _ := svc.GenerateRecoveryCodes("user-1")
+	rr := doAccount(r, "POST", "/api/account/totp/reenroll", session.AccessToken,
+		ReenrollTOTPRequest{Password: "securepass123", RecoveryCode: codes[0]})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var started SignUpResponse
+	json.NewDecoder(rr.Body).Decode(&started)
+	if started.TempID == "" || started.TotpQr == "" {
+		t.Fatalf("Expected temp ID and QR code, got %+v", started)
+	}
+
+	var newSecret string
+	db.QueryRow("SELECT totp_secret FROM totp_reenrollments WHERE temp_id = ?", started.TempID).Scan(&newSecret)
+
+	// The old secret keeps working until the new one is confirmed
+	if rr := doAccount(r, "POST", "/api/account/totp/reenroll/confirm", session.AccessToken,
+		VerifyTotpRequest{TempID: started.TempID, TotpCode: totpAt(testTOTPSecret, 0)}); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected old secret's code rejected at confirm, got %d", rr.Code)
+	}
+
+	rr = doAccount(r, "POST", "/api/account/totp/reenroll/confirm", session.AccessToken,
+		VerifyTotpRequest{TempID: started.TempID, TotpCode: totpAt(newSecret, 0)})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := doAccount(r, "GET", "/api/auth/me", session.AccessToken, nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected pre-change token rejected, got %d", rr.Code)
+	}
+
+	if _, _, err := svc.Authenticate(context.Background(), "test@securesystem.email", "securepass123", totpAt(testTOTPSecret, 1), ClientInfo{}); err == nil {
+		t.Error("Expected old TOTP secret rejected after re-enrollment")
+	}
+	if _, _, err := svc.Authenticate(context.Background(), "test@securesystem.email", "securepass123", totpAt(newSecret, 1), ClientInfo{}); err != nil {
+		t.Errorf("Expected login with new TOTP secret, got %v", err)
+	}
+}
+
+func TestDeleteAccountHandler(t *testing.T) {
+	db := newAccountTestDB(t)
+	svc := newTestService(db)
+	db.Exec("INSERT INTO users (id, email, password_hash, totp_secret) VALUES (?, ?, ?, ?)",
+		"user-2", "other@securesystem.email", "hash", testTOTPSecret)
+	db.Exec("INSERT INTO emails (id, sender_id, recipient_email, encrypted_content) VALUES ('e1', 'user-1', 'x@example.com', 'c')")
+	db.Exec("INSERT INTO emails (id, sender_id, recipient_email, encrypted_content) VALUES ('e2', 'user-2', 'y@example.com', 'c')")
+	db.Exec("INSERT INTO access_attempts (id, email_id, ip_address) VALUES ('a1', 'e1', '203.0.113.7')")
+	db.Exec("INSERT INTO folders (id, user_id, name) VALUES ('f1', 'user-1', 'Inbox')")
+	db.Exec("INSERT INTO email_folders (email_id, folder_id) VALUES ('e1', 'f1')")
+	svc.GenerateRecoveryCodes("user-1")
+
+	r := newAccountRouter(svc)
+	session, _ := svc.CreateSession("user-1", "test@securesystem.email", ClientInfo{})
+	if rr := doAccount(r, "DELETE", "/api/account", session.AccessToken,
+		DeleteAccountRequest{Password: "wrongpass123", TotpCode: totpAt(testTOTPSecret, 0)}); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for wrong password, got %d", rr.Code)
+	}
+
+	rr := doAccount(r, "DELETE", "/api/account", session.AccessToken,
+		DeleteAccountRequest{Password: "securepass123", TotpCode: totpAt(testTOTPSecret, 0)})
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	for _, q := range []string{
+		"SELECT COUNT(*) FROM users WHERE id = 'user-1'",
+		"SELECT COUNT(*) FROM emails WHERE sender_id = 'user-1'",
+		"SELECT COUNT(*) FROM access_attempts",
+		"SELECT COUNT(*) FROM folders",
+		"SELECT COUNT(*) FROM email_folders",
+		"SELECT COUNT(*) FROM sessions",
+		"SELECT COUNT(*) FROM recovery_codes",
+	} {
+		var n int
+		db.QueryRow(q).Scan(&n)
+		if n != 0 {
+			t.Errorf("Expected no rows left for %q, got %d", q, n)
+		}
+	}
+	var others int
+	db.QueryRow("SELECT COUNT(*) FROM emails WHERE sender_id = 'user-2'").Scan(&others)
+	if others != 1 {
+		t.Error("Expected other users' emails untouched")
+	}
+	if rr := doAccount(r, "GET", "/api/auth/me", session.AccessToken, nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected deleted user's token rejected, got %d", rr.Code)
+	}
+}
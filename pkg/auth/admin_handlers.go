@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"secure-email-mvp/pkg/audit"
+	"secure-email-mvp/pkg/logging"
+
+	"github.com/gorilla/mux"
+)
+
+type ListUsersResponse struct {
+	Users []UserSummary `json:"users"`
+	Total int           `json:"total"` // Matching users before limit and offset
+}
+
+type SetUserRoleRequest struct {
+	Role string `json:"role"`
+}
+
+type ResetTOTPResponse struct {
+	TotpSecret string `json:"totp_secret"`
+	TotpQr     string `json:"totp_qr"`
+}
+
+// adminError maps user administration errors to responses
+func adminError(w http.ResponseWriter, r *http.Request, action string, err error) {
+	switch err {
+	case ErrUserNotFound:
+		http.Error(w, `{"error":"User not found"}`, http.StatusNotFound)
+	case ErrSelfAdministration:
+		http.Error(w, `{"error":"Cannot change your own role or status"}`, http.StatusBadRequest)
+	default:
+		http.Error(w, `{"error":"Internal server error"}`, http.StatusInternalServerError)
+		logging.FromContext(r.Context()).Error(action+" failed", "error", err)
+	}
+}
+
+// auditAdmin records an administrator's action on userID, with detail as the
+// reason when it succeeded
+func (s *Service) auditAdmin(r *http.Request, action, userID, detail string, err error) {
+	e := audit.Event{Action: action, Target: userID, Reason: detail}
+	if id, ok := IdentityFromContext(r.Context()); ok {
+		e.ActorID, e.ActorEmail = id.UserID, id.Email
+	}
+	s.RecordAudit(r.Context(), s.clientInfo(r), auditOutcome(e, err))
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, action string, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logging.FromContext(r.Context()).Warn(action+" response failed", "error", err)
+	}
+}
+
+// ListUsersHandler lists users, filtered by the q, domain, role and disabled
+// query parameters and paged with limit and offset. It must run behind
+// RequirePermission(PermReadUsers).
+func ListUsersHandler(s *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		f := UserFilter{Query: q.Get("q"), Domain: q.Get("domain"), Role: q.Get("role")}
+		var err error
+		if v := q.Get("disabled"); v != "" {
+			var disabled bool
+			if disabled, err = strconv.ParseBool(v); err == nil {
+				f.Disabled = &disabled
+			}
+		}
+		if v := q.Get("limit"); v != "" && err == nil {
+			f.Limit, err = strconv.Atoi(v)
+		}
+		if v := q.Get("offset"); v != "" && err == nil {
+			f.Offset, err = strconv.Atoi(v)
+		}
+		if err != nil || (f.Role != "" && !ValidRole(f.Role)) {
+			http.Error(w, `{"error":"Invalid request"}`, http.StatusBadRequest)
+			return
+		}
+
+		users, total, err := s.ListUsers(f)
+		if err != nil {
+			adminError(w, r, "List users", err)
+			return
+		}
+		writeJSON(w, r, "List users", ListUsersResponse{Users: users, Total: total})
+	}
+}
+
+// GetUserHandler returns the user named by the {id} route variable. It must
+// run behind RequirePermission(PermReadUsers).
+func GetUserHandler(s *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, err := s.GetUser(mux.Vars(r)["id"])
+		if err != nil {
+			adminError(w, r, "Get user", err)
+			return
+		}
+		writeJSON(w, r, "Get user", u)
+	}
+}
+
+// SetUserRoleHandler changes the role of the user named by the {id} route
+// variable. Admins cannot change their own role, so one always remains. It
+// must run behind RequirePermission(PermManageUsers).
+func SetUserRoleHandler(s *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := IdentityFromContext(r.Context())
+		if !ok {
+			unauthorized(w)
+			return
+		}
+		var req SetUserRoleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !ValidRole(req.Role) {
+			http.Error(w, `{"error":"Role must be user, admin or auditor"}`, http.StatusBadRequest)
+			return
+		}
+
+		userID := mux.Vars(r)["id"]
+		err := ErrSelfAdministration
+		if userID != id.UserID {
+			err = s.SetUserRole(userID, req.Role)
+		}
+		s.auditAdmin(r, audit.ActionAdminSetRole, userID, "role "+req.Role, err)
+		if err != nil {
+			adminError(w, r, "Set role", err)
+			return
+		}
+		logging.FromContext(r.Context()).Info("User role changed", "user_id", userID, "role", req.Role, "actor_id", id.UserID)
+		u, err := s.GetUser(userID)
+		if err != nil {
+			adminError(w, r, "Set role", err)
+			return
+		}
+		writeJSON(w, r, "Set role", u)
+	}
+}
+
+// DisableUserHandler disables the user named by the {id} route variable and
+// signs them out everywhere. It must run behind RequirePermission(PermManageUsers).
+func DisableUserHandler(s *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := IdentityFromContext(r.Context())
+		if !ok {
+			unauthorized(w)
+			return
+		}
+		userID := mux.Vars(r)["id"]
+		err := ErrSelfAdministration
+		if userID != id.UserID {
+			err = s.DisableUser(userID, time.Now())
+		}
+		s.auditAdmin(r, audit.ActionAdminDisableUser, userID, "", err)
+		if err != nil {
+			adminError(w, r, "Disable user", err)
+			return
+		}
+		logging.FromContext(r.Context()).Info("User disabled", "user_id", userID, "actor_id", id.UserID)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// EnableUserHandler re-enables the user named by the {id} route variable. It
+// must run behind RequirePermission(PermManageUsers).
+func EnableUserHandler(s *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := mux.Vars(r)["id"]
+		err := s.EnableUser(userID)
+		s.auditAdmin(r, audit.ActionAdminEnableUser, userID, "", err)
+		if err != nil {
+			adminError(w, r, "Enable user", err)
+			return
+		}
+		if id, ok := IdentityFromContext(r.Context()); ok {
+			logging.FromContext(r.Context()).Info("User enabled", "user_id", userID, "actor_id", id.UserID)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ResetTOTPHandler gives the user named by the {id} route variable a new TOTP
+// secret and returns it once so it can be passed to them. It must run behind
+// RequirePermission(PermManageUsers).
+func ResetTOTPHandler(s *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := mux.Vars(r)["id"]
+		secret, qr, err := s.ResetTOTP(userID)
+		s.auditAdmin(r, audit.ActionAdminResetTOTP, userID, "", err)
+		if err != nil {
+			adminError(w, r, "Reset TOTP", err)
+			return
+		}
+		if id, ok := IdentityFromContext(r.Context()); ok {
+			logging.FromContext(r.Context()).Info("TOTP reset", "user_id", userID, "actor_id", id.UserID)
+		}
+		writeJSON(w, r, "Reset TOTP", ResetTOTPResponse{TotpSecret: secret, TotpQr: qr})
+	}
+}
+
+// UserStatsHandler reports user counts overall and per domain against its
+// cap. It must run behind RequirePermission(PermReadUsers).
+func UserStatsHandler(s *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stats, err := s.GetUserStats()
+		if err != nil {
+			adminError(w, r, "User stats", err)
+			return
+		}
+		writeJSON(w, r, "User stats", stats)
+	}
+}
@@ -0,0 +1,234 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"secure-email-mvp/pkg/store"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrUserNotFound is returned when a user ID does not exist
+	ErrUserNotFound = errors.New("user not found")
+	// ErrUserExists is returned when provisioning an address that already has a user
+	ErrUserExists = errors.New("user already exists")
+	// ErrSelfAdministration is returned when an admin tries to change their own role or disable themselves
+	ErrSelfAdministration = errors.New("cannot change your own role or status")
+)
+
+// UserSummary is a user as seen by administrators, without credentials
+type UserSummary struct {
+	ID         string     `json:"id"`
+	Email      string     `json:"email"`
+	Role       string     `json:"role"`
+	Disabled   bool       `json:"disabled"`
+	DisabledAt *time.Time `json:"disabled_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// UserFilter narrows ListUsers; zero fields match everything. Limit
+// defaults to 50 and is at most 200.
+type UserFilter = store.UserFilter
+
+// UserStats counts users overall and per hosted domain against its cap
+type UserStats struct {
+	Users    int            `json:"users"`
+	Disabled int            `json:"disabled"`
+	Roles    map[string]int `json:"roles"`
+	Domains  []Domain       `json:"domains"`
+}
+
+// summarize drops a user's credentials
+func summarize(u store.User) UserSummary {
+	sum := UserSummary{ID: u.ID, Email: u.Email, Role: u.Role, CreatedAt: u.CreatedAt}
+	if !u.DisabledAt.IsZero() {
+		t := u.DisabledAt
+		sum.Disabled, sum.DisabledAt = true, &t
+	}
+	return sum
+}
+
+// userError maps store.ErrNotFound to ErrUserNotFound
+func userError(err error) error {
+	if err == store.ErrNotFound {
+		return ErrUserNotFound
+	}
+	return err
+}
+
+// ListUsers returns the users matching f ordered by email, and how many match in total
+func (s *Service) ListUsers(f UserFilter) ([]UserSummary, int, error) {
+	if f.Limit <= 0 {
+		f.Limit = 50
+	}
+	if f.Limit > 200 {
+		f.Limit = 200
+	}
+	if f.Offset < 0 {
+		f.Offset = 0
+	}
+	list, total, err := s.Store.Users.List(f)
+	if err != nil {
+		return nil, 0, err
+	}
+	users := make([]UserSummary, 0, len(list))
+	for _, u := range list {
+		users = append(users, summarize(u))
+	}
+	return users, total, nil
+}
+
+// GetUser returns one user's summary
+func (s *Service) GetUser(userID string) (*UserSummary, error) {
+	u, err := s.Store.Users.ByID(userID)
+	if err != nil {
+		return nil, userError(err)
+	}
+	sum := summarize(u)
+	return &sum, nil
+}
+
+// GetUserByEmail returns the summary of the user with the given address
+func (s *Service) GetUserByEmail(email string) (*UserSummary, error) {
+	u, err := s.Store.Users.ByEmail(NormalizeEmail(email))
+	if err != nil {
+		return nil, userError(err)
+	}
+	sum := summarize(u)
+	return &sum, nil
+}
+
+// ProvisionUser creates an enrolled user with role at a hosted domain on an
+// operator's behalf, bypassing the domain's sign-up policy and cap. It returns
+// the new user's ID and TOTP secret, which must be handed to them.
+func (s *Service) ProvisionUser(email, password, role string) (userID, secret string, err error) {
+	email = NormalizeEmail(email)
+	if !ValidateAddress(email) {
+		return "", "", fmt.Errorf("invalid email format")
+	}
+	if !ValidatePassword(password) {
+		return "", "", fmt.Errorf("invalid password length")
+	}
+	if !ValidRole(role) {
+		return "", "", fmt.Errorf("invalid role %q", role)
+	}
+	if _, err := s.GetDomain(DomainOf(email)); err != nil {
+		return "", "", err
+	}
+	if _, err := s.Store.Users.ByEmail(email); err == nil {
+		return "", "", ErrUserExists
+	} else if err != store.ErrNotFound {
+		return "", "", err
+	}
+
+	passwordHash, err := HashPassword(password)
+	if err != nil {
+		return "", "", fmt.Errorf("password hashing error: %v", err)
+	}
+	if secret, err = GenerateTOTPSecret(); err != nil {
+		return "", "", err
+	}
+	userID = uuid.New().String()
+	err = s.Store.Users.Create(store.User{ID: userID, Email: email, PasswordHash: passwordHash, TOTPSecret: secret, Role: role})
+	if err == store.ErrExists {
+		return "", "", ErrUserExists
+	}
+	if err != nil {
+		return "", "", err
+	}
+	return userID, secret, nil
+}
+
+// DisableUser stops a user from signing in and revokes all their sessions.
+// Disabling an already disabled user keeps the original time.
+func (s *Service) DisableUser(userID string, now time.Time) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("database error: %v", err)
+	}
+	defer tx.Rollback()
+
+	repos := s.Store.WithTx(tx)
+	u, err := repos.Users.ByID(userID)
+	if err != nil {
+		return userError(err)
+	}
+	if u.DisabledAt.IsZero() {
+		if err := repos.Users.SetDisabled(userID, now); err != nil {
+			return userError(err)
+		}
+	}
+	if _, err := repos.Sessions.RevokeAll(userID, "", now); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("database commit error: %v", err)
+	}
+	return nil
+}
+
+// EnableUser lets a disabled user sign in again
+func (s *Service) EnableUser(userID string) error {
+	return userError(s.Store.Users.SetDisabled(userID, time.Time{}))
+}
+
+// ResetTOTP gives a user a new TOTP secret, for when they lost their
+// authenticator and their recovery codes. Pending re-enrollments are dropped
+// and every session is revoked. It returns the secret and a base64 PNG QR
+// code to hand to the user.
+func (s *Service) ResetTOTP(userID string) (secret, qr string, err error) {
+	u, err := s.Store.Users.ByID(userID)
+	if err != nil {
+		return "", "", userError(err)
+	}
+	issuer, err := s.totpIssuer(u.Email)
+	if err != nil {
+		return "", "", err
+	}
+	secret, qr, err = newTOTPEnrollment(issuer, u.Email)
+	if err != nil {
+		return "", "", err
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return "", "", fmt.Errorf("database error: %v", err)
+	}
+	defer tx.Rollback()
+	repos := s.Store.WithTx(tx)
+	if err := repos.Users.SetTOTPSecret(userID, secret); err != nil {
+		return "", "", userError(err)
+	}
+	if err := repos.Reenroll.DeleteUser(userID); err != nil {
+		return "", "", err
+	}
+	if _, err := repos.Sessions.RevokeAll(userID, "", time.Now()); err != nil {
+		return "", "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", "", fmt.Errorf("database commit error: %v", err)
+	}
+	return secret, qr, nil
+}
+
+// GetUserStats counts users by role and status, with each domain's count and cap
+func (s *Service) GetUserStats() (*UserStats, error) {
+	stats := &UserStats{Roles: map[string]int{RoleUser: 0, RoleAdmin: 0, RoleAuditor: 0}}
+	roles, disabled, err := s.Store.Users.CountRoles()
+	if err != nil {
+		return nil, err
+	}
+	for role, n := range roles {
+		stats.Roles[role] = n
+		stats.Users += n
+	}
+	stats.Disabled = disabled
+
+	if stats.Domains, err = s.ListDomains(); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
@@ -0,0 +1,270 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"secure-email-mvp/pkg/audit"
+
+	"github.com/gorilla/mux"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+func TestListUsers(t *testing.T) {
+	db := newAccountTestDB(t)
+	svc := newTestService(db)
+	for i := 2; i <= 5; i++ {
+		db.Exec("INSERT INTO users (id, email, password_hash, totp_secret) VALUES (?, ?, 'x', 'y')",
+			fmt.Sprintf("user-%d", i), fmt.Sprintf("member%d@example.org", i))
+	}
+	db.Exec("INSERT INTO users (id, email, password_hash, totp_secret) VALUES ('user-6', 'odd_name@example.org', 'x', 'y')")
+	svc.SetUserRole("user-2", RoleAuditor)
+	svc.DisableUser("user-3", time.Now())
+
+	yes := true
+	tests := []struct {
+		name   string
+		filter UserFilter
+		total  int
+	}{
+		{"All", UserFilter{}, 6},
+		{"Domain", UserFilter{Domain: "Example.org"}, 5},
+		{"Query", UserFilter{Query: "MEMBER"}, 4},
+		{"Underscore is literal", UserFilter{Query: "d_n"}, 1},
+		{"Role", UserFilter{Role: RoleAuditor}, 1},
+		{"Disabled", UserFilter{Disabled: &yes}, 1},
+	}
+	for _, tt := range tests {
+		users, total, err := svc.ListUsers(tt.filter)
+		if err != nil || total != tt.total || len(users) != tt.total {
+			t.Errorf("%s: expected %d users, got %d (%d listed) %v", tt.name, tt.total, total, len(users), err)
+		}
+	}
+
+	users, total, _ := svc.ListUsers(UserFilter{Domain: "example.org", Limit: 2, Offset: 2})
+	if total != 5 || len(users) != 2 || users[0].Email != "member4@example.org" {
+		t.Errorf("Expected second page sorted by email, got %d %+v", total, users)
+	}
+
+	u, err := svc.GetUser("user-3")
+	if err != nil || !u.Disabled || u.DisabledAt == nil || u.Role != RoleUser {
+		t.Errorf("Unexpected user %+v %v", u, err)
+	}
+	if _, err := svc.GetUser("missing"); err != ErrUserNotFound {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+}
+
+func TestDisableUser(t *testing.T) {
+	db := newAccountTestDB(t)
+	svc := newTestService(db)
+	r := newAccountRouter(svc)
+	tokens, _ := svc.CreateSession("user-1", "test@securesystem.email", ClientInfo{})
+
+	if err := svc.DisableUser("user-1", time.Now()); err != nil {
+		t.Fatal("DisableUser failed:", err)
+	}
+	if rr := doAccount(r, "GET", "/api/auth/me", tokens.AccessToken, nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected existing access token rejected, got %d", rr.Code)
+	}
+	if _, err := svc.RefreshSession(tokens.RefreshToken, ClientInfo{}); err == nil {
+		t.Error("Expected refresh rejected for disabled user")
+	}
+	if _, err := svc.CreateSession("user-1", "test@securesystem.email", ClientInfo{}); err != ErrAccountDisabled {
+		t.Errorf("Expected ErrAccountDisabled, got %v", err)
+	}
+	if sessions, _ := svc.ListSessions("user-1"); len(sessions) != 0 {
+		t.Errorf("Expected sessions revoked, got %d", len(sessions))
+	}
+
+	if err := svc.EnableUser("user-1"); err != nil {
+		t.Fatal("EnableUser failed:", err)
+	}
+	if _, err := svc.CreateSession("user-1", "test@securesystem.email", ClientInfo{}); err != nil {
+		t.Errorf("Expected sign-in allowed again, got %v", err)
+	}
+	if err := svc.DisableUser("missing", time.Now()); err != ErrUserNotFound {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+}
+
+func TestResetTOTP(t *testing.T) {
+	db := newAccountTestDB(t)
+	svc := newTestService(db)
+	tokens, _ := svc.CreateSession("user-1", "test@securesystem.email", ClientInfo{})
+
+	secret, qr, err := svc.ResetTOTP("user-1")
+	if err != nil || secret == testTOTPSecret || qr == "" {
+		t.Fatalf("Expected a new secret, got %q %v", secret, err)
+	}
+	if _, err := svc.RefreshSession(tokens.RefreshToken, ClientInfo{}); err == nil {
+		t.Error("Expected sessions revoked after reset")
+	}
+	code, _ := totp.GenerateCode(secret, time.Now())
+	if _, _, err := svc.Authenticate(context.Background(), "test@securesystem.email", "securepass123", code, ClientInfo{}); err != nil {
+		t.Errorf("Expected new secret accepted, got %v", err)
+	}
+	if _, _, err := svc.ResetTOTP("missing"); err != ErrUserNotFound {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+}
+
+func TestAdminHandlers(t *testing.T) {
+	db := newSignUpTestDB(t)
+	svc := newTestService(db)
+	hash, _ := HashPassword("securepass123")
+	db.Exec("INSERT INTO users (id, email, password_hash, totp_secret, role) VALUES ('admin-1', 'admin@securesystem.email', ?, ?, 'admin')", hash, testTOTPSecret)
+	db.Exec("INSERT INTO users (id, email, password_hash, totp_secret, role) VALUES ('audit-1', 'audit@securesystem.email', ?, ?, 'auditor')", hash, testTOTPSecret)
+	db.Exec("INSERT INTO users (id, email, password_hash, totp_secret) VALUES ('user-1', 'test@securesystem.email', ?, ?)", hash, testTOTPSecret)
+	admin, _ := svc.CreateSession("admin-1", "admin@securesystem.email", ClientInfo{})
+	auditor, _ := svc.CreateSession("audit-1", "audit@securesystem.email", ClientInfo{})
+	user, _ := svc.CreateSession("user-1", "test@securesystem.email", ClientInfo{})
+
+	r := mux.NewRouter()
+	r.Use(RequireAuth(svc))
+	r.Handle("/api/admin/stats", RequirePermission(PermReadUsers)(UserStatsHandler(svc))).Methods("GET")
+	r.Handle("/api/admin/users", RequirePermission(PermReadUsers)(ListUsersHandler(svc))).Methods("GET")
+	r.Handle("/api/admin/users/{id}", RequirePermission(PermReadUsers)(GetUserHandler(svc))).Methods("GET")
+	r.Handle("/api/admin/users/{id}/role", RequirePermission(PermManageUsers)(SetUserRoleHandler(svc))).Methods("PUT")
+	r.Handle("/api/admin/users/{id}/disable", RequirePermission(PermManageUsers)(DisableUserHandler(svc))).Methods("POST")
+	r.Handle("/api/admin/users/{id}/enable", RequirePermission(PermManageUsers)(EnableUserHandler(svc))).Methods("POST")
+	r.Handle("/api/admin/users/{id}/reset-totp", RequirePermission(PermManageUsers)(ResetTOTPHandler(svc))).Methods("POST")
+
+	// Auditors read; only admins write; plain users get neither
+	if rr := doAccount(r, "GET", "/api/admin/users", user.AccessToken, nil); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for plain user, got %d", rr.Code)
+	}
+	rr := doAccount(r, "GET", "/api/admin/users?role=user&q=test", auditor.AccessToken, nil)
+	var list ListUsersResponse
+	json.NewDecoder(rr.Body).Decode(&list)
+	if rr.Code != http.StatusOK || list.Total != 1 || list.Users[0].ID != "user-1" {
+		t.Errorf("Expected auditor to find user-1, got %d %+v", rr.Code, list)
+	}
+	if rr := doAccount(r, "GET", "/api/admin/users?limit=lots", auditor.AccessToken, nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for bad limit, got %d", rr.Code)
+	}
+	if rr := doAccount(r, "POST", "/api/admin/users/user-1/disable", auditor.AccessToken, nil); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for auditor disabling a user, got %d", rr.Code)
+	}
+
+	rr = doAccount(r, "GET", "/api/admin/stats", auditor.AccessToken, nil)
+	var stats UserStats
+	json.NewDecoder(rr.Body).Decode(&stats)
+	if stats.Users != 3 || stats.Roles[RoleAdmin] != 1 || len(stats.Domains) != 1 || stats.Domains[0].Users != 3 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	// Admins cannot demote or disable themselves
+	if rr := doAccount(r, "PUT", "/api/admin/users/admin-1/role", admin.AccessToken, SetUserRoleRequest{Role: RoleUser}); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for own role, got %d", rr.Code)
+	}
+	if rr := doAccount(r, "POST", "/api/admin/users/admin-1/disable", admin.AccessToken, nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for disabling self, got %d", rr.Code)
+	}
+	if rr := doAccount(r, "PUT", "/api/admin/users/user-1/role", admin.AccessToken, SetUserRoleRequest{Role: "root"}); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for unknown role, got %d", rr.Code)
+	}
+	if rr := doAccount(r, "PUT", "/api/admin/users/missing/role", admin.AccessToken, SetUserRoleRequest{Role: RoleAuditor}); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", rr.Code)
+	}
+	rr = doAccount(r, "PUT", "/api/admin/users/user-1/role", admin.AccessToken, SetUserRoleRequest{Role: RoleAuditor})
+	var u UserSummary
+	json.NewDecoder(rr.Body).Decode(&u)
+	if rr.Code != http.StatusOK || u.Role != RoleAuditor {
+		t.Errorf("Expected role changed, got %d %+v", rr.Code, u)
+	}
+
+	if rr := doAccount(r, "POST", "/api/admin/users/user-1/disable", admin.AccessToken, nil); rr.Code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %d", rr.Code)
+	}
+	if rr := doAccount(r, "GET", "/api/admin/users", user.AccessToken, nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected disabled user signed out, got %d", rr.Code)
+	}
+	if rr := doAccount(r, "POST", "/api/admin/users/user-1/enable", admin.AccessToken, nil); rr.Code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %d", rr.Code)
+	}
+
+	rr = doAccount(r, "POST", "/api/admin/users/user-1/reset-totp", admin.AccessToken, nil)
+	var reset ResetTOTPResponse
+	json.NewDecoder(rr.Body).Decode(&reset)
+	if rr.Code != http.StatusOK || reset.TotpSecret == "" || reset.TotpQr == "" {
+		t.Errorf("Expected new TOTP secret, got %d %+v", rr.Code, reset)
+	}
+
+	// Every change, and every refused one, is audited with the admin as actor
+	events, _ := svc.Audit.List(audit.Filter{Actor: "admin-1"})
+	want := []struct{ action, target, outcome string }{
+		{audit.ActionAdminResetTOTP, "user-1", audit.OutcomeSuccess},
+		{audit.ActionAdminEnableUser, "user-1", audit.OutcomeSuccess},
+		{audit.ActionAdminDisableUser, "user-1", audit.OutcomeSuccess},
+		{audit.ActionAdminSetRole, "user-1", audit.OutcomeSuccess},
+		{audit.ActionAdminSetRole, "missing", audit.OutcomeFailure},
+		{audit.ActionAdminDisableUser, "admin-1", audit.OutcomeFailure},
+		{audit.ActionAdminSetRole, "admin-1", audit.OutcomeFailure},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("Expected %d admin events, got %+v", len(want), events)
+	}
+	for i, w := range want {
+		if e := events[i]; e.Action != w.action || e.Target != w.target || e.Outcome != w.outcome || e.ActorEmail != "admin@securesystem.email" {
+			t.Errorf("Event %d: expected %+v, got %+v", i, w, e)
+		}
+	}
+	if events[3].Reason != "role auditor" {
+		t.Errorf("Expected the new role recorded, got %q", events[3].Reason)
+	}
+}
+
+func TestProvisionUser(t *testing.T) {
+	db := newSignUpTestDB(t)
+	svc := newTestService(db)
+	svc.CreateDomain(&Domain{Name: "example.org", SignUp: SignUpClosed, MaxUsers: 1, TOTPIssuer: "Example Mail"})
+
+	// Operators are not bound by the domain's sign-up policy
+	userID, secret, err := svc.ProvisionUser("ops@example.org", "securepass123", RoleAdmin)
+	if err != nil {
+		t.Fatal("ProvisionUser failed:", err)
+	}
+	u, err := svc.GetUserByEmail("OPS@example.org")
+	if err != nil || u.ID != userID || u.Role != RoleAdmin {
+		t.Errorf("Expected provisioned admin, got %+v %v", u, err)
+	}
+	code, _ := totp.GenerateCode(secret, time.Now())
+	if _, _, err := svc.Authenticate(context.Background(), "ops@example.org", "securepass123", code, ClientInfo{}); err != nil {
+		t.Errorf("Expected provisioned user to log in, got %v", err)
+	}
+
+	uri, err := svc.TOTPURI("ops@example.org", secret)
+	if err != nil {
+		t.Fatal("TOTPURI failed:", err)
+	}
+	key, err := otp.NewKeyFromURL(uri)
+	if err != nil || key.Secret() != secret || key.Issuer() != "Example Mail" || key.AccountName() != "ops@example.org" {
+		t.Errorf("Unexpected provisioning URI %q %v", uri, err)
+	}
+
+	tests := []struct {
+		name, email, password, role string
+	}{
+		{"Existing user", "Ops@example.org", "securepass123", RoleUser},
+		{"Unhosted domain", "a@unhosted.example", "securepass123", RoleUser},
+		{"Short password", "b@example.org", "short", RoleUser},
+		{"Unknown role", "b@example.org", "securepass123", "root"},
+	}
+	for _, tt := range tests {
+		if _, _, err := svc.ProvisionUser(tt.email, tt.password, tt.role); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+	if _, _, err := svc.ProvisionUser("ops@example.org", "securepass123", RoleUser); err != ErrUserExists {
+		t.Errorf("Expected ErrUserExists, got %v", err)
+	}
+	if _, err := svc.GetUserByEmail("nobody@example.org"); err != ErrUserNotFound {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+}
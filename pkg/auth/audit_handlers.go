@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"secure-email-mvp/pkg/audit"
+	"secure-email-mvp/pkg/logging"
+)
+
+type AuditResponse struct {
+	Events []audit.Event `json:"events"`
+	Next   int64         `json:"next,omitempty"` // Pass as before for the next page; absent on the last
+}
+
+type AuditVerifyResponse struct {
+	Valid    bool   `json:"valid"`
+	Checked  int    `json:"checked"`
+	BrokenAt int64  `json:"broken_at,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// RecordAudit appends e to the audit log with the client's IP and user agent.
+// Auditing never fails the action itself, so errors are only logged.
+func (s *Service) RecordAudit(ctx context.Context, client ClientInfo, e audit.Event) {
+	e.IP, e.UserAgent = client.IP, client.UserAgent
+	if err := s.Audit.Record(&e); err != nil {
+		logging.FromContext(ctx).Error("Recording audit event failed", "action", e.Action, "error", err)
+	}
+}
+
+// auditOutcome fills in e's outcome from err, with err as the reason it failed
+func auditOutcome(e audit.Event, err error) audit.Event {
+	e.Outcome = audit.OutcomeSuccess
+	if err != nil {
+		e.Outcome, e.Reason = audit.OutcomeFailure, err.Error()
+	}
+	return e
+}
+
+// auditFilter reads the paging and filter parameters shared by the audit
+// endpoints: action, outcome, since and until (RFC 3339), before and limit
+func auditFilter(r *http.Request) (audit.Filter, error) {
+	q := r.URL.Query()
+	f := audit.Filter{Action: q.Get("action"), Outcome: q.Get("outcome")}
+	if f.Outcome != "" && f.Outcome != audit.OutcomeSuccess && f.Outcome != audit.OutcomeFailure {
+		return f, errors.New("invalid outcome")
+	}
+	var err error
+	if v := q.Get("since"); v != "" {
+		if f.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, err
+		}
+	}
+	if v := q.Get("until"); v != "" {
+		if f.Until, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, err
+		}
+	}
+	if v := q.Get("before"); v != "" {
+		if f.Before, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return f, err
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil {
+			return f, err
+		}
+	}
+	return f, nil
+}
+
+// writeAuditEvents lists the events matching f, with the cursor for the next
+// page when there may be more
+func writeAuditEvents(w http.ResponseWriter, r *http.Request, s *Service, action string, f audit.Filter) {
+	if f.Limit <= 0 {
+		f.Limit = audit.DefaultLimit
+	}
+	if f.Limit > audit.MaxLimit {
+		f.Limit = audit.MaxLimit
+	}
+	events, err := s.Audit.List(f)
+	if err != nil {
+		http.Error(w, `{"error":"Internal server error"}`, http.StatusInternalServerError)
+		logging.FromContext(r.Context()).Error(action+" failed", "error", err)
+		return
+	}
+	resp := AuditResponse{Events: events}
+	if len(events) == f.Limit {
+		resp.Next = events[len(events)-1].ID
+	}
+	writeJSON(w, r, action, resp)
+}
+
+// AuditHandler lists audit events, newest first, filtered by the actor,
+// target, action, outcome, since and until query parameters and paged with
+// before and limit. It must run behind RequirePermission(PermReadAudit).
+func AuditHandler(s *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f, err := auditFilter(r)
+		if err != nil {
+			http.Error(w, `{"error":"Invalid request"}`, http.StatusBadRequest)
+			return
+		}
+		f.Actor, f.Target = r.URL.Query().Get("actor"), r.URL.Query().Get("target")
+		writeAuditEvents(w, r, s, "List audit events", f)
+	}
+}
+
+// AuditVerifyHandler checks the audit log's hash chain. It must run behind
+// RequirePermission(PermReadAudit).
+func AuditVerifyHandler(s *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		checked, err := s.Audit.Verify()
+		resp := AuditVerifyResponse{Valid: err == nil, Checked: checked}
+		var chainErr *audit.ChainError
+		if errors.As(err, &chainErr) {
+			resp.BrokenAt, resp.Reason = chainErr.ID, chainErr.Reason
+			logging.FromContext(r.Context()).Error("Audit log verification failed", "error", err)
+		} else if err != nil {
+			http.Error(w, `{"error":"Internal server error"}`, http.StatusInternalServerError)
+			logging.FromContext(r.Context()).Error("Verify audit log failed", "error", err)
+			return
+		}
+		writeJSON(w, r, "Verify audit log", resp)
+	}
+}
+
+// AccountActivityHandler lists the caller's own audit events: what they did
+// and what was done to their account, including failed logins. It takes the
+// same parameters as AuditHandler except actor and target, and must run
+// behind RequireAuth.
+func AccountActivityHandler(s *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := IdentityFromContext(r.Context())
+		if !ok {
+			unauthorized(w)
+			return
+		}
+		f, err := auditFilter(r)
+		if err != nil {
+			http.Error(w, `{"error":"Invalid request"}`, http.StatusBadRequest)
+			return
+		}
+		f.User = id.UserID
+		writeAuditEvents(w, r, s, "Account activity", f)
+	}
+}
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"secure-email-mvp/pkg/audit"
+
+	"github.com/gorilla/mux"
+)
+
+func TestLoginAudited(t *testing.T) {
+	db := newAccountTestDB(t)
+	svc := newTestService(db)
+	client := ClientInfo{IP: "203.0.113.7", UserAgent: "test-agent"}
+	svc.Authenticate(context.Background(), "test@securesystem.email", "wrongpass123", totpAt(testTOTPSecret, 0), client)
+	svc.Authenticate(context.Background(), "nobody@securesystem.email", "wrongpass123", totpAt(testTOTPSecret, 0), client)
+	if _, _, err := svc.Authenticate(context.Background(), "test@securesystem.email", "securepass123", totpAt(testTOTPSecret, 0), client); err != nil {
+		t.Fatal("Authenticate failed:", err)
+	}
+
+	events, _ := svc.Audit.List(audit.Filter{Action: audit.ActionLogin})
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 login events, got %d", len(events))
+	}
+	ok, unknown, wrong := events[0], events[1], events[2]
+	if ok.Outcome != audit.OutcomeSuccess || ok.ActorID != "user-1" || ok.Target != "user-1" || ok.IP != "203.0.113.7" || ok.UserAgent != "test-agent" {
+		t.Errorf("Unexpected success event %+v", ok)
+	}
+	if wrong.Outcome != audit.OutcomeFailure || wrong.ActorID != "" || wrong.Target != "user-1" || wrong.Reason != ErrInvalidCredentials.Error() {
+		t.Errorf("Expected failure against user-1, got %+v", wrong)
+	}
+	if unknown.Target != "" || unknown.ActorEmail != "nobody@securesystem.email" {
+		t.Errorf("Expected failure for unknown email without target, got %+v", unknown)
+	}
+}
+
+func TestAuditHandlers(t *testing.T) {
+	db := newAccountTestDB(t)
+	svc := newTestService(db)
+	hash, _ := HashPassword("securepass123")
+	db.Exec("INSERT INTO users (id, email, password_hash, totp_secret, role) VALUES ('audit-1', 'audit@securesystem.email', ?, ?, 'auditor')", hash, testTOTPSecret)
+	auditor, _ := svc.CreateSession("audit-1", "audit@securesystem.email", ClientInfo{})
+	user, _ := svc.CreateSession("user-1", "test@securesystem.email", ClientInfo{})
+	svc.Authenticate(context.Background(), "test@securesystem.email", "wrongpass123", totpAt(testTOTPSecret, 0), ClientInfo{})
+	svc.Authenticate(context.Background(), "audit@securesystem.email", "wrongpass123", totpAt(testTOTPSecret, 0), ClientInfo{})
+	svc.Authenticate(context.Background(), "audit@securesystem.email", "wrongpass123", totpAt(testTOTPSecret, 0), ClientInfo{})
+
+	r := mux.NewRouter()
+	r.Use(RequireAuth(svc))
+	r.Handle("/api/audit", RequirePermission(PermReadAudit)(AuditHandler(svc))).Methods("GET")
+	r.Handle("/api/audit/verify", RequirePermission(PermReadAudit)(AuditVerifyHandler(svc))).Methods("GET")
+	r.HandleFunc("/api/account/activity", AccountActivityHandler(svc)).Methods("GET")
+
+	if rr := doAccount(r, "GET", "/api/audit", user.AccessToken, nil); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for plain user, got %d", rr.Code)
+	}
+	rr := doAccount(r, "GET", "/api/audit?target=audit-1&limit=1", auditor.AccessToken, nil)
+	var page AuditResponse
+	json.NewDecoder(rr.Body).Decode(&page)
+	if rr.Code != http.StatusOK || len(page.Events) != 1 || page.Events[0].ID != 3 || page.Next != 3 {
+		t.Fatalf("Expected newest event for audit-1 and a cursor, got %d %+v", rr.Code, page)
+	}
+	rr = doAccount(r, "GET", "/api/audit?target=audit-1&limit=1&before=3", auditor.AccessToken, nil)
+	page = AuditResponse{}
+	json.NewDecoder(rr.Body).Decode(&page)
+	if len(page.Events) != 1 || page.Events[0].ID != 2 {
+		t.Errorf("Expected next page to hold event 2, got %+v", page)
+	}
+	if rr := doAccount(r, "GET", "/api/audit?since=yesterday", auditor.AccessToken, nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for bad since, got %d", rr.Code)
+	}
+
+	rr = doAccount(r, "GET", "/api/audit/verify", auditor.AccessToken, nil)
+	var verify AuditVerifyResponse
+	json.NewDecoder(rr.Body).Decode(&verify)
+	if !verify.Valid || verify.Checked != 3 {
+		t.Errorf("Expected valid chain of 3, got %+v", verify)
+	}
+
+	// Users see only events about their own account, whatever they ask for
+	rr = doAccount(r, "GET", "/api/account/activity?target=audit-1", user.AccessToken, nil)
+	var own AuditResponse
+	json.NewDecoder(rr.Body).Decode(&own)
+	if rr.Code != http.StatusOK || len(own.Events) != 1 || own.Events[0].Target != "user-1" || own.Next != 0 {
+		t.Errorf("Expected only user-1's failed login, got %d %+v", rr.Code, own)
+	}
+}
@@ -1,16 +1,58 @@
 package auth
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"secure-email-mvp/pkg/migrate"
+	"secure-email-mvp/pkg/store/sqlite"
+
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/pquerna/otp/totp"
 	"golang.org/x/crypto/argon2"
 )
 
+// testKeyring signs the tokens of every test Service
+var testKeyring *Keyring
+
+// testAuditKey chains the audit log of every test Service
+var testAuditKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestMain(m *testing.M) {
+	keyring, err := NewKeyring()
+	if err != nil {
+		panic(err)
+	}
+	testKeyring = keyring
+	os.Exit(m.Run())
+}
+
+// newTestService returns a Service on db with the default settings
+func newTestService(db *sql.DB) *Service {
+	return NewService(db, sqlite.Dialect, testKeyring, testAuditKey)
+}
+
+// newTestDB returns an in-memory database with every migration applied
+func newTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal("Failed to open database:", err)
+	}
+	// Each connection to :memory: is a separate database
+	db.SetMaxOpenConns(1)
+	if _, err := migrate.Up(db); err != nil {
+		t.Fatal("Failed to migrate database:", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
 func TestValidateEmail(t *testing.T) {
 	tests := []struct {
 		email string
@@ -32,6 +74,23 @@ func TestValidateEmail(t *testing.T) {
 	}
 }
 
+func TestNormalizeEmail(t *testing.T) {
+	tests := []struct {
+		email, want string
+	}{
+		{"bob@securesystem.email", "bob@securesystem.email"},
+		{"bob@SecureSystem.Email", "bob@securesystem.email"},
+		{" Bob@SECURESYSTEM.EMAIL ", "Bob@securesystem.email"},
+		{"invalid-email", "invalid-email"},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizeEmail(tt.email); got != tt.want {
+			t.Errorf("NormalizeEmail(%q) = %q, want %q", tt.email, got, tt.want)
+		}
+	}
+}
+
 func TestValidatePassword(t *testing.T) {
 	tests := []struct {
 		password string
@@ -39,7 +98,7 @@ func TestValidatePassword(t *testing.T) {
 	}{
 		{"short", false},
 		{"validpass", true},
-		{"verylongpassword" + string(make([]byte, 100)), false},
+		{"verylongpassword" + strings.Repeat("x", 120), false},
 		{"12345678", true},
 		{"", false},
 	}
@@ -77,19 +136,22 @@ func TestHashPassword(t *testing.T) {
 	password := "testpassword123"
 	email := "test@securesystem.email"
 
-	hash, err := HashPassword(password, email)
+	hash, err := HashPassword(password)
 	if err != nil {
 		t.Fatalf("HashPassword failed: %v", err)
 	}
 
-	if hash == "" {
-		t.Error("HashPassword returned empty string")
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=65536,t=1,p=4$") {
+		t.Errorf("HashPassword returned unexpected format: %s", hash)
 	}
 
 	// Verify the hash can be used for verification
-	expectedHash := argon2.IDKey([]byte(password), []byte(email), 1, 64*1024, 4, 32)
-	if hash != string(expectedHash) {
-		t.Error("HashPassword result doesn't match expected Argon2 hash")
+	ok, needsRehash, err := VerifyPassword(password, email, hash)
+	if err != nil || !ok {
+		t.Errorf("VerifyPassword failed for fresh hash: ok=%v err=%v", ok, err)
+	}
+	if needsRehash {
+		t.Error("Fresh hash should not need rehash")
 	}
 }
 
@@ -110,21 +172,9 @@ func TestGenerateTOTPSecret(t *testing.T) {
 }
 
 func TestAuthenticate(t *testing.T) {
-	// Set JWT_SECRET for tests
-	os.Setenv("JWT_SECRET", "test-secret-32-bytes-1234567890ab")
-
 	// Setup in-memory SQLite
-	db, err := sql.Open("sqlite3", ":memory:")
-	if err != nil {
-		t.Fatal("Failed to open database:", err)
-	}
-	defer db.Close()
-
-	// Create users table
-	_, err = db.Exec(`CREATE TABLE users (id TEXT PRIMARY KEY, email TEXT UNIQUE, password_hash TEXT, totp_secret TEXT)`)
-	if err != nil {
-		t.Fatal("Failed to create table:", err)
-	}
+	db := newTestDB(t)
+	svc := newTestService(db)
 
 	// Insert test user
 	email := "test@securesystem.email"
@@ -132,7 +182,7 @@ func TestAuthenticate(t *testing.T) {
 	totpSecret, _ := totp.Generate(totp.GenerateOpts{Issuer: "SecureEmail", AccountName: email})
 	hash := argon2.IDKey([]byte(password), []byte(email), 1, 64*1024, 4, 32)
 	id := "test-user-id"
-	_, err = db.Exec("INSERT INTO users (id, email, password_hash, totp_secret) VALUES (?, ?, ?, ?)",
+	_, err := db.Exec("INSERT INTO users (id, email, password_hash, totp_secret) VALUES (?, ?, ?, ?)",
 		id, email, string(hash), totpSecret.Secret())
 	if err != nil {
 		t.Fatal("Failed to insert user:", err)
@@ -142,61 +192,76 @@ func TestAuthenticate(t *testing.T) {
 	totpCode, _ := totp.GenerateCode(totpSecret.Secret(), time.Now())
 
 	// Test successful authentication
-	token, userID, err := Authenticate(db, email, password, totpCode)
+	token, userID, err := svc.Authenticate(context.Background(), email, password, totpCode, ClientInfo{})
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
 	}
 	if userID != id {
 		t.Errorf("Expected userID %s, got %s", id, userID)
 	}
-	if token == "" {
-		t.Error("Expected non-empty JWT token")
+	if token == nil || token.AccessToken == "" || token.RefreshToken == "" {
+		t.Error("Expected non-empty access and refresh tokens")
 	}
 
 	// Test invalid password
-	_, _, err = Authenticate(db, email, "wrongpass", totpCode)
+	_, _, err = svc.Authenticate(context.Background(), email, "wrongpass", totpCode, ClientInfo{})
 	if err == nil {
 		t.Error("Expected error for invalid password")
 	}
 
 	// Test invalid TOTP
-	_, _, err = Authenticate(db, email, password, "000000")
+	_, _, err = svc.Authenticate(context.Background(), email, password, "000000", ClientInfo{})
 	if err == nil {
 		t.Error("Expected error for invalid TOTP")
 	}
 
 	// Test invalid email
-	_, _, err = Authenticate(db, "invalid@example.com", password, totpCode)
+	_, _, err = svc.Authenticate(context.Background(), "invalid@example.com", password, totpCode, ClientInfo{})
 	if err == nil {
 		t.Error("Expected error for invalid email")
 	}
 
 	// Test non-existent user
-	_, _, err = Authenticate(db, "nonexistent@securesystem.email", password, totpCode)
+	_, _, err = svc.Authenticate(context.Background(), "nonexistent@securesystem.email", password, totpCode, ClientInfo{})
 	if err == nil {
 		t.Error("Expected error for non-existent user")
 	}
 }
 
-func TestCreateUser(t *testing.T) {
-	// Setup in-memory SQLite
-	db, err := sql.Open("sqlite3", ":memory:")
-	if err != nil {
-		t.Fatal("Failed to open database:", err)
+func TestLoginResult(t *testing.T) {
+	tests := []struct {
+		err       error
+		knownUser bool
+		want      string
+	}{
+		{nil, true, "success"},
+		{ErrInvalidCredentials, false, "unknown_user"},
+		{ErrInvalidCredentials, true, "bad_password"},
+		{ErrTOTPReplayed, true, "bad_totp"},
+		{ErrRecoveryCodeInvalid, true, "bad_recovery_code"},
+		{&LockoutError{RetryAfter: time.Minute}, false, "locked"},
+		{fmt.Errorf("password verification error: %w", ErrHashPoolFull), false, "busy"},
+		{ErrAccountDisabled, true, "disabled"},
+		{errors.New("passkey does not belong to this user"), true, "bad_second_factor"},
+		{errors.New("invalid email format"), false, "other"},
 	}
-	defer db.Close()
-
-	// Create users table
-	_, err = db.Exec(`CREATE TABLE users (id TEXT PRIMARY KEY, email TEXT UNIQUE, password_hash TEXT, totp_secret TEXT)`)
-	if err != nil {
-		t.Fatal("Failed to create table:", err)
+	for _, tt := range tests {
+		if got := loginResult(tt.err, tt.knownUser); got != tt.want {
+			t.Errorf("loginResult(%v, %v) = %q, want %q", tt.err, tt.knownUser, got, tt.want)
+		}
 	}
+}
+
+func TestCreateUser(t *testing.T) {
+	// Setup in-memory SQLite
+	db := newTestDB(t)
+	svc := newTestService(db)
 
 	// Test successful user creation
 	email := "newuser@securesystem.email"
 	password := "newpass123"
 
-	userID, totpSecret, err := CreateUser(db, email, password)
+	userID, totpSecret, err := svc.CreateUser(email, password)
 	if err != nil {
 		t.Fatalf("CreateUser failed: %v", err)
 	}
@@ -225,61 +290,62 @@ func TestCreateUser(t *testing.T) {
 		t.Errorf("Expected TOTP secret %s, got %s", totpSecret, storedTOTP)
 	}
 
-	// Test duplicate user creation
-	_, _, err = CreateUser(db, email, password)
+	// Test duplicate user creation, also when the domain differs in case
+	_, _, err = svc.CreateUser(email, password)
 	if err == nil {
 		t.Error("Expected error for duplicate user")
 	}
+	if _, _, err = svc.CreateUser("newuser@SecureSystem.Email", password); err == nil {
+		t.Error("Expected error for duplicate user with upper-case domain")
+	}
+
+	// The domain is stored in lower case
+	mixedID, _, err := svc.CreateUser("Other@SecureSystem.Email", password)
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	if u, _ := svc.GetUser(mixedID); u == nil || u.Email != "Other@securesystem.email" {
+		t.Errorf("Expected stored email Other@securesystem.email, got %+v", u)
+	}
 
 	// Test invalid email
-	_, _, err = CreateUser(db, "invalid@example.com", password)
+	_, _, err = svc.CreateUser("invalid@example.com", password)
 	if err == nil {
 		t.Error("Expected error for invalid email")
 	}
 
 	// Test invalid password
-	_, _, err = CreateUser(db, "valid@securesystem.email", "short")
+	_, _, err = svc.CreateUser("valid@securesystem.email", "short")
 	if err == nil {
 		t.Error("Expected error for invalid password")
 	}
 }
 
 func TestValidateJWT(t *testing.T) {
-	// Set JWT_SECRET for tests
-	os.Setenv("JWT_SECRET", "test-secret-32-bytes-1234567890ab")
-
 	// Test with valid JWT (we'll create one using the auth package)
-	db, err := sql.Open("sqlite3", ":memory:")
-	if err != nil {
-		t.Fatal("Failed to open database:", err)
-	}
-	defer db.Close()
+	db := newTestDB(t)
+	svc := newTestService(db)
 
 	// Create a user and authenticate to get a valid JWT
-	_, err = db.Exec(`CREATE TABLE users (id TEXT PRIMARY KEY, email TEXT UNIQUE, password_hash TEXT, totp_secret TEXT)`)
-	if err != nil {
-		t.Fatal("Failed to create table:", err)
-	}
-
 	email := "test@securesystem.email"
 	password := "securepass123"
 	totpSecret, _ := totp.Generate(totp.GenerateOpts{Issuer: "SecureEmail", AccountName: email})
 	hash := argon2.IDKey([]byte(password), []byte(email), 1, 64*1024, 4, 32)
 	id := "test-user-id"
-	_, err = db.Exec("INSERT INTO users (id, email, password_hash, totp_secret) VALUES (?, ?, ?, ?)",
+	_, err := db.Exec("INSERT INTO users (id, email, password_hash, totp_secret) VALUES (?, ?, ?, ?)",
 		id, email, string(hash), totpSecret.Secret())
 	if err != nil {
 		t.Fatal("Failed to insert user:", err)
 	}
 
 	totpCode, _ := totp.GenerateCode(totpSecret.Secret(), time.Now())
-	token, _, err := Authenticate(db, email, password, totpCode)
+	token, _, err := svc.Authenticate(context.Background(), email, password, totpCode, ClientInfo{})
 	if err != nil {
 		t.Fatalf("Authentication failed: %v", err)
 	}
 
 	// Test valid JWT
-	userID, userEmail, err := ValidateJWT(token)
+	userID, userEmail, err := testKeyring.ValidateJWT(token.AccessToken)
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
 	}
@@ -291,13 +357,13 @@ func TestValidateJWT(t *testing.T) {
 	}
 
 	// Test invalid JWT
-	_, _, err = ValidateJWT("invalid.jwt.token")
+	_, _, err = testKeyring.ValidateJWT("invalid.jwt.token")
 	if err == nil {
 		t.Error("Expected error for invalid JWT")
 	}
 
 	// Test empty JWT
-	_, _, err = ValidateJWT("")
+	_, _, err = testKeyring.ValidateJWT("")
 	if err == nil {
 		t.Error("Expected error for empty JWT")
 	}
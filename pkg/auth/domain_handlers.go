@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"secure-email-mvp/pkg/logging"
+
+	"github.com/gorilla/mux"
+)
+
+type ListDomainsResponse struct {
+	Domains []Domain `json:"domains"`
+}
+
+// UpdateDomainRequest changes only the fields that are present
+type UpdateDomainRequest struct {
+	SignUp     *string `json:"signup"`
+	MaxUsers   *int    `json:"max_users"`
+	TOTPIssuer *string `json:"totp_issuer"`
+}
+
+// domainError maps domain store errors to responses
+func domainError(w http.ResponseWriter, r *http.Request, action string, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidDomain):
+		http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), http.StatusBadRequest)
+	case err == ErrDomainNotFound:
+		http.Error(w, `{"error":"Domain not found"}`, http.StatusNotFound)
+	case err == ErrDomainExists:
+		http.Error(w, `{"error":"Domain already exists"}`, http.StatusConflict)
+	case err == ErrDomainInUse:
+		http.Error(w, `{"error":"Domain has users; close sign-up instead"}`, http.StatusConflict)
+	default:
+		http.Error(w, `{"error":"Internal server error"}`, http.StatusInternalServerError)
+		logging.FromContext(r.Context()).Error(action+" failed", "error", err)
+	}
+}
+
+func writeDomain(w http.ResponseWriter, r *http.Request, status int, d *Domain) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(d); err != nil {
+		logging.FromContext(r.Context()).Warn("Domain response failed", "error", err)
+	}
+}
+
+// ListDomainsHandler lists every hosted domain. It must run behind
+// RequirePermission(PermReadDomains).
+func ListDomainsHandler(s *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		domains, err := s.ListDomains()
+		if err != nil {
+			domainError(w, r, "List domains", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(ListDomainsResponse{Domains: domains}); err != nil {
+			logging.FromContext(r.Context()).Warn("List domains response failed", "error", err)
+		}
+	}
+}
+
+// CreateDomainHandler starts hosting a domain. Sign-up defaults to open and
+// the TOTP issuer to DefaultTOTPIssuer. It must run behind
+// RequirePermission(PermManageDomains).
+func CreateDomainHandler(s *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d := Domain{SignUp: SignUpOpen}
+		if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+			http.Error(w, `{"error":"Invalid request"}`, http.StatusBadRequest)
+			return
+		}
+		if err := s.CreateDomain(&d); err != nil {
+			domainError(w, r, "Create domain", err)
+			return
+		}
+		d.Users = 0
+		if id, ok := IdentityFromContext(r.Context()); ok {
+			logging.FromContext(r.Context()).Info("Domain created", "domain", d.Name, "actor_id", id.UserID)
+		}
+		writeDomain(w, r, http.StatusCreated, &d)
+	}
+}
+
+// UpdateDomainHandler changes the domain named by the {name} route variable.
+// It must run behind RequirePermission(PermManageDomains).
+func UpdateDomainHandler(s *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req UpdateDomainRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, `{"error":"Invalid request"}`, http.StatusBadRequest)
+			return
+		}
+
+		d, err := s.GetDomain(mux.Vars(r)["name"])
+		if err != nil {
+			domainError(w, r, "Update domain", err)
+			return
+		}
+		if req.SignUp != nil {
+			d.SignUp = *req.SignUp
+		}
+		if req.MaxUsers != nil {
+			d.MaxUsers = *req.MaxUsers
+		}
+		if req.TOTPIssuer != nil {
+			d.TOTPIssuer = *req.TOTPIssuer
+		}
+		if err := s.UpdateDomain(d); err != nil {
+			domainError(w, r, "Update domain", err)
+			return
+		}
+		if id, ok := IdentityFromContext(r.Context()); ok {
+			logging.FromContext(r.Context()).Info("Domain updated", "domain", d.Name, "actor_id", id.UserID)
+		}
+		writeDomain(w, r, http.StatusOK, d)
+	}
+}
+
+// DeleteDomainHandler stops hosting the domain named by the {name} route
+// variable if it has no users. It must run behind
+// RequirePermission(PermManageDomains).
+func DeleteDomainHandler(s *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["name"]
+		if err := s.DeleteDomain(name); err != nil {
+			domainError(w, r, "Delete domain", err)
+			return
+		}
+		if id, ok := IdentityFromContext(r.Context()); ok {
+			logging.FromContext(r.Context()).Info("Domain deleted", "domain", name, "actor_id", id.UserID)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
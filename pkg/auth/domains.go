@@ -0,0 +1,224 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"secure-email-mvp/pkg/store"
+)
+
+// Sign-up policies a domain can have
+const (
+	SignUpOpen   = "open"   // Anyone with an address at the domain may sign up
+	SignUpInvite = "invite" // Sign-up needs an invitation
+	SignUpClosed = "closed" // No new users
+)
+
+// DefaultTOTPIssuer names the service in authenticator apps unless a domain sets its own
+const DefaultTOTPIssuer = "SecureEmailMVP"
+
+var (
+	// ErrDomainNotFound is returned when a domain is not hosted here
+	ErrDomainNotFound = errors.New("domain not found")
+	// ErrDomainExists is returned when creating a domain that is already hosted
+	ErrDomainExists = errors.New("domain already exists")
+	// ErrDomainInUse is returned when deleting a domain that still has users
+	ErrDomainInUse = errors.New("domain has users")
+	// ErrInvalidDomain wraps every reason a domain's settings are rejected
+	ErrInvalidDomain = errors.New("invalid domain")
+)
+
+// UserCapError is returned when a domain already has its maximum number of users
+type UserCapError struct {
+	MaxUsers int
+}
+
+func (e *UserCapError) Error() string {
+	return fmt.Sprintf("max %d users reached", e.MaxUsers)
+}
+
+// Domain is a mail domain hosted by this deployment with its own sign-up
+// policy, user cap and TOTP branding
+type Domain struct {
+	Name       string    `json:"name"`
+	SignUp     string    `json:"signup"`
+	MaxUsers   int       `json:"max_users"`
+	TOTPIssuer string    `json:"totp_issuer"`
+	Users      int       `json:"users"` // Current user count; ignored on writes
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ValidateDomainName checks name is dot-separated labels of letters, digits and hyphens
+func ValidateDomainName(name string) bool {
+	if name == "" || len(name) > 253 || !strings.Contains(name, ".") {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// DomainOf returns the lowercase domain of an email address
+func DomainOf(email string) string {
+	_, host, _ := strings.Cut(email, "@")
+	return strings.ToLower(host)
+}
+
+// validate normalizes d and checks every field
+func (d *Domain) validate() error {
+	d.Name = strings.ToLower(strings.TrimSpace(d.Name))
+	d.TOTPIssuer = strings.TrimSpace(d.TOTPIssuer)
+	if d.TOTPIssuer == "" {
+		d.TOTPIssuer = DefaultTOTPIssuer
+	}
+	switch {
+	case !ValidateDomainName(d.Name):
+		return fmt.Errorf("%w: name is not a domain name", ErrInvalidDomain)
+	case d.SignUp != SignUpOpen && d.SignUp != SignUpInvite && d.SignUp != SignUpClosed:
+		return fmt.Errorf("%w: signup must be open, invite or closed", ErrInvalidDomain)
+	case d.MaxUsers < 1:
+		return fmt.Errorf("%w: max_users must be at least 1", ErrInvalidDomain)
+	case len(d.TOTPIssuer) > 64 || strings.Contains(d.TOTPIssuer, ":"):
+		return fmt.Errorf("%w: totp_issuer must be at most 64 characters without a colon", ErrInvalidDomain)
+	}
+	return nil
+}
+
+// domainFromStore converts a stored domain for the API
+func domainFromStore(d store.Domain) *Domain {
+	return &Domain{
+		Name:       d.Name,
+		SignUp:     d.SignUp,
+		MaxUsers:   d.MaxUsers,
+		TOTPIssuer: d.TOTPIssuer,
+		Users:      d.Users,
+		CreatedAt:  d.CreatedAt,
+	}
+}
+
+func (d *Domain) toStore() store.Domain {
+	return store.Domain{
+		Name:       d.Name,
+		SignUp:     d.SignUp,
+		MaxUsers:   d.MaxUsers,
+		TOTPIssuer: d.TOTPIssuer,
+		CreatedAt:  d.CreatedAt,
+	}
+}
+
+// GetDomain loads a hosted domain and its user count
+func (s *Service) GetDomain(name string) (*Domain, error) {
+	d, err := s.Store.Domains.ByName(name)
+	if err == store.ErrNotFound {
+		return nil, ErrDomainNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return domainFromStore(d), nil
+}
+
+// checkUserCap returns a *UserCapError if the domain hosting email has more
+// users than it allows. Run it on the repositories of the transaction that
+// added a user, after the insert, so sign-ups finishing together cannot all
+// pass a stale count.
+func checkUserCap(repos *store.Store, email string) error {
+	d, err := repos.Domains.ByName(DomainOf(email))
+	if err == store.ErrNotFound {
+		return ErrDomainNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if d.Users > d.MaxUsers {
+		return &UserCapError{MaxUsers: d.MaxUsers}
+	}
+	return nil
+}
+
+// ListDomains returns every hosted domain with its user count
+func (s *Service) ListDomains() ([]Domain, error) {
+	list, err := s.Store.Domains.List()
+	if err != nil {
+		return nil, err
+	}
+	domains := make([]Domain, len(list))
+	for i, d := range list {
+		domains[i] = *domainFromStore(d)
+	}
+	return domains, nil
+}
+
+// CreateDomain starts hosting d
+func (s *Service) CreateDomain(d *Domain) error {
+	if err := d.validate(); err != nil {
+		return err
+	}
+	d.CreatedAt = time.Unix(time.Now().Unix(), 0)
+	err := s.Store.Domains.Create(d.toStore())
+	if err == store.ErrExists {
+		return ErrDomainExists
+	}
+	return err
+}
+
+// EnsureDomain creates d unless a domain with its name is already hosted, in
+// which case the stored settings are kept
+func (s *Service) EnsureDomain(d *Domain) (bool, error) {
+	err := s.CreateDomain(d)
+	if err == ErrDomainExists {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// UpdateDomain replaces the policy, cap and branding of a hosted domain
+func (s *Service) UpdateDomain(d *Domain) error {
+	if err := d.validate(); err != nil {
+		return err
+	}
+	err := s.Store.Domains.Update(d.toStore())
+	if err == store.ErrNotFound {
+		return ErrDomainNotFound
+	}
+	return err
+}
+
+// DeleteDomain stops hosting a domain and drops its invites. Domains with
+// users are refused; close sign-up instead.
+func (s *Service) DeleteDomain(name string) error {
+	d, err := s.GetDomain(name)
+	if err != nil {
+		return err
+	}
+	if d.Users > 0 {
+		return ErrDomainInUse
+	}
+	err = s.Store.Domains.Delete(d.Name)
+	if err == store.ErrNotFound {
+		return ErrDomainNotFound
+	}
+	return err
+}
+
+// totpIssuer returns the authenticator app name for email's domain
+func (s *Service) totpIssuer(email string) (string, error) {
+	d, err := s.Store.Domains.ByName(DomainOf(email))
+	if err == store.ErrNotFound {
+		return DefaultTOTPIssuer, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return d.TOTPIssuer, nil
+}
@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestDomainStore(t *testing.T) {
+	db := newAccountTestDB(t)
+	svc := newTestService(db)
+
+	d := &Domain{Name: " SecureSystem.Email ", SignUp: SignUpOpen, MaxUsers: 100}
+	if err := svc.CreateDomain(d); err != nil {
+		t.Fatal("CreateDomain failed:", err)
+	}
+	if d.Name != "securesystem.email" || d.TOTPIssuer != DefaultTOTPIssuer {
+		t.Errorf("Expected name normalized and default issuer, got %+v", d)
+	}
+	if err := svc.CreateDomain(&Domain{Name: "securesystem.email", SignUp: SignUpOpen, MaxUsers: 5}); err != ErrDomainExists {
+		t.Errorf("Expected ErrDomainExists, got %v", err)
+	}
+
+	// EnsureDomain leaves an existing domain's settings alone
+	created, err := svc.EnsureDomain(&Domain{Name: "securesystem.email", SignUp: SignUpClosed, MaxUsers: 1})
+	if err != nil || created {
+		t.Fatalf("Expected existing domain kept, got %v %v", created, err)
+	}
+	got, err := svc.GetDomain("SECURESYSTEM.email")
+	if err != nil {
+		t.Fatal("GetDomain failed:", err)
+	}
+	if got.SignUp != SignUpOpen || got.MaxUsers != 100 || got.Users != 1 {
+		t.Errorf("Unexpected domain %+v", got)
+	}
+
+	invalid := []Domain{
+		{Name: "localhost", SignUp: SignUpOpen, MaxUsers: 1},
+		{Name: "-bad.example", SignUp: SignUpOpen, MaxUsers: 1},
+		{Name: "example.org", SignUp: "anyone", MaxUsers: 1},
+		{Name: "example.org", SignUp: SignUpOpen, MaxUsers: 0},
+		{Name: "example.org", SignUp: SignUpOpen, MaxUsers: 1, TOTPIssuer: "Example:Mail"},
+	}
+	for _, d := range invalid {
+		if err := svc.CreateDomain(&d); !errors.Is(err, ErrInvalidDomain) {
+			t.Errorf("Expected %+v rejected, got %v", d, err)
+		}
+	}
+
+	if err := svc.CreateDomain(&Domain{Name: "example.org", SignUp: SignUpInvite, MaxUsers: 10, TOTPIssuer: "Example Mail"}); err != nil {
+		t.Fatal("CreateDomain failed:", err)
+	}
+	domains, err := svc.ListDomains()
+	if err != nil || len(domains) != 2 || domains[0].Name != "example.org" || domains[0].Users != 0 {
+		t.Fatalf("Expected two domains sorted by name, got %+v %v", domains, err)
+	}
+
+	// Each domain brands its own authenticator entries
+	if issuer, _ := svc.totpIssuer("a@Example.org"); issuer != "Example Mail" {
+		t.Errorf("Expected domain issuer, got %q", issuer)
+	}
+	if issuer, _ := svc.totpIssuer("a@unhosted.example"); issuer != DefaultTOTPIssuer {
+		t.Errorf("Expected default issuer for unhosted domain, got %q", issuer)
+	}
+
+	if err := svc.UpdateDomain(&Domain{Name: "missing.example", SignUp: SignUpOpen, MaxUsers: 1}); err != ErrDomainNotFound {
+		t.Errorf("Expected ErrDomainNotFound, got %v", err)
+	}
+	if err := svc.DeleteDomain("securesystem.email"); err != ErrDomainInUse {
+		t.Errorf("Expected domain with users kept, got %v", err)
+	}
+	if err := svc.DeleteDomain("example.org"); err != nil {
+		t.Errorf("DeleteDomain failed: %v", err)
+	}
+	if _, err := svc.GetDomain("example.org"); err != ErrDomainNotFound {
+		t.Errorf("Expected domain gone, got %v", err)
+	}
+}
+
+func TestDomainHandlers(t *testing.T) {
+	db := newAccountTestDB(t)
+	svc := newTestService(db)
+	db.Exec("INSERT INTO users (id, email, password_hash, totp_secret) VALUES ('user-2', 'other@securesystem.email', 'x', 'y')")
+	svc.SetUserRole("user-1", RoleAdmin)
+	db.Exec("INSERT INTO users (id, email, password_hash, totp_secret, role) VALUES ('user-3', 'audit@securesystem.email', 'x', 'y', 'auditor')")
+	admin, _ := svc.CreateSession("user-1", "test@securesystem.email", ClientInfo{})
+	other, _ := svc.CreateSession("user-2", "other@securesystem.email", ClientInfo{})
+	auditor, _ := svc.CreateSession("user-3", "audit@securesystem.email", ClientInfo{})
+
+	r := mux.NewRouter()
+	r.Use(RequireAuth(svc))
+	r.Handle("/api/admin/domains", RequirePermission(PermReadDomains)(ListDomainsHandler(svc))).Methods("GET")
+	r.Handle("/api/admin/domains", RequirePermission(PermManageDomains)(CreateDomainHandler(svc))).Methods("POST")
+	r.Handle("/api/admin/domains/{name}", RequirePermission(PermManageDomains)(UpdateDomainHandler(svc))).Methods("PATCH")
+	r.Handle("/api/admin/domains/{name}", RequirePermission(PermManageDomains)(DeleteDomainHandler(svc))).Methods("DELETE")
+
+	// Plain users get nothing; auditors may look but not touch
+	if rr := doAccount(r, "GET", "/api/admin/domains", other.AccessToken, nil); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for plain user, got %d", rr.Code)
+	}
+	if rr := doAccount(r, "GET", "/api/admin/domains", auditor.AccessToken, nil); rr.Code != http.StatusOK {
+		t.Errorf("Expected auditor to list domains, got %d", rr.Code)
+	}
+	if rr := doAccount(r, "POST", "/api/admin/domains", auditor.AccessToken, map[string]interface{}{"name": "example.org", "max_users": 50}); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for auditor creating a domain, got %d", rr.Code)
+	}
+
+	rr := doAccount(r, "POST", "/api/admin/domains", admin.AccessToken, map[string]interface{}{"name": "example.org", "max_users": 50})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var d Domain
+	json.NewDecoder(rr.Body).Decode(&d)
+	if d.SignUp != SignUpOpen || d.MaxUsers != 50 || d.TOTPIssuer != DefaultTOTPIssuer {
+		t.Errorf("Expected defaults filled in, got %+v", d)
+	}
+	if rr := doAccount(r, "POST", "/api/admin/domains", admin.AccessToken, map[string]interface{}{"name": "example.org", "max_users": 50}); rr.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for duplicate, got %d", rr.Code)
+	}
+	if rr := doAccount(r, "POST", "/api/admin/domains", admin.AccessToken, map[string]interface{}{"name": "example.net", "signup": "sometimes", "max_users": 5}); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for bad policy, got %d", rr.Code)
+	}
+
+	// PATCH changes only the given fields
+	rr = doAccount(r, "PATCH", "/api/admin/domains/example.org", admin.AccessToken, map[string]interface{}{"signup": "invite", "totp_issuer": "Example Mail"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	json.NewDecoder(rr.Body).Decode(&d)
+	if d.SignUp != SignUpInvite || d.MaxUsers != 50 || d.TOTPIssuer != "Example Mail" {
+		t.Errorf("Unexpected domain after update %+v", d)
+	}
+	if rr := doAccount(r, "PATCH", "/api/admin/domains/missing.example", admin.AccessToken, map[string]interface{}{"max_users": 1}); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", rr.Code)
+	}
+
+	rr = doAccount(r, "GET", "/api/admin/domains", admin.AccessToken, nil)
+	var list ListDomainsResponse
+	json.NewDecoder(rr.Body).Decode(&list)
+	if len(list.Domains) != 1 || list.Domains[0].Name != "example.org" {
+		t.Errorf("Expected one domain listed, got %+v", list.Domains)
+	}
+
+	if rr := doAccount(r, "DELETE", "/api/admin/domains/example.org", admin.AccessToken, nil); rr.Code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %d", rr.Code)
+	}
+	svc.CreateDomain(&Domain{Name: "securesystem.email", SignUp: SignUpOpen, MaxUsers: 100})
+	if rr := doAccount(r, "DELETE", "/api/admin/domains/securesystem.email", admin.AccessToken, nil); rr.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for domain with users, got %d", rr.Code)
+	}
+}
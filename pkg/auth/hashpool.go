@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Default hash pool limits. Each Argon2 call holds DefaultPasswordParams.Memory
+// (64 MiB), so two workers cap hashing at 128 MiB.
+const (
+	DefaultHashWorkers = 2
+	DefaultHashQueue   = 32
+)
+
+// ErrHashPoolFull is returned when every worker is busy and the wait queue is full
+var ErrHashPoolFull = errors.New("password hashing capacity exhausted")
+
+// HashPool bounds how many Argon2 hashes run at once. Callers beyond the
+// worker count wait in a bounded queue; beyond that they are rejected at once.
+type HashPool struct {
+	workers chan struct{}
+	queue   chan struct{}
+
+	mu    sync.Mutex
+	stats HashPoolStats
+}
+
+// HashPoolStats is a snapshot of a HashPool's load and latency
+type HashPoolStats struct {
+	Workers     int
+	QueueSize   int
+	Running     int // Hashes in progress
+	Queued      int // Callers waiting for a worker
+	Completed   uint64
+	Rejected    uint64        // Turned away because the queue was full
+	Canceled    uint64        // Gave up while queued
+	WaitTime    time.Duration // Total time completed hashes spent queued
+	HashTime    time.Duration // Total time spent hashing
+	MaxHashTime time.Duration
+}
+
+// NewHashPool creates a pool running up to workers hashes with up to queue callers waiting
+func NewHashPool(workers, queue int) *HashPool {
+	return &HashPool{
+		workers: make(chan struct{}, workers),
+		queue:   make(chan struct{}, queue),
+		stats:   HashPoolStats{Workers: workers, QueueSize: queue},
+	}
+}
+
+// Do runs fn on a free worker, waiting in the queue if needed. It returns
+// ErrHashPoolFull if the queue is full, or ctx's error if ctx ends first.
+func (p *HashPool) Do(ctx context.Context, fn func()) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	start := time.Now()
+	select {
+	case p.workers <- struct{}{}:
+	default:
+		select {
+		case p.queue <- struct{}{}:
+		default:
+			p.update(func(s *HashPoolStats) { s.Rejected++ })
+			return ErrHashPoolFull
+		}
+		select {
+		case p.workers <- struct{}{}:
+			<-p.queue
+		case <-ctx.Done():
+			<-p.queue
+			p.update(func(s *HashPoolStats) { s.Canceled++ })
+			return ctx.Err()
+		}
+	}
+	defer func() { <-p.workers }()
+
+	waited := time.Since(start)
+	hashStart := time.Now()
+	fn()
+	took := time.Since(hashStart)
+	p.update(func(s *HashPoolStats) {
+		s.Completed++
+		s.WaitTime += waited
+		s.HashTime += took
+		if took > s.MaxHashTime {
+			s.MaxHashTime = took
+		}
+	})
+	return nil
+}
+
+func (p *HashPool) update(f func(s *HashPoolStats)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f(&p.stats)
+}
+
+// Stats returns the pool's current load and cumulative counters
+func (p *HashPool) Stats() HashPoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.stats
+	s.Running = len(p.workers)
+	s.Queued = len(p.queue)
+	return s
+}
+
+// hashBusy reports whether err means hashing was not attempted because the
+// pool was saturated or the request ended while queued
+func hashBusy(err error) bool {
+	return errors.Is(err, ErrHashPoolFull) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// serverBusy tells the client to retry shortly
+func serverBusy(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", "1")
+	http.Error(w, `{"error":"Server busy, try again shortly"}`, http.StatusServiceUnavailable)
+}
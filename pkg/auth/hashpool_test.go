@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"secure-email-mvp/pkg/store/sqlite"
+)
+
+// occupy holds one worker of p until the returned release function is called
+func occupy(p *HashPool) (release func()) {
+	started, done := make(chan struct{}), make(chan struct{})
+	go p.Do(context.Background(), func() {
+		close(started)
+		<-done
+	})
+	<-started
+	return func() { close(done) }
+}
+
+// waitQueued waits until n callers are queued on p
+func waitQueued(t *testing.T, p *HashPool, n int) {
+	deadline := time.Now().Add(time.Second)
+	for p.Stats().Queued != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d queued, got %d", n, p.Stats().Queued)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHashPoolBounds(t *testing.T) {
+	p := NewHashPool(1, 1)
+	release := occupy(p)
+
+	// The second caller waits in the queue; a third is turned away at once
+	queued := make(chan error)
+	go func() { queued <- p.Do(context.Background(), func() {}) }()
+	waitQueued(t, p, 1)
+	if err := p.Do(context.Background(), func() {}); err != ErrHashPoolFull {
+		t.Errorf("Expected ErrHashPoolFull, got %v", err)
+	}
+	if s := p.Stats(); s.Running != 1 || s.Queued != 1 || s.Rejected != 1 {
+		t.Errorf("Unexpected stats while saturated %+v", s)
+	}
+
+	release()
+	if err := <-queued; err != nil {
+		t.Errorf("Expected queued caller to run, got %v", err)
+	}
+	s := p.Stats()
+	if s.Completed != 2 || s.Running != 0 || s.Queued != 0 || s.HashTime <= 0 || s.MaxHashTime <= 0 {
+		t.Errorf("Unexpected stats after draining %+v", s)
+	}
+}
+
+func TestHashPoolCancel(t *testing.T) {
+	p := NewHashPool(1, 1)
+	release := occupy(p)
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+	ran := false
+	go func() { result <- p.Do(ctx, func() { ran = true }) }()
+	waitQueued(t, p, 1)
+	cancel()
+	if err := <-result; err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if ran {
+		t.Error("Expected canceled caller not to run")
+	}
+	if s := p.Stats(); s.Canceled != 1 || s.Queued != 0 {
+		t.Errorf("Unexpected stats after cancel %+v", s)
+	}
+
+	// An already-ended context is refused before queueing
+	if err := p.Do(ctx, func() {}); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestSignUpHandlerBusy(t *testing.T) {
+	db := newSignUpTestDB(t)
+	svc := newTestService(db)
+	p := NewHashPool(1, 0)
+	svc.Hash = p
+	release := occupy(p)
+	defer release()
+
+	req, _ := http.NewRequest("POST", "/api/auth/signup",
+		bytes.NewBufferString(`{"email":"test@securesystem.email","password":"password123","confirm_password":"password123"}`))
+	rr := httptest.NewRecorder()
+	SignUpHandler(svc, sqlite.New(db).Pending).ServeHTTP(rr, req)
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected 503 with Retry-After, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"secure-email-mvp/pkg/logging"
+
+	"github.com/gorilla/mux"
+)
+
+type CreateInviteRequest struct {
+	Domain    string `json:"domain"`     // Defaults to the domain of Email
+	Email     string `json:"email"`      // Optional; only this address may redeem the code
+	MaxUses   int    `json:"max_uses"`   // Defaults to 1
+	ExpiresIn int64  `json:"expires_in"` // Seconds; defaults to DefaultInviteTTL
+}
+
+type ListInvitesResponse struct {
+	Invites []Invite `json:"invites"`
+}
+
+// CreateInviteHandler mints an invite code. The code is only ever shown in
+// this response. It must run behind RequirePermission(PermManageInvites).
+func CreateInviteHandler(s *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := IdentityFromContext(r.Context())
+		if !ok {
+			unauthorized(w)
+			return
+		}
+
+		req := CreateInviteRequest{MaxUses: 1, ExpiresIn: int64(DefaultInviteTTL.Seconds())}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, `{"error":"Invalid request"}`, http.StatusBadRequest)
+			return
+		}
+
+		inv := Invite{Domain: req.Domain, Email: req.Email, MaxUses: req.MaxUses, CreatedBy: id.UserID}
+		err := s.CreateInvite(&inv, time.Duration(req.ExpiresIn)*time.Second)
+		if errors.Is(err, ErrInvalidInvite) {
+			http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, `{"error":"Internal server error"}`, http.StatusInternalServerError)
+			logging.FromContext(r.Context()).Error("Create invite failed", "error", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		if err := json.NewEncoder(w).Encode(inv); err != nil {
+			logging.FromContext(r.Context()).Warn("Create invite response failed", "error", err)
+		}
+		logging.FromContext(r.Context()).Info("Invite created", "invite_id", inv.ID, "domain", inv.Domain, "actor_id", id.UserID)
+	}
+}
+
+// ListInvitesHandler lists outstanding invites, optionally only those for the
+// ?domain= query parameter. It must run behind
+// RequirePermission(PermManageInvites).
+func ListInvitesHandler(s *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		invites, err := s.ListInvites(r.URL.Query().Get("domain"), time.Now())
+		if err != nil {
+			http.Error(w, `{"error":"Internal server error"}`, http.StatusInternalServerError)
+			logging.FromContext(r.Context()).Error("List invites failed", "error", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(ListInvitesResponse{Invites: invites}); err != nil {
+			logging.FromContext(r.Context()).Warn("List invites response failed", "error", err)
+		}
+	}
+}
+
+// RevokeInviteHandler expires the invite named by the {id} route variable.
+// It must run behind RequirePermission(PermManageInvites).
+func RevokeInviteHandler(s *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		inviteID := mux.Vars(r)["id"]
+		err := s.RevokeInvite(inviteID, time.Now())
+		if err == ErrInviteNotFound {
+			http.Error(w, `{"error":"Invite not found"}`, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, `{"error":"Internal server error"}`, http.StatusInternalServerError)
+			logging.FromContext(r.Context()).Error("Revoke invite failed", "error", err)
+			return
+		}
+		if id, ok := IdentityFromContext(r.Context()); ok {
+			logging.FromContext(r.Context()).Info("Invite revoked", "invite_id", inviteID, "actor_id", id.UserID)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
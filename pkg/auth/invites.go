@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"secure-email-mvp/pkg/store"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultInviteTTL is how long an invite stays valid unless the admin chooses otherwise
+	DefaultInviteTTL = 7 * 24 * time.Hour
+	// MaxInviteTTL is the longest an invite can stay valid
+	MaxInviteTTL = 90 * 24 * time.Hour
+)
+
+var (
+	// ErrInviteCodeInvalid is returned when an invite code is unknown, expired,
+	// used up, or not valid for the address signing up
+	ErrInviteCodeInvalid = errors.New("invalid or expired invite code")
+	// ErrInviteNotFound is returned when revoking an invite that is not outstanding
+	ErrInviteNotFound = errors.New("invite not found")
+	// ErrInvalidInvite wraps every reason a new invite's settings are rejected
+	ErrInvalidInvite = errors.New("invalid invite")
+)
+
+// Invite lets up to MaxUses people sign up at Domain, or only Email if set,
+// until ExpiresAt. Codes look like recovery codes and are stored hashed.
+type Invite struct {
+	ID        string    `json:"id"`
+	Code      string    `json:"code,omitempty"` // Only known when the invite is created
+	Domain    string    `json:"domain"`
+	Email     string    `json:"email,omitempty"`
+	MaxUses   int       `json:"max_uses"`
+	Uses      int       `json:"uses"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// CreateInvite mints a code for inv valid for ttl. Domain defaults to the
+// domain of Email and must be hosted here. The plaintext code is set on inv.Code.
+func (s *Service) CreateInvite(inv *Invite, ttl time.Duration) error {
+	inv.Email = NormalizeEmail(inv.Email)
+	inv.Domain = strings.ToLower(strings.TrimSpace(inv.Domain))
+	if inv.Domain == "" {
+		inv.Domain = DomainOf(inv.Email)
+	}
+	switch {
+	case inv.Email != "" && !ValidateAddress(inv.Email):
+		return fmt.Errorf("%w: email is not an email address", ErrInvalidInvite)
+	case inv.Email != "" && DomainOf(inv.Email) != inv.Domain:
+		return fmt.Errorf("%w: email is not at domain", ErrInvalidInvite)
+	case inv.MaxUses < 1:
+		return fmt.Errorf("%w: max_uses must be at least 1", ErrInvalidInvite)
+	case ttl <= 0 || ttl > MaxInviteTTL:
+		return fmt.Errorf("%w: expires_in must be between 1 second and %d days", ErrInvalidInvite, int(MaxInviteTTL.Hours()/24))
+	}
+	if _, err := s.GetDomain(inv.Domain); err == ErrDomainNotFound {
+		return fmt.Errorf("%w: domain is not hosted here", ErrInvalidInvite)
+	} else if err != nil {
+		return err
+	}
+
+	code, err := newRecoveryCode()
+	if err != nil {
+		return err
+	}
+	now := time.Unix(time.Now().Unix(), 0)
+	inv.ID = uuid.New().String()
+	inv.Code = code
+	inv.Uses = 0
+	inv.CreatedAt = now
+	inv.ExpiresAt = now.Add(ttl)
+
+	return s.Store.Invites.Create(store.Invite{
+		ID:        inv.ID,
+		CodeHash:  hashRecoveryCode(code),
+		Domain:    inv.Domain,
+		Email:     inv.Email,
+		MaxUses:   inv.MaxUses,
+		CreatedBy: inv.CreatedBy,
+		CreatedAt: inv.CreatedAt,
+		ExpiresAt: inv.ExpiresAt,
+	})
+}
+
+// inviteFromStore converts a stored invite for the API, without its code
+func inviteFromStore(inv store.Invite) *Invite {
+	return &Invite{
+		ID:        inv.ID,
+		Domain:    inv.Domain,
+		Email:     inv.Email,
+		MaxUses:   inv.MaxUses,
+		Uses:      inv.Uses,
+		CreatedBy: inv.CreatedBy,
+		CreatedAt: inv.CreatedAt,
+		ExpiresAt: inv.ExpiresAt,
+	}
+}
+
+// ListInvites returns invites that can still be redeemed, newest first. An
+// empty domain lists every domain's invites.
+func (s *Service) ListInvites(domain string, now time.Time) ([]Invite, error) {
+	list, err := s.Store.Invites.ListOutstanding(domain, now)
+	if err != nil {
+		return nil, err
+	}
+	invites := make([]Invite, len(list))
+	for i, inv := range list {
+		invites[i] = *inviteFromStore(inv)
+	}
+	return invites, nil
+}
+
+// CheckInviteCode returns the outstanding invite for code if email may redeem it
+func (s *Service) CheckInviteCode(code, email string, now time.Time) (*Invite, error) {
+	if !ValidateRecoveryCode(code) {
+		return nil, ErrInviteCodeInvalid
+	}
+	inv, err := s.Store.Invites.ByCodeHash(hashRecoveryCode(code))
+	if err == store.ErrNotFound {
+		return nil, ErrInviteCodeInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	if inv.Uses >= inv.MaxUses || !now.Before(inv.ExpiresAt) || inv.Domain != DomainOf(email) ||
+		(inv.Email != "" && !strings.EqualFold(inv.Email, email)) {
+		return nil, ErrInviteCodeInvalid
+	}
+	return inviteFromStore(inv), nil
+}
+
+// redeemInvite spends one use of an invite for a new user on the repositories
+// of the transaction that created them. It fails with ErrInviteCodeInvalid if
+// the invite expired or was used up since it was checked.
+func redeemInvite(repos *store.Store, inviteID, userID string, now time.Time) error {
+	err := repos.Invites.Redeem(inviteID, userID, now)
+	if err == store.ErrNotFound {
+		return ErrInviteCodeInvalid
+	}
+	return err
+}
+
+// RevokeInvite expires an outstanding invite now. Its redemptions are kept.
+func (s *Service) RevokeInvite(id string, now time.Time) error {
+	err := s.Store.Invites.Revoke(id, now)
+	if err == store.ErrNotFound {
+		return ErrInviteNotFound
+	}
+	return err
+}
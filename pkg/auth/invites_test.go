@@ -0,0 +1,234 @@
+package auth
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"secure-email-mvp/pkg/store/sqlite"
+
+	"github.com/gorilla/mux"
+	"github.com/pquerna/otp/totp"
+)
+
+// newInviteTestDB hosts invite-only example.org alongside the account test user
+func newInviteTestDB(t *testing.T) *sql.DB {
+	db := newAccountTestDB(t)
+	svc := newTestService(db)
+	if err := svc.CreateDomain(&Domain{Name: "example.org", SignUp: SignUpInvite, MaxUsers: 10}); err != nil {
+		t.Fatal("Failed to create domain:", err)
+	}
+	return db
+}
+
+func TestCreateInvite(t *testing.T) {
+	db := newInviteTestDB(t)
+	svc := newTestService(db)
+
+	inv := Invite{Email: "Alice@Example.org", MaxUses: 1, CreatedBy: "user-1"}
+	if err := svc.CreateInvite(&inv, time.Hour); err != nil {
+		t.Fatal("CreateInvite failed:", err)
+	}
+	if inv.Domain != "example.org" || !ValidateRecoveryCode(inv.Code) || inv.ExpiresAt.Sub(inv.CreatedAt) != time.Hour {
+		t.Errorf("Unexpected invite %+v", inv)
+	}
+	var stored string
+	db.QueryRow("SELECT code_hash FROM invites WHERE id = ?", inv.ID).Scan(&stored)
+	if stored == inv.Code || stored != hashRecoveryCode(inv.Code) {
+		t.Error("Expected only the code's hash stored")
+	}
+
+	invalid := []struct {
+		inv Invite
+		ttl time.Duration
+	}{
+		{Invite{Domain: "unhosted.example", MaxUses: 1}, time.Hour},
+		{Invite{Domain: "example.org", Email: "bob@elsewhere.example", MaxUses: 1}, time.Hour},
+		{Invite{Domain: "example.org", Email: "not-an-address", MaxUses: 1}, time.Hour},
+		{Invite{Domain: "example.org", MaxUses: 0}, time.Hour},
+		{Invite{Domain: "example.org", MaxUses: 1}, 0},
+		{Invite{Domain: "example.org", MaxUses: 1}, MaxInviteTTL + time.Second},
+	}
+	for _, tt := range invalid {
+		if err := svc.CreateInvite(&tt.inv, tt.ttl); !errors.Is(err, ErrInvalidInvite) {
+			t.Errorf("Expected %+v for %v rejected, got %v", tt.inv, tt.ttl, err)
+		}
+	}
+}
+
+func TestCheckInviteCode(t *testing.T) {
+	db := newInviteTestDB(t)
+	svc := newTestService(db)
+	now := time.Now()
+
+	open := Invite{Domain: "example.org", MaxUses: 2, CreatedBy: "user-1"}
+	bound := Invite{Email: "alice@example.org", MaxUses: 1, CreatedBy: "user-1"}
+	svc.CreateInvite(&open, time.Hour)
+	svc.CreateInvite(&bound, time.Hour)
+
+	tests := []struct {
+		name  string
+		code  string
+		email string
+		ok    bool
+	}{
+		{"Any address at the domain", open.Code, "bob@example.org", true},
+		{"Lowercase without dashes", strings.ToLower(normalizeRecoveryCode(open.Code)), "bob@example.org", true},
+		{"Other domain", open.Code, "bob@securesystem.email", false},
+		{"Bound address", bound.Code, "ALICE@example.org", true},
+		{"Bound to someone else", bound.Code, "bob@example.org", false},
+		{"Unknown code", "AAAA-BBBB-CCCC-DDDD", "bob@example.org", false},
+		{"Malformed code", "nope", "bob@example.org", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := svc.CheckInviteCode(tt.code, tt.email, now)
+			if tt.ok && err != nil {
+				t.Errorf("Expected code accepted, got %v", err)
+			}
+			if !tt.ok && err != ErrInviteCodeInvalid {
+				t.Errorf("Expected ErrInviteCodeInvalid, got %v", err)
+			}
+		})
+	}
+
+	if _, err := svc.CheckInviteCode(open.Code, "bob@example.org", now.Add(2*time.Hour)); err != ErrInviteCodeInvalid {
+		t.Errorf("Expected expired invite rejected, got %v", err)
+	}
+	if err := svc.RevokeInvite(open.ID, now); err != nil {
+		t.Fatal("RevokeInvite failed:", err)
+	}
+	if _, err := svc.CheckInviteCode(open.Code, "bob@example.org", now); err != ErrInviteCodeInvalid {
+		t.Errorf("Expected revoked invite rejected, got %v", err)
+	}
+	if err := svc.RevokeInvite(open.ID, now); err != ErrInviteNotFound {
+		t.Errorf("Expected ErrInviteNotFound revoking twice, got %v", err)
+	}
+
+	// Only the bound invite is still outstanding
+	invites, err := svc.ListInvites("", now)
+	if err != nil || len(invites) != 1 || invites[0].ID != bound.ID || invites[0].Code != "" {
+		t.Errorf("Expected only the bound invite listed without its code, got %+v %v", invites, err)
+	}
+	if invites, _ := svc.ListInvites("securesystem.email", now); len(invites) != 0 {
+		t.Errorf("Expected no invites for another domain, got %+v", invites)
+	}
+}
+
+func TestInviteSignUp(t *testing.T) {
+	db := newInviteTestDB(t)
+	svc := newTestService(db)
+	pending := sqlite.New(db).Pending
+	signUpHandler := SignUpHandler(svc, pending)
+	verifyHandler := VerifyTotpHandler(svc, pending)
+	inv := Invite{Domain: "example.org", MaxUses: 1, CreatedBy: "user-1"}
+	if err := svc.CreateInvite(&inv, time.Hour); err != nil {
+		t.Fatal("CreateInvite failed:", err)
+	}
+
+	post := func(h http.Handler, body interface{}) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		json.NewEncoder(&buf).Encode(body)
+		req, _ := http.NewRequest("POST", "/", &buf)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+	signUp := func(email, code string) *httptest.ResponseRecorder {
+		return post(signUpHandler, SignUpRequest{Email: email, Password: "password123", ConfirmPassword: "password123", InviteCode: code})
+	}
+	verify := func(rr *httptest.ResponseRecorder) *httptest.ResponseRecorder {
+		var resp SignUpResponse
+		json.NewDecoder(rr.Body).Decode(&resp)
+		state, err := pending.Load(resp.TempID)
+		if err != nil {
+			t.Fatal("Pending sign-up missing:", err)
+		}
+		code, _ := totp.GenerateCode(state.TotpSecret, time.Now())
+		return post(verifyHandler, VerifyTotpRequest{TempID: resp.TempID, TotpCode: code})
+	}
+
+	if rr := signUp("alice@example.org", ""); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected invite-only domain to need a code, got %d", rr.Code)
+	}
+	if rr := signUp("alice@example.org", "AAAA-BBBB-CCCC-DDDD"); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected unknown code rejected, got %d", rr.Code)
+	}
+
+	// Two people start with the same single-use code; only the first to finish gets in
+	alice := signUp("alice@example.org", inv.Code)
+	bob := signUp("bob@example.org", inv.Code)
+	if alice.Code != http.StatusOK || bob.Code != http.StatusOK {
+		t.Fatalf("Expected both sign-ups to start, got %d and %d", alice.Code, bob.Code)
+	}
+	if rr := verify(alice); rr.Code != http.StatusOK {
+		t.Fatalf("Expected alice created, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := verify(bob); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected used-up invite to stop bob, got %d", rr.Code)
+	}
+	var users int
+	db.QueryRow("SELECT COUNT(*) FROM users WHERE email = 'bob@example.org'").Scan(&users)
+	if users != 0 {
+		t.Error("Expected no user created for bob")
+	}
+
+	// The redemption is recorded against the new user
+	var redeemedBy string
+	var uses int
+	db.QueryRow("SELECT u.email, i.uses FROM invite_redemptions r JOIN users u ON u.id = r.user_id JOIN invites i ON i.id = r.invite_id WHERE r.invite_id = ?", inv.ID).Scan(&redeemedBy, &uses)
+	if redeemedBy != "alice@example.org" || uses != 1 {
+		t.Errorf("Expected redemption by alice, got %q with %d uses", redeemedBy, uses)
+	}
+	if rr := signUp("carol@example.org", inv.Code); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected used-up code rejected at sign-up, got %d", rr.Code)
+	}
+}
+
+func TestInviteHandlers(t *testing.T) {
+	db := newInviteTestDB(t)
+	svc := newTestService(db)
+	svc.SetUserRole("user-1", RoleAdmin)
+	admin, _ := svc.CreateSession("user-1", "test@securesystem.email", ClientInfo{})
+
+	r := mux.NewRouter()
+	r.Use(RequireAuth(svc))
+	r.Use(RequirePermission(PermManageInvites))
+	r.HandleFunc("/api/admin/invites", ListInvitesHandler(svc)).Methods("GET")
+	r.HandleFunc("/api/admin/invites", CreateInviteHandler(svc)).Methods("POST")
+	r.HandleFunc("/api/admin/invites/{id}", RevokeInviteHandler(svc)).Methods("DELETE")
+
+	rr := doAccount(r, "POST", "/api/admin/invites", admin.AccessToken, map[string]interface{}{"domain": "example.org"})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var inv Invite
+	json.NewDecoder(rr.Body).Decode(&inv)
+	if inv.Code == "" || inv.MaxUses != 1 || inv.CreatedBy != "user-1" || inv.ExpiresAt.Sub(inv.CreatedAt) != DefaultInviteTTL {
+		t.Errorf("Expected single-use week-long invite with its code, got %+v", inv)
+	}
+
+	if rr := doAccount(r, "POST", "/api/admin/invites", admin.AccessToken, map[string]interface{}{"domain": "unhosted.example"}); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for unhosted domain, got %d", rr.Code)
+	}
+
+	rr = doAccount(r, "GET", "/api/admin/invites?domain=example.org", admin.AccessToken, nil)
+	var list ListInvitesResponse
+	json.NewDecoder(rr.Body).Decode(&list)
+	if len(list.Invites) != 1 || list.Invites[0].ID != inv.ID || list.Invites[0].Code != "" {
+		t.Errorf("Expected the invite listed without its code, got %+v", list.Invites)
+	}
+
+	if rr := doAccount(r, "DELETE", "/api/admin/invites/"+inv.ID, admin.AccessToken, nil); rr.Code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %d", rr.Code)
+	}
+	if rr := doAccount(r, "DELETE", "/api/admin/invites/"+inv.ID, admin.AccessToken, nil); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 once revoked, got %d", rr.Code)
+	}
+}
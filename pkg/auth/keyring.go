@@ -0,0 +1,354 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"secure-email-mvp/pkg/logging"
+)
+
+// kidTimeLayout prefixes every key ID so IDs sort by creation time
+const kidTimeLayout = "20060102T150405Z"
+
+// jwksMaxAge is how long clients may cache /.well-known/jwks.json
+const jwksMaxAge = 5 * time.Minute
+
+// KeyActivationDelay is how long a new key is published in the JWKS before it
+// signs tokens, so services holding a cached copy already know it
+const KeyActivationDelay = jwksMaxAge
+
+// missReloadInterval limits how often a token with an unknown kid makes the
+// keyring re-read its directory
+const missReloadInterval = 10 * time.Second
+
+// SigningKey is one ES256 key in the keyring
+type SigningKey struct {
+	ID        string
+	Key       *ecdsa.PrivateKey
+	CreatedAt time.Time
+}
+
+// Keyring holds the ES256 keys used to sign and verify access tokens. The
+// newest key published for at least KeyActivationDelay signs; older keys stay
+// available for verification until every token they signed has expired. With
+// a directory, keys are persisted as <kid>.pem files so every API instance
+// sharing it verifies the same tokens.
+type Keyring struct {
+	mu   sync.RWMutex
+	dir  string
+	keys []*SigningKey // Oldest first
+
+	missMu     sync.Mutex
+	lastMissAt time.Time // Last Reload caused by an unknown kid
+}
+
+// NewKeyring creates an in-memory keyring with one fresh key. Keys are lost on
+// restart, so it is only suitable for tests and single-instance development.
+func NewKeyring() (*Keyring, error) {
+	k := &Keyring{}
+	if _, err := k.Rotate(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// LoadKeyring reads every <kid>.pem key in dir, creating the directory and a
+// first key if there are none
+func LoadKeyring(dir string) (*Keyring, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create key directory: %v", err)
+	}
+	k := &Keyring{dir: dir}
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+	if len(k.keys) == 0 {
+		if _, err := k.Rotate(); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// Reload re-reads the key directory, picking up keys rotated by other instances
+func (k *Keyring) Reload() error {
+	if k.dir == "" {
+		return nil
+	}
+	paths, err := filepath.Glob(filepath.Join(k.dir, "*.pem"))
+	if err != nil {
+		return fmt.Errorf("failed to list keys: %v", err)
+	}
+
+	var keys []*SigningKey
+	for _, path := range paths {
+		key, err := readSigningKey(path)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+
+	k.mu.Lock()
+	k.keys = keys
+	k.mu.Unlock()
+	return nil
+}
+
+func readSigningKey(path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key %s: %v", path, err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key %s: %v", path, err)
+	}
+	priv, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok || priv.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("key %s is not a P-256 ECDSA key", path)
+	}
+
+	id := strings.TrimSuffix(filepath.Base(path), ".pem")
+	created, err := time.Parse(kidTimeLayout, strings.SplitN(id, "-", 2)[0])
+	if err != nil {
+		info, statErr := os.Stat(path)
+		if statErr != nil {
+			return nil, fmt.Errorf("failed to stat key %s: %v", path, statErr)
+		}
+		created = info.ModTime()
+	}
+	return &SigningKey{ID: id, Key: priv, CreatedAt: created}, nil
+}
+
+// Rotate generates and publishes a new key, which starts signing after
+// KeyActivationDelay. Older keys keep verifying tokens until Prune removes
+// them.
+func (k *Keyring) Rotate() (*SigningKey, error) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %v", err)
+	}
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, fmt.Errorf("failed to generate key ID: %v", err)
+	}
+	now := time.Now().UTC()
+	key := &SigningKey{
+		ID:        now.Format(kidTimeLayout) + "-" + hex.EncodeToString(suffix),
+		Key:       priv,
+		CreatedAt: now.Truncate(time.Second),
+	}
+
+	if k.dir != "" {
+		der, err := x509.MarshalPKCS8PrivateKey(priv)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode signing key: %v", err)
+		}
+		data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+		// Write then rename so other instances never read a partial key
+		path := filepath.Join(k.dir, key.ID+".pem")
+		if err := os.WriteFile(path+".tmp", data, 0600); err != nil {
+			return nil, fmt.Errorf("failed to write signing key: %v", err)
+		}
+		if err := os.Rename(path+".tmp", path); err != nil {
+			return nil, fmt.Errorf("failed to write signing key: %v", err)
+		}
+	}
+
+	k.mu.Lock()
+	k.keys = append(k.keys, key)
+	k.mu.Unlock()
+	return key, nil
+}
+
+// Prune drops keys whose successor has been signing for longer than the
+// access token lifetime, since no unexpired token can still reference them
+func (k *Keyring) Prune(now time.Time) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	var kept []*SigningKey
+	for i, key := range k.keys {
+		if i < len(k.keys)-1 && now.Sub(k.keys[i+1].CreatedAt) > KeyActivationDelay+AccessTokenTTL {
+			if k.dir != "" {
+				if err := os.Remove(filepath.Join(k.dir, key.ID+".pem")); err != nil && !os.IsNotExist(err) {
+					return fmt.Errorf("failed to remove retired key: %v", err)
+				}
+			}
+			continue
+		}
+		kept = append(kept, key)
+	}
+	k.keys = kept
+	return nil
+}
+
+// Active returns the key currently used for signing
+func (k *Keyring) Active() *SigningKey {
+	return k.ActiveAt(time.Now())
+}
+
+// ActiveAt returns the newest key published for at least KeyActivationDelay
+// at now. Until a second key has been published that long, the oldest key
+// signs, so a fresh keyring can sign straight away.
+func (k *Keyring) ActiveAt(now time.Time) *SigningKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if len(k.keys) == 0 {
+		return nil
+	}
+	for i := len(k.keys) - 1; i > 0; i-- {
+		if now.Sub(k.keys[i].CreatedAt) >= KeyActivationDelay {
+			return k.keys[i]
+		}
+	}
+	return k.keys[0]
+}
+
+// Keys returns every key that can still verify tokens, oldest first. Keys
+// newer than Active are published but not yet signing.
+func (k *Keyring) Keys() []*SigningKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return append([]*SigningKey(nil), k.keys...)
+}
+
+// PublicKey returns the verification key for a key ID. An unknown ID may be
+// a key another instance just created, so the directory is re-read at most
+// once per missReloadInterval before giving up.
+func (k *Keyring) PublicKey(kid string) (*ecdsa.PublicKey, bool) {
+	if pub, ok := k.publicKey(kid); ok {
+		return pub, true
+	}
+	if !k.reloadOnMiss(time.Now()) {
+		return nil, false
+	}
+	return k.publicKey(kid)
+}
+
+func (k *Keyring) publicKey(kid string) (*ecdsa.PublicKey, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	for _, key := range k.keys {
+		if key.ID == kid {
+			return &key.Key.PublicKey, true
+		}
+	}
+	return nil, false
+}
+
+// reloadOnMiss re-reads the key directory unless it was re-read for an
+// unknown kid within missReloadInterval, reporting whether it did
+func (k *Keyring) reloadOnMiss(now time.Time) bool {
+	if k.dir == "" {
+		return false
+	}
+	k.missMu.Lock()
+	defer k.missMu.Unlock()
+	if now.Sub(k.lastMissAt) < missReloadInterval {
+		return false
+	}
+	k.lastMissAt = now
+	if err := k.Reload(); err != nil {
+		slog.Error("Key reload failed", "error", err)
+		return false
+	}
+	return true
+}
+
+// StartRotation checks the keyring every interval/24, publishing a new key
+// once the newest is older than interval and pruning retired keys, until
+// stopped
+func (k *Keyring) StartRotation(interval time.Duration) (stop func()) {
+	return startSweeper(interval/24, func(now time.Time) {
+		if err := k.Reload(); err != nil {
+			slog.Error("Key reload failed", "error", err)
+			return
+		}
+		if keys := k.Keys(); len(keys) == 0 || now.Sub(keys[len(keys)-1].CreatedAt) >= interval {
+			key, err := k.Rotate()
+			if err != nil {
+				slog.Error("Key rotation failed", "error", err)
+				return
+			}
+			slog.Info("Published JWT signing key", "kid", key.ID, "signing_from", key.CreatedAt.Add(KeyActivationDelay).Format(time.RFC3339))
+		}
+		if err := k.Prune(now); err != nil {
+			slog.Error("Key prune failed", "error", err)
+		}
+	})
+}
+
+// JWK is the public half of a signing key in RFC 7517 form
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+}
+
+// JWKSet is the document served at /.well-known/jwks.json
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns every key that can still verify tokens
+func (k *Keyring) JWKS() JWKSet {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range k.keys {
+		pub, err := key.Key.PublicKey.ECDH()
+		if err != nil {
+			continue
+		}
+		raw := pub.Bytes() // 0x04 || X || Y
+		set.Keys = append(set.Keys, JWK{
+			Kty: "EC",
+			Crv: "P-256",
+			X:   base64.RawURLEncoding.EncodeToString(raw[1:33]),
+			Y:   base64.RawURLEncoding.EncodeToString(raw[33:]),
+			Kid: key.ID,
+			Use: "sig",
+			Alg: "ES256",
+		})
+	}
+	return set
+}
+
+// JWKSHandler serves the keyring's public keys so other services can verify
+// access tokens without holding a secret
+func JWKSHandler(k *Keyring) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(jwksMaxAge.Seconds())))
+		if err := json.NewEncoder(w).Encode(k.JWKS()); err != nil {
+			logging.FromContext(r.Context()).Warn("JWKS response failed", "error", err)
+		}
+	}
+}
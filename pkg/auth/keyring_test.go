@@ -0,0 +1,207 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestLoadKeyring(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "keys")
+
+	k, err := LoadKeyring(dir)
+	if err != nil {
+		t.Fatalf("LoadKeyring failed: %v", err)
+	}
+	active := k.Active()
+	if active == nil {
+		t.Fatal("Expected a key to be generated in an empty directory")
+	}
+	info, err := os.Stat(filepath.Join(dir, active.ID+".pem"))
+	if err != nil {
+		t.Fatalf("Expected key file on disk: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected key file mode 0600, got %v", info.Mode().Perm())
+	}
+
+	// A second instance on the same directory uses the same key
+	other, err := LoadKeyring(dir)
+	if err != nil {
+		t.Fatalf("Second LoadKeyring failed: %v", err)
+	}
+	if other.Active().ID != active.ID {
+		t.Errorf("Expected key %s reloaded, got %s", active.ID, other.Active().ID)
+	}
+
+	token, _ := k.IssueToken("user-1", "test@securesystem.email", "session-1")
+	if _, err := other.ParseToken(token); err != nil {
+		t.Errorf("Expected token verifiable by another instance: %v", err)
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	dir := t.TempDir()
+	k, _ := LoadKeyring(dir)
+
+	oldKey := k.Active()
+	oldToken, _ := k.IssueToken("user-1", "test@securesystem.email", "session-1")
+
+	newKey, err := k.Rotate()
+	if err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	if newKey.ID == oldKey.ID {
+		t.Fatal("Expected a new key ID")
+	}
+
+	// The new key is published at once but only signs after the JWKS cache
+	// lifetime, so cached copies downstream know it first
+	if k.Active().ID != oldKey.ID {
+		t.Errorf("Expected %s to keep signing until the new key activates", oldKey.ID)
+	}
+	if len(k.JWKS().Keys) != 2 {
+		t.Errorf("Expected both keys published, got %d", len(k.JWKS().Keys))
+	}
+	if got := k.ActiveAt(newKey.CreatedAt.Add(KeyActivationDelay)); got == nil || got.ID != newKey.ID {
+		t.Fatal("Expected the new key to become active after KeyActivationDelay")
+	}
+
+	newKey.CreatedAt = newKey.CreatedAt.Add(-KeyActivationDelay)
+	newToken, _ := k.IssueToken("user-1", "test@securesystem.email", "session-1")
+	parsed, _ := jwt.Parse(newToken, nil)
+	if parsed == nil || parsed.Header["kid"] != newKey.ID {
+		t.Errorf("Expected kid %s in new token header", newKey.ID)
+	}
+
+	// Old tokens stay valid while they can still be unexpired
+	if err := k.Prune(time.Now()); err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if _, err := k.ParseToken(oldToken); err != nil {
+		t.Errorf("Expected old token valid right after rotation: %v", err)
+	}
+	if keys := k.Keys(); len(keys) != 2 || keys[1].ID != newKey.ID {
+		t.Errorf("Expected both keys listed with the newest last, got %d", len(keys))
+	}
+
+	// Once the new key has signed for the access token lifetime, the old
+	// key is dropped
+	if err := k.Prune(newKey.CreatedAt.Add(KeyActivationDelay + AccessTokenTTL + time.Minute)); err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if _, err := k.ParseToken(oldToken); err == nil {
+		t.Error("Expected token from pruned key to be rejected")
+	}
+	if _, err := k.ParseToken(newToken); err != nil {
+		t.Errorf("Expected token from active key valid: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, oldKey.ID+".pem")); !os.IsNotExist(err) {
+		t.Error("Expected pruned key file removed")
+	}
+}
+
+func TestKeyringReloadsOnUnknownKid(t *testing.T) {
+	dir := t.TempDir()
+	local, _ := LoadKeyring(dir)
+	peer, _ := LoadKeyring(dir)
+
+	// A peer publishes a key and, once it activates, signs with it before
+	// this instance's rotation loop has re-read the directory
+	sign := func() string {
+		key, err := peer.Rotate()
+		if err != nil {
+			t.Fatalf("Rotate failed: %v", err)
+		}
+		key.CreatedAt = key.CreatedAt.Add(-KeyActivationDelay)
+		token, _ := peer.IssueToken("user-1", "test@securesystem.email", "session-1")
+		return token
+	}
+
+	if _, err := local.ParseToken(sign()); err != nil {
+		t.Errorf("Expected unknown kid to trigger a reload: %v", err)
+	}
+
+	// Unknown kids re-read the directory at most once per missReloadInterval
+	if _, err := local.ParseToken(sign()); err == nil {
+		t.Error("Expected a second unknown kid within the interval to be rejected")
+	}
+	local.lastMissAt = time.Now().Add(-missReloadInterval)
+	if _, err := local.ParseToken(sign()); err != nil {
+		t.Errorf("Expected reload once the interval has passed: %v", err)
+	}
+}
+
+func TestParseTokenRejectsForeignAndSymmetricTokens(t *testing.T) {
+	claims := jwt.MapClaims{
+		"user_id": "user-1",
+		"email":   "test@securesystem.email",
+		"exp":     time.Now().Add(time.Minute).Unix(),
+	}
+
+	// Signed by a key outside the keyring but claiming the active kid
+	k := testKeyring
+	foreign, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["kid"] = k.Active().ID
+	forged, _ := token.SignedString(foreign)
+	if _, err := k.ParseToken(forged); err == nil {
+		t.Error("Expected token signed by a foreign key to be rejected")
+	}
+
+	// Signed by the active key but missing jti, sid and scope
+	token = jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["kid"] = k.Active().ID
+	bare, _ := token.SignedString(k.Active().Key)
+	if _, err := k.ParseToken(bare); err == nil {
+		t.Error("Expected token without jti, sid and scope to be rejected")
+	}
+
+	// HS256 tokens are no longer accepted
+	hs, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("test-secret-32-bytes-1234567890ab"))
+	if _, err := k.ParseToken(hs); err == nil {
+		t.Error("Expected HS256 token to be rejected")
+	}
+}
+
+func TestJWKSHandler(t *testing.T) {
+	k, _ := NewKeyring()
+	token, _ := k.IssueToken("user-1", "test@securesystem.email", "session-1")
+
+	rr := httptest.NewRecorder()
+	JWKSHandler(k)(rr, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+	var set JWKSet
+	if err := json.NewDecoder(rr.Body).Decode(&set); err != nil {
+		t.Fatalf("Failed to decode JWKS: %v", err)
+	}
+	if len(set.Keys) != 1 {
+		t.Fatalf("Expected 1 key, got %d", len(set.Keys))
+	}
+	jwk := set.Keys[0]
+	if jwk.Kty != "EC" || jwk.Crv != "P-256" || jwk.Alg != "ES256" || jwk.Kid != k.Active().ID {
+		t.Errorf("Unexpected JWK %+v", jwk)
+	}
+
+	// A downstream service can verify the token from the JWKS alone
+	x, _ := base64.RawURLEncoding.DecodeString(jwk.X)
+	y, _ := base64.RawURLEncoding.DecodeString(jwk.Y)
+	pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	parsed, err := jwt.Parse(token, func(*jwt.Token) (interface{}, error) { return pub, nil })
+	if err != nil || !parsed.Valid {
+		t.Errorf("Expected token verifiable with published key: %v", err)
+	}
+}
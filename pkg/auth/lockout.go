@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"secure-email-mvp/pkg/store"
+)
+
+// LockoutPolicy controls how failed logins for one email slow down and then
+// block further attempts
+type LockoutPolicy struct {
+	BackoffAfter int           // Failures before each new attempt must wait
+	BaseDelay    time.Duration // First wait; doubles with every further failure
+	LockAfter    int           // Failures after which every attempt waits LockDuration
+	LockDuration time.Duration
+	ResetAfter   time.Duration // Quiet period after which the count starts over
+}
+
+// DefaultLockout is the policy applied by Authenticate unless configured
+// otherwise. The count only resets on a successful login, an admin unlock or
+// ResetAfter without failures, so each failure past LockAfter locks the
+// account again.
+var DefaultLockout = LockoutPolicy{
+	BackoffAfter: 5,
+	BaseDelay:    time.Second,
+	LockAfter:    10,
+	LockDuration: 15 * time.Minute,
+	ResetAfter:   24 * time.Hour,
+}
+
+// LockoutError is returned while an email is backing off or locked out
+type LockoutError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockoutError) Error() string {
+	return fmt.Sprintf("too many failed logins, retry in %v", e.RetryAfter)
+}
+
+// delay returns how long to wait after the given number of failures
+func (p LockoutPolicy) delay(failures int) time.Duration {
+	switch {
+	case failures >= p.LockAfter:
+		return p.LockDuration
+	case failures >= p.BackoffAfter:
+		d := p.BaseDelay << uint(failures-p.BackoffAfter)
+		if d > p.LockDuration || d <= 0 {
+			return p.LockDuration
+		}
+		return d
+	}
+	return 0
+}
+
+// lockoutKey is the form of email failures are counted under: the whole
+// address in lower case, as Users.ByEmail matches it, so case variants of one
+// account share a count
+func lockoutKey(email string) string {
+	return strings.ToLower(NormalizeEmail(email))
+}
+
+// reserveLoginAttempt counts an attempt for email as a failure before its
+// password is checked, or returns a *LockoutError if email must wait first.
+// The count is claimed with a compare-and-swap, so a parallel burst cannot
+// pass the check before any of its failures is recorded. A successful login
+// clears the count with UnlockAccount. The count starts over if the previous
+// failure is older than p.ResetAfter.
+func reserveLoginAttempt(counts store.LoginFailures, p LockoutPolicy, email string, now time.Time) error {
+	email = lockoutKey(email)
+	for {
+		current, err := counts.Get(email)
+		if err == store.ErrNotFound {
+			err := counts.Create(store.LoginFailure{Email: email, Failures: 1, LastAt: now})
+			if err != store.ErrExists {
+				return err
+			}
+			continue // Another attempt created the count first
+		}
+		if err != nil {
+			return err
+		}
+
+		next := store.LoginFailure{Email: email, Failures: current.Failures + 1, LastAt: now}
+		if now.Sub(current.LastAt) >= p.ResetAfter {
+			next.Failures = 1
+		} else if wait := current.LastAt.Add(p.delay(current.Failures)).Sub(now); wait > 0 {
+			return &LockoutError{RetryAfter: wait}
+		}
+		err = counts.Replace(current, next)
+		if err != store.ErrNotFound {
+			return err
+		}
+		// Another attempt claimed the count first; check again against it
+	}
+}
+
+// releaseLoginAttempt returns an attempt reserved for email whose password
+// was never checked, such as when the hash pool turned it away
+func releaseLoginAttempt(counts store.LoginFailures, email string) error {
+	return counts.Release(lockoutKey(email))
+}
+
+// UnlockAccount clears failed logins for email, in any case, lifting any
+// backoff or lock. It reports whether there was anything to clear.
+func (s *Service) UnlockAccount(email string) (bool, error) {
+	err := s.Store.Failures.Delete(lockoutKey(email))
+	if err == store.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// PurgeLoginFailures deletes failure counts that the lockout policy has
+// already reset
+func (s *Service) PurgeLoginFailures(now time.Time) error {
+	_, err := s.Store.Failures.DeleteBefore(now.Add(-s.Lockout.ResetAfter))
+	return err
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+// dummyPasswordHash returns a hash with the current parameters so unknown
+// emails cost as much to check as a wrong password
+func dummyPasswordHash() string {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = HashPassword("dummy password for unknown users")
+	})
+	return dummyHash
+}
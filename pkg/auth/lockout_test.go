@@ -0,0 +1,244 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLockoutDelay(t *testing.T) {
+	p := LockoutPolicy{BackoffAfter: 3, BaseDelay: time.Second, LockAfter: 6, LockDuration: time.Minute}
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{2, 0},
+		{3, time.Second},
+		{4, 2 * time.Second},
+		{5, 4 * time.Second},
+		{6, time.Minute},
+		{20, time.Minute},
+	}
+	for _, tt := range tests {
+		if got := p.delay(tt.failures); got != tt.want {
+			t.Errorf("delay(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+
+	// Backoff never exceeds the lock duration
+	p.LockAfter = 100
+	if got := p.delay(90); got != time.Minute {
+		t.Errorf("Expected backoff capped at %v, got %v", time.Minute, got)
+	}
+}
+
+// rewindFailures moves the last failure for email back by d
+func rewindFailures(t *testing.T, db *sql.DB, email string, d time.Duration) {
+	if _, err := db.Exec("UPDATE login_failures SET last_failure_at = last_failure_at - ? WHERE email = ?", int64(d.Seconds()), email); err != nil {
+		t.Fatal("Failed to rewind failures:", err)
+	}
+}
+
+func failureCount(db *sql.DB, email string) int {
+	var n int
+	db.QueryRow("SELECT failures FROM login_failures WHERE email = ?", email).Scan(&n)
+	return n
+}
+
+func TestAuthenticateLockout(t *testing.T) {
+	db := newAccountTestDB(t)
+	svc := newTestService(db)
+	svc.Lockout = LockoutPolicy{BackoffAfter: 2, BaseDelay: time.Minute, LockAfter: 3, LockDuration: time.Hour, ResetAfter: 24 * time.Hour}
+	email := "test@securesystem.email"
+
+	_, _, err := svc.Authenticate(context.Background(), email, "wrongpass123", totpAt(testTOTPSecret, 0), ClientInfo{})
+	if err != ErrInvalidCredentials {
+		t.Fatalf("Expected ErrInvalidCredentials, got %v", err)
+	}
+
+	// A wrong second factor counts too, and the second failure starts the backoff
+	svc.Authenticate(context.Background(), email, "securepass123", "000000", ClientInfo{})
+	if n := failureCount(db, email); n != 2 {
+		t.Fatalf("Expected 2 failures, got %d", n)
+	}
+	_, _, err = svc.Authenticate(context.Background(), email, "securepass123", totpAt(testTOTPSecret, 0), ClientInfo{})
+	var locked *LockoutError
+	if !errors.As(err, &locked) || locked.RetryAfter <= 0 || locked.RetryAfter > time.Minute {
+		t.Fatalf("Expected a backoff of up to a minute even with the right password, got %v", err)
+	}
+
+	// Once the backoff passes, the third failure locks the account
+	rewindFailures(t, db, email, time.Minute)
+	svc.Authenticate(context.Background(), email, "wrongpass123", totpAt(testTOTPSecret, 0), ClientInfo{})
+	_, _, err = svc.Authenticate(context.Background(), email, "securepass123", totpAt(testTOTPSecret, 0), ClientInfo{})
+	if !errors.As(err, &locked) || locked.RetryAfter <= 30*time.Minute {
+		t.Fatalf("Expected an hour-long lock, got %v", err)
+	}
+
+	// The lock expires on its own; success clears the count
+	rewindFailures(t, db, email, time.Hour)
+	if _, _, err := svc.Authenticate(context.Background(), email, "securepass123", totpAt(testTOTPSecret, 0), ClientInfo{}); err != nil {
+		t.Fatalf("Expected login after the lock expired, got %v", err)
+	}
+	if n := failureCount(db, email); n != 0 {
+		t.Errorf("Expected failures cleared after success, got %d", n)
+	}
+}
+
+func TestAuthenticateUnknownEmail(t *testing.T) {
+	db := newAccountTestDB(t)
+	svc := newTestService(db)
+	svc.Lockout = LockoutPolicy{BackoffAfter: 2, BaseDelay: time.Minute, LockAfter: 3, LockDuration: time.Hour, ResetAfter: 24 * time.Hour}
+	email := "nobody@securesystem.email"
+
+	// Unknown emails fail exactly like a wrong password and back off the same way
+	for i := 0; i < 2; i++ {
+		if _, _, err := svc.Authenticate(context.Background(), email, "securepass123", totpAt(testTOTPSecret, 0), ClientInfo{}); err != ErrInvalidCredentials {
+			t.Fatalf("Expected ErrInvalidCredentials, got %v", err)
+		}
+	}
+	var locked *LockoutError
+	if _, _, err := svc.Authenticate(context.Background(), email, "securepass123", totpAt(testTOTPSecret, 0), ClientInfo{}); !errors.As(err, &locked) {
+		t.Errorf("Expected unknown email to back off, got %v", err)
+	}
+}
+
+func TestLockoutIgnoresCase(t *testing.T) {
+	db := newAccountTestDB(t)
+	svc := newTestService(db)
+	svc.Lockout = LockoutPolicy{BackoffAfter: 2, BaseDelay: time.Minute, LockAfter: 3, LockDuration: time.Hour, ResetAfter: 24 * time.Hour}
+
+	// Alice@ and alice@ reach the same account, so they share one counter
+	for _, email := range []string{"Test@securesystem.email", "test@SecureSystem.Email"} {
+		if _, _, err := svc.Authenticate(context.Background(), email, "wrongpass123", totpAt(testTOTPSecret, 0), ClientInfo{}); err != ErrInvalidCredentials {
+			t.Fatalf("Expected ErrInvalidCredentials for %s, got %v", email, err)
+		}
+	}
+	var rows int
+	db.QueryRow("SELECT COUNT(*) FROM login_failures").Scan(&rows)
+	if n := failureCount(db, "test@securesystem.email"); rows != 1 || n != 2 {
+		t.Fatalf("Expected one counter with 2 failures, got %d rows and %d failures", rows, n)
+	}
+	var locked *LockoutError
+	if _, _, err := svc.Authenticate(context.Background(), "TEST@securesystem.email", "securepass123", totpAt(testTOTPSecret, 0), ClientInfo{}); !errors.As(err, &locked) {
+		t.Fatalf("Expected another case variant to back off, got %v", err)
+	}
+
+	// Unlocking in any case clears it
+	if cleared, err := svc.UnlockAccount("TeSt@SECURESYSTEM.email"); err != nil || !cleared {
+		t.Errorf("Expected unlock in another case to clear failures, got %v %v", cleared, err)
+	}
+}
+
+func TestReserveLoginAttemptParallel(t *testing.T) {
+	policy := LockoutPolicy{BackoffAfter: 3, BaseDelay: time.Minute, LockAfter: 5, LockDuration: time.Hour, ResetAfter: 24 * time.Hour}
+	db := newAccountTestDB(t)
+	svc := newTestService(db)
+	email := "test@securesystem.email"
+	now := time.Now()
+
+	// A burst of parallel attempts gets exactly BackoffAfter through; every
+	// other one sees the failures already reserved and backs off
+	const attempts = 20
+	errs := make(chan error, attempts)
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- reserveLoginAttempt(svc.Store.Failures, policy, email, now)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	passed := 0
+	for err := range errs {
+		var locked *LockoutError
+		switch {
+		case err == nil:
+			passed++
+		case !errors.As(err, &locked):
+			t.Fatalf("Expected nil or *LockoutError, got %v", err)
+		}
+	}
+	if passed != policy.BackoffAfter {
+		t.Errorf("Expected %d attempts through, got %d", policy.BackoffAfter, passed)
+	}
+	if n := failureCount(db, email); n != policy.BackoffAfter {
+		t.Errorf("Expected %d failures reserved, got %d", policy.BackoffAfter, n)
+	}
+
+	// An attempt whose password was never checked gives its reservation back
+	if err := releaseLoginAttempt(svc.Store.Failures, email); err != nil {
+		t.Fatal("releaseLoginAttempt failed:", err)
+	}
+	if n := failureCount(db, email); n != policy.BackoffAfter-1 {
+		t.Errorf("Expected %d failures after release, got %d", policy.BackoffAfter-1, n)
+	}
+}
+
+// lockEmail reserves DefaultLockout.LockAfter failed attempts for email at now
+func lockEmail(t *testing.T, db *sql.DB, email string, now time.Time) {
+	t.Helper()
+	if _, err := db.Exec("INSERT INTO login_failures (email, failures, last_failure_at) VALUES (?, ?, ?)",
+		email, DefaultLockout.LockAfter, now.Unix()); err != nil {
+		t.Fatal("Failed to insert failures:", err)
+	}
+}
+
+func TestUnlockAccount(t *testing.T) {
+	db := newAccountTestDB(t)
+	svc := newTestService(db)
+	email := "test@securesystem.email"
+	now := time.Now()
+	lockEmail(t, db, email, now)
+	var locked *LockoutError
+	if err := reserveLoginAttempt(svc.Store.Failures, DefaultLockout, email, now); !errors.As(err, &locked) {
+		t.Fatalf("Expected account locked, got %v", err)
+	}
+
+	cleared, err := svc.UnlockAccount(email)
+	if err != nil || !cleared {
+		t.Fatalf("Expected unlock to clear failures, got %v %v", cleared, err)
+	}
+	if err := reserveLoginAttempt(svc.Store.Failures, DefaultLockout, email, now); err != nil {
+		t.Errorf("Expected no lock after unlock, got %v", err)
+	}
+
+	// Success clears the attempt it reserved, leaving nothing to unlock
+	svc.UnlockAccount(email)
+	if cleared, _ := svc.UnlockAccount(email); cleared {
+		t.Error("Expected nothing to clear on second unlock")
+	}
+}
+
+func TestLoginFailuresReset(t *testing.T) {
+	db := newAccountTestDB(t)
+	svc := newTestService(db)
+	email := "test@securesystem.email"
+	now := time.Now()
+	lockEmail(t, db, email, now.Add(-DefaultLockout.ResetAfter))
+
+	// A quiet period longer than ResetAfter lifts the lock and restarts the count
+	if err := reserveLoginAttempt(svc.Store.Failures, DefaultLockout, email, now); err != nil {
+		t.Errorf("Expected stale failures ignored, got %v", err)
+	}
+	if n := failureCount(db, email); n != 1 {
+		t.Errorf("Expected count to restart at 1, got %d", n)
+	}
+
+	lockEmail(t, db, "other@securesystem.email", now.Add(-DefaultLockout.ResetAfter))
+	if err := svc.PurgeLoginFailures(now); err != nil {
+		t.Fatal("PurgeLoginFailures failed:", err)
+	}
+	var remaining int
+	db.QueryRow("SELECT COUNT(*) FROM login_failures").Scan(&remaining)
+	if remaining != 1 {
+		t.Errorf("Expected only the recent count kept, got %d rows", remaining)
+	}
+}
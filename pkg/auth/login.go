@@ -1,27 +1,53 @@
 package auth
 
 import (
-	"bytes"
+	"context"
 	"crypto/rand"
-	"database/sql"
 	"encoding/base32"
+	"errors"
 	"fmt"
 	"regexp"
+	"strings"
 	"time"
 
-	"os"
+	"secure-email-mvp/pkg/audit"
+	"secure-email-mvp/pkg/logging"
+	"secure-email-mvp/pkg/metrics"
+	"secure-email-mvp/pkg/store"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/google/uuid"
-	"github.com/pquerna/otp/totp"
-	"golang.org/x/crypto/argon2"
 )
 
-var emailRegex = regexp.MustCompile(`^[^@]+@securesystem.email$`)
+// DefaultEmailDomain is the domain users sign up with unless configured otherwise
+const DefaultEmailDomain = "securesystem.email"
 
-// ValidateEmail checks if email matches securesystem.email domain
+// ValidateEmail checks if email matches the DefaultEmailDomain domain
 func ValidateEmail(email string) bool {
-	return emailRegex.MatchString(email)
+	return ValidateEmailDomain(email, DefaultEmailDomain)
+}
+
+// ValidateEmailDomain checks if email is an address at domain
+func ValidateEmailDomain(email, domain string) bool {
+	local, host, ok := strings.Cut(email, "@")
+	return ok && local != "" && !strings.ContainsAny(local, "@ ") && strings.EqualFold(host, domain)
+}
+
+// ValidateAddress checks email has the shape local@domain. Login accepts any
+// domain; only addresses that signed up can match a user.
+func ValidateAddress(email string) bool {
+	local, host, ok := strings.Cut(email, "@")
+	return ok && local != "" && host != "" && !strings.ContainsAny(local+host, "@ ")
+}
+
+// NormalizeEmail trims email and lowercases its domain, which is case
+// insensitive. The local part is kept as typed.
+func NormalizeEmail(email string) string {
+	email = strings.TrimSpace(email)
+	local, host, ok := strings.Cut(email, "@")
+	if !ok {
+		return email
+	}
+	return local + "@" + strings.ToLower(host)
 }
 
 // ValidatePassword checks length (8–128 characters)
@@ -34,13 +60,6 @@ func ValidateTOTP(code string) bool {
 	return len(code) == 6 && regexp.MustCompile(`^\d{6}$`).MatchString(code)
 }
 
-// HashPassword creates Argon2 hash with email as salt
-func HashPassword(password, email string) (string, error) {
-	// Hash password using Argon2 with email as salt
-	hash := argon2.IDKey([]byte(password), []byte(email), 1, 64*1024, 4, 32)
-	return string(hash), nil
-}
-
 // GenerateTOTPSecret creates a new base32 TOTP secret
 func GenerateTOTPSecret() (string, error) {
 	// Generate 20 random bytes for TOTP secret
@@ -51,67 +70,134 @@ func GenerateTOTPSecret() (string, error) {
 	return base32.StdEncoding.EncodeToString(secret), nil
 }
 
-// Authenticate verifies credentials and returns JWT
-func Authenticate(db *sql.DB, email, password, totpCode string) (string, string, error) {
+// Authenticate verifies credentials and starts a session, returning its
+// token pair and the user ID
+func (s *Service) Authenticate(ctx context.Context, email, password, totpCode string, client ClientInfo) (*TokenPair, string, error) {
+	if !ValidateTOTP(totpCode) {
+		return nil, "", fmt.Errorf("invalid TOTP format")
+	}
+	return s.authenticate(ctx, email, password, audit.ActionLogin, client, func(userID, totpSecret string) error {
+		// Verify TOTP; each code is single-use
+		return s.CheckTOTP(userID, totpSecret, totpCode)
+	})
+}
+
+// AuthenticateWithSecondFactor verifies email and password, then runs check in
+// place of a TOTP code. It lets other factors, such as passkeys, reuse login.
+func (s *Service) AuthenticateWithSecondFactor(ctx context.Context, email, password string, client ClientInfo, check func(userID string) error) (*TokenPair, string, error) {
+	return s.authenticate(ctx, email, password, audit.ActionLoginSecondFactor, client, func(userID, totpSecret string) error {
+		return check(userID)
+	})
+}
+
+// authenticate checks email and password, then runs the second-factor check
+// before starting a session. Every attempt is audited as action, against the
+// account when the email matches one, and counted in metrics.
+func (s *Service) authenticate(ctx context.Context, email, password, action string, client ClientInfo, secondFactor func(userID, totpSecret string) error) (tokens *TokenPair, userID string, err error) {
+	email = NormalizeEmail(email)
+	var target string
+	defer func() {
+		e := audit.Event{Action: action, ActorEmail: email, Target: target}
+		if err == nil {
+			e.ActorID = userID
+		}
+		s.RecordAudit(ctx, client, auditOutcome(e, err))
+		metrics.AuthAttempt(action, loginResult(err, target != ""))
+	}()
+
 	// Validate inputs
-	if !ValidateEmail(email) {
-		return "", "", fmt.Errorf("invalid email format")
+	if !ValidateAddress(email) {
+		return nil, "", fmt.Errorf("invalid email format")
 	}
 	if !ValidatePassword(password) {
-		return "", "", fmt.Errorf("invalid password length")
+		return nil, "", fmt.Errorf("invalid password length")
 	}
-	if !ValidateTOTP(totpCode) {
-		return "", "", fmt.Errorf("invalid TOTP format")
+
+	// Refuse early while this email is backing off, otherwise count the
+	// attempt as a failure until it succeeds. The wait depends only on the
+	// email, not on whether the account exists.
+	if err := reserveLoginAttempt(s.Store.Failures, s.Lockout, email, time.Now()); err != nil {
+		return nil, "", err
 	}
 
-	// Query user
-	var user struct {
-		ID           string
-		PasswordHash string
-		TOTPSecret   string
+	// Query user. Unknown emails are checked against a dummy hash so they take
+	// as long as a wrong password and count towards lockout the same way.
+	user, err := s.Store.Users.ByEmail(email)
+	target = user.ID
+	if err == store.ErrNotFound {
+		user.PasswordHash = dummyPasswordHash()
+	} else if err != nil {
+		return nil, "", err
 	}
-	err := db.QueryRow("SELECT id, password_hash, totp_secret FROM users WHERE email = ?", email).Scan(&user.ID, &user.PasswordHash, &user.TOTPSecret)
+
+	// Verify password with Argon2
+	ok, needsRehash, err := s.Hash.VerifyPassword(ctx, password, email, user.PasswordHash)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return "", "", fmt.Errorf("user not found")
+		if hashBusy(err) {
+			if rerr := releaseLoginAttempt(s.Store.Failures, email); rerr != nil {
+				logging.FromContext(ctx).Error("Releasing login attempt failed", "email", email, "error", rerr)
+			}
 		}
-		return "", "", fmt.Errorf("database error: %v", err)
+		return nil, "", fmt.Errorf("password verification error: %w", err)
 	}
-
-	// Verify password with Argon2
-	expectedHash := []byte(user.PasswordHash)
-	actualHash := argon2.IDKey([]byte(password), []byte(email), 1, 64*1024, 4, 32)
-	if !bytes.Equal(expectedHash, actualHash) {
-		return "", "", fmt.Errorf("invalid password")
+	if !ok || user.ID == "" {
+		return nil, "", ErrInvalidCredentials
 	}
 
-	// Verify TOTP
-	if !totp.Validate(totpCode, user.TOTPSecret) {
-		return "", "", fmt.Errorf("invalid TOTP code")
+	if err := secondFactor(user.ID, user.TOTPSecret); err != nil {
+		return nil, "", err
+	}
+	if _, err := s.UnlockAccount(email); err != nil {
+		logging.FromContext(ctx).Error("Clearing failed logins failed", "user_id", user.ID, "error", err)
 	}
 
-	// Generate JWT
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		return "", "", fmt.Errorf("JWT_SECRET not configured")
+	// Upgrade legacy or outdated hashes now that we know the password
+	if needsRehash {
+		if err := s.rehashPassword(ctx, user.ID, password); err != nil {
+			logging.FromContext(ctx).Error("Password rehash failed", "user_id", user.ID, "error", err)
+		}
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": user.ID,
-		"email":   email,
-		"exp":     time.Now().Add(24 * time.Hour).Unix(),
-		"iat":     time.Now().Unix(),
-	})
-	tokenString, err := token.SignedString([]byte(jwtSecret))
+	// Start session and generate JWT
+	tokens, err = s.CreateSession(user.ID, user.Email, client)
 	if err != nil {
-		return "", "", fmt.Errorf("JWT signing error: %v", err)
+		return nil, "", err
 	}
 
-	return tokenString, user.ID, nil
+	return tokens, user.ID, nil
+}
+
+// loginResult names the outcome of a login for metrics: success or why it
+// failed. knownUser tells a wrong password from an unknown email.
+func loginResult(err error, knownUser bool) string {
+	var locked *LockoutError
+	switch {
+	case err == nil:
+		return "success"
+	case errors.As(err, &locked):
+		return "locked"
+	case hashBusy(err):
+		return "busy"
+	case errors.Is(err, ErrInvalidCredentials) && !knownUser:
+		return "unknown_user"
+	case errors.Is(err, ErrInvalidCredentials):
+		return "bad_password"
+	case errors.Is(err, ErrTOTPInvalid), errors.Is(err, ErrTOTPReplayed):
+		return "bad_totp"
+	case errors.Is(err, ErrRecoveryCodeInvalid):
+		return "bad_recovery_code"
+	case errors.Is(err, ErrAccountDisabled):
+		return "disabled"
+	case knownUser:
+		return "bad_second_factor"
+	default:
+		return "other" // Malformed input or an internal error
+	}
 }
 
 // CreateUser creates a new user with hashed password and TOTP secret
-func CreateUser(db *sql.DB, email, password string) (string, string, error) {
+func (s *Service) CreateUser(email, password string) (string, string, error) {
+	email = NormalizeEmail(email)
 	// Validate inputs
 	if !ValidateEmail(email) {
 		return "", "", fmt.Errorf("invalid email format")
@@ -121,19 +207,17 @@ func CreateUser(db *sql.DB, email, password string) (string, string, error) {
 	}
 
 	// Check if user already exists
-	var existingID string
-	err := db.QueryRow("SELECT id FROM users WHERE email = ?", email).Scan(&existingID)
-	if err == nil {
+	if _, err := s.Store.Users.ByEmail(email); err == nil {
 		return "", "", fmt.Errorf("user already exists")
-	} else if err != sql.ErrNoRows {
-		return "", "", fmt.Errorf("database error: %v", err)
+	} else if err != store.ErrNotFound {
+		return "", "", err
 	}
 
 	// Generate user ID
 	userID := uuid.New().String()
 
-	// Hash password using Argon2 with email as salt
-	passwordHash, err := HashPassword(password, email)
+	// Hash password using Argon2
+	passwordHash, err := HashPassword(password)
 	if err != nil {
 		return "", "", fmt.Errorf("password hashing error: %v", err)
 	}
@@ -145,44 +229,31 @@ func CreateUser(db *sql.DB, email, password string) (string, string, error) {
 	}
 
 	// Insert user
-	_, err = db.Exec("INSERT INTO users (id, email, password_hash, totp_secret) VALUES (?, ?, ?, ?)",
-		userID, email, passwordHash, totpSecret)
+	err = s.Store.Users.Create(store.User{ID: userID, Email: email, PasswordHash: passwordHash, TOTPSecret: totpSecret})
+	if err == store.ErrExists {
+		return "", "", fmt.Errorf("user already exists")
+	}
 	if err != nil {
-		return "", "", fmt.Errorf("database insert error: %v", err)
+		return "", "", err
 	}
 
 	return userID, totpSecret, nil
 }
 
-// ValidateJWT validates and parses JWT token
-func ValidateJWT(tokenString string) (string, string, error) {
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		return "", "", fmt.Errorf("JWT_SECRET not configured")
-	}
-
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(jwtSecret), nil
-	})
-
+// rehashPassword replaces a user's stored hash with one using the current format and parameters
+func (s *Service) rehashPassword(ctx context.Context, userID, password string) error {
+	passwordHash, err := s.Hash.HashPassword(ctx, password)
 	if err != nil {
-		return "", "", fmt.Errorf("JWT parsing error: %v", err)
+		return err
 	}
+	return s.Store.Users.SetPasswordHash(userID, passwordHash)
+}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		userID, ok := claims["user_id"].(string)
-		if !ok {
-			return "", "", fmt.Errorf("invalid user_id in JWT")
-		}
-		email, ok := claims["email"].(string)
-		if !ok {
-			return "", "", fmt.Errorf("invalid email in JWT")
-		}
-		return userID, email, nil
+// ValidateJWT validates and parses JWT token
+func (k *Keyring) ValidateJWT(tokenString string) (string, string, error) {
+	claims, err := k.ParseToken(tokenString)
+	if err != nil {
+		return "", "", err
 	}
-
-	return "", "", fmt.Errorf("invalid JWT token")
+	return claims.UserID, claims.Email, nil
 }
@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"secure-email-mvp/pkg/logging"
+	"secure-email-mvp/pkg/store"
+)
+
+// Identity is the authenticated caller attached to a request by RequireAuth
+type Identity struct {
+	UserID    string    `json:"user_id"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	TokenID   string    `json:"token_id"`
+	SessionID string    `json:"session_id"`
+	Scopes    []string  `json:"scopes"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// HasScope reports whether the identity was granted scope
+func (id *Identity) HasScope(scope string) bool {
+	for _, s := range id.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type identityKey struct{}
+
+// ContextWithIdentity returns a copy of ctx carrying id
+func ContextWithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFromContext returns the identity set by RequireAuth, if any
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(*Identity)
+	return id, ok && id != nil
+}
+
+// UserIDFromContext returns the authenticated user ID or "" if there is none
+func UserIDFromContext(ctx context.Context) string {
+	if id, ok := IdentityFromContext(ctx); ok {
+		return id.UserID
+	}
+	return ""
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer <token>" header
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+	http.Error(w, `{"error":"Unauthorized"}`, http.StatusUnauthorized)
+}
+
+// RequireAuth validates the bearer JWT, checks neither it nor its session has
+// been revoked, loads the user it belongs to and stores an Identity in the
+// request context. Anything else is rejected with 401.
+func RequireAuth(s *Service) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tokenString, ok := bearerToken(r)
+			if !ok {
+				unauthorized(w)
+				return
+			}
+
+			claims, err := s.Keyring.ParseToken(tokenString)
+			if err != nil {
+				unauthorized(w)
+				return
+			}
+
+			revoked, err := s.IsTokenRevoked(claims.TokenID)
+			if err != nil {
+				http.Error(w, `{"error":"Internal server error"}`, http.StatusInternalServerError)
+				logging.FromContext(r.Context()).Error("Auth middleware failed", "error", err)
+				return
+			}
+			if revoked {
+				unauthorized(w)
+				return
+			}
+
+			// The user may have been removed or disabled, or the session revoked,
+			// since the token was issued. The role is read fresh on every request.
+			session, err := s.Store.Sessions.ByID(claims.SessionID)
+			var user store.User
+			if err == nil {
+				user, err = s.Store.Users.ByID(session.UserID)
+			}
+			if err == store.ErrNotFound {
+				unauthorized(w)
+				return
+			}
+			if err != nil {
+				http.Error(w, `{"error":"Internal server error"}`, http.StatusInternalServerError)
+				logging.FromContext(r.Context()).Error("Auth middleware failed", "error", err)
+				return
+			}
+			now := time.Now()
+			if session.UserID != claims.UserID || !session.RevokedAt.IsZero() || !user.DisabledAt.IsZero() ||
+				now.Unix() >= session.ExpiresAt.Unix() {
+				unauthorized(w)
+				return
+			}
+			if now.Sub(session.LastSeenAt) >= sessionTouchInterval {
+				if err := s.Store.Sessions.Touch(session.ID, now); err != nil {
+					logging.FromContext(r.Context()).Error("Session touch failed", "error", err)
+				}
+			}
+
+			id := &Identity{
+				UserID:    claims.UserID,
+				Email:     user.Email,
+				Role:      user.Role,
+				TokenID:   claims.TokenID,
+				SessionID: claims.SessionID,
+				Scopes:    claims.Scopes,
+				ExpiresAt: claims.ExpiresAt,
+			}
+			logging.SetUserID(r.Context(), id.UserID)
+			next.ServeHTTP(w, r.WithContext(ContextWithIdentity(r.Context(), id)))
+		})
+	}
+}
+
+// MeHandler returns the identity of the authenticated caller
+func MeHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := IdentityFromContext(r.Context())
+	if !ok {
+		unauthorized(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(id); err != nil {
+		logging.FromContext(r.Context()).Warn("Me response failed", "error", err)
+	}
+}
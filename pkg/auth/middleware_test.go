@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireAuth(t *testing.T) {
+	db := newSessionTestDB(t)
+	svc := newTestService(db)
+
+	session, _ := svc.CreateSession("user-1", "test@securesystem.email", ClientInfo{})
+	valid := session.AccessToken
+	claims, _ := testKeyring.ParseToken(valid)
+	sessionID := claims.SessionID
+	orphan, _ := testKeyring.IssueToken("deleted-user", "gone@securesystem.email", sessionID)
+	noSession, _ := testKeyring.IssueToken("user-1", "test@securesystem.email", "missing-session")
+
+	revoked, _ := testKeyring.IssueToken("user-1", "test@securesystem.email", sessionID)
+	claims, _ = testKeyring.ParseToken(revoked)
+	if err := svc.RevokeToken(claims.TokenID, claims.ExpiresAt); err != nil {
+		t.Fatal("Failed to revoke token:", err)
+	}
+
+	ended, _ := svc.CreateSession("user-1", "test@securesystem.email", ClientInfo{})
+	claims, _ = testKeyring.ParseToken(ended.AccessToken)
+	if err := svc.RevokeSession(claims.SessionID); err != nil {
+		t.Fatal("Failed to revoke session:", err)
+	}
+
+	var seen *Identity
+	handler := RequireAuth(svc)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = IdentityFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name   string
+		header string
+		status int
+	}{
+		{"Valid token", "Bearer " + valid, http.StatusOK},
+		{"Lowercase scheme", "bearer " + valid, http.StatusOK},
+		{"Missing header", "", http.StatusUnauthorized},
+		{"Wrong scheme", "Basic " + valid, http.StatusUnauthorized},
+		{"Malformed token", "Bearer not.a.jwt", http.StatusUnauthorized},
+		{"Unknown user", "Bearer " + orphan, http.StatusUnauthorized},
+		{"Revoked token", "Bearer " + revoked, http.StatusUnauthorized},
+		{"Unknown session", "Bearer " + noSession, http.StatusUnauthorized},
+		{"Revoked session", "Bearer " + ended.AccessToken, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen = nil
+			req, _ := http.NewRequest("GET", "/api/auth/me", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			if rr.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, rr.Code)
+			}
+			if tt.status == http.StatusOK {
+				if seen == nil || seen.UserID != "user-1" || seen.Email != "test@securesystem.email" {
+					t.Errorf("Expected identity for user-1, got %+v", seen)
+				} else if seen.TokenID == "" || seen.SessionID != sessionID || !seen.HasScope(ScopeUser) {
+					t.Errorf("Expected token ID, session ID and user scope, got %+v", seen)
+				}
+			} else if rr.Header().Get("WWW-Authenticate") == "" {
+				t.Error("Expected WWW-Authenticate header on 401")
+			}
+		})
+	}
+}
+
+func TestMeHandler(t *testing.T) {
+	id := &Identity{UserID: "user-1", Email: "test@securesystem.email", TokenID: "jti", Scopes: []string{ScopeUser}}
+	req, _ := http.NewRequest("GET", "/api/auth/me", nil)
+	req = req.WithContext(ContextWithIdentity(req.Context(), id))
+	rr := httptest.NewRecorder()
+	MeHandler(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+	var got Identity
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if got.UserID != id.UserID || got.Email != id.Email {
+		t.Errorf("Expected %+v, got %+v", id, got)
+	}
+	if UserIDFromContext(req.Context()) != "user-1" {
+		t.Error("UserIDFromContext did not return the identity's user ID")
+	}
+}
@@ -0,0 +1,191 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"secure-email-mvp/pkg/metrics"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// PasswordParams controls the cost of Argon2id password hashing
+type PasswordParams struct {
+	Time    uint32 // Iterations
+	Memory  uint32 // Memory in KiB
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+// DefaultPasswordParams are used for every new hash. Stored hashes with
+// other parameters are upgraded on the next successful login.
+var DefaultPasswordParams = PasswordParams{
+	Time:    1,
+	Memory:  64 * 1024,
+	Threads: 4,
+	SaltLen: 16,
+	KeyLen:  32,
+}
+
+// Legacy hash layouts found in users.password_hash before the PHC format
+const (
+	legacyEmailSaltLen = 32 // argon2id(password, email) written by CreateUser
+	legacyRandomLen    = 48 // salt(16) || argon2id(password, salt) written by SignUpHandler
+)
+
+// Bounds on the parameters of a stored PHC hash, so a corrupt or hostile
+// password_hash cannot crash argon2 or allocate without limit
+const (
+	minPHCSaltLen = 8
+	minPHCKeyLen  = 16
+	maxPHCKeyLen  = 64
+	maxPHCTime    = 16
+	maxPHCMemory  = 1024 * 1024 // 1 GiB in KiB
+)
+
+var b64 = base64.RawStdEncoding
+
+// HashPassword creates a PHC-formatted Argon2id hash with a random salt:
+// $argon2id$v=19$m=65536,t=1,p=4$<salt>$<hash>
+// It hashes on the calling goroutine; request handlers use
+// HashPool.HashPassword so concurrent hashes stay bounded.
+func HashPassword(password string) (string, error) {
+	return hashPasswordWithParams(password, DefaultPasswordParams)
+}
+
+// HashPassword is HashPassword run on the pool, giving up if ctx ends while
+// waiting for a worker
+func (p *HashPool) HashPassword(ctx context.Context, password string) (string, error) {
+	var encoded string
+	var err error
+	if perr := p.Do(ctx, func() {
+		defer observeHash("hash", time.Now())
+		encoded, err = HashPassword(password)
+	}); perr != nil {
+		return "", perr
+	}
+	return encoded, err
+}
+
+// observeHash records the time since start as one Argon2 hash or verification
+func observeHash(op string, start time.Time) {
+	metrics.ObservePasswordHash(op, time.Since(start))
+}
+
+func hashPasswordWithParams(password string, p PasswordParams) (string, error) {
+	salt := make([]byte, p.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %v", err)
+	}
+	hash := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Time, p.Threads, b64.EncodeToString(salt), b64.EncodeToString(hash)), nil
+}
+
+// VerifyPassword checks password against a stored hash in PHC or any legacy
+// format. The email is only used by legacy hashes that were salted with it.
+// needsRehash reports whether the stored hash should be replaced with one
+// produced by HashPassword.
+func VerifyPassword(password, email, encoded string) (ok bool, needsRehash bool, err error) {
+	return verifyPassword(password, email, encoded)
+}
+
+// VerifyPassword is VerifyPassword run on the pool, giving up if ctx ends
+// while waiting for a worker
+func (p *HashPool) VerifyPassword(ctx context.Context, password, email, encoded string) (ok bool, needsRehash bool, err error) {
+	if perr := p.Do(ctx, func() {
+		defer observeHash("verify", time.Now())
+		ok, needsRehash, err = verifyPassword(password, email, encoded)
+	}); perr != nil {
+		return false, false, perr
+	}
+	return ok, needsRehash, err
+}
+
+func verifyPassword(password, email, encoded string) (ok bool, needsRehash bool, err error) {
+	if strings.HasPrefix(encoded, "$argon2id$") {
+		p, salt, hash, err := decodePHC(encoded)
+		if err != nil {
+			return false, false, err
+		}
+		actual := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+		if subtle.ConstantTimeCompare(hash, actual) != 1 {
+			return false, false, nil
+		}
+		return true, p != DefaultPasswordParams, nil
+	}
+
+	var salt, hash []byte
+	switch len(encoded) {
+	case legacyEmailSaltLen:
+		salt, hash = []byte(email), []byte(encoded)
+	case legacyRandomLen:
+		salt, hash = []byte(encoded[:16]), []byte(encoded[16:])
+	default:
+		return false, false, fmt.Errorf("unrecognized password hash format")
+	}
+	actual := argon2.IDKey([]byte(password), salt, 1, 64*1024, 4, 32)
+	if subtle.ConstantTimeCompare(hash, actual) != 1 {
+		return false, false, nil
+	}
+	return true, true, nil
+}
+
+// decodePHC parses $argon2id$v=19$m=...,t=...,p=...$salt$hash
+func decodePHC(encoded string) (PasswordParams, []byte, []byte, error) {
+	var p PasswordParams
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return p, nil, nil, fmt.Errorf("invalid PHC hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return p, nil, nil, fmt.Errorf("invalid PHC version: %v", err)
+	}
+	if version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return p, nil, nil, fmt.Errorf("invalid PHC parameters: %v", err)
+	}
+
+	salt, err := b64.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, fmt.Errorf("invalid PHC salt: %v", err)
+	}
+	hash, err := b64.DecodeString(parts[5])
+	if err != nil {
+		return p, nil, nil, fmt.Errorf("invalid PHC hash: %v", err)
+	}
+	p.SaltLen = uint32(len(salt))
+	p.KeyLen = uint32(len(hash))
+	if err := checkPHCParams(p); err != nil {
+		return p, nil, nil, err
+	}
+	return p, salt, hash, nil
+}
+
+// checkPHCParams rejects parameters argon2.IDKey would panic on or that
+// would cost more than any hash this service writes
+func checkPHCParams(p PasswordParams) error {
+	switch {
+	case p.Threads == 0:
+		return fmt.Errorf("invalid PHC parameters: p must be at least 1")
+	case p.Time == 0 || p.Time > maxPHCTime:
+		return fmt.Errorf("invalid PHC parameters: t=%d out of range", p.Time)
+	case p.Memory < 8*uint32(p.Threads) || p.Memory > maxPHCMemory:
+		return fmt.Errorf("invalid PHC parameters: m=%d out of range", p.Memory)
+	case p.SaltLen < minPHCSaltLen:
+		return fmt.Errorf("invalid PHC salt: %d bytes is too short", p.SaltLen)
+	case p.KeyLen < minPHCKeyLen || p.KeyLen > maxPHCKeyLen:
+		return fmt.Errorf("invalid PHC hash: %d bytes out of range", p.KeyLen)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+	"golang.org/x/crypto/argon2"
+)
+
+func TestVerifyPassword(t *testing.T) {
+	password := "securepass123"
+	email := "test@securesystem.email"
+
+	phc, _ := HashPassword(password)
+	weak, _ := hashPasswordWithParams(password, PasswordParams{Time: 1, Memory: 8 * 1024, Threads: 1, SaltLen: 16, KeyLen: 32})
+	legacyEmail := string(argon2.IDKey([]byte(password), []byte(email), 1, 64*1024, 4, 32))
+	salt := []byte("0123456789abcdef")
+	legacyRandom := string(append(salt, argon2.IDKey([]byte(password), salt, 1, 64*1024, 4, 32)...))
+
+	tests := []struct {
+		name        string
+		password    string
+		encoded     string
+		ok          bool
+		needsRehash bool
+		wantErr     bool
+	}{
+		{"PHC current params", password, phc, true, false, false},
+		{"PHC wrong password", "wrongpass123", phc, false, false, false},
+		{"PHC outdated params", password, weak, true, true, false},
+		{"Legacy email salt", password, legacyEmail, true, true, false},
+		{"Legacy email salt wrong password", "wrongpass123", legacyEmail, false, false, false},
+		{"Legacy random salt", password, legacyRandom, true, true, false},
+		{"Malformed PHC", password, "$argon2id$v=19$garbage", false, false, true},
+		{"PHC empty hash", password, "$argon2id$v=19$m=65536,t=1,p=4$c2FsdHNhbHRzYWx0$", false, false, true},
+		{"PHC zero parallelism", password, strings.Replace(phc, ",p=4$", ",p=0$", 1), false, false, true},
+		{"PHC zero iterations", password, strings.Replace(phc, ",t=1,", ",t=0,", 1), false, false, true},
+		{"PHC huge memory", password, strings.Replace(phc, "m=65536,", "m=4294967295,", 1), false, false, true},
+		{"PHC short salt", password, "$argon2id$v=19$m=65536,t=1,p=4$c2FsdA$" + strings.Split(phc, "$")[5], false, false, true},
+		{"Unknown format", password, "plaintext", false, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, needsRehash, err := VerifyPassword(tt.password, email, tt.encoded)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error=%v, got %v", tt.wantErr, err)
+			}
+			if ok != tt.ok {
+				t.Errorf("Expected ok=%v, got %v", tt.ok, ok)
+			}
+			if needsRehash != tt.needsRehash {
+				t.Errorf("Expected needsRehash=%v, got %v", tt.needsRehash, needsRehash)
+			}
+		})
+	}
+}
+
+func TestAuthenticateRehashesLegacyPassword(t *testing.T) {
+	db := newTestDB(t)
+	svc := newTestService(db)
+
+	email := "legacy@securesystem.email"
+	password := "securepass123"
+	key, _ := totp.Generate(totp.GenerateOpts{Issuer: "SecureEmail", AccountName: email})
+	salt := []byte("0123456789abcdef")
+	legacy := append(salt, argon2.IDKey([]byte(password), salt, 1, 64*1024, 4, 32)...)
+	_, err := db.Exec("INSERT INTO users (id, email, password_hash, totp_secret) VALUES (?, ?, ?, ?)",
+		"legacy-id", email, legacy, key.Secret())
+	if err != nil {
+		t.Fatal("Failed to insert user:", err)
+	}
+
+	totpCode, _ := totp.GenerateCode(key.Secret(), time.Now())
+	if _, _, err := svc.Authenticate(context.Background(), email, password, totpCode, ClientInfo{}); err != nil {
+		t.Fatalf("Authenticate with legacy hash failed: %v", err)
+	}
+
+	var stored string
+	db.QueryRow("SELECT password_hash FROM users WHERE id = ?", "legacy-id").Scan(&stored)
+	if !strings.HasPrefix(stored, "$argon2id$") {
+		t.Fatalf("Expected hash upgraded to PHC format, got %q", stored)
+	}
+
+	// The upgraded hash must keep working; TOTP codes are single-use so take the next step's
+	nextCode, _ := totp.GenerateCode(key.Secret(), time.Now().Add(30*time.Second))
+	if _, _, err := svc.Authenticate(context.Background(), email, password, nextCode, ClientInfo{}); err != nil {
+		t.Errorf("Authenticate after rehash failed: %v", err)
+	}
+}
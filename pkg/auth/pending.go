@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"log/slog"
+	"time"
+
+	"secure-email-mvp/pkg/store"
+)
+
+// ErrPendingNotFound is returned when a temp ID has no pending enrollment
+var ErrPendingNotFound = store.ErrNotFound
+
+// PendingStore holds sign-ups between SignUpHandler and VerifyTotpHandler.
+// store.PendingEnrollments satisfies it.
+type PendingStore interface {
+	Save(tempID string, state TempState) error
+	Load(tempID string) (TempState, error)
+	Delete(tempID string) error
+	DeleteExpired(now time.Time) (int64, error)
+}
+
+// StartPendingSweeper removes expired enrollments every interval until the
+// returned stop function is called
+func StartPendingSweeper(pending PendingStore, interval time.Duration) (stop func()) {
+	return startSweeper(interval, func(now time.Time) {
+		n, err := pending.DeleteExpired(now)
+		if err != nil {
+			slog.Error("Pending enrollment sweep failed", "error", err)
+		} else if n > 0 {
+			slog.Info("Swept expired pending enrollments", "count", n)
+		}
+	})
+}
+
+// startSweeper calls sweep every interval on a single goroutine until stopped
+func startSweeper(interval time.Duration, sweep func(now time.Time)) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				sweep(now)
+			}
+		}
+	}()
+	return func() { close(done) }
+}
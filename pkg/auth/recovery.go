@@ -0,0 +1,191 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"secure-email-mvp/pkg/audit"
+	"secure-email-mvp/pkg/logging"
+	"secure-email-mvp/pkg/store"
+)
+
+// RecoveryCodeCount is how many codes are issued per generation
+const RecoveryCodeCount = 10
+
+// ErrRecoveryCodeInvalid is returned when a recovery code is unknown or already used
+var ErrRecoveryCodeInvalid = errors.New("invalid recovery code")
+
+// Recovery codes are 16 base32 characters (80 bits), shown as XXXX-XXXX-XXXX-XXXX
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate recovery code: %v", err)
+	}
+	raw := recoveryEncoding.EncodeToString(b)
+	return raw[0:4] + "-" + raw[4:8] + "-" + raw[8:12] + "-" + raw[12:16], nil
+}
+
+// normalizeRecoveryCode accepts codes typed in any case, with or without separators
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToUpper(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	return code
+}
+
+// ValidateRecoveryCode checks the code is 16 base32 characters once normalized
+func ValidateRecoveryCode(code string) bool {
+	code = normalizeRecoveryCode(code)
+	if len(code) != 16 {
+		return false
+	}
+	_, err := recoveryEncoding.DecodeString(code)
+	return err == nil
+}
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(normalizeRecoveryCode(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateRecoveryCodes replaces all of the user's recovery codes with a new
+// set and returns the plaintext codes. They cannot be retrieved again.
+func (s *Service) GenerateRecoveryCodes(userID string) ([]string, error) {
+	return replaceRecoveryCodes(s.Store, userID)
+}
+
+// replaceRecoveryCodes is GenerateRecoveryCodes on repos, which may be bound
+// to a transaction
+func replaceRecoveryCodes(repos *store.Store, userID string) ([]string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	hashes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+		hashes[i] = hashRecoveryCode(code)
+	}
+
+	if err := repos.Recovery.Replace(userID, hashes, time.Now()); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// UseRecoveryCode marks one of the user's codes as used
+func (s *Service) UseRecoveryCode(userID, code string) error {
+	err := s.Store.Recovery.Use(userID, hashRecoveryCode(code), time.Now())
+	if err == store.ErrNotFound {
+		return ErrRecoveryCodeInvalid
+	}
+	return err
+}
+
+// RemainingRecoveryCodes counts the user's unused recovery codes
+func (s *Service) RemainingRecoveryCodes(userID string) (int, error) {
+	return s.Store.Recovery.CountUnused(userID)
+}
+
+// AuthenticateWithRecoveryCode verifies email and password with a recovery
+// code in place of a TOTP code and starts a session. The code is consumed.
+func (s *Service) AuthenticateWithRecoveryCode(ctx context.Context, email, password, recoveryCode string, client ClientInfo) (*TokenPair, string, error) {
+	if !ValidateRecoveryCode(recoveryCode) {
+		return nil, "", fmt.Errorf("invalid recovery code format")
+	}
+	return s.authenticate(ctx, email, password, audit.ActionLoginRecoveryCode, client, func(userID, totpSecret string) error {
+		return s.UseRecoveryCode(userID, recoveryCode)
+	})
+}
+
+type RegenerateRecoveryCodesRequest struct {
+	TotpCode string `json:"totp_code"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+	Remaining     int      `json:"remaining"`
+}
+
+// RecoveryCodesStatusHandler returns how many unused recovery codes the
+// caller has left. It must run behind RequireAuth.
+func RecoveryCodesStatusHandler(s *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := IdentityFromContext(r.Context())
+		if !ok {
+			unauthorized(w)
+			return
+		}
+
+		remaining, err := s.RemainingRecoveryCodes(id.UserID)
+		if err != nil {
+			http.Error(w, `{"error":"Internal server error"}`, http.StatusInternalServerError)
+			logging.FromContext(r.Context()).Error("Recovery code count failed", "error", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(RecoveryCodesResponse{Remaining: remaining}); err != nil {
+			logging.FromContext(r.Context()).Warn("Recovery codes response failed", "error", err)
+		}
+	}
+}
+
+// RegenerateRecoveryCodesHandler issues a fresh set of recovery codes after
+// confirming a current TOTP code. It must run behind RequireAuth.
+func RegenerateRecoveryCodesHandler(s *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := IdentityFromContext(r.Context())
+		if !ok {
+			unauthorized(w)
+			return
+		}
+
+		var req RegenerateRecoveryCodesRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !ValidateTOTP(req.TotpCode) {
+			http.Error(w, `{"error":"Invalid request"}`, http.StatusBadRequest)
+			return
+		}
+
+		user, err := s.Store.Users.ByID(id.UserID)
+		if err != nil {
+			http.Error(w, `{"error":"Internal server error"}`, http.StatusInternalServerError)
+			logging.FromContext(r.Context()).Error("Recovery code regeneration failed", "error", err)
+			return
+		}
+		if err := s.CheckTOTP(id.UserID, user.TOTPSecret, req.TotpCode); err != nil {
+			if err == ErrTOTPInvalid || err == ErrTOTPReplayed {
+				http.Error(w, `{"error":"Invalid TOTP code"}`, http.StatusUnauthorized)
+				logging.FromContext(r.Context()).Warn("Recovery code regeneration rejected", "user_id", id.UserID, "error", err)
+				return
+			}
+			http.Error(w, `{"error":"Internal server error"}`, http.StatusInternalServerError)
+			logging.FromContext(r.Context()).Error("Recovery code regeneration failed", "error", err)
+			return
+		}
+
+		codes, err := s.GenerateRecoveryCodes(id.UserID)
+		if err != nil {
+			http.Error(w, `{"error":"Internal server error"}`, http.StatusInternalServerError)
+			logging.FromContext(r.Context()).Error("Recovery code regeneration failed", "error", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(RecoveryCodesResponse{RecoveryCodes: codes, Remaining: len(codes)}); err != nil {
+			logging.FromContext(r.Context()).Warn("Recovery codes response failed", "error", err)
+		}
+		logging.FromContext(r.Context()).Info("Recovery codes regenerated", "user_id", id.UserID)
+	}
+}
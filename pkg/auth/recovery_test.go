@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+)
+
+func TestRecoveryCodes(t *testing.T) {
+	db := newTOTPTestDB(t)
+	svc := newTestService(db)
+
+	codes, err := svc.GenerateRecoveryCodes("user-1")
+	if err != nil {
+		t.Fatalf("GenerateRecoveryCodes failed: %v", err)
+	}
+	if len(codes) != RecoveryCodeCount {
+		t.Fatalf("Expected %d codes, got %d", RecoveryCodeCount, len(codes))
+	}
+	for _, code := range codes {
+		if !ValidateRecoveryCode(code) {
+			t.Errorf("Generated code %q fails validation", code)
+		}
+	}
+
+	// Only hashes are stored
+	var stored int
+	db.QueryRow("SELECT COUNT(*) FROM recovery_codes WHERE code_hash = ?", codes[0]).Scan(&stored)
+	if stored != 0 {
+		t.Error("Expected plaintext code not stored")
+	}
+
+	// Codes are single-use and accepted regardless of case or dashes
+	typed := strings.ToLower(strings.ReplaceAll(codes[0], "-", ""))
+	if err := svc.UseRecoveryCode("user-1", typed); err != nil {
+		t.Fatalf("Expected code accepted, got %v", err)
+	}
+	if err := svc.UseRecoveryCode("user-1", codes[0]); err != ErrRecoveryCodeInvalid {
+		t.Errorf("Expected ErrRecoveryCodeInvalid on reuse, got %v", err)
+	}
+	if remaining, _ := svc.RemainingRecoveryCodes("user-1"); remaining != RecoveryCodeCount-1 {
+		t.Errorf("Expected %d remaining, got %d", RecoveryCodeCount-1, remaining)
+	}
+
+	// Regenerating invalidates the old set
+	if _, err := svc.GenerateRecoveryCodes("user-1"); err != nil {
+		t.Fatalf("GenerateRecoveryCodes failed: %v", err)
+	}
+	if err := svc.UseRecoveryCode("user-1", codes[1]); err != ErrRecoveryCodeInvalid {
+		t.Errorf("Expected old code rejected after regeneration, got %v", err)
+	}
+	if remaining, _ := svc.RemainingRecoveryCodes("user-1"); remaining != RecoveryCodeCount {
+		t.Errorf("Expected %d remaining, got %d", RecoveryCodeCount, remaining)
+	}
+}
+
+func TestAuthenticateWithRecoveryCode(t *testing.T) {
+	db := newTestDB(t)
+	svc := newTestService(db)
+	email := "test@securesystem.email"
+	password := "securepass123"
+	userID, _, err := svc.CreateUser(email, password)
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	codes, _ := svc.GenerateRecoveryCodes(userID)
+
+	if _, _, err := svc.AuthenticateWithRecoveryCode(context.Background(), email, "wrongpass123", codes[0], ClientInfo{}); err == nil {
+		t.Error("Expected wrong password rejected")
+	}
+	if remaining, _ := svc.RemainingRecoveryCodes(userID); remaining != RecoveryCodeCount {
+		t.Error("Expected code not consumed by a failed password check")
+	}
+
+	tokens, id, err := svc.AuthenticateWithRecoveryCode(context.Background(), email, password, codes[0], ClientInfo{})
+	if err != nil {
+		t.Fatalf("AuthenticateWithRecoveryCode failed: %v", err)
+	}
+	if id != userID || tokens.AccessToken == "" {
+		t.Errorf("Expected session for %s, got %s", userID, id)
+	}
+	if _, _, err := svc.AuthenticateWithRecoveryCode(context.Background(), email, password, codes[0], ClientInfo{}); err != ErrRecoveryCodeInvalid {
+		t.Errorf("Expected used code rejected, got %v", err)
+	}
+	if _, _, err := svc.AuthenticateWithRecoveryCode(context.Background(), email, password, "not-a-code", ClientInfo{}); err == nil {
+		t.Error("Expected malformed code rejected")
+	}
+}
+
+func TestRecoveryCodesHandlers(t *testing.T) {
+	db := newTOTPTestDB(t)
+	svc := newTestService(db)
+	codes, _ := svc.GenerateRecoveryCodes("user-1")
+	svc.UseRecoveryCode("user-1", codes[0])
+	tokens, _ := svc.CreateSession("user-1", "test@securesystem.email", ClientInfo{})
+
+	do := func(h http.Handler, method, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, "/api/account/recovery-codes", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+		rr := httptest.NewRecorder()
+		RequireAuth(svc)(h).ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := do(RecoveryCodesStatusHandler(svc), "GET", "")
+	var status RecoveryCodesResponse
+	json.NewDecoder(rr.Body).Decode(&status)
+	if rr.Code != http.StatusOK || status.Remaining != RecoveryCodeCount-1 {
+		t.Errorf("Expected 200 with %d remaining, got %d %+v", RecoveryCodeCount-1, rr.Code, status)
+	}
+
+	regenerate := RegenerateRecoveryCodesHandler(svc)
+	if rr := do(regenerate, "POST", `{"totp_code":"abc"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for malformed code, got %d", rr.Code)
+	}
+
+	code, _ := totp.GenerateCode(testTOTPSecret, time.Now())
+	rr = do(regenerate, "POST", `{"totp_code":"`+code+`"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var fresh RecoveryCodesResponse
+	json.NewDecoder(rr.Body).Decode(&fresh)
+	if len(fresh.RecoveryCodes) != RecoveryCodeCount || fresh.Remaining != RecoveryCodeCount {
+		t.Errorf("Expected a full new set, got %+v", fresh)
+	}
+
+	// The confirming TOTP code is spent
+	if rr := do(regenerate, "POST", `{"totp_code":"`+code+`"}`); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for replayed TOTP code, got %d", rr.Code)
+	}
+}
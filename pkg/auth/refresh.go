@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"secure-email-mvp/pkg/logging"
+)
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// RefreshHandler exchanges a refresh token for a new access/refresh token pair
+func RefreshHandler(s *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req RefreshRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+			http.Error(w, `{"error":"Invalid request"}`, http.StatusBadRequest)
+			return
+		}
+
+		tokens, err := s.RefreshSession(req.RefreshToken, s.clientInfo(r))
+		if err == ErrInvalidRefreshToken || err == ErrRefreshTokenReused {
+			http.Error(w, `{"error":"Invalid refresh token"}`, http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, `{"error":"Internal server error"}`, http.StatusInternalServerError)
+			logging.FromContext(r.Context()).Error("Token refresh failed", "error", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(tokens); err != nil {
+			logging.FromContext(r.Context()).Warn("Token refresh response failed", "error", err)
+		}
+	}
+}
+
+// LogoutHandler revokes the caller's session and current access token. It
+// must run behind RequireAuth.
+func LogoutHandler(s *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := IdentityFromContext(r.Context())
+		if !ok {
+			unauthorized(w)
+			return
+		}
+
+		if id.SessionID != "" {
+			if err := s.RevokeSession(id.SessionID); err != nil {
+				http.Error(w, `{"error":"Internal server error"}`, http.StatusInternalServerError)
+				logging.FromContext(r.Context()).Error("Logout failed", "error", err)
+				return
+			}
+		}
+		if err := s.RevokeToken(id.TokenID, id.ExpiresAt); err != nil {
+			http.Error(w, `{"error":"Internal server error"}`, http.StatusInternalServerError)
+			logging.FromContext(r.Context()).Error("Logout failed", "error", err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRefreshHandler(t *testing.T) {
+	db := newSessionTestDB(t)
+	svc := newTestService(db)
+	handler := RefreshHandler(svc)
+	tokens, _ := svc.CreateSession("user-1", "test@securesystem.email", ClientInfo{})
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/api/auth/refresh", bytes.NewBufferString(body))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := post(`{"refresh_token":"` + tokens.RefreshToken + `"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var refreshed TokenPair
+	if err := json.NewDecoder(rr.Body).Decode(&refreshed); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if refreshed.AccessToken == "" || refreshed.RefreshToken == "" {
+		t.Error("Expected new token pair")
+	}
+
+	if rr := post(`{"refresh_token":"` + tokens.RefreshToken + `"}`); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for reused token, got %d", rr.Code)
+	}
+	if rr := post(`{}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for missing token, got %d", rr.Code)
+	}
+}
+
+func TestLogoutHandler(t *testing.T) {
+	db := newSessionTestDB(t)
+	svc := newTestService(db)
+	tokens, _ := svc.CreateSession("user-1", "test@securesystem.email", ClientInfo{})
+	handler := RequireAuth(svc)(LogoutHandler(svc))
+
+	logout := func() int {
+		req, _ := http.NewRequest("POST", "/api/auth/logout", nil)
+		req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if code := logout(); code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d", code)
+	}
+
+	// The access token is on the revocation list and the session is gone
+	if code := logout(); code != http.StatusUnauthorized {
+		t.Errorf("Expected revoked access token to be rejected, got %d", code)
+	}
+	if _, err := svc.RefreshSession(tokens.RefreshToken, ClientInfo{}); err != ErrInvalidRefreshToken {
+		t.Errorf("Expected refresh after logout to fail, got %v", err)
+	}
+}
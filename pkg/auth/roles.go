@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+
+	"secure-email-mvp/pkg/store"
+)
+
+// Roles a user can have
+const (
+	RoleUser    = "user"    // Manages only their own account
+	RoleAdmin   = "admin"   // Everything
+	RoleAuditor = "auditor" // Reads administrative data but changes nothing
+)
+
+// Permission is an action on administrative data
+type Permission string
+
+const (
+	PermReadUsers     Permission = "users:read"
+	PermManageUsers   Permission = "users:write"
+	PermReadDomains   Permission = "domains:read"
+	PermManageDomains Permission = "domains:write"
+	PermManageInvites Permission = "invites:write"
+	PermReadAudit     Permission = "audit:read"
+)
+
+// rolePermissions lists what each role may do; RoleUser may do none of it
+var rolePermissions = map[string][]Permission{
+	RoleAdmin:   {PermReadUsers, PermManageUsers, PermReadDomains, PermManageDomains, PermManageInvites, PermReadAudit},
+	RoleAuditor: {PermReadUsers, PermReadDomains, PermReadAudit},
+}
+
+// ValidRole reports whether role is one of RoleUser, RoleAdmin or RoleAuditor
+func ValidRole(role string) bool {
+	return role == RoleUser || role == RoleAdmin || role == RoleAuditor
+}
+
+// RoleCan reports whether role grants p
+func RoleCan(role string, p Permission) bool {
+	for _, granted := range rolePermissions[role] {
+		if granted == p {
+			return true
+		}
+	}
+	return false
+}
+
+// Can reports whether the identity's role grants p
+func (id *Identity) Can(p Permission) bool {
+	return RoleCan(id.Role, p)
+}
+
+// RequirePermission lets through only callers whose role grants p and
+// rejects everyone else with 403. It must run behind RequireAuth.
+func RequirePermission(p Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, ok := IdentityFromContext(r.Context())
+			if !ok {
+				unauthorized(w)
+				return
+			}
+			if !id.Can(p) {
+				http.Error(w, `{"error":"Forbidden"}`, http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// SetUserRole changes a user's role
+func (s *Service) SetUserRole(userID, role string) error {
+	if !ValidRole(role) {
+		return fmt.Errorf("invalid role %q", role)
+	}
+	if err := s.Store.Users.SetRole(userID, role); err == store.ErrNotFound {
+		return ErrUserNotFound
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// PromoteAdmins gives RoleAdmin to each existing user whose address is in
+// emails and returns how many changed. Addresses without a user are skipped.
+func (s *Service) PromoteAdmins(emails []string) (int64, error) {
+	var promoted int64
+	for _, email := range emails {
+		user, err := s.Store.Users.ByEmail(NormalizeEmail(email))
+		if err == store.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return promoted, err
+		}
+		if user.Role == RoleAdmin {
+			continue
+		}
+		if err := s.Store.Users.SetRole(user.ID, RoleAdmin); err != nil {
+			return promoted, err
+		}
+		promoted++
+	}
+	return promoted, nil
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRoleCan(t *testing.T) {
+	tests := []struct {
+		role string
+		perm Permission
+		want bool
+	}{
+		{RoleAdmin, PermManageUsers, true},
+		{RoleAdmin, PermManageInvites, true},
+		{RoleAuditor, PermReadUsers, true},
+		{RoleAuditor, PermReadDomains, true},
+		{RoleAuditor, PermReadAudit, true},
+		{RoleAuditor, PermManageUsers, false},
+		{RoleAuditor, PermManageInvites, false},
+		{RoleUser, PermReadUsers, false},
+		{RoleUser, PermReadAudit, false},
+		{"root", PermReadUsers, false},
+	}
+	for _, tt := range tests {
+		if got := RoleCan(tt.role, tt.perm); got != tt.want {
+			t.Errorf("RoleCan(%q, %q) = %v, want %v", tt.role, tt.perm, got, tt.want)
+		}
+	}
+}
+
+func TestPromoteAdmins(t *testing.T) {
+	db := newAccountTestDB(t)
+	svc := newTestService(db)
+
+	n, err := svc.PromoteAdmins([]string{"Test@SecureSystem.email", "nobody@securesystem.email"})
+	if err != nil || n != 1 {
+		t.Fatalf("Expected one user promoted, got %d %v", n, err)
+	}
+	if u, _ := svc.GetUser("user-1"); u.Role != RoleAdmin {
+		t.Errorf("Expected admin role, got %q", u.Role)
+	}
+	// Running again at the next start changes nothing
+	if n, _ := svc.PromoteAdmins([]string{"test@securesystem.email"}); n != 0 {
+		t.Errorf("Expected no change for an existing admin, got %d", n)
+	}
+
+	if err := svc.SetUserRole("user-1", "root"); err == nil {
+		t.Error("Expected invalid role rejected")
+	}
+	if err := svc.SetUserRole("missing", RoleAuditor); err != ErrUserNotFound {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	db := newAccountTestDB(t)
+	svc := newTestService(db)
+	r := newAccountRouter(svc)
+	r.Handle("/api/admin/users", RequirePermission(PermReadUsers)(ListUsersHandler(svc))).Methods("GET")
+	tokens, _ := svc.CreateSession("user-1", "test@securesystem.email", ClientInfo{})
+
+	if rr := doAccount(r, "GET", "/api/admin/users", tokens.AccessToken, nil); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for plain user, got %d", rr.Code)
+	}
+	// The role is read on every request, so a change applies to existing tokens
+	svc.SetUserRole("user-1", RoleAuditor)
+	if rr := doAccount(r, "GET", "/api/admin/users", tokens.AccessToken, nil); rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 for auditor, got %d", rr.Code)
+	}
+	if rr := doAccount(r, "GET", "/api/admin/users", "", nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a token, got %d", rr.Code)
+	}
+}
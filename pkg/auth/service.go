@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"secure-email-mvp/pkg/audit"
+	"secure-email-mvp/pkg/store"
+	"secure-email-mvp/pkg/store/sqlstore"
+)
+
+// Service holds what the auth handlers share: the database, the JWT signing
+// keys, the audit log, the Argon2 worker pool, the TOTP and lockout settings
+// and how to find the caller's IP. main builds one from config and passes it
+// to the handler constructors.
+type Service struct {
+	DB       *sql.DB
+	Store    *store.Store // Every table but the audit log's
+	Keyring  *Keyring
+	Audit    *audit.Log
+	Hash     *HashPool
+	TOTPSkew uint // Time-steps either side of now a TOTP code is accepted for
+	Lockout  LockoutPolicy
+	ClientIP func(*http.Request) string // Nil uses the connection's remote address
+}
+
+// NewService returns a Service on db, which speaks dialect, signing with
+// keyring and chaining audit events with auditKey, with a default hash pool,
+// TOTP skew and lockout policy for the caller to override
+func NewService(db *sql.DB, dialect *sqlstore.Dialect, keyring *Keyring, auditKey []byte) *Service {
+	return &Service{
+		DB:       db,
+		Store:    sqlstore.New(db, dialect),
+		Keyring:  keyring,
+		Audit:    audit.New(db, auditKey, dialect.Rebind),
+		Hash:     NewHashPool(DefaultHashWorkers, DefaultHashQueue),
+		TOTPSkew: DefaultTOTPSkew,
+		Lockout:  DefaultLockout,
+	}
+}
+
+// clientInfo describes the caller of r for sessions and the audit log
+func (s *Service) clientInfo(r *http.Request) ClientInfo {
+	return ClientInfoFromRequest(r, s.ClientIP)
+}
+
+// matchTOTPStep matches code against secret within the service's skew window
+func (s *Service) matchTOTPStep(code, secret string) (int64, error) {
+	return matchTOTPStep(code, secret, time.Now(), s.TOTPSkew)
+}
@@ -0,0 +1,276 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"secure-email-mvp/pkg/store"
+
+	"github.com/google/uuid"
+)
+
+// RefreshTokenTTL is how long a session survives without being refreshed
+const RefreshTokenTTL = 30 * 24 * time.Hour
+
+// sessionTouchInterval limits how often a request updates last_seen_at
+const sessionTouchInterval = time.Minute
+
+var (
+	// ErrInvalidRefreshToken is returned for unknown, expired or revoked refresh tokens
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	// ErrRefreshTokenReused is returned when a rotated-out refresh token is presented again
+	ErrRefreshTokenReused = errors.New("refresh token reuse detected")
+	// ErrSessionNotFound is returned when a session does not exist or belongs to another user
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrAccountDisabled is returned when starting a session for a disabled user
+	ErrAccountDisabled = errors.New("account disabled")
+)
+
+// Session is an active login on one device
+type Session struct {
+	ID         string    `json:"id"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+// TokenPair is returned whenever a session is created or refreshed
+type TokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"` // Access token lifetime in seconds
+}
+
+// ClientInfo describes the device a session was created from
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+}
+
+// ClientInfoFromRequest extracts the caller's user agent and the IP that
+// clientIP resolves, such as one forwarded by a trusted proxy. Without
+// clientIP the connection's remote address is used.
+func ClientInfoFromRequest(r *http.Request, clientIP func(*http.Request) string) ClientInfo {
+	if clientIP != nil {
+		return ClientInfo{IP: clientIP(r), UserAgent: r.UserAgent()}
+	}
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return ClientInfo{IP: ip, UserAgent: r.UserAgent()}
+}
+
+// newRefreshToken returns a random refresh token and the hash we store for it
+func newRefreshToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("failed to generate refresh token: %v", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashRefreshToken(token), nil
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateSession starts a new session for the user and returns its first token
+// pair. Disabled users get ErrAccountDisabled.
+func (s *Service) CreateSession(userID, email string, client ClientInfo) (*TokenPair, error) {
+	refreshToken, refreshHash, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	sessionID := uuid.New().String()
+	now := time.Now()
+	err = s.Store.Sessions.Create(store.Session{
+		ID:          sessionID,
+		UserID:      userID,
+		RefreshHash: refreshHash,
+		IP:          client.IP,
+		UserAgent:   client.UserAgent,
+		CreatedAt:   now,
+		LastSeenAt:  now,
+		ExpiresAt:   now.Add(RefreshTokenTTL),
+	})
+	if err == store.ErrDisabled {
+		return nil, ErrAccountDisabled
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := s.Keyring.IssueToken(userID, email, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{AccessToken: accessToken, RefreshToken: refreshToken, ExpiresIn: int64(AccessTokenTTL.Seconds())}, nil
+}
+
+// RefreshSession exchanges a refresh token for a new token pair. Each refresh
+// token is single-use: presenting one that was already rotated out revokes
+// the whole session and returns ErrRefreshTokenReused.
+func (s *Service) RefreshSession(refreshToken string, client ClientInfo) (*TokenPair, error) {
+	oldHash := hashRefreshToken(refreshToken)
+	newToken, newHash, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session, err := s.Store.Sessions.ByRefreshHash(oldHash)
+	if err == store.ErrNotFound {
+		return nil, s.detectReuse(oldHash, now)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !session.RevokedAt.IsZero() || now.Unix() >= session.ExpiresAt.Unix() {
+		return nil, ErrInvalidRefreshToken
+	}
+	user, err := s.Store.Users.ByID(session.UserID)
+	if err == store.ErrNotFound || (err == nil && !user.DisabledAt.IsZero()) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Rotate only succeeds while oldHash is still current, so of two
+	// concurrent refreshes with the same token only one wins
+	err = s.Store.Sessions.Rotate(oldHash, store.Session{
+		ID:          session.ID,
+		RefreshHash: newHash,
+		IP:          client.IP,
+		UserAgent:   client.UserAgent,
+		LastSeenAt:  now,
+		ExpiresAt:   now.Add(RefreshTokenTTL),
+	})
+	if err == store.ErrNotFound {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := s.Keyring.IssueToken(user.ID, user.Email, session.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{AccessToken: accessToken, RefreshToken: newToken, ExpiresIn: int64(AccessTokenTTL.Seconds())}, nil
+}
+
+// detectReuse revokes the session a rotated-out refresh token belonged to
+func (s *Service) detectReuse(tokenHash string, now time.Time) error {
+	session, err := s.Store.Sessions.ByUsedRefreshHash(tokenHash)
+	if err == store.ErrNotFound {
+		return ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.Store.Sessions.Revoke(session.ID, now); err != nil {
+		return err
+	}
+	slog.Warn("Refresh token reuse detected, revoked session", "session_id", session.ID, "user_id", session.UserID)
+	return ErrRefreshTokenReused
+}
+
+// RevokeSession ends a session so its refresh token can no longer be used
+func (s *Service) RevokeSession(sessionID string) error {
+	if err := s.Store.Sessions.Revoke(sessionID, time.Now()); err != nil && err != store.ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+// ListSessions returns the user's active sessions, most recently used first
+func (s *Service) ListSessions(userID string) ([]Session, error) {
+	active, err := s.Store.Sessions.ListActive(userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]Session, 0, len(active))
+	for _, a := range active {
+		sessions = append(sessions, Session{
+			ID:         a.ID,
+			IP:         a.IP,
+			UserAgent:  a.UserAgent,
+			CreatedAt:  a.CreatedAt,
+			LastSeenAt: a.LastSeenAt,
+			ExpiresAt:  a.ExpiresAt,
+		})
+	}
+	return sessions, nil
+}
+
+// RevokeUserSession revokes one of the user's own active sessions
+func (s *Service) RevokeUserSession(userID, sessionID string) error {
+	session, err := s.Store.Sessions.ByID(sessionID)
+	if err == store.ErrNotFound || (err == nil && (session.UserID != userID || !session.RevokedAt.IsZero())) {
+		return ErrSessionNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return s.Store.Sessions.Revoke(sessionID, time.Now())
+}
+
+// RevokeOtherSessions revokes every session of the user except keepSessionID
+// and returns how many were revoked
+func (s *Service) RevokeOtherSessions(userID, keepSessionID string) (int64, error) {
+	return s.Store.Sessions.RevokeAll(userID, keepSessionID, time.Now())
+}
+
+// RevokeToken adds an access token ID to the revocation list until it expires
+func (s *Service) RevokeToken(tokenID string, expiresAt time.Time) error {
+	if tokenID == "" {
+		return nil
+	}
+	return s.Store.Revoked.Add(tokenID, expiresAt)
+}
+
+// IsTokenRevoked reports whether an access token ID is on the revocation list
+func (s *Service) IsTokenRevoked(tokenID string) (bool, error) {
+	if tokenID == "" {
+		return false, nil
+	}
+	return s.Store.Revoked.Contains(tokenID)
+}
+
+// PurgeExpiredTokens removes revocation entries and sessions that have expired
+func (s *Service) PurgeExpiredTokens(now time.Time) error {
+	if _, err := s.Store.Revoked.DeleteExpired(now); err != nil {
+		return err
+	}
+	_, err := s.Store.Sessions.DeleteExpired(now)
+	return err
+}
+
+// StartSessionSweeper purges expired sessions, revocation entries and
+// failed-login counts the service's lockout policy has reset every interval
+// until the returned stop function is called
+func StartSessionSweeper(s *Service, interval time.Duration) (stop func()) {
+	return startSweeper(interval, func(now time.Time) {
+		if err := s.PurgeExpiredTokens(now); err != nil {
+			slog.Error("Session sweep failed", "error", err)
+		}
+		if err := s.PurgeLoginFailures(now); err != nil {
+			slog.Error("Login failure sweep failed", "error", err)
+		}
+	})
+}
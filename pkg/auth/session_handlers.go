@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"secure-email-mvp/pkg/logging"
+
+	"github.com/gorilla/mux"
+)
+
+type ListSessionsResponse struct {
+	Sessions []Session `json:"sessions"`
+}
+
+type RevokeOtherSessionsResponse struct {
+	Revoked int64 `json:"revoked"`
+}
+
+// ListSessionsHandler lists the caller's active sessions. It must run behind RequireAuth.
+func ListSessionsHandler(s *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := IdentityFromContext(r.Context())
+		if !ok {
+			unauthorized(w)
+			return
+		}
+
+		sessions, err := s.ListSessions(id.UserID)
+		if err != nil {
+			http.Error(w, `{"error":"Internal server error"}`, http.StatusInternalServerError)
+			logging.FromContext(r.Context()).Error("List sessions failed", "error", err)
+			return
+		}
+		for i := range sessions {
+			sessions[i].Current = sessions[i].ID == id.SessionID
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(ListSessionsResponse{Sessions: sessions}); err != nil {
+			logging.FromContext(r.Context()).Warn("List sessions response failed", "error", err)
+		}
+	}
+}
+
+// RevokeSessionHandler revokes the session named by the {id} route variable.
+// It must run behind RequireAuth.
+func RevokeSessionHandler(s *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := IdentityFromContext(r.Context())
+		if !ok {
+			unauthorized(w)
+			return
+		}
+
+		err := s.RevokeUserSession(id.UserID, mux.Vars(r)["id"])
+		if err == ErrSessionNotFound {
+			http.Error(w, `{"error":"Session not found"}`, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, `{"error":"Internal server error"}`, http.StatusInternalServerError)
+			logging.FromContext(r.Context()).Error("Revoke session failed", "error", err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// RevokeOtherSessionsHandler signs the caller out everywhere except the
+// current session. It must run behind RequireAuth.
+func RevokeOtherSessionsHandler(s *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := IdentityFromContext(r.Context())
+		if !ok {
+			unauthorized(w)
+			return
+		}
+
+		n, err := s.RevokeOtherSessions(id.UserID, id.SessionID)
+		if err != nil {
+			http.Error(w, `{"error":"Internal server error"}`, http.StatusInternalServerError)
+			logging.FromContext(r.Context()).Error("Revoke other sessions failed", "error", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(RevokeOtherSessionsResponse{Revoked: n}); err != nil {
+			logging.FromContext(r.Context()).Warn("Revoke other sessions response failed", "error", err)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestSessionHandlers(t *testing.T) {
+	db := newSessionTestDB(t)
+	svc := newTestService(db)
+	db.Exec("INSERT INTO users (id, email, password_hash, totp_secret) VALUES (?, ?, ?, ?)",
+		"user-2", "other@securesystem.email", "hash", "secret")
+
+	laptop, _ := svc.CreateSession("user-1", "test@securesystem.email", ClientInfo{IP: "198.51.100.1", UserAgent: "laptop"})
+	phone, _ := svc.CreateSession("user-1", "test@securesystem.email", ClientInfo{IP: "198.51.100.2", UserAgent: "phone"})
+	tablet, _ := svc.CreateSession("user-1", "test@securesystem.email", ClientInfo{IP: "198.51.100.3", UserAgent: "tablet"})
+	foreign, _ := svc.CreateSession("user-2", "other@securesystem.email", ClientInfo{})
+	phoneClaims, _ := testKeyring.ParseToken(phone.AccessToken)
+	foreignClaims, _ := testKeyring.ParseToken(foreign.AccessToken)
+
+	r := mux.NewRouter()
+	r.Use(RequireAuth(svc))
+	r.HandleFunc("/api/sessions", ListSessionsHandler(svc)).Methods("GET")
+	r.HandleFunc("/api/sessions/revoke-others", RevokeOtherSessionsHandler(svc)).Methods("POST")
+	r.HandleFunc("/api/sessions/{id}", RevokeSessionHandler(svc)).Methods("DELETE")
+
+	do := func(method, path, token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// List shows all three devices and marks the caller's session
+	rr := do("GET", "/api/sessions", laptop.AccessToken)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+	var list ListSessionsResponse
+	json.NewDecoder(rr.Body).Decode(&list)
+	if len(list.Sessions) != 3 {
+		t.Fatalf("Expected 3 sessions, got %d", len(list.Sessions))
+	}
+	current := 0
+	for _, s := range list.Sessions {
+		if s.Current {
+			current++
+			if s.UserAgent != "laptop" || s.IP != "198.51.100.1" {
+				t.Errorf("Expected laptop session marked current, got %+v", s)
+			}
+		}
+		if s.CreatedAt.IsZero() || s.LastSeenAt.IsZero() {
+			t.Errorf("Expected timestamps on session %+v", s)
+		}
+	}
+	if current != 1 {
+		t.Errorf("Expected exactly one current session, got %d", current)
+	}
+
+	// Another user's session cannot be revoked
+	if rr := do("DELETE", "/api/sessions/"+foreignClaims.SessionID, laptop.AccessToken); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for foreign session, got %d", rr.Code)
+	}
+
+	// Revoking the phone locks its access token out immediately
+	if rr := do("DELETE", "/api/sessions/"+phoneClaims.SessionID, laptop.AccessToken); rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d", rr.Code)
+	}
+	if rr := do("GET", "/api/sessions", phone.AccessToken); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected revoked session token rejected, got %d", rr.Code)
+	}
+
+	// Sign out everywhere else leaves only the laptop
+	rr = do("POST", "/api/sessions/revoke-others", laptop.AccessToken)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+	var revoked RevokeOtherSessionsResponse
+	json.NewDecoder(rr.Body).Decode(&revoked)
+	if revoked.Revoked != 1 {
+		t.Errorf("Expected 1 session revoked, got %d", revoked.Revoked)
+	}
+	if rr := do("GET", "/api/sessions", tablet.AccessToken); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected tablet token rejected, got %d", rr.Code)
+	}
+	if rr := do("GET", "/api/sessions", laptop.AccessToken); rr.Code != http.StatusOK {
+		t.Errorf("Expected laptop token still valid, got %d", rr.Code)
+	}
+	if rr := do("GET", "/api/sessions", foreign.AccessToken); rr.Code != http.StatusOK {
+		t.Errorf("Expected other user's session untouched, got %d", rr.Code)
+	}
+}
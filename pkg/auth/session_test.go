@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newSessionTestDB(t *testing.T) *sql.DB {
+	db := newTestDB(t)
+	_, err := db.Exec("INSERT INTO users (id, email, password_hash, totp_secret) VALUES (?, ?, ?, ?)",
+		"user-1", "test@securesystem.email", "hash", "secret")
+	if err != nil {
+		t.Fatal("Failed to insert user:", err)
+	}
+	return db
+}
+
+func TestCreateSession(t *testing.T) {
+	db := newSessionTestDB(t)
+	svc := newTestService(db)
+
+	tokens, err := svc.CreateSession("user-1", "test@securesystem.email", ClientInfo{IP: "203.0.113.7", UserAgent: "test-agent"})
+	if err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	if tokens.ExpiresIn != int64(AccessTokenTTL.Seconds()) {
+		t.Errorf("Expected expires_in %d, got %d", int64(AccessTokenTTL.Seconds()), tokens.ExpiresIn)
+	}
+
+	claims, err := testKeyring.ParseToken(tokens.AccessToken)
+	if err != nil {
+		t.Fatalf("ParseToken failed: %v", err)
+	}
+	if claims.SessionID == "" || claims.TokenID == "" {
+		t.Errorf("Expected sid and jti claims, got %+v", claims)
+	}
+	if ttl := time.Until(claims.ExpiresAt); ttl > AccessTokenTTL || ttl < AccessTokenTTL-time.Minute {
+		t.Errorf("Expected access token to expire in about %v, got %v", AccessTokenTTL, ttl)
+	}
+
+	// Only the hash of the refresh token is stored
+	var stored, ip, ua string
+	err = db.QueryRow("SELECT refresh_hash, ip_address, user_agent FROM sessions WHERE id = ?", claims.SessionID).Scan(&stored, &ip, &ua)
+	if err != nil {
+		t.Fatalf("Failed to query session: %v", err)
+	}
+	if stored == tokens.RefreshToken || stored != hashRefreshToken(tokens.RefreshToken) {
+		t.Error("Expected refresh token stored as its SHA-256 hash")
+	}
+	if ip != "203.0.113.7" || ua != "test-agent" {
+		t.Errorf("Expected client info recorded, got %s / %s", ip, ua)
+	}
+}
+
+func TestRefreshSessionRotates(t *testing.T) {
+	db := newSessionTestDB(t)
+	svc := newTestService(db)
+	first, _ := svc.CreateSession("user-1", "test@securesystem.email", ClientInfo{})
+
+	second, err := svc.RefreshSession(first.RefreshToken, ClientInfo{})
+	if err != nil {
+		t.Fatalf("RefreshSession failed: %v", err)
+	}
+	if second.RefreshToken == first.RefreshToken {
+		t.Error("Expected a new refresh token")
+	}
+	c1, _ := testKeyring.ParseToken(first.AccessToken)
+	c2, _ := testKeyring.ParseToken(second.AccessToken)
+	if c1.SessionID != c2.SessionID {
+		t.Error("Expected refreshed token to stay in the same session")
+	}
+	if c1.TokenID == c2.TokenID {
+		t.Error("Expected a new jti")
+	}
+
+	third, err := svc.RefreshSession(second.RefreshToken, ClientInfo{})
+	if err != nil {
+		t.Fatalf("Second RefreshSession failed: %v", err)
+	}
+
+	// Replaying a rotated-out token kills the whole family
+	if _, err := svc.RefreshSession(first.RefreshToken, ClientInfo{}); err != ErrRefreshTokenReused {
+		t.Fatalf("Expected ErrRefreshTokenReused, got %v", err)
+	}
+	if _, err := svc.RefreshSession(third.RefreshToken, ClientInfo{}); err != ErrInvalidRefreshToken {
+		t.Errorf("Expected latest token rejected after reuse, got %v", err)
+	}
+}
+
+func TestRefreshSessionRejects(t *testing.T) {
+	db := newSessionTestDB(t)
+	svc := newTestService(db)
+
+	if _, err := svc.RefreshSession("unknown-token", ClientInfo{}); err != ErrInvalidRefreshToken {
+		t.Errorf("Expected ErrInvalidRefreshToken for unknown token, got %v", err)
+	}
+
+	revoked, _ := svc.CreateSession("user-1", "test@securesystem.email", ClientInfo{})
+	claims, _ := testKeyring.ParseToken(revoked.AccessToken)
+	svc.RevokeSession(claims.SessionID)
+	if _, err := svc.RefreshSession(revoked.RefreshToken, ClientInfo{}); err != ErrInvalidRefreshToken {
+		t.Errorf("Expected ErrInvalidRefreshToken for revoked session, got %v", err)
+	}
+
+	expired, _ := svc.CreateSession("user-1", "test@securesystem.email", ClientInfo{})
+	claims, _ = testKeyring.ParseToken(expired.AccessToken)
+	db.Exec("UPDATE sessions SET expires_at = ? WHERE id = ?", time.Now().Add(-time.Minute).Unix(), claims.SessionID)
+	if _, err := svc.RefreshSession(expired.RefreshToken, ClientInfo{}); err != ErrInvalidRefreshToken {
+		t.Errorf("Expected ErrInvalidRefreshToken for expired session, got %v", err)
+	}
+}
+
+func TestRevokeTokenAndPurge(t *testing.T) {
+	db := newSessionTestDB(t)
+	svc := newTestService(db)
+	now := time.Now()
+
+	svc.RevokeToken("old-jti", now.Add(-time.Minute))
+	svc.RevokeToken("live-jti", now.Add(time.Minute))
+	if revoked, _ := svc.IsTokenRevoked("live-jti"); !revoked {
+		t.Error("Expected live-jti to be revoked")
+	}
+	if revoked, _ := svc.IsTokenRevoked("other-jti"); revoked {
+		t.Error("Expected other-jti not to be revoked")
+	}
+
+	if err := svc.PurgeExpiredTokens(now); err != nil {
+		t.Fatalf("PurgeExpiredTokens failed: %v", err)
+	}
+	if revoked, _ := svc.IsTokenRevoked("old-jti"); revoked {
+		t.Error("Expected expired revocation entry to be purged")
+	}
+	if revoked, _ := svc.IsTokenRevoked("live-jti"); !revoked {
+		t.Error("Expected live revocation entry to be kept")
+	}
+}
+
+func TestClientInfoFromRequest(t *testing.T) {
+	req := httptest.NewRequest("POST", "/api/auth/refresh", nil)
+	req.RemoteAddr = "10.0.0.1:4321"
+	req.Header.Set("User-Agent", "test-agent")
+	req.Header.Set("X-Forwarded-For", "203.0.113.9")
+
+	if c := ClientInfoFromRequest(req, nil); c.IP != "10.0.0.1" || c.UserAgent != "test-agent" {
+		t.Errorf("Expected the remote address without a resolver, got %+v", c)
+	}
+	forwarded := func(r *http.Request) string { return r.Header.Get("X-Forwarded-For") }
+	if c := ClientInfoFromRequest(req, forwarded); c.IP != "203.0.113.9" {
+		t.Errorf("Expected the resolved client IP, got %+v", c)
+	}
+
+	// Sessions record the IP the service resolves, not the proxy's
+	db := newSessionTestDB(t)
+	svc := newTestService(db)
+	svc.ClientIP = forwarded
+	tokens, _ := svc.CreateSession("user-1", "test@securesystem.email", ClientInfo{})
+	body, _ := json.Marshal(RefreshRequest{RefreshToken: tokens.RefreshToken})
+	req = httptest.NewRequest("POST", "/api/auth/refresh", bytes.NewReader(body))
+	req.RemoteAddr = "10.0.0.1:4321"
+	req.Header.Set("X-Forwarded-For", "203.0.113.9")
+	rr := httptest.NewRecorder()
+	RefreshHandler(svc).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected refresh to succeed, got %d", rr.Code)
+	}
+	var ip string
+	db.QueryRow("SELECT ip_address FROM sessions WHERE user_id = ?", "user-1").Scan(&ip)
+	if ip != "203.0.113.9" {
+		t.Errorf("Expected session IP 203.0.113.9, got %q", ip)
+	}
+}
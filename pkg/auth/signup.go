@@ -1,27 +1,23 @@
 package auth
 
 import (
-	"bytes"
-	"crypto/rand"
-	"database/sql"
-	"encoding/base64"
 	"encoding/json"
-	"image/png"
-	"log"
+	"fmt"
 	"net/http"
-	"regexp"
-	"sync"
 	"time"
 
+	"secure-email-mvp/pkg/audit"
+	"secure-email-mvp/pkg/logging"
+	"secure-email-mvp/pkg/store"
+
 	"github.com/google/uuid"
-	"github.com/pquerna/otp/totp"
-	"golang.org/x/crypto/argon2"
 )
 
 type SignUpRequest struct {
 	Email           string `json:"email"`
 	Password        string `json:"password"`
 	ConfirmPassword string `json:"confirm_password"`
+	InviteCode      string `json:"invite_code"` // Required when the domain is invite-only
 }
 
 type SignUpResponse struct {
@@ -29,29 +25,41 @@ type SignUpResponse struct {
 	TotpQr string `json:"totp_qr"`
 }
 
-type TempState struct {
-	Email        string
-	PasswordHash []byte
-	TotpSecret   string
-	ExpiresAt    time.Time
-}
-
-var tempStore = sync.Map{} // In-memory store for temp TOTP state
+// TempState is a sign-up waiting for its first TOTP code
+type TempState = store.PendingEnrollment
 
-func SignUpHandler(db *sql.DB) http.HandlerFunc {
+func SignUpHandler(s *Service, pending PendingStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req SignUpRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, `{"error":"Invalid request"}`, http.StatusBadRequest)
-			log.Printf("Sign-up failed: %v", err)
+			logging.FromContext(r.Context()).Error("Sign-up failed", "error", err)
 			return
 		}
 
-		// Validate email
-		if !regexp.MustCompile(`^[^@]+@securesystem.email$`).MatchString(req.Email) {
+		req.Email = NormalizeEmail(req.Email)
+
+		// Sign-ups refused by policy are audited; malformed requests are not
+		client := s.clientInfo(r)
+		refuse := func(reason string) {
+			s.RecordAudit(r.Context(), client, audit.Event{Action: audit.ActionSignUp, ActorEmail: req.Email, Outcome: audit.OutcomeFailure, Reason: reason})
+		}
+
+		// Validate email; only domains hosted here accept sign-ups
+		if !ValidateAddress(req.Email) {
 			http.Error(w, `{"error":"Invalid email format"}`, http.StatusBadRequest)
 			return
 		}
+		domain, err := s.GetDomain(DomainOf(req.Email))
+		if err == ErrDomainNotFound {
+			http.Error(w, `{"error":"Invalid email format"}`, http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, `{"error":"Internal server error"}`, http.StatusInternalServerError)
+			logging.FromContext(r.Context()).Error("Sign-up failed", "error", err)
+			return
+		}
 
 		// Validate passwords
 		if req.Password != req.ConfirmPassword {
@@ -63,72 +71,89 @@ func SignUpHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		// Check user count
-		var count int
-		if err := db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count); err != nil {
-			http.Error(w, `{"error":"Internal server error"}`, http.StatusInternalServerError)
-			log.Printf("Sign-up failed: %v", err)
+		// Check the domain's policy and user cap. An invite code is checked
+		// whenever one is given; it is only redeemed once the user is created.
+		if domain.SignUp == SignUpClosed {
+			refuse("sign-up closed")
+			http.Error(w, `{"error":"Sign-up is closed"}`, http.StatusForbidden)
+			return
+		}
+		var inviteID string
+		if req.InviteCode != "" {
+			invite, err := s.CheckInviteCode(req.InviteCode, req.Email, time.Now())
+			if err == ErrInviteCodeInvalid {
+				refuse("invalid invite code")
+				http.Error(w, `{"error":"Invalid or expired invite code"}`, http.StatusForbidden)
+				return
+			}
+			if err != nil {
+				http.Error(w, `{"error":"Internal server error"}`, http.StatusInternalServerError)
+				logging.FromContext(r.Context()).Error("Sign-up failed", "error", err)
+				return
+			}
+			inviteID = invite.ID
+		} else if domain.SignUp == SignUpInvite {
+			refuse("invitation required")
+			http.Error(w, `{"error":"Sign-up requires an invitation"}`, http.StatusForbidden)
 			return
 		}
-		if count >= 100 {
-			http.Error(w, `{"error":"Max 100 users reached"}`, http.StatusForbidden)
+		if domain.Users >= domain.MaxUsers {
+			refuse("user cap reached")
+			http.Error(w, fmt.Sprintf(`{"error":"Max %d users reached"}`, domain.MaxUsers), http.StatusForbidden)
 			return
 		}
 
 		// Check email uniqueness
-		var exists int
-		if err := db.QueryRow("SELECT COUNT(*) FROM users WHERE email = ?", req.Email).Scan(&exists); err != nil || exists > 0 {
+		if _, err := s.Store.Users.ByEmail(req.Email); err != store.ErrNotFound {
+			refuse("email already registered")
 			http.Error(w, `{"error":"Email already exists"}`, http.StatusBadRequest)
 			return
 		}
 
 		// Hash password
-		salt := make([]byte, 16)
-		if _, err := rand.Read(salt); err != nil {
-			http.Error(w, `{"error":"Internal server error"}`, http.StatusInternalServerError)
-			log.Printf("Sign-up failed: %v", err)
+		passwordHash, err := s.Hash.HashPassword(r.Context(), req.Password)
+		if hashBusy(err) {
+			serverBusy(w)
+			logging.FromContext(r.Context()).Warn("Sign-up rejected", "error", err)
 			return
 		}
-		hash := argon2.IDKey([]byte(req.Password), salt, 1, 64*1024, 4, 32)
-		passwordHash := append(salt, hash...)
-
-		// Generate TOTP secret
-		key, err := totp.Generate(totp.GenerateOpts{
-			Issuer:      "SecureEmailMVP",
-			AccountName: req.Email,
-		})
 		if err != nil {
 			http.Error(w, `{"error":"Internal server error"}`, http.StatusInternalServerError)
-			log.Printf("Sign-up failed: %v", err)
+			logging.FromContext(r.Context()).Error("Sign-up failed", "error", err)
 			return
 		}
 
-		// Generate QR code
-		qr, err := key.Image(160, 160)
+		// Generate TOTP secret and QR code
+		totpSecret, totpQr, err := newTOTPEnrollment(domain.TOTPIssuer, req.Email)
 		if err != nil {
 			http.Error(w, `{"error":"Internal server error"}`, http.StatusInternalServerError)
-			log.Printf("Sign-up failed: %v", err)
+			logging.FromContext(r.Context()).Error("Sign-up failed", "error", err)
 			return
 		}
-		var buf bytes.Buffer
-		png.Encode(&buf, qr)
-		totpQr := base64.StdEncoding.EncodeToString(buf.Bytes())
 
 		// Store temp state
 		tempID := uuid.New().String()
-		tempStore.Store(tempID, TempState{
+		err = pending.Save(tempID, TempState{
 			Email:        req.Email,
 			PasswordHash: passwordHash,
-			TotpSecret:   key.Secret(),
+			TotpSecret:   totpSecret,
+			InviteID:     inviteID,
 			ExpiresAt:    time.Now().Add(5 * time.Minute),
 		})
+		if err != nil {
+			http.Error(w, `{"error":"Internal server error"}`, http.StatusInternalServerError)
+			logging.FromContext(r.Context()).Error("Sign-up failed", "error", err)
+			return
+		}
+
+		s.RecordAudit(r.Context(), client, audit.Event{Action: audit.ActionSignUp, ActorEmail: req.Email, Outcome: audit.OutcomeSuccess})
 
 		// Respond
 		resp := SignUpResponse{TempID: tempID, TotpQr: totpQr}
 		w.Header().Set("Content-Type", "application/json")
 		if err := json.NewEncoder(w).Encode(resp); err != nil {
-			log.Printf("Sign-up response failed: %v", err)
+			logging.FromContext(r.Context()).Warn("Sign-up response failed", "error", err)
 		}
-		log.Printf("Sign-up initiated for %s", req.Email)
+		logging.FromContext(r.Context()).Info("Sign-up initiated", "email", req.Email)
 	}
 }
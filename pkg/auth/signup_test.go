@@ -3,18 +3,30 @@ package auth
 import (
 	"bytes"
 	"database/sql"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
 
-	_ "github.com/mattn/go-sqlite3"
+	"secure-email-mvp/pkg/audit"
+	"secure-email-mvp/pkg/store/sqlite"
 )
 
+// newSignUpTestDB hosts the default domain with open sign-up, as the API does at startup
+func newSignUpTestDB(t *testing.T) *sql.DB {
+	db := newTestDB(t)
+	svc := newTestService(db)
+	if err := svc.CreateDomain(&Domain{Name: DefaultEmailDomain, SignUp: SignUpOpen, MaxUsers: 100}); err != nil {
+		t.Fatal("Failed to create domain:", err)
+	}
+	return db
+}
+
 func TestSignUpHandler(t *testing.T) {
-	db, _ := sql.Open("sqlite3", ":memory:")
-	db.Exec("CREATE TABLE users (user_id TEXT, email TEXT UNIQUE, password_hash BLOB, totp_secret TEXT)")
-	handler := SignUpHandler(db)
+	db := newSignUpTestDB(t)
+	svc := newTestService(db)
+	handler := SignUpHandler(svc, sqlite.New(db).Pending)
 
 	tests := []struct {
 		name     string
@@ -55,3 +67,79 @@ func TestSignUpHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestSignUpDomainPolicy(t *testing.T) {
+	db := newAccountTestDB(t)
+	svc := newTestService(db)
+	handler := SignUpHandler(svc, sqlite.New(db).Pending)
+	d := &Domain{Name: "example.org", SignUp: SignUpOpen, MaxUsers: 2, TOTPIssuer: "Example Mail"}
+	if err := svc.CreateDomain(d); err != nil {
+		t.Fatal("Failed to create domain:", err)
+	}
+
+	signUp := func(email string) *httptest.ResponseRecorder {
+		body := `{"email":"` + email + `","password":"password123","confirm_password":"password123"}`
+		req, _ := http.NewRequest("POST", "/api/auth/signup", bytes.NewBufferString(body))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Only hosted domains accept sign-ups, matched case-insensitively
+	if rr := signUp("new@securesystem.email"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected unhosted domain rejected, got %d", rr.Code)
+	}
+	rr := signUp("new@Example.org")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected hosted domain accepted, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// The domain is stored in lower case, so the address can't sign up twice
+	var resp SignUpResponse
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if state, err := sqlite.New(db).Pending.Load(resp.TempID); err != nil || state.Email != "new@example.org" {
+		t.Errorf("Expected pending email new@example.org, got %q (%v)", state.Email, err)
+	}
+	if _, err := db.Exec("INSERT INTO users (id, email, password_hash, totp_secret) VALUES ('user-new', 'new@example.org', 'x', 'y')"); err != nil {
+		t.Fatal("Failed to insert user:", err)
+	}
+	if rr := signUp("new@EXAMPLE.ORG"); rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "Email already exists") {
+		t.Errorf("Expected existing address refused, got %d: %s", rr.Code, rr.Body.String())
+	}
+	db.Exec("DELETE FROM users WHERE id = 'user-new'")
+
+	// The cap counts only the domain's own users
+	if _, err := db.Exec("INSERT INTO users (id, email, password_hash, totp_secret) VALUES ('user-2', 'two@EXAMPLE.org', 'x', 'y')"); err != nil {
+		t.Fatal("Failed to insert user:", err)
+	}
+	if rr := signUp("third@example.org"); rr.Code != http.StatusOK {
+		t.Errorf("Expected room for a second user, got %d: %s", rr.Code, rr.Body.String())
+	}
+	db.Exec("INSERT INTO users (id, email, password_hash, totp_secret) VALUES ('user-3', 'three@example.org', 'x', 'y')")
+	rr = signUp("fourth@example.org")
+	if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), "Max 2 users reached") {
+		t.Errorf("Expected cap of 2 users, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Invite-only and closed domains turn open sign-up away
+	d.MaxUsers = 10
+	for policy, msg := range map[string]string{SignUpInvite: "requires an invitation", SignUpClosed: "Sign-up is closed"} {
+		d.SignUp = policy
+		if err := svc.UpdateDomain(d); err != nil {
+			t.Fatal("Failed to update domain:", err)
+		}
+		rr := signUp("fifth@example.org")
+		if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), msg) {
+			t.Errorf("Expected %s domain to refuse sign-up, got %d: %s", policy, rr.Code, rr.Body.String())
+		}
+	}
+
+	// Refusals are audited with the address and the reason
+	refused, _ := svc.Audit.List(audit.Filter{Action: audit.ActionSignUp, Outcome: audit.OutcomeFailure})
+	if len(refused) != 4 || refused[2].ActorEmail != "fourth@example.org" || refused[2].Reason != "user cap reached" {
+		t.Fatalf("Expected 4 refused sign-ups audited, got %+v", refused)
+	}
+	if reasons := refused[0].Reason + "," + refused[1].Reason; !strings.Contains(reasons, "sign-up closed") || !strings.Contains(reasons, "invitation required") {
+		t.Errorf("Expected policy refusals audited, got %q", reasons)
+	}
+}
@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+)
+
+// ScopeUser is granted to every signed-in user
+const ScopeUser = "user"
+
+// AccessTokenTTL is how long an issued JWT stays valid. Clients use their
+// refresh token to get a new one.
+const AccessTokenTTL = 15 * time.Minute
+
+// Claims are the fields we read back out of a validated JWT
+type Claims struct {
+	UserID    string
+	Email     string
+	TokenID   string
+	SessionID string
+	Scopes    []string
+	ExpiresAt time.Time
+}
+
+// IssueToken signs an ES256 JWT for the user's session with a unique token
+// ID (jti) and the active key's ID (kid) in the header
+func (k *Keyring) IssueToken(userID, email, sessionID string, scopes ...string) (string, error) {
+	key := k.Active()
+	if key == nil {
+		return "", fmt.Errorf("no active JWT signing key")
+	}
+	if len(scopes) == 0 {
+		scopes = []string{ScopeUser}
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"user_id": userID,
+		"email":   email,
+		"jti":     uuid.New().String(),
+		"sid":     sessionID,
+		"scope":   strings.Join(scopes, " "),
+		"exp":     now.Add(AccessTokenTTL).Unix(),
+		"iat":     now.Unix(),
+	})
+	token.Header["kid"] = key.ID
+	tokenString, err := token.SignedString(key.Key)
+	if err != nil {
+		return "", fmt.Errorf("JWT signing error: %v", err)
+	}
+	return tokenString, nil
+}
+
+// ParseToken validates a JWT against the keyring and returns its claims
+func (k *Keyring) ParseToken(tokenString string) (*Claims, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodES256 {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		pub, ok := k.PublicKey(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		return pub, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("JWT parsing error: %v", err)
+	}
+
+	mc, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("invalid JWT token")
+	}
+
+	claims := &Claims{}
+	if claims.UserID, ok = mc["user_id"].(string); !ok {
+		return nil, fmt.Errorf("invalid user_id in JWT")
+	}
+	if claims.Email, ok = mc["email"].(string); !ok {
+		return nil, fmt.Errorf("invalid email in JWT")
+	}
+	if claims.TokenID, ok = mc["jti"].(string); !ok || claims.TokenID == "" {
+		return nil, fmt.Errorf("invalid jti in JWT")
+	}
+	if claims.SessionID, ok = mc["sid"].(string); !ok || claims.SessionID == "" {
+		return nil, fmt.Errorf("invalid sid in JWT")
+	}
+	scope, _ := mc["scope"].(string)
+	if claims.Scopes = strings.Fields(scope); len(claims.Scopes) == 0 {
+		return nil, fmt.Errorf("invalid scope in JWT")
+	}
+	if exp, ok := mc["exp"].(float64); ok {
+		claims.ExpiresAt = time.Unix(int64(exp), 0)
+	}
+	return claims, nil
+}
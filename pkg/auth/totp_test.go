@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"secure-email-mvp/pkg/store/sqlite"
+
+	"github.com/pquerna/otp/totp"
+)
+
+const testTOTPSecret = "JBSWY3DPEHPK3PXP"
+
+func newTOTPTestDB(t *testing.T) *sql.DB {
+	db := newTestDB(t)
+	_, err := db.Exec("INSERT INTO users (id, email, password_hash, totp_secret) VALUES (?, ?, ?, ?)",
+		"user-1", "test@securesystem.email", "hash", testTOTPSecret)
+	if err != nil {
+		t.Fatal("Failed to insert user:", err)
+	}
+	return db
+}
+
+func TestCheckTOTPSingleUse(t *testing.T) {
+	db := newTOTPTestDB(t)
+	svc := newTestService(db)
+	now := time.Now()
+	code, _ := totp.GenerateCode(testTOTPSecret, now)
+
+	if err := svc.CheckTOTP("user-1", testTOTPSecret, code); err != nil {
+		t.Fatalf("Expected first use to succeed, got %v", err)
+	}
+	if err := svc.CheckTOTP("user-1", testTOTPSecret, code); err != ErrTOTPReplayed {
+		t.Errorf("Expected ErrTOTPReplayed on reuse, got %v", err)
+	}
+
+	// An older code inside the skew window is also spent
+	previous, _ := totp.GenerateCode(testTOTPSecret, now.Add(-totpPeriod))
+	if previous != code {
+		if err := svc.CheckTOTP("user-1", testTOTPSecret, previous); err != ErrTOTPReplayed {
+			t.Errorf("Expected ErrTOTPReplayed for older step, got %v", err)
+		}
+	}
+
+	// The next step is still accepted
+	next, _ := totp.GenerateCode(testTOTPSecret, now.Add(totpPeriod))
+	if err := svc.CheckTOTP("user-1", testTOTPSecret, next); err != nil {
+		t.Errorf("Expected next step accepted, got %v", err)
+	}
+
+	if err := svc.CheckTOTP("user-1", testTOTPSecret, "000000"); err != ErrTOTPInvalid && err != ErrTOTPReplayed {
+		t.Errorf("Expected rejection for wrong code, got %v", err)
+	}
+}
+
+func TestMatchTOTPStepSkew(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	current := now.Unix() / 30
+
+	tests := []struct {
+		name   string
+		skew   uint
+		offset int64
+		ok     bool
+	}{
+		{"Current step", 0, 0, true},
+		{"Previous step without skew", 0, -1, false},
+		{"Previous step with skew 1", 1, -1, true},
+		{"Next step with skew 1", 1, 1, true},
+		{"Two steps back with skew 1", 1, -2, false},
+		{"Two steps back with skew 2", 2, -2, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _ := totp.GenerateCode(testTOTPSecret, time.Unix((current+tt.offset)*30, 0))
+			step, err := matchTOTPStep(code, testTOTPSecret, now, tt.skew)
+			if tt.ok {
+				if err != nil || step != current+tt.offset {
+					t.Errorf("Expected step %d, got %d, %v", current+tt.offset, step, err)
+				}
+			} else if err != ErrTOTPInvalid {
+				t.Errorf("Expected ErrTOTPInvalid, got %v", err)
+			}
+		})
+	}
+}
+
+func TestEnrollmentCodeCannotBeReplayedAtLogin(t *testing.T) {
+	db := newSignUpTestDB(t)
+	svc := newTestService(db)
+	pending := sqlite.New(db).Pending
+	password := "securepass123"
+	hash, _ := HashPassword(password)
+	pending.Save("temp-1", TempState{
+		Email:        "new@securesystem.email",
+		PasswordHash: hash,
+		TotpSecret:   testTOTPSecret,
+		ExpiresAt:    time.Now().Add(5 * time.Minute),
+	})
+
+	code, _ := totp.GenerateCode(testTOTPSecret, time.Now())
+	req, _ := http.NewRequest("POST", "/api/auth/verify-totp", bytes.NewBufferString(`{"temp_id":"temp-1","totp_code":"`+code+`"}`))
+	rr := httptest.NewRecorder()
+	VerifyTotpHandler(svc, pending).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected enrollment to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	if _, _, err := svc.Authenticate(context.Background(), "new@securesystem.email", password, code, ClientInfo{}); err != ErrTOTPReplayed {
+		t.Errorf("Expected ErrTOTPReplayed for enrollment code, got %v", err)
+	}
+}
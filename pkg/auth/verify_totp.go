@@ -1,16 +1,17 @@
 package auth
 
 import (
-	"database/sql"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
 	"net/http"
-	"os"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
+	"secure-email-mvp/pkg/audit"
+	"secure-email-mvp/pkg/logging"
+	"secure-email-mvp/pkg/store"
+
 	"github.com/google/uuid"
-	"github.com/pquerna/otp/totp"
 )
 
 type VerifyTotpRequest struct {
@@ -19,70 +20,143 @@ type VerifyTotpRequest struct {
 }
 
 type VerifyTotpResponse struct {
-	Token string `json:"token"`
+	Token         string   `json:"token"`
+	RefreshToken  string   `json:"refresh_token"`
+	ExpiresIn     int64    `json:"expires_in"`
+	RecoveryCodes []string `json:"recovery_codes"`
 }
 
-func VerifyTotpHandler(db *sql.DB) http.HandlerFunc {
+func VerifyTotpHandler(s *Service, pending PendingStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req VerifyTotpRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, `{"error":"Invalid request"}`, http.StatusBadRequest)
-			log.Printf("TOTP verification failed: %v", err)
+			logging.FromContext(r.Context()).Error("TOTP verification failed", "error", err)
 			return
 		}
 
 		// Retrieve temp state
-		data, ok := tempStore.Load(req.TempID)
-		if !ok {
+		state, err := pending.Load(req.TempID)
+		if err == ErrPendingNotFound {
 			http.Error(w, `{"error":"Invalid or expired temp ID"}`, http.StatusBadRequest)
 			return
 		}
-		state := data.(TempState)
+		if err != nil {
+			http.Error(w, `{"error":"Internal server error"}`, http.StatusInternalServerError)
+			logging.FromContext(r.Context()).Error("TOTP verification failed", "error", err)
+			return
+		}
 		if time.Now().After(state.ExpiresAt) {
-			tempStore.Delete(req.TempID)
+			pending.Delete(req.TempID)
 			http.Error(w, `{"error":"Temp ID expired"}`, http.StatusBadRequest)
 			return
 		}
 
 		// Validate TOTP
-		if !totp.Validate(req.TotpCode, state.TotpSecret) {
+		client := s.clientInfo(r)
+		refuse := func(reason string) {
+			s.RecordAudit(r.Context(), client, audit.Event{Action: audit.ActionSignUpVerifyTOTP, ActorEmail: state.Email, Outcome: audit.OutcomeFailure, Reason: reason})
+		}
+		step, err := s.matchTOTPStep(req.TotpCode, state.TotpSecret)
+		if err != nil {
+			refuse(err.Error())
 			http.Error(w, `{"error":"Invalid TOTP code"}`, http.StatusBadRequest)
+			logging.FromContext(r.Context()).Warn("TOTP verification failed", "email", state.Email, "error", err)
 			return
 		}
 
-		// Create user
+		// Create user, redeem their invite and issue recovery codes together;
+		// the enrollment code's step is spent so it cannot be replayed at login.
+		// The codes are only ever shown in this response.
 		userID := uuid.New().String()
-		_, err := db.Exec(
-			"INSERT INTO users (user_id, email, password_hash, totp_secret) VALUES (?, ?, ?, ?)",
-			userID, state.Email, state.PasswordHash, state.TotpSecret,
-		)
+		codes, err := s.createEnrolledUser(userID, state, step)
+		if err == ErrInviteCodeInvalid {
+			refuse("invalid invite code")
+			pending.Delete(req.TempID)
+			http.Error(w, `{"error":"Invalid or expired invite code"}`, http.StatusForbidden)
+			return
+		}
+		var capErr *UserCapError
+		if errors.As(err, &capErr) {
+			refuse("user cap reached")
+			pending.Delete(req.TempID)
+			http.Error(w, fmt.Sprintf(`{"error":"Max %d users reached"}`, capErr.MaxUsers), http.StatusForbidden)
+			return
+		}
+		if err == ErrDomainNotFound {
+			refuse("domain not hosted")
+			pending.Delete(req.TempID)
+			http.Error(w, `{"error":"Sign-up is closed"}`, http.StatusForbidden)
+			return
+		}
 		if err != nil {
 			http.Error(w, `{"error":"Internal server error"}`, http.StatusInternalServerError)
-			log.Printf("User creation failed: %v", err)
+			logging.FromContext(r.Context()).Error("User creation failed", "error", err)
 			return
 		}
+		s.RecordAudit(r.Context(), client, audit.Event{Action: audit.ActionSignUpVerifyTOTP, ActorID: userID, ActorEmail: state.Email, Target: userID, Outcome: audit.OutcomeSuccess})
 
-		// Generate JWT
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-			"user_id": userID,
-			"exp":     time.Now().Add(24 * time.Hour).Unix(),
-		})
-		tokenString, err := token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+		// Start session and generate JWT
+		tokens, err := s.CreateSession(userID, state.Email, client)
 		if err != nil {
 			http.Error(w, `{"error":"Internal server error"}`, http.StatusInternalServerError)
-			log.Printf("JWT generation failed: %v", err)
+			logging.FromContext(r.Context()).Error("JWT generation failed", "error", err)
 			return
 		}
 
 		// Clean up temp state
-		tempStore.Delete(req.TempID)
+		if err := pending.Delete(req.TempID); err != nil {
+			logging.FromContext(r.Context()).Error("Pending enrollment cleanup failed", "error", err)
+		}
 
 		// Respond
-		resp := VerifyTotpResponse{Token: tokenString}
+		resp := VerifyTotpResponse{Token: tokens.AccessToken, RefreshToken: tokens.RefreshToken, ExpiresIn: tokens.ExpiresIn, RecoveryCodes: codes}
 		w.Header().Set("Content-Type", "application/json")
 		if err := json.NewEncoder(w).Encode(resp); err != nil {
-			log.Printf("TOTP verification response failed: %v", err)
+			logging.FromContext(r.Context()).Warn("TOTP verification response failed", "error", err)
+		}
+		logging.SetUserID(r.Context(), userID)
+		logging.FromContext(r.Context()).Info("User created", "email", state.Email, "user_id", userID)
+	}
+}
+
+// createEnrolledUser inserts the user for a verified sign-up with a fresh set
+// of recovery codes and, if it came with an invite, records the redemption,
+// all in one transaction. The domain's user cap is checked again here, since
+// sign-ups started under it may finish after it is reached. It returns the
+// plaintext recovery codes.
+func (s *Service) createEnrolledUser(userID string, state TempState, step int64) ([]string, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer tx.Rollback()
+
+	repos := s.Store.WithTx(tx)
+	err = repos.Users.Create(store.User{
+		ID:           userID,
+		Email:        state.Email,
+		PasswordHash: state.PasswordHash,
+		TOTPSecret:   state.TotpSecret,
+		TOTPLastStep: step,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := checkUserCap(repos, state.Email); err != nil {
+		return nil, err
+	}
+	if state.InviteID != "" {
+		if err := redeemInvite(repos, state.InviteID, userID, time.Now()); err != nil {
+			return nil, err
 		}
-		log.Printf("User created for %s", state.Email)
 	}
+	codes, err := replaceRecoveryCodes(repos, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("database commit error: %v", err)
+	}
+	return codes, nil
 }
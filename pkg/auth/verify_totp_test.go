@@ -2,31 +2,32 @@ package auth
 
 import (
 	"bytes"
-	"database/sql"
 	"net/http"
 	"net/http/httptest"
-	"os"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"secure-email-mvp/pkg/audit"
+	"secure-email-mvp/pkg/store/sqlite"
+
 	"github.com/pquerna/otp/totp"
 )
 
 func TestVerifyTotpHandler(t *testing.T) {
-	db, _ := sql.Open("sqlite3", ":memory:")
-	db.Exec("CREATE TABLE users (user_id TEXT, email TEXT UNIQUE, password_hash BLOB, totp_secret TEXT)")
-	os.Setenv("JWT_SECRET", "test-secret")
-	handler := VerifyTotpHandler(db)
+	db := newSignUpTestDB(t)
+	svc := newTestService(db)
+	pending := sqlite.New(db).Pending
+	handler := VerifyTotpHandler(svc, pending)
 
 	// Setup temp state
 	tempID := "test-uuid"
 	secret := "JBSWY3DPEHPK3PXP"
 	totpCode, _ := totp.GenerateCode(secret, time.Now())
-	tempStore.Store(tempID, TempState{
+	pending.Save(tempID, TempState{
 		Email:        "test@securesystem.email",
-		PasswordHash: []byte("hashed"),
+		PasswordHash: "hashed",
 		TotpSecret:   secret,
 		ExpiresAt:    time.Now().Add(5 * time.Minute),
 	})
@@ -37,16 +38,22 @@ func TestVerifyTotpHandler(t *testing.T) {
 		status   int
 		errorMsg string
 	}{
+		{
+			name:     "Invalid TOTP",
+			body:     `{"temp_id":"test-uuid","totp_code":"123456"}`,
+			status:   http.StatusBadRequest,
+			errorMsg: "Invalid TOTP code",
+		},
 		{
 			name:   "Valid TOTP",
 			body:   `{"temp_id":"test-uuid","totp_code":"` + totpCode + `"}`,
 			status: http.StatusOK,
 		},
 		{
-			name:     "Invalid TOTP",
-			body:     `{"temp_id":"test-uuid","totp_code":"123456"}`,
+			name:     "Temp ID consumed",
+			body:     `{"temp_id":"test-uuid","totp_code":"` + totpCode + `"}`,
 			status:   http.StatusBadRequest,
-			errorMsg: "Invalid TOTP code",
+			errorMsg: "Invalid or expired temp ID",
 		},
 		{
 			name:     "Invalid temp ID",
@@ -69,4 +76,115 @@ func TestVerifyTotpHandler(t *testing.T) {
 			}
 		})
 	}
+
+	// Sign-up completion issued a full set of recovery codes
+	var userID string
+	db.QueryRow("SELECT id FROM users WHERE email = ?", "test@securesystem.email").Scan(&userID)
+	if remaining, _ := svc.RemainingRecoveryCodes(userID); remaining != RecoveryCodeCount {
+		t.Errorf("Expected %d recovery codes, got %d", RecoveryCodeCount, remaining)
+	}
+
+	// The bad code and the new account are both audited
+	events, _ := svc.Audit.List(audit.Filter{Action: audit.ActionSignUpVerifyTOTP})
+	if len(events) != 2 || events[0].Outcome != audit.OutcomeSuccess || events[0].Target != userID || events[1].Outcome != audit.OutcomeFailure {
+		t.Errorf("Expected failed and successful verification audited, got %+v", events)
+	}
+}
+
+func TestVerifyTotpRecoveryCodesAtomic(t *testing.T) {
+	db := newSignUpTestDB(t)
+	svc := newTestService(db)
+	pending := sqlite.New(db).Pending
+	handler := VerifyTotpHandler(svc, pending)
+
+	secret := "JBSWY3DPEHPK3PXP"
+	pending.Save("test-uuid", TempState{
+		Email:        "test@securesystem.email",
+		PasswordHash: "hashed",
+		TotpSecret:   secret,
+		ExpiresAt:    time.Now().Add(5 * time.Minute),
+	})
+	verify := func(offset int) *httptest.ResponseRecorder {
+		code, _ := totp.GenerateCode(secret, time.Now().Add(time.Duration(offset)*totpPeriod))
+		req, _ := http.NewRequest("POST", "/api/auth/verify-totp", bytes.NewBufferString(`{"temp_id":"test-uuid","totp_code":"`+code+`"}`))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// If the codes cannot be stored, the user is not created either
+	if _, err := db.Exec("ALTER TABLE recovery_codes RENAME TO recovery_codes_hidden"); err != nil {
+		t.Fatal("Failed to hide recovery codes:", err)
+	}
+	if rr := verify(-1); rr.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected status 500, got %d", rr.Code)
+	}
+	var users int
+	db.QueryRow("SELECT COUNT(*) FROM users").Scan(&users)
+	if users != 0 {
+		t.Fatalf("Expected no user without recovery codes, got %d", users)
+	}
+
+	// The sign-up is still pending, so a retry completes it
+	db.Exec("ALTER TABLE recovery_codes_hidden RENAME TO recovery_codes")
+	if rr := verify(0); rr.Code != http.StatusOK {
+		t.Fatalf("Expected retry to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestVerifyTotpUserCap(t *testing.T) {
+	db := newTestDB(t)
+	svc := newTestService(db)
+	pending := sqlite.New(db).Pending
+	handler := VerifyTotpHandler(svc, pending)
+	if err := svc.CreateDomain(&Domain{Name: "example.org", SignUp: SignUpOpen, MaxUsers: 2}); err != nil {
+		t.Fatal("Failed to create domain:", err)
+	}
+	if _, err := db.Exec("INSERT INTO users (id, email, password_hash, totp_secret) VALUES ('user-1', 'one@example.org', 'x', 'y')"); err != nil {
+		t.Fatal("Failed to insert user:", err)
+	}
+
+	// Both sign-ups started while one slot was left
+	secret := "JBSWY3DPEHPK3PXP"
+	for _, tempID := range []string{"first", "second"} {
+		pending.Save(tempID, TempState{
+			Email:        tempID + "@example.org",
+			PasswordHash: "hashed",
+			TotpSecret:   secret,
+			ExpiresAt:    time.Now().Add(5 * time.Minute),
+		})
+	}
+
+	// Whichever finishes first takes the slot; the other is refused
+	codes := make(chan int, 2)
+	var wg sync.WaitGroup
+	for i, tempID := range []string{"first", "second"} {
+		wg.Add(1)
+		go func(tempID string, offset int) {
+			defer wg.Done()
+			code, _ := totp.GenerateCode(secret, time.Now().Add(time.Duration(offset)*totpPeriod))
+			req, _ := http.NewRequest("POST", "/api/auth/verify-totp", bytes.NewBufferString(`{"temp_id":"`+tempID+`","totp_code":"`+code+`"}`))
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			codes <- rr.Code
+		}(tempID, i-1)
+	}
+	wg.Wait()
+	close(codes)
+	got := map[int]int{}
+	for code := range codes {
+		got[code]++
+	}
+	if got[http.StatusOK] != 1 || got[http.StatusForbidden] != 1 {
+		t.Errorf("Expected one 200 and one 403, got %v", got)
+	}
+	var users int
+	db.QueryRow("SELECT COUNT(*) FROM users WHERE email LIKE '%@example.org'").Scan(&users)
+	if users != 2 {
+		t.Errorf("Expected the cap of 2 users kept, got %d", users)
+	}
+	refused, _ := svc.Audit.List(audit.Filter{Action: audit.ActionSignUpVerifyTOTP, Outcome: audit.OutcomeFailure})
+	if len(refused) != 1 || refused[0].Reason != "user cap reached" {
+		t.Errorf("Expected the refusal audited, got %+v", refused)
+	}
 }
@@ -0,0 +1,151 @@
+package webauthn
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"secure-email-mvp/pkg/store"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	gowebauthn "github.com/go-webauthn/webauthn/webauthn"
+)
+
+// maxCredentialNameLen bounds the user-chosen label of a passkey
+const maxCredentialNameLen = 64
+
+// Credential is a registered passkey as shown to its owner
+type Credential struct {
+	ID             string     `json:"id"`
+	Name           string     `json:"name"`
+	CreatedAt      time.Time  `json:"created_at"`
+	LastUsedAt     *time.Time `json:"last_used_at,omitempty"`
+	BackupEligible bool       `json:"backup_eligible"` // Synced passkey rather than a device-bound key
+	Disabled       bool       `json:"disabled"`        // Disabled after a possible clone was detected
+}
+
+// loadCredentials returns the user's enabled passkeys in go-webauthn form
+func (s *Service) loadCredentials(userID string) ([]gowebauthn.Credential, error) {
+	keys, err := s.accounts.Store.Passkeys.List(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var creds []gowebauthn.Credential
+	for _, k := range keys {
+		if k.CloneWarning {
+			continue
+		}
+		c := gowebauthn.Credential{PublicKey: k.PublicKey, AttestationType: k.AttestationType}
+		c.Authenticator.AAGUID = k.AAGUID
+		c.Authenticator.SignCount = k.SignCount
+		c.Flags.BackupEligible = k.BackupEligible
+		c.Flags.BackupState = k.BackupState
+		if c.ID, err = decodeID(k.ID); err != nil {
+			return nil, fmt.Errorf("corrupt credential ID %q: %v", k.ID, err)
+		}
+		for _, t := range strings.Split(k.Transports, ",") {
+			if t != "" {
+				c.Transport = append(c.Transport, protocol.AuthenticatorTransport(t))
+			}
+		}
+		creds = append(creds, c)
+	}
+	return creds, nil
+}
+
+// insertCredential stores a newly registered passkey
+func (s *Service) insertCredential(userID, name string, cred *gowebauthn.Credential) (*Credential, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = "Passkey"
+	}
+	if len(name) > maxCredentialNameLen {
+		name = name[:maxCredentialNameLen]
+	}
+
+	transports := make([]string, len(cred.Transport))
+	for i, t := range cred.Transport {
+		transports[i] = string(t)
+	}
+	key := store.Passkey{
+		ID:              encodeID(cred.ID),
+		UserID:          userID,
+		Name:            name,
+		PublicKey:       cred.PublicKey,
+		AttestationType: cred.AttestationType,
+		AAGUID:          cred.Authenticator.AAGUID,
+		Transports:      strings.Join(transports, ","),
+		SignCount:       cred.Authenticator.SignCount,
+		BackupEligible:  cred.Flags.BackupEligible,
+		BackupState:     cred.Flags.BackupState,
+		CreatedAt:       time.Unix(time.Now().Unix(), 0),
+	}
+	err := s.accounts.Store.Passkeys.Create(key)
+	if err == store.ErrExists {
+		return nil, ErrCredentialExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	return credentialFromStore(key), nil
+}
+
+// recordCredentialUse stores the sign count and backup state from a successful login
+func (s *Service) recordCredentialUse(cred *gowebauthn.Credential) error {
+	return s.accounts.Store.Passkeys.RecordUse(encodeID(cred.ID), cred.Authenticator.SignCount, cred.Flags.BackupState, time.Now())
+}
+
+// disableCredential stops a passkey from being used after a possible clone
+func (s *Service) disableCredential(id []byte) error {
+	return s.accounts.Store.Passkeys.Flag(encodeID(id))
+}
+
+// credentialFromStore converts a stored passkey for its owner
+func credentialFromStore(k store.Passkey) *Credential {
+	c := &Credential{
+		ID:             k.ID,
+		Name:           k.Name,
+		CreatedAt:      k.CreatedAt,
+		BackupEligible: k.BackupEligible,
+		Disabled:       k.CloneWarning,
+	}
+	if !k.LastUsedAt.IsZero() {
+		t := k.LastUsedAt
+		c.LastUsedAt = &t
+	}
+	return c
+}
+
+// ListCredentials returns the user's passkeys, oldest first
+func (s *Service) ListCredentials(userID string) ([]Credential, error) {
+	keys, err := s.accounts.Store.Passkeys.List(userID)
+	if err != nil {
+		return nil, err
+	}
+	creds := make([]Credential, len(keys))
+	for i, k := range keys {
+		creds[i] = *credentialFromStore(k)
+	}
+	return creds, nil
+}
+
+// DeleteCredential removes one of the user's passkeys
+func (s *Service) DeleteCredential(userID, id string) error {
+	err := s.accounts.Store.Passkeys.Delete(userID, id)
+	if err == store.ErrNotFound {
+		return ErrCredentialNotFound
+	}
+	return err
+}
+
+// encodeID is how credential IDs appear in the database and the API
+func encodeID(id []byte) string {
+	return base64.RawURLEncoding.EncodeToString(id)
+}
+
+// decodeID reverses encodeID
+func decodeID(id string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(id)
+}
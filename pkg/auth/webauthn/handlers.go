@@ -0,0 +1,185 @@
+package webauthn
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"secure-email-mvp/pkg/auth"
+	"secure-email-mvp/pkg/logging"
+)
+
+// CeremonyResponse carries a challenge to the browser. Options is passed
+// as-is to navigator.credentials.create() or navigator.credentials.get().
+type CeremonyResponse struct {
+	ChallengeID string      `json:"challenge_id"`
+	Options     interface{} `json:"options"`
+}
+
+type BeginRegistrationRequest struct {
+	TotpCode string `json:"totp_code"`
+}
+
+type FinishRegistrationRequest struct {
+	ChallengeID string          `json:"challenge_id"`
+	Name        string          `json:"name"`
+	Credential  json.RawMessage `json:"credential"`
+}
+
+type ListCredentialsResponse struct {
+	Passkeys []Credential `json:"passkeys"`
+}
+
+// BeginRegistrationHandler starts adding a passkey once the caller confirms
+// a current TOTP code. It must run behind RequireAuth.
+func BeginRegistrationHandler(s *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := auth.IdentityFromContext(r.Context())
+		if !ok {
+			http.Error(w, `{"error":"Unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+
+		var req BeginRegistrationRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !auth.ValidateTOTP(req.TotpCode) {
+			http.Error(w, `{"error":"Invalid request"}`, http.StatusBadRequest)
+			return
+		}
+
+		account, err := s.accounts.Store.Users.ByID(id.UserID)
+		if err != nil {
+			http.Error(w, `{"error":"Internal server error"}`, http.StatusInternalServerError)
+			logging.FromContext(r.Context()).Error("Passkey registration failed", "error", err)
+			return
+		}
+		if err := s.accounts.CheckTOTP(id.UserID, account.TOTPSecret, req.TotpCode); err != nil {
+			if err == auth.ErrTOTPInvalid || err == auth.ErrTOTPReplayed {
+				http.Error(w, `{"error":"Invalid TOTP code"}`, http.StatusUnauthorized)
+				logging.FromContext(r.Context()).Warn("Passkey registration rejected", "user_id", id.UserID, "error", err)
+				return
+			}
+			http.Error(w, `{"error":"Internal server error"}`, http.StatusInternalServerError)
+			logging.FromContext(r.Context()).Error("Passkey registration failed", "error", err)
+			return
+		}
+
+		challengeID, options, err := s.BeginRegistration(id.UserID)
+		if err != nil {
+			http.Error(w, `{"error":"Internal server error"}`, http.StatusInternalServerError)
+			logging.FromContext(r.Context()).Error("Passkey registration failed", "error", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(CeremonyResponse{ChallengeID: challengeID, Options: options}); err != nil {
+			logging.FromContext(r.Context()).Warn("Passkey registration response failed", "error", err)
+		}
+	}
+}
+
+// FinishRegistrationHandler verifies the authenticator's response and stores
+// the passkey. It must run behind RequireAuth.
+func FinishRegistrationHandler(s *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := auth.IdentityFromContext(r.Context())
+		if !ok {
+			http.Error(w, `{"error":"Unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+
+		var req FinishRegistrationRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ChallengeID == "" || len(req.Credential) == 0 {
+			http.Error(w, `{"error":"Invalid request"}`, http.StatusBadRequest)
+			return
+		}
+
+		cred, err := s.FinishRegistration(id.UserID, req.ChallengeID, req.Name, req.Credential)
+		if err == ErrChallengeNotFound {
+			http.Error(w, `{"error":"Invalid or expired challenge"}`, http.StatusBadRequest)
+			return
+		}
+		if err == ErrCredentialExists {
+			http.Error(w, `{"error":"Passkey already registered"}`, http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, `{"error":"Passkey registration failed"}`, http.StatusBadRequest)
+			logging.FromContext(r.Context()).Error("Passkey registration failed", "user_id", id.UserID, "error", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		if err := json.NewEncoder(w).Encode(cred); err != nil {
+			logging.FromContext(r.Context()).Warn("Passkey registration response failed", "error", err)
+		}
+		logging.FromContext(r.Context()).Info("Passkey registered", "credential_id", cred.ID, "user_id", id.UserID)
+	}
+}
+
+// ListCredentialsHandler lists the caller's passkeys. It must run behind RequireAuth.
+func ListCredentialsHandler(s *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := auth.IdentityFromContext(r.Context())
+		if !ok {
+			http.Error(w, `{"error":"Unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+
+		creds, err := s.ListCredentials(id.UserID)
+		if err != nil {
+			http.Error(w, `{"error":"Internal server error"}`, http.StatusInternalServerError)
+			logging.FromContext(r.Context()).Error("List passkeys failed", "error", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(ListCredentialsResponse{Passkeys: creds}); err != nil {
+			logging.FromContext(r.Context()).Warn("List passkeys response failed", "error", err)
+		}
+	}
+}
+
+// DeleteCredentialHandler removes the passkey named by the {id} route
+// variable. It must run behind RequireAuth.
+func DeleteCredentialHandler(s *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := auth.IdentityFromContext(r.Context())
+		if !ok {
+			http.Error(w, `{"error":"Unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+
+		err := s.DeleteCredential(id.UserID, mux.Vars(r)["id"])
+		if err == ErrCredentialNotFound {
+			http.Error(w, `{"error":"Passkey not found"}`, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, `{"error":"Internal server error"}`, http.StatusInternalServerError)
+			logging.FromContext(r.Context()).Error("Delete passkey failed", "error", err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// BeginLoginHandler issues a login challenge. The answer is sent to
+// /api/auth/login as the passkey field. The request body is ignored, so the
+// response is the same for every caller.
+func BeginLoginHandler(s *Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		challengeID, options, err := s.BeginLogin()
+		if err != nil {
+			http.Error(w, `{"error":"Internal server error"}`, http.StatusInternalServerError)
+			logging.FromContext(r.Context()).Error("Passkey login failed", "error", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(CeremonyResponse{ChallengeID: challengeID, Options: options}); err != nil {
+			logging.FromContext(r.Context()).Warn("Passkey login response failed", "error", err)
+		}
+	}
+}
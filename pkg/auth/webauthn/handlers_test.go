@@ -0,0 +1,98 @@
+package webauthn
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/gorilla/mux"
+	"github.com/pquerna/otp/totp"
+
+	"secure-email-mvp/pkg/auth"
+)
+
+func TestPasskeyHandlers(t *testing.T) {
+	s, _ := newTestService(t)
+	tokens, _ := s.accounts.CreateSession("user-1", "test@securesystem.email", auth.ClientInfo{})
+
+	r := mux.NewRouter()
+	r.HandleFunc("/api/auth/passkey/begin", BeginLoginHandler(s)).Methods("POST")
+	protected := r.PathPrefix("/api").Subrouter()
+	protected.Use(auth.RequireAuth(s.accounts))
+	protected.HandleFunc("/account/passkeys", ListCredentialsHandler(s)).Methods("GET")
+	protected.HandleFunc("/account/passkeys/register/begin", BeginRegistrationHandler(s)).Methods("POST")
+	protected.HandleFunc("/account/passkeys/register/finish", FinishRegistrationHandler(s)).Methods("POST")
+	protected.HandleFunc("/account/passkeys/{id}", DeleteCredentialHandler(s)).Methods("DELETE")
+
+	do := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		json.NewEncoder(&buf).Encode(body)
+		req, _ := http.NewRequest(method, path, &buf)
+		req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Registration requires a valid TOTP code
+	if rr := do("POST", "/api/account/passkeys/register/begin", BeginRegistrationRequest{TotpCode: "000000"}); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for wrong TOTP code, got %d", rr.Code)
+	}
+	code, _ := totp.GenerateCode("JBSWY3DPEHPK3PXP", time.Now().Add(30*time.Second))
+	rr := do("POST", "/api/account/passkeys/register/begin", BeginRegistrationRequest{TotpCode: code})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var begin struct {
+		ChallengeID string                      `json:"challenge_id"`
+		Options     protocol.CredentialCreation `json:"options"`
+	}
+	json.NewDecoder(rr.Body).Decode(&begin)
+
+	a := newSoftAuthenticator(t, "user-1")
+	rr = do("POST", "/api/account/passkeys/register/finish", FinishRegistrationRequest{
+		ChallengeID: begin.ChallengeID,
+		Name:        "Phone",
+		Credential:  a.register(t, &begin.Options),
+	})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var created Credential
+	json.NewDecoder(rr.Body).Decode(&created)
+	if created.Name != "Phone" || created.ID != encode(a.credentialID) {
+		t.Errorf("Unexpected credential %+v", created)
+	}
+
+	rr = do("GET", "/api/account/passkeys", nil)
+	var list ListCredentialsResponse
+	json.NewDecoder(rr.Body).Decode(&list)
+	if rr.Code != http.StatusOK || len(list.Passkeys) != 1 {
+		t.Errorf("Expected 1 passkey listed, got %d %+v", rr.Code, list)
+	}
+
+	// The login challenge endpoint needs no token, and ignores the email
+	// older clients send
+	req, _ := http.NewRequest("POST", "/api/auth/passkey/begin", bytes.NewBufferString(`{"email":"test@securesystem.email"}`))
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	var login struct {
+		ChallengeID string                       `json:"challenge_id"`
+		Options     protocol.CredentialAssertion `json:"options"`
+	}
+	json.NewDecoder(rr.Body).Decode(&login)
+	if rr.Code != http.StatusOK || login.ChallengeID == "" || len(login.Options.Response.AllowedCredentials) != 0 {
+		t.Errorf("Expected a discoverable login challenge, got %d", rr.Code)
+	}
+
+	if rr := do("DELETE", "/api/account/passkeys/unknown", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", rr.Code)
+	}
+	if rr := do("DELETE", "/api/account/passkeys/"+created.ID, nil); rr.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", rr.Code)
+	}
+}
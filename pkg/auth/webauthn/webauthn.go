@@ -0,0 +1,331 @@
+// Package webauthn adds passkeys as a second factor and as a passwordless
+// login method. Credentials and in-flight ceremonies are stored in SQLite.
+package webauthn
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	gowebauthn "github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+
+	"secure-email-mvp/pkg/audit"
+	"secure-email-mvp/pkg/auth"
+	"secure-email-mvp/pkg/metrics"
+	"secure-email-mvp/pkg/store"
+)
+
+// ChallengeTTL is how long a registration or login ceremony stays open
+const ChallengeTTL = 5 * time.Minute
+
+const (
+	ceremonyRegistration = "registration"
+	ceremonyLogin        = "login"
+)
+
+var (
+	// ErrChallengeNotFound is returned for unknown, used or expired challenges
+	ErrChallengeNotFound = errors.New("challenge not found or expired")
+	// ErrCredentialNotFound is returned when a passkey does not exist or belongs to another user
+	ErrCredentialNotFound = errors.New("credential not found")
+	// ErrCredentialExists is returned when registering a passkey that is already registered
+	ErrCredentialExists = errors.New("credential already registered")
+	// ErrCloneDetected is returned when an authenticator's sign count did not
+	// increase, which means the key may have been copied. The credential is disabled.
+	ErrCloneDetected = errors.New("possible cloned authenticator")
+	// ErrUserVerificationRequired is returned when a passwordless login was
+	// not verified with a PIN or biometric on the authenticator
+	ErrUserVerificationRequired = errors.New("user verification required")
+	// ErrUserMismatch is returned when a passkey belongs to a different account
+	// than the password it was presented with
+	ErrUserMismatch = errors.New("passkey does not belong to this user")
+)
+
+// Config identifies the relying party to authenticators
+type Config struct {
+	RPID          string   // Domain the passkeys are scoped to, e.g. securesystem.email
+	RPDisplayName string   // Shown by the browser during ceremonies
+	RPOrigins     []string // Fully qualified origins allowed to run ceremonies
+}
+
+// Service runs WebAuthn ceremonies against the credentials in the database
+type Service struct {
+	accounts *auth.Service // Holds the passkeys, starts sessions and checks TOTP codes
+	wa       *gowebauthn.WebAuthn
+}
+
+// New creates a Service on the accounts' database for the relying party
+// described by cfg
+func New(accounts *auth.Service, cfg Config) (*Service, error) {
+	wa, err := gowebauthn.New(&gowebauthn.Config{
+		RPID:          cfg.RPID,
+		RPDisplayName: cfg.RPDisplayName,
+		RPOrigins:     cfg.RPOrigins,
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			// Logins never list an account's passkeys, so every passkey
+			// must be discoverable
+			ResidentKey:      protocol.ResidentKeyRequirementRequired,
+			UserVerification: protocol.VerificationPreferred,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid WebAuthn config: %v", err)
+	}
+	return &Service{accounts: accounts, wa: wa}, nil
+}
+
+// user adapts an account and its passkeys to the go-webauthn User interface.
+// The user handle is the account's UUID.
+type user struct {
+	id          string
+	email       string
+	credentials []gowebauthn.Credential
+}
+
+func (u *user) WebAuthnID() []byte                           { return []byte(u.id) }
+func (u *user) WebAuthnName() string                         { return u.email }
+func (u *user) WebAuthnDisplayName() string                  { return u.email }
+func (u *user) WebAuthnIcon() string                         { return "" }
+func (u *user) WebAuthnCredentials() []gowebauthn.Credential { return u.credentials }
+
+// loadUser reads an account and its enabled passkeys
+func (s *Service) loadUser(userID string) (*user, error) {
+	account, err := s.accounts.Store.Users.ByID(userID)
+	if err == store.ErrNotFound {
+		return nil, fmt.Errorf("user not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	u := &user{id: userID, email: account.Email}
+	u.credentials, err = s.loadCredentials(userID)
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// saveChallenge stores the state of a ceremony until the client finishes it.
+// Expired challenges are cleared at the same time.
+func (s *Service) saveChallenge(userID, ceremony string, session *gowebauthn.SessionData) (string, error) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode challenge: %v", err)
+	}
+	id := uuid.New().String()
+	now := time.Now()
+	err = s.accounts.Store.Challenges.Save(store.Challenge{
+		ID:          id,
+		UserID:      userID,
+		Ceremony:    ceremony,
+		SessionData: string(data),
+		ExpiresAt:   now.Add(ChallengeTTL),
+	}, now)
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// takeChallenge loads and deletes a challenge so it can only be answered once.
+// The returned user ID is empty for discoverable logins.
+func (s *Service) takeChallenge(id, ceremony string) (*gowebauthn.SessionData, string, error) {
+	c, err := s.accounts.Store.Challenges.Take(id, ceremony)
+	if err == store.ErrNotFound {
+		return nil, "", ErrChallengeNotFound
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if !time.Now().Before(c.ExpiresAt) {
+		return nil, "", ErrChallengeNotFound
+	}
+
+	var session gowebauthn.SessionData
+	if err := json.Unmarshal([]byte(c.SessionData), &session); err != nil {
+		return nil, "", fmt.Errorf("failed to decode challenge: %v", err)
+	}
+	return &session, c.UserID, nil
+}
+
+// BeginRegistration starts adding a passkey to the user's account and returns
+// the challenge ID and the options to pass to navigator.credentials.create()
+func (s *Service) BeginRegistration(userID string) (string, *protocol.CredentialCreation, error) {
+	u, err := s.loadUser(userID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Stop the same authenticator from being registered twice
+	exclude := make([]protocol.CredentialDescriptor, len(u.credentials))
+	for i, c := range u.credentials {
+		exclude[i] = c.Descriptor()
+	}
+	options, session, err := s.wa.BeginRegistration(u, gowebauthn.WithExclusions(exclude))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to begin registration: %v", err)
+	}
+
+	id, err := s.saveChallenge(userID, ceremonyRegistration, session)
+	if err != nil {
+		return "", nil, err
+	}
+	return id, options, nil
+}
+
+// FinishRegistration verifies the authenticator's attestation response and
+// stores the new passkey under name
+func (s *Service) FinishRegistration(userID, challengeID, name string, response []byte) (*Credential, error) {
+	session, owner, err := s.takeChallenge(challengeID, ceremonyRegistration)
+	if err != nil {
+		return nil, err
+	}
+	if owner != userID {
+		return nil, ErrChallengeNotFound
+	}
+	u, err := s.loadUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(response))
+	if err != nil {
+		return nil, fmt.Errorf("invalid registration response: %v", describe(err))
+	}
+	cred, err := s.wa.CreateCredential(u, *session, parsed)
+	if err != nil {
+		return nil, fmt.Errorf("registration verification failed: %v", describe(err))
+	}
+	return s.insertCredential(userID, name, cred)
+}
+
+// BeginLogin starts a passkey login, for passwordless sign-in or as a second
+// factor after the password. The options never list an account's passkeys:
+// the browser offers any passkey it holds for this site, so the unauthenticated
+// caller learns nothing about which accounts exist or have passkeys.
+func (s *Service) BeginLogin() (string, *protocol.CredentialAssertion, error) {
+	options, session, err := s.wa.BeginDiscoverableLogin()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to begin login: %v", err)
+	}
+	id, err := s.saveChallenge("", ceremonyLogin, session)
+	if err != nil {
+		return "", nil, err
+	}
+	return id, options, nil
+}
+
+// Assertion is a client's answer to a login challenge, as sent to /api/auth/login
+type Assertion struct {
+	ChallengeID string          `json:"challenge_id"`
+	Credential  json.RawMessage `json:"credential"`
+}
+
+// loginResult describes a verified assertion
+type loginResult struct {
+	userID       string
+	email        string
+	userVerified bool
+}
+
+// finishLogin verifies an assertion, updates the passkey's sign count and
+// disables it if the count shows signs of cloning
+func (s *Service) finishLogin(a Assertion) (*loginResult, error) {
+	session, _, err := s.takeChallenge(a.ChallengeID, ceremonyLogin)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(a.Credential))
+	if err != nil {
+		return nil, fmt.Errorf("invalid login response: %v", describe(err))
+	}
+
+	var u *user
+	cred, err := s.wa.ValidateDiscoverableLogin(func(rawID, userHandle []byte) (gowebauthn.User, error) {
+		var err error
+		u, err = s.loadUser(string(userHandle))
+		return u, err
+	}, *session, parsed)
+	if err != nil {
+		return nil, fmt.Errorf("login verification failed: %v", describe(err))
+	}
+
+	if cred.Authenticator.CloneWarning {
+		if err := s.disableCredential(cred.ID); err != nil {
+			return nil, err
+		}
+		return nil, ErrCloneDetected
+	}
+	if err := s.recordCredentialUse(cred); err != nil {
+		return nil, err
+	}
+	return &loginResult{userID: u.id, email: u.email, userVerified: cred.Flags.UserVerified}, nil
+}
+
+// Login signs a user in with a passkey alone. The authenticator must have
+// verified the user, since the passkey replaces both password and TOTP.
+// Every attempt is audited, against the account once the passkey names one,
+// and counted in metrics.
+func (s *Service) Login(ctx context.Context, a Assertion, client auth.ClientInfo) (tokens *auth.TokenPair, userID string, err error) {
+	var result *loginResult
+	defer func() {
+		e := audit.Event{Action: audit.ActionLoginPasskey, Outcome: audit.OutcomeSuccess}
+		if result != nil {
+			e.ActorEmail, e.Target = result.email, result.userID
+		}
+		counted := "success"
+		if err != nil {
+			e.Outcome, e.Reason = audit.OutcomeFailure, err.Error()
+			counted = "bad_passkey"
+			if errors.Is(err, auth.ErrAccountDisabled) {
+				counted = "disabled"
+			}
+		} else {
+			e.ActorID = userID
+		}
+		s.accounts.RecordAudit(ctx, client, e)
+		metrics.AuthAttempt(audit.ActionLoginPasskey, counted)
+	}()
+
+	result, err = s.finishLogin(a)
+	if err != nil {
+		return nil, "", err
+	}
+	if !result.userVerified {
+		return nil, "", ErrUserVerificationRequired
+	}
+	tokens, err = s.accounts.CreateSession(result.userID, result.email, client)
+	if err != nil {
+		return nil, "", err
+	}
+	return tokens, result.userID, nil
+}
+
+// SecondFactor returns a check for auth.Service.AuthenticateWithSecondFactor
+// that accepts the assertion in place of a TOTP code
+func (s *Service) SecondFactor(a Assertion) func(userID string) error {
+	return func(userID string) error {
+		result, err := s.finishLogin(a)
+		if err != nil {
+			return err
+		}
+		if result.userID != userID {
+			return ErrUserMismatch
+		}
+		return nil
+	}
+}
+
+// describe includes the debug info go-webauthn attaches to its errors
+func describe(err error) string {
+	var perr *protocol.Error
+	if errors.As(err, &perr) && perr.DevInfo != "" {
+		return perr.Details + " (" + perr.DevInfo + ")"
+	}
+	return err.Error()
+}
@@ -0,0 +1,332 @@
+package webauthn
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	_ "github.com/mattn/go-sqlite3"
+
+	"secure-email-mvp/pkg/auth"
+	"secure-email-mvp/pkg/migrate"
+	"secure-email-mvp/pkg/store/sqlite"
+)
+
+const (
+	testRPID     = "securesystem.email"
+	testOrigin   = "https://securesystem.email"
+	testPassword = "securepass123"
+)
+
+// newTestService returns a Service over an in-memory database holding
+// user-1 and user-2, both with password testPassword
+func newTestService(t *testing.T) (*Service, *sql.DB) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal("Failed to open database:", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	if _, err := migrate.Up(db); err != nil {
+		t.Fatal("Failed to migrate:", err)
+	}
+
+	hash, _ := auth.HashPassword(testPassword)
+	for _, u := range [][2]string{{"user-1", "test@securesystem.email"}, {"user-2", "other@securesystem.email"}} {
+		_, err := db.Exec("INSERT INTO users (id, email, password_hash, totp_secret) VALUES (?, ?, ?, ?)",
+			u[0], u[1], hash, "JBSWY3DPEHPK3PXP")
+		if err != nil {
+			t.Fatal("Failed to insert user:", err)
+		}
+	}
+
+	keyring, err := auth.NewKeyring()
+	if err != nil {
+		t.Fatal("NewKeyring failed:", err)
+	}
+	s, err := New(auth.NewService(db, sqlite.Dialect, keyring, []byte("0123456789abcdef0123456789abcdef")), Config{RPID: testRPID, RPDisplayName: "SecureEmail", RPOrigins: []string{testOrigin}})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	return s, db
+}
+
+// softAuthenticator is an in-process authenticator holding a single ES256
+// passkey. It produces the same JSON a browser sends after
+// navigator.credentials.create() and navigator.credentials.get().
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	signCount    uint32
+	origin       string
+	userVerified bool
+}
+
+func newSoftAuthenticator(t *testing.T, userID string) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &softAuthenticator{key: key, credentialID: id, userHandle: []byte(userID), origin: testOrigin, userVerified: true}
+}
+
+func (a *softAuthenticator) clientData(ceremony protocol.CeremonyType, challenge protocol.URLEncodedBase64) []byte {
+	data, _ := json.Marshal(map[string]string{
+		"type":      string(ceremony),
+		"challenge": challenge.String(),
+		"origin":    a.origin,
+	})
+	return data
+}
+
+// authData builds authenticator data: rpIdHash | flags | signCount [| attested credential]
+func (a *softAuthenticator) authData(attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+	flags := byte(protocol.FlagUserPresent)
+	if a.userVerified {
+		flags |= byte(protocol.FlagUserVerified)
+	}
+	if attested != nil {
+		flags |= byte(protocol.FlagAttestedCredentialData)
+	}
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	return append(data, attested...)
+}
+
+func (a *softAuthenticator) register(t *testing.T, options *protocol.CredentialCreation) []byte {
+	t.Helper()
+	coseKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  int64(webauthncose.P256),
+		XCoord: a.key.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attested := make([]byte, 16) // AAGUID of all zeros
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialID)))
+	attested = append(attested, a.credentialID...)
+	attested = append(attested, coseKey...)
+
+	attestation, err := webauthncbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": a.authData(attested),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a.credentialJSON(map[string]string{
+		"clientDataJSON":    encode(a.clientData(protocol.CreateCeremony, options.Response.Challenge)),
+		"attestationObject": encode(attestation),
+	})
+}
+
+func (a *softAuthenticator) assert(t *testing.T, options *protocol.CredentialAssertion) []byte {
+	t.Helper()
+	a.signCount++
+	authData := a.authData(nil)
+	clientData := a.clientData(protocol.AssertCeremony, options.Response.Challenge)
+	clientHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a.credentialJSON(map[string]string{
+		"clientDataJSON":    encode(clientData),
+		"authenticatorData": encode(authData),
+		"signature":         encode(sig),
+		"userHandle":        encode(a.userHandle),
+	})
+}
+
+func (a *softAuthenticator) credentialJSON(response map[string]string) []byte {
+	data, _ := json.Marshal(map[string]interface{}{
+		"id":       encode(a.credentialID),
+		"rawId":    encode(a.credentialID),
+		"type":     "public-key",
+		"response": response,
+	})
+	return data
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// registerPasskey runs a full registration ceremony for userID
+func registerPasskey(t *testing.T, s *Service, userID string) *softAuthenticator {
+	t.Helper()
+	a := newSoftAuthenticator(t, userID)
+	challengeID, options, err := s.BeginRegistration(userID)
+	if err != nil {
+		t.Fatalf("BeginRegistration failed: %v", err)
+	}
+	if _, err := s.FinishRegistration(userID, challengeID, "Laptop", a.register(t, options)); err != nil {
+		t.Fatalf("FinishRegistration failed: %v", err)
+	}
+	return a
+}
+
+// beginAssertion starts a login and answers it with a
+func beginAssertion(t *testing.T, s *Service, a *softAuthenticator) Assertion {
+	t.Helper()
+	challengeID, options, err := s.BeginLogin()
+	if err != nil {
+		t.Fatalf("BeginLogin failed: %v", err)
+	}
+	return Assertion{ChallengeID: challengeID, Credential: a.assert(t, options)}
+}
+
+func TestRegistration(t *testing.T) {
+	s, _ := newTestService(t)
+	a := registerPasskey(t, s, "user-1")
+
+	creds, err := s.ListCredentials("user-1")
+	if err != nil {
+		t.Fatalf("ListCredentials failed: %v", err)
+	}
+	if len(creds) != 1 || creds[0].ID != encode(a.credentialID) || creds[0].Name != "Laptop" {
+		t.Fatalf("Expected the registered passkey listed, got %+v", creds)
+	}
+
+	// The same authenticator is excluded and cannot be registered again
+	challengeID, options, _ := s.BeginRegistration("user-1")
+	if len(options.Response.CredentialExcludeList) != 1 {
+		t.Errorf("Expected existing passkey in exclude list, got %d", len(options.Response.CredentialExcludeList))
+	}
+	if _, err := s.FinishRegistration("user-1", challengeID, "", a.register(t, options)); err != ErrCredentialExists {
+		t.Errorf("Expected ErrCredentialExists, got %v", err)
+	}
+
+	// A challenge is single-use and bound to the user who started it
+	challengeID, options, _ = s.BeginRegistration("user-1")
+	other := newSoftAuthenticator(t, "user-1")
+	if _, err := s.FinishRegistration("user-2", challengeID, "", other.register(t, options)); err != ErrChallengeNotFound {
+		t.Errorf("Expected another user's challenge rejected, got %v", err)
+	}
+	if _, err := s.FinishRegistration("user-1", challengeID, "", other.register(t, options)); err != ErrChallengeNotFound {
+		t.Errorf("Expected used challenge rejected, got %v", err)
+	}
+
+	// Responses from another origin are rejected
+	challengeID, options, _ = s.BeginRegistration("user-1")
+	phished := newSoftAuthenticator(t, "user-1")
+	phished.origin = "https://securesystem.email.evil.example"
+	if _, err := s.FinishRegistration("user-1", challengeID, "", phished.register(t, options)); err == nil {
+		t.Error("Expected registration from a foreign origin rejected")
+	}
+
+	if err := s.DeleteCredential("user-2", creds[0].ID); err != ErrCredentialNotFound {
+		t.Errorf("Expected another user's passkey not deletable, got %v", err)
+	}
+	if err := s.DeleteCredential("user-1", creds[0].ID); err != nil {
+		t.Errorf("DeleteCredential failed: %v", err)
+	}
+}
+
+func TestPasswordlessLogin(t *testing.T) {
+	s, _ := newTestService(t)
+	a := registerPasskey(t, s, "user-1")
+
+	assertion := beginAssertion(t, s, a)
+	tokens, userID, err := s.Login(context.Background(), assertion, auth.ClientInfo{})
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	claims, err := s.accounts.Keyring.ParseToken(tokens.AccessToken)
+	if err != nil || userID != "user-1" || claims.UserID != "user-1" {
+		t.Errorf("Expected a session for user-1, got %s (%v)", userID, err)
+	}
+
+	// The assertion cannot be replayed
+	if _, _, err := s.Login(context.Background(), assertion, auth.ClientInfo{}); err != ErrChallengeNotFound {
+		t.Errorf("Expected replayed assertion rejected, got %v", err)
+	}
+
+	// Without a PIN or biometric the passkey cannot replace password and TOTP
+	a.userVerified = false
+	if _, _, err := s.Login(context.Background(), beginAssertion(t, s, a), auth.ClientInfo{}); err == nil {
+		t.Error("Expected login without user verification rejected")
+	}
+}
+
+func TestPasskeyAsSecondFactor(t *testing.T) {
+	s, _ := newTestService(t)
+	a := registerPasskey(t, s, "user-1")
+	b := registerPasskey(t, s, "user-2")
+
+	// Challenges never list an account's passkeys, so they cannot be used to
+	// find out which accounts exist or have passkeys
+	_, options, err := s.BeginLogin()
+	if err != nil || len(options.Response.AllowedCredentials) != 0 {
+		t.Errorf("Expected discoverable options, got %d credentials (%v)", len(options.Response.AllowedCredentials), err)
+	}
+
+	// The password is still checked, and a user presence check is enough
+	a.userVerified = false
+	_, _, err = s.accounts.AuthenticateWithSecondFactor(context.Background(), "test@securesystem.email", "wrongpass123", auth.ClientInfo{},
+		s.SecondFactor(beginAssertion(t, s, a)))
+	if err == nil {
+		t.Error("Expected wrong password rejected")
+	}
+	_, userID, err := s.accounts.AuthenticateWithSecondFactor(context.Background(), "test@securesystem.email", testPassword, auth.ClientInfo{},
+		s.SecondFactor(beginAssertion(t, s, a)))
+	if err != nil || userID != "user-1" {
+		t.Fatalf("Expected password + passkey login for user-1, got %s (%v)", userID, err)
+	}
+
+	// Another account's passkey does not satisfy the second factor
+	_, _, err = s.accounts.AuthenticateWithSecondFactor(context.Background(), "test@securesystem.email", testPassword, auth.ClientInfo{},
+		s.SecondFactor(beginAssertion(t, s, b)))
+	if err != ErrUserMismatch {
+		t.Errorf("Expected ErrUserMismatch, got %v", err)
+	}
+}
+
+func TestCloneDetection(t *testing.T) {
+	s, _ := newTestService(t)
+	a := registerPasskey(t, s, "user-1")
+	for i := 0; i < 3; i++ {
+		if _, _, err := s.Login(context.Background(), beginAssertion(t, s, a), auth.ClientInfo{}); err != nil {
+			t.Fatalf("Login failed: %v", err)
+		}
+	}
+
+	// A copy of the key whose counter lags behind the original
+	clone := *a
+	clone.signCount = 1
+	if _, _, err := s.Login(context.Background(), beginAssertion(t, s, &clone), auth.ClientInfo{}); err != ErrCloneDetected {
+		t.Fatalf("Expected ErrCloneDetected, got %v", err)
+	}
+
+	creds, _ := s.ListCredentials("user-1")
+	if len(creds) != 1 || !creds[0].Disabled {
+		t.Fatalf("Expected the passkey marked disabled, got %+v", creds)
+	}
+	// The credential stays disabled, even for the original authenticator
+	if _, _, err := s.Login(context.Background(), beginAssertion(t, s, a), auth.ClientInfo{}); err == nil {
+		t.Error("Expected disabled passkey rejected")
+	}
+}
@@ -0,0 +1,174 @@
+// Package certs serves the API's TLS certificate and replaces it when the
+// files change on disk, so renewed certificates take effect without a
+// restart. Handshakes always use the last certificate that loaded cleanly.
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadDelay lets a renewal finish writing both files before they are read
+const reloadDelay = 500 * time.Millisecond
+
+// Reloader holds the current certificate for a certificate and key file pair
+type Reloader struct {
+	certFile, keyFile string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+// NewReloader loads the certificate chain in certFile and its key in keyFile
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads both files again. If they do not form a valid pair, such as
+// halfway through a renewal, the previous certificate stays in use.
+func (r *Reloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading certificate: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("parsing certificate: %w", err)
+	}
+	cert.Leaf = leaf
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.mu.Unlock()
+	return nil
+}
+
+// GetCertificate returns the current certificate, for tls.Config
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Leaf returns the current certificate, for reporting its subject and expiry
+func (r *Reloader) Leaf() *x509.Certificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert.Leaf
+}
+
+// Watch reloads the certificate whenever either file changes. It watches
+// their directories rather than the files, so certificates replaced by
+// rename or symlink swap, as certbot and Kubernetes do, are picked up too.
+func (r *Reloader) Watch() (stop func(), err error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	watched := map[string]bool{}
+	for _, f := range []string{r.certFile, r.keyFile} {
+		dir := filepath.Dir(f)
+		if watched[dir] {
+			continue
+		}
+		if err := w.Add(dir); err != nil {
+			w.Close()
+			return nil, fmt.Errorf("watching %s: %w", dir, err)
+		}
+		watched[dir] = true
+	}
+
+	done := make(chan struct{})
+	go func() {
+		// Renewals write several files in quick succession; reload once
+		// they have settled
+		timer := time.NewTimer(reloadDelay)
+		timer.Stop()
+		for {
+			select {
+			case <-done:
+				timer.Stop()
+				return
+			case event, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if r.affects(event.Name) {
+					timer.Reset(reloadDelay)
+				}
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				slog.Error("Certificate watch error", "error", err)
+			case <-timer.C:
+				r.ReloadAndLog()
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		w.Close()
+	}, nil
+}
+
+// affects reports whether a change to name may change the certificate:
+// either file itself, or a symlink or directory in the same place such as
+// Kubernetes' ..data
+func (r *Reloader) affects(name string) bool {
+	for _, f := range []string{r.certFile, r.keyFile} {
+		if filepath.Clean(name) == filepath.Clean(f) {
+			return true
+		}
+	}
+	base := filepath.Base(name)
+	return len(base) > 0 && base[0] == '.'
+}
+
+// ReloadAndLog reloads after a file change or SIGHUP, logging the outcome
+// instead of returning it
+func (r *Reloader) ReloadAndLog() {
+	if err := r.Reload(); err != nil {
+		slog.Error("Certificate reload failed, keeping the current one", "error", err)
+		return
+	}
+	leaf := r.Leaf()
+	slog.Info("Loaded TLS certificate", "subject", leaf.Subject.CommonName, "expires", leaf.NotAfter.UTC().Format(time.RFC3339))
+}
+
+// ServerConfig returns a TLS 1.3-only configuration serving r's certificate.
+// With clientCAFile, clients must present a certificate signed by one of
+// the CAs in it, such as Cloudflare's Authenticated Origin Pulls CA.
+func ServerConfig(r *Reloader, clientCAFile string) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS13,
+		GetCertificate: r.GetCertificate,
+	}
+	if clientCAFile == "" {
+		return cfg, nil
+	}
+	pem, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading client CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("client CA file contains no PEM certificates")
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	return cfg, nil
+}
@@ -0,0 +1,189 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self-signed certificate for cn and its key into dir
+func writeCert(t *testing.T, dir, cn string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		DNSNames:              []string{cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	// Replace both files by rename, as renewal tools do
+	for name, block := range map[string]*pem.Block{
+		certFile: {Type: "CERTIFICATE", Bytes: der},
+		keyFile:  {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	} {
+		tmp := name + ".tmp"
+		if err := os.WriteFile(tmp, pem.EncodeToMemory(block), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return certFile, keyFile
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCert(t, dir, "one.example.org")
+	r, err := NewReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal("NewReloader failed:", err)
+	}
+	if cn := r.Leaf().Subject.CommonName; cn != "one.example.org" {
+		t.Errorf("Expected first certificate, got %s", cn)
+	}
+
+	// A half-written renewal keeps the old certificate
+	if err := os.WriteFile(keyFile, []byte("garbage"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Reload(); err == nil {
+		t.Error("Expected mismatched key rejected")
+	}
+	if cert, _ := r.GetCertificate(nil); cert.Leaf.Subject.CommonName != "one.example.org" {
+		t.Errorf("Expected previous certificate kept, got %s", cert.Leaf.Subject.CommonName)
+	}
+
+	if _, err := NewReloader(filepath.Join(dir, "missing.crt"), keyFile); err == nil {
+		t.Error("Expected missing certificate rejected")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCert(t, dir, "one.example.org")
+	r, err := NewReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal("NewReloader failed:", err)
+	}
+	stop, err := r.Watch()
+	if err != nil {
+		t.Fatal("Watch failed:", err)
+	}
+	defer stop()
+
+	writeCert(t, dir, "two.example.org")
+	deadline := time.Now().Add(5 * time.Second)
+	for r.Leaf().Subject.CommonName != "two.example.org" {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected renewed certificate loaded without a restart")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// handshake connects a client using clientCfg to a server using serverCfg
+// and reports the server's verdict
+func handshake(t *testing.T, serverCfg, clientCfg *tls.Config) error {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	serverErr := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		defer conn.Close()
+		serverErr <- tls.Server(conn, serverCfg).Handshake()
+	}()
+
+	conn, err := tls.Dial("tcp", ln.Addr().String(), clientCfg)
+	if err != nil {
+		<-serverErr
+		return err
+	}
+	defer conn.Close()
+	// TLS 1.3 clients finish before the server checks their certificate
+	return <-serverErr
+}
+
+func TestServerConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCert(t, dir, "api.example.org")
+	r, err := NewReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal("NewReloader failed:", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(r.Leaf())
+	client := func(max uint16) *tls.Config {
+		return &tls.Config{ServerName: "api.example.org", RootCAs: roots, MaxVersion: max}
+	}
+
+	cfg, err := ServerConfig(r, "")
+	if err != nil {
+		t.Fatal("ServerConfig failed:", err)
+	}
+	if err := handshake(t, cfg, client(0)); err != nil {
+		t.Errorf("Expected TLS 1.3 handshake, got %v", err)
+	}
+	if err := handshake(t, cfg, client(tls.VersionTLS12)); err == nil {
+		t.Error("Expected TLS 1.2 refused")
+	}
+
+	// With a client CA, only clients holding a certificate it signed get in
+	caDir := t.TempDir()
+	caCert, caKey := writeCert(t, caDir, "origin-pull.example.org")
+	cfg, err = ServerConfig(r, caCert)
+	if err != nil {
+		t.Fatal("ServerConfig with client CA failed:", err)
+	}
+	if err := handshake(t, cfg, client(0)); err == nil {
+		t.Error("Expected client without a certificate refused")
+	}
+	pair, err := tls.LoadX509KeyPair(caCert, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withCert := client(0)
+	withCert.Certificates = []tls.Certificate{pair}
+	if err := handshake(t, cfg, withCert); err != nil {
+		t.Errorf("Expected client certificate accepted, got %v", err)
+	}
+
+	if _, err := ServerConfig(r, keyFile); err == nil {
+		t.Error("Expected client CA file without certificates rejected")
+	}
+}
@@ -0,0 +1,478 @@
+// Package config loads the API's settings from defaults, an optional YAML or
+// TOML file, a .env file and the environment, and validates them at startup.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+
+	"secure-email-mvp/pkg/auth"
+	"secure-email-mvp/pkg/logging"
+	"secure-email-mvp/pkg/ratelimit"
+)
+
+// Config is every setting the API reads. Field tags name the keys used in
+// config files; each field's environment variable is listed in env().
+type Config struct {
+	Server    ServerConfig    `yaml:"server" toml:"server"`
+	Admin     AdminConfig     `yaml:"admin" toml:"admin"`
+	TLS       TLSConfig       `yaml:"tls" toml:"tls"`
+	Database  DatabaseConfig  `yaml:"database" toml:"database"`
+	Log       LogConfig       `yaml:"log" toml:"log"`
+	JWT       JWTConfig       `yaml:"jwt" toml:"jwt"`
+	TOTP      TOTPConfig      `yaml:"totp" toml:"totp"`
+	WebAuthn  WebAuthnConfig  `yaml:"webauthn" toml:"webauthn"`
+	RateLimit RateLimitConfig `yaml:"rate_limit" toml:"rate_limit"`
+	Lockout   LockoutConfig   `yaml:"lockout" toml:"lockout"`
+	Hash      HashConfig      `yaml:"hash" toml:"hash"`
+	Accounts  AccountsConfig  `yaml:"accounts" toml:"accounts"`
+	Audit     AuditConfig     `yaml:"audit" toml:"audit"`
+}
+
+// ServerConfig controls the HTTP listener. The timeouts bound how long a
+// client may hold a connection. After SIGTERM the API reports draining for
+// PreStopDelay while still serving, so load balancers stop sending traffic,
+// then in-flight requests get ShutdownTimeout to finish.
+type ServerConfig struct {
+	Addr              string        `yaml:"addr" toml:"addr"`
+	CORSOrigins       []string      `yaml:"cors_origins" toml:"cors_origins"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" toml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout" toml:"read_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout" toml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout" toml:"idle_timeout"`
+	PreStopDelay      time.Duration `yaml:"pre_stop_delay" toml:"pre_stop_delay"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+}
+
+// AdminConfig controls the operators' listener, which serves /metrics and
+// detailed health checks. Keep it off the public network.
+type AdminConfig struct {
+	Addr string `yaml:"addr" toml:"addr"` // Empty disables the listener
+}
+
+// TLSConfig turns on HTTPS for the public listener when CertFile and KeyFile
+// are set. The files are reloaded when they change or on SIGHUP.
+type TLSConfig struct {
+	CertFile     string `yaml:"cert_file" toml:"cert_file"`           // PEM certificate chain, leaf first
+	KeyFile      string `yaml:"key_file" toml:"key_file"`             // PEM private key
+	ClientCAFile string `yaml:"client_ca_file" toml:"client_ca_file"` // Require client certificates signed by these CAs
+	RedirectAddr string `yaml:"redirect_addr" toml:"redirect_addr"`   // Plain HTTP listener redirecting to HTTPS; empty disables it
+}
+
+// Enabled reports whether the API serves HTTPS
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != ""
+}
+
+// DatabaseConfig picks the database the API runs on and locates it
+type DatabaseConfig struct {
+	Driver string `yaml:"driver" toml:"driver"` // sqlite or postgres
+	Path   string `yaml:"path" toml:"path"`     // SQLite database file
+	DSN    string `yaml:"dsn" toml:"dsn"`       // PostgreSQL URL or key=value connection string
+}
+
+// Source is what the driver opens: the SQLite file or the PostgreSQL DSN
+func (d DatabaseConfig) Source() string {
+	if d.Driver == "postgres" {
+		return d.DSN
+	}
+	return d.Path
+}
+
+// LogConfig controls the structured JSON log
+type LogConfig struct {
+	File       string `yaml:"file" toml:"file"`               // Empty logs to stderr
+	Level      string `yaml:"level" toml:"level"`             // debug, info, warn or error
+	MaxSizeMB  int    `yaml:"max_size_mb" toml:"max_size_mb"` // Rotate the file at this size
+	MaxBackups int    `yaml:"max_backups" toml:"max_backups"` // Rotated files kept; 0 keeps all
+	Redact     string `yaml:"redact" toml:"redact"`           // Email and IP redaction: hash, mask or none
+	HashKey    string `yaml:"hash_key" toml:"hash_key"`       // Keeps redact=hash stable across restarts
+}
+
+// JWTConfig controls the access token signing keys
+type JWTConfig struct {
+	KeyDir   string        `yaml:"key_dir" toml:"key_dir"`
+	Rotation time.Duration `yaml:"rotation" toml:"rotation"`
+}
+
+// TOTPConfig controls TOTP code checks
+type TOTPConfig struct {
+	Skew uint `yaml:"skew" toml:"skew"`
+}
+
+// WebAuthnConfig identifies the relying party for passkeys
+type WebAuthnConfig struct {
+	RPID      string   `yaml:"rp_id" toml:"rp_id"`
+	RPOrigins []string `yaml:"rp_origins" toml:"rp_origins"`
+}
+
+// RateLimitConfig controls request rate limiting. Each unauthenticated auth
+// endpoint has its own per-IP policy, so a burst on one never locks a client
+// out of another.
+type RateLimitConfig struct {
+	Login          RatePolicy `yaml:"login" toml:"login"`
+	SignUp         RatePolicy `yaml:"signup" toml:"signup"`
+	VerifyTOTP     RatePolicy `yaml:"verify_totp" toml:"verify_totp"`
+	Refresh        RatePolicy `yaml:"refresh" toml:"refresh"`
+	Passkey        RatePolicy `yaml:"passkey" toml:"passkey"` // Starting a passkey login
+	Store          string     `yaml:"store" toml:"store"`     // memory or database; sqlite is the old name for database
+	TrustedProxies []string   `yaml:"trusted_proxies" toml:"trusted_proxies"`
+}
+
+// RatePolicy allows Requests per Window from one IP
+type RatePolicy struct {
+	Requests int           `yaml:"requests" toml:"requests"`
+	Window   time.Duration `yaml:"window" toml:"window"`
+}
+
+// Policies returns the per-route policies by config key, which is also the
+// policy name their buckets and metrics use
+func (c RateLimitConfig) Policies() map[string]RatePolicy {
+	return map[string]RatePolicy{
+		"login":       c.Login,
+		"signup":      c.SignUp,
+		"verify_totp": c.VerifyTOTP,
+		"refresh":     c.Refresh,
+		"passkey":     c.Passkey,
+	}
+}
+
+// LockoutConfig controls per-account login lockout
+type LockoutConfig struct {
+	After    int           `yaml:"after" toml:"after"`
+	Duration time.Duration `yaml:"duration" toml:"duration"`
+}
+
+// HashConfig bounds concurrent Argon2 hashing
+type HashConfig struct {
+	Workers int `yaml:"workers" toml:"workers"`
+	Queue   int `yaml:"queue" toml:"queue"`
+}
+
+// AccountsConfig seeds the first hosted domain and administrators. Domain and
+// MaxUsers only apply when the domain is not in the database yet, and Admins
+// are promoted at every start; after that the admin API manages both.
+type AccountsConfig struct {
+	Domain   string   `yaml:"domain" toml:"domain"`       // Created with open sign-up at startup if missing
+	MaxUsers int      `yaml:"max_users" toml:"max_users"` // User cap for a newly created Domain
+	Admins   []string `yaml:"admins" toml:"admins"`       // Existing users given the admin role at startup
+}
+
+// AuditConfig controls the security audit log
+type AuditConfig struct {
+	Retention time.Duration `yaml:"retention" toml:"retention"` // Older events are pruned; 0 keeps them forever
+	KeyFile   string        `yaml:"key_file" toml:"key_file"`   // HMAC key for the hash chain; created if missing
+}
+
+// Default returns the settings used when nothing overrides them
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Addr:              ":8080",
+			CORSOrigins:       []string{"http://localhost:3000", "https://secure-email-mvp.netlify.app"},
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       15 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			PreStopDelay:      5 * time.Second,
+			ShutdownTimeout:   30 * time.Second,
+		},
+		Admin:    AdminConfig{Addr: "127.0.0.1:9090"},
+		Database: DatabaseConfig{Driver: "sqlite", Path: "/var/db/secure-email.db"},
+		Log:      LogConfig{Level: "info", MaxSizeMB: 100, MaxBackups: 5, Redact: logging.RedactHash},
+		JWT:      JWTConfig{KeyDir: "/var/lib/secure-email/keys", Rotation: 7 * 24 * time.Hour},
+		TOTP:     TOTPConfig{Skew: auth.DefaultTOTPSkew},
+		WebAuthn: WebAuthnConfig{RPID: "securesystem.email", RPOrigins: []string{"https://securesystem.email"}},
+		RateLimit: RateLimitConfig{
+			Login:      RatePolicy{Requests: 10, Window: time.Minute},
+			SignUp:     RatePolicy{Requests: 5, Window: time.Minute},
+			VerifyTOTP: RatePolicy{Requests: 10, Window: time.Minute},
+			Refresh:    RatePolicy{Requests: 30, Window: time.Minute},
+			Passkey:    RatePolicy{Requests: 10, Window: time.Minute},
+			Store:      "memory",
+		},
+		Lockout:  LockoutConfig{After: auth.DefaultLockout.LockAfter, Duration: auth.DefaultLockout.LockDuration},
+		Hash:     HashConfig{Workers: auth.DefaultHashWorkers, Queue: auth.DefaultHashQueue},
+		Accounts: AccountsConfig{Domain: auth.DefaultEmailDomain, MaxUsers: 100},
+		Audit:    AuditConfig{Retention: 365 * 24 * time.Hour, KeyFile: "/var/lib/secure-email/audit.key"},
+	}
+}
+
+// Load builds the configuration. Later sources override earlier ones:
+// defaults, then the YAML (.yaml, .yml) or TOML (.toml) file at path, or at
+// CONFIG_FILE if path is empty, then the environment, with variables from a
+// .env file in the working directory filling in any that are unset.
+func Load(path string) (*Config, error) {
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("reading .env: %v", err)
+	}
+
+	cfg := Default()
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadFile decodes path over cfg, choosing the format by extension
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %v", err)
+	}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, c)
+	case ".toml":
+		err = toml.Unmarshal(data, c)
+	default:
+		return fmt.Errorf("config file %s: unsupported format %q, use .yaml or .toml", path, ext)
+	}
+	if err != nil {
+		return fmt.Errorf("parsing config file %s: %v", path, err)
+	}
+	return nil
+}
+
+// envVar binds one environment variable to a field
+type envVar struct {
+	name string
+	set  func(v string) error
+}
+
+// env lists the environment variable for every setting
+func (c *Config) env() []envVar {
+	return []envVar{
+		{"API_PORT", func(v string) error {
+			if _, err := strconv.ParseUint(v, 10, 16); err != nil {
+				return fmt.Errorf("must be a port number")
+			}
+			c.Server.Addr = ":" + v
+			return nil
+		}},
+		{"CORS_ORIGINS", setList(&c.Server.CORSOrigins)},
+		{"READ_HEADER_TIMEOUT", setDuration(&c.Server.ReadHeaderTimeout)},
+		{"READ_TIMEOUT", setDuration(&c.Server.ReadTimeout)},
+		{"WRITE_TIMEOUT", setDuration(&c.Server.WriteTimeout)},
+		{"IDLE_TIMEOUT", setDuration(&c.Server.IdleTimeout)},
+		{"PRE_STOP_DELAY", setDuration(&c.Server.PreStopDelay)},
+		{"SHUTDOWN_TIMEOUT", setDuration(&c.Server.ShutdownTimeout)},
+		{"ADMIN_ADDR", setString(&c.Admin.Addr)},
+		{"TLS_CERT_FILE", setString(&c.TLS.CertFile)},
+		{"TLS_KEY_FILE", setString(&c.TLS.KeyFile)},
+		{"TLS_CLIENT_CA_FILE", setString(&c.TLS.ClientCAFile)},
+		{"TLS_REDIRECT_ADDR", setString(&c.TLS.RedirectAddr)},
+		{"DATABASE_DRIVER", setString(&c.Database.Driver)},
+		{"SQLITE_DB", setString(&c.Database.Path)},
+		{"DATABASE_DSN", setString(&c.Database.DSN)},
+		{"LOG_FILE", setString(&c.Log.File)},
+		{"LOG_LEVEL", setString(&c.Log.Level)},
+		{"LOG_MAX_SIZE_MB", setInt(&c.Log.MaxSizeMB)},
+		{"LOG_MAX_BACKUPS", setInt(&c.Log.MaxBackups)},
+		{"LOG_REDACT", setString(&c.Log.Redact)},
+		{"LOG_HASH_KEY", setString(&c.Log.HashKey)},
+		{"JWT_KEY_DIR", setString(&c.JWT.KeyDir)},
+		{"JWT_KEY_ROTATION", setDuration(&c.JWT.Rotation)},
+		{"TOTP_SKEW", func(v string) error {
+			n, err := strconv.ParseUint(v, 10, 8)
+			if err != nil {
+				return fmt.Errorf("must be a number")
+			}
+			c.TOTP.Skew = uint(n)
+			return nil
+		}},
+		{"WEBAUTHN_RP_ID", setString(&c.WebAuthn.RPID)},
+		{"WEBAUTHN_RP_ORIGINS", setList(&c.WebAuthn.RPOrigins)},
+		{"RATE_LIMIT_LOGIN_REQUESTS", setInt(&c.RateLimit.Login.Requests)},
+		{"RATE_LIMIT_LOGIN_WINDOW", setSeconds(&c.RateLimit.Login.Window)},
+		{"RATE_LIMIT_SIGNUP_REQUESTS", setInt(&c.RateLimit.SignUp.Requests)},
+		{"RATE_LIMIT_SIGNUP_WINDOW", setSeconds(&c.RateLimit.SignUp.Window)},
+		{"RATE_LIMIT_VERIFY_TOTP_REQUESTS", setInt(&c.RateLimit.VerifyTOTP.Requests)},
+		{"RATE_LIMIT_VERIFY_TOTP_WINDOW", setSeconds(&c.RateLimit.VerifyTOTP.Window)},
+		{"RATE_LIMIT_REFRESH_REQUESTS", setInt(&c.RateLimit.Refresh.Requests)},
+		{"RATE_LIMIT_REFRESH_WINDOW", setSeconds(&c.RateLimit.Refresh.Window)},
+		{"RATE_LIMIT_PASSKEY_REQUESTS", setInt(&c.RateLimit.Passkey.Requests)},
+		{"RATE_LIMIT_PASSKEY_WINDOW", setSeconds(&c.RateLimit.Passkey.Window)},
+		{"RATE_LIMIT_STORE", setString(&c.RateLimit.Store)},
+		{"TRUSTED_PROXIES", setList(&c.RateLimit.TrustedProxies)},
+		{"LOGIN_LOCK_AFTER", setInt(&c.Lockout.After)},
+		{"LOGIN_LOCK_DURATION", setDuration(&c.Lockout.Duration)},
+		{"HASH_WORKERS", setInt(&c.Hash.Workers)},
+		{"HASH_QUEUE", setInt(&c.Hash.Queue)},
+		{"MAIL_DOMAIN", setString(&c.Accounts.Domain)},
+		{"MAX_USERS", setInt(&c.Accounts.MaxUsers)},
+		{"ADMIN_EMAILS", setList(&c.Accounts.Admins)},
+		{"AUDIT_RETENTION", setDuration(&c.Audit.Retention)},
+		{"AUDIT_KEY_FILE", setString(&c.Audit.KeyFile)},
+	}
+}
+
+// loadEnv applies every environment variable that is set and not empty
+func (c *Config) loadEnv() error {
+	var errs []error
+	for _, e := range c.env() {
+		v := strings.TrimSpace(os.Getenv(e.name))
+		if v == "" {
+			continue
+		}
+		if err := e.set(v); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s %q: %v", e.name, v, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func setString(p *string) func(string) error {
+	return func(v string) error {
+		*p = v
+		return nil
+	}
+}
+
+func setInt(p *int) func(string) error {
+	return func(v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("must be a number")
+		}
+		*p = n
+		return nil
+	}
+}
+
+func setDuration(p *time.Duration) func(string) error {
+	return func(v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("must be a duration such as 15m")
+		}
+		*p = d
+		return nil
+	}
+}
+
+// setSeconds reads a whole number of seconds
+func setSeconds(p *time.Duration) func(string) error {
+	return func(v string) error {
+		secs, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("must be a number of seconds")
+		}
+		*p = time.Duration(secs) * time.Second
+		return nil
+	}
+}
+
+// setList splits a comma-separated value, dropping empty entries
+func setList(p *[]string) func(string) error {
+	return func(v string) error {
+		var list []string
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		*p = list
+		return nil
+	}
+}
+
+// Validate reports every invalid setting at once
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Server.Addr != "", "server.addr must be set")
+	for _, origin := range c.Server.CORSOrigins {
+		check(validOrigin(origin), "server.cors_origins: %q is not an http(s) origin", origin)
+	}
+	check(c.Server.ReadHeaderTimeout > 0, "server.read_header_timeout must be positive")
+	check(c.Server.ReadTimeout > 0, "server.read_timeout must be positive")
+	check(c.Server.WriteTimeout > 0, "server.write_timeout must be positive")
+	check(c.Server.IdleTimeout > 0, "server.idle_timeout must be positive")
+	check(c.Server.PreStopDelay >= 0, "server.pre_stop_delay must not be negative")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout must be positive")
+	check(c.Admin.Addr == "" || c.Admin.Addr != c.Server.Addr, "admin.addr must differ from server.addr")
+	check((c.TLS.CertFile == "") == (c.TLS.KeyFile == ""), "tls.cert_file and tls.key_file must be set together")
+	check(c.TLS.Enabled() || c.TLS.ClientCAFile == "", "tls.client_ca_file needs tls.cert_file")
+	check(c.TLS.Enabled() || c.TLS.RedirectAddr == "", "tls.redirect_addr needs tls.cert_file")
+	check(c.TLS.RedirectAddr == "" || (c.TLS.RedirectAddr != c.Server.Addr && c.TLS.RedirectAddr != c.Admin.Addr), "tls.redirect_addr must differ from server.addr and admin.addr")
+	check(c.Database.Driver == "sqlite" || c.Database.Driver == "postgres", "database.driver must be sqlite or postgres")
+	check(c.Database.Driver != "sqlite" || c.Database.Path != "", "database.path must be set for sqlite")
+	check(c.Database.Driver != "postgres" || c.Database.DSN != "", "database.dsn must be set for postgres")
+	_, err := logging.ParseLevel(c.Log.Level)
+	check(err == nil, "log.level must be debug, info, warn or error")
+	check(c.Log.MaxSizeMB >= 1, "log.max_size_mb must be at least 1")
+	check(c.Log.MaxBackups >= 0, "log.max_backups must not be negative")
+	check(logging.ValidRedact(c.Log.Redact), "log.redact must be hash, mask or none")
+	check(c.JWT.KeyDir != "", "jwt.key_dir must be set")
+	check(c.JWT.Rotation > auth.AccessTokenTTL, "jwt.rotation must be longer than the %v access token lifetime", auth.AccessTokenTTL)
+	check(c.TOTP.Skew <= 3, "totp.skew must be 0-3")
+	check(c.WebAuthn.RPID != "", "webauthn.rp_id must be set")
+	check(len(c.WebAuthn.RPOrigins) > 0, "webauthn.rp_origins must list at least one origin")
+	for _, origin := range c.WebAuthn.RPOrigins {
+		check(validOrigin(origin), "webauthn.rp_origins: %q is not an http(s) origin", origin)
+	}
+	policies := c.RateLimit.Policies()
+	names := make([]string, 0, len(policies))
+	for name := range policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		check(policies[name].Requests >= 1, "rate_limit.%s.requests must be at least 1", name)
+		check(policies[name].Window > 0, "rate_limit.%s.window must be positive", name)
+	}
+	check(c.RateLimit.Store == "memory" || c.RateLimit.Store == "database" || c.RateLimit.Store == "sqlite", "rate_limit.store must be memory or database")
+	if _, err := ratelimit.ParseTrustedProxies(c.RateLimit.TrustedProxies); err != nil {
+		errs = append(errs, fmt.Errorf("rate_limit.trusted_proxies: %v", err))
+	}
+	check(c.Lockout.After > auth.DefaultLockout.BackoffAfter, "lockout.after must be above %d", auth.DefaultLockout.BackoffAfter)
+	check(c.Lockout.Duration > 0, "lockout.duration must be positive")
+	check(c.Hash.Workers >= 1, "hash.workers must be at least 1")
+	check(c.Hash.Queue >= 0, "hash.queue must not be negative")
+	check(auth.ValidateDomainName(c.Accounts.Domain), "accounts.domain: %q is not a domain name", c.Accounts.Domain)
+	check(c.Accounts.MaxUsers >= 1, "accounts.max_users must be at least 1")
+	for _, email := range c.Accounts.Admins {
+		check(auth.ValidateAddress(email), "accounts.admins: %q is not an email address", email)
+	}
+	check(c.Audit.Retention >= 0, "audit.retention must not be negative")
+	check(c.Audit.KeyFile != "", "audit.key_file must be set")
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// validOrigin accepts scheme://host[:port] with no path
+func validOrigin(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && (u.Path == "" || u.Path == "/")
+}
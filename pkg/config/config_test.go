@@ -0,0 +1,265 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"secure-email-mvp/pkg/auth"
+)
+
+// clearEnv blanks every variable Load reads so the host environment can't leak in
+func clearEnv(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	for _, e := range Default().env() {
+		t.Setenv(e.name, "")
+	}
+}
+
+func writeFile(t *testing.T, name, data string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal("Failed to write config file:", err)
+	}
+	return path
+}
+
+func TestDefaultValid(t *testing.T) {
+	clearEnv(t)
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatal("Expected defaults to be valid, got", err)
+	}
+	if !reflect.DeepEqual(cfg, Default()) {
+		t.Errorf("Expected defaults with nothing set, got %+v", cfg)
+	}
+}
+
+func TestLoadYAML(t *testing.T) {
+	clearEnv(t)
+	path := writeFile(t, "api.yaml", `
+server:
+  addr: 127.0.0.1:9000
+  cors_origins: [https://mail.example.org]
+jwt:
+  rotation: 48h
+rate_limit:
+  login:
+    requests: 5
+    window: 30s
+  trusted_proxies: [10.0.0.0/8]
+accounts:
+  domain: example.org
+  max_users: 500
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal("Load failed:", err)
+	}
+	if cfg.Server.Addr != "127.0.0.1:9000" || !reflect.DeepEqual(cfg.Server.CORSOrigins, []string{"https://mail.example.org"}) {
+		t.Errorf("Unexpected server config %+v", cfg.Server)
+	}
+	if cfg.JWT.Rotation != 48*time.Hour || cfg.RateLimit.Login != (RatePolicy{Requests: 5, Window: 30 * time.Second}) {
+		t.Errorf("Expected durations and numbers decoded, got %+v %+v", cfg.JWT, cfg.RateLimit)
+	}
+	if cfg.Accounts.Domain != "example.org" || cfg.Accounts.MaxUsers != 500 {
+		t.Errorf("Unexpected accounts config %+v", cfg.Accounts)
+	}
+
+	// Unset keys keep their defaults
+	if cfg.Database.Path != Default().Database.Path {
+		t.Errorf("Expected default database path, got %q", cfg.Database.Path)
+	}
+	if cfg.RateLimit.Refresh != Default().RateLimit.Refresh {
+		t.Errorf("Expected the refresh policy unchanged by the login one, got %+v", cfg.RateLimit.Refresh)
+	}
+}
+
+func TestLoadTOML(t *testing.T) {
+	clearEnv(t)
+	path := writeFile(t, "api.toml", `
+[database]
+path = "/tmp/api.db"
+
+[lockout]
+after = 8
+duration = "1h"
+
+[hash]
+workers = 4
+
+[audit]
+retention = "720h"
+key_file = "/tmp/audit.key"
+`)
+	t.Setenv("CONFIG_FILE", path)
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatal("Load failed:", err)
+	}
+	if cfg.Database.Path != "/tmp/api.db" || cfg.Lockout.After != 8 || cfg.Lockout.Duration != time.Hour || cfg.Hash.Workers != 4 {
+		t.Errorf("Unexpected config %+v %+v %+v", cfg.Database, cfg.Lockout, cfg.Hash)
+	}
+	if cfg.Audit.Retention != 30*24*time.Hour || cfg.Audit.KeyFile != "/tmp/audit.key" {
+		t.Errorf("Expected 30 day audit retention and key file, got %+v", cfg.Audit)
+	}
+}
+
+func TestEnvOverridesFile(t *testing.T) {
+	clearEnv(t)
+	path := writeFile(t, "api.yaml", "server:\n  addr: :9000\naccounts:\n  max_users: 500\n")
+	t.Setenv("API_PORT", "8443")
+	t.Setenv("MAX_USERS", "50")
+	t.Setenv("RATE_LIMIT_REFRESH_WINDOW", "120")
+	t.Setenv("PRE_STOP_DELAY", "0s")
+	t.Setenv("WEBAUTHN_RP_ORIGINS", "https://a.example.org, https://b.example.org,")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal("Load failed:", err)
+	}
+	if cfg.Server.Addr != ":8443" || cfg.Accounts.MaxUsers != 50 {
+		t.Errorf("Expected environment to win, got addr %q max users %d", cfg.Server.Addr, cfg.Accounts.MaxUsers)
+	}
+	if cfg.RateLimit.Refresh.Window != 2*time.Minute || cfg.RateLimit.Login.Window != time.Minute {
+		t.Errorf("Expected RATE_LIMIT_REFRESH_WINDOW in seconds for refresh alone, got %+v", cfg.RateLimit)
+	}
+	if cfg.Server.PreStopDelay != 0 {
+		t.Errorf("Expected PRE_STOP_DELAY to turn the delay off, got %v", cfg.Server.PreStopDelay)
+	}
+	if want := []string{"https://a.example.org", "https://b.example.org"}; !reflect.DeepEqual(cfg.WebAuthn.RPOrigins, want) {
+		t.Errorf("Expected origins %v, got %v", want, cfg.WebAuthn.RPOrigins)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	clearEnv(t)
+
+	// Every bad value is reported, not just the first
+	t.Setenv("MAX_USERS", "lots")
+	t.Setenv("LOGIN_LOCK_DURATION", "forever")
+	_, err := Load("")
+	if err == nil || !strings.Contains(err.Error(), "MAX_USERS") || !strings.Contains(err.Error(), "LOGIN_LOCK_DURATION") {
+		t.Errorf("Expected both variables reported, got %v", err)
+	}
+
+	clearEnv(t)
+	if _, err := Load(writeFile(t, "api.json", "{}")); err == nil || !strings.Contains(err.Error(), "unsupported format") {
+		t.Errorf("Expected unsupported format error, got %v", err)
+	}
+	if _, err := Load(writeFile(t, "api.yaml", "server: [")); err == nil || !strings.Contains(err.Error(), "parsing config file") {
+		t.Errorf("Expected parse error, got %v", err)
+	}
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("Expected error for missing config file")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cfg := Default()
+	cfg.Server.CORSOrigins = []string{"https://ok.example.org", "ftp://bad"}
+	cfg.Server.WriteTimeout = 0
+	cfg.Server.PreStopDelay = -time.Second
+	cfg.Admin.Addr = cfg.Server.Addr
+	cfg.TLS.KeyFile = "/etc/tls/key.pem"
+	cfg.TLS.RedirectAddr = ":80"
+	cfg.Log.Level = "verbose"
+	cfg.Log.Redact = "scramble"
+	cfg.JWT.Rotation = time.Minute
+	cfg.TOTP.Skew = 5
+	cfg.RateLimit.SignUp.Requests = 0
+	cfg.RateLimit.Refresh.Window = 0
+	cfg.RateLimit.Store = "redis"
+	cfg.RateLimit.TrustedProxies = []string{"not-an-ip"}
+	cfg.Lockout.After = 1
+	cfg.Hash.Workers = 0
+	cfg.Accounts.Domain = "-bad-.example"
+	cfg.Accounts.MaxUsers = 0
+	cfg.Audit.Retention = -time.Hour
+	cfg.Audit.KeyFile = ""
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Expected validation errors")
+	}
+	for _, want := range []string{
+		`"ftp://bad"`,
+		"server.write_timeout",
+		"server.pre_stop_delay",
+		"admin.addr",
+		"tls.cert_file and tls.key_file",
+		"tls.redirect_addr needs",
+		"log.level",
+		"log.redact",
+		"jwt.rotation",
+		"totp.skew",
+		"rate_limit.signup.requests",
+		"rate_limit.refresh.window",
+		"rate_limit.store",
+		"rate_limit.trusted_proxies",
+		"lockout.after",
+		"hash.workers",
+		"accounts.domain",
+		"accounts.max_users",
+		"audit.retention",
+		"audit.key_file",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected %s in error, got %v", want, err)
+		}
+	}
+	if strings.Contains(err.Error(), "ok.example.org") {
+		t.Errorf("Expected valid origin not reported, got %v", err)
+	}
+}
+
+func TestDatabaseDriver(t *testing.T) {
+	cfg := Default()
+	if cfg.Database.Source() != cfg.Database.Path {
+		t.Errorf("Expected SQLite to open the path, got %q", cfg.Database.Source())
+	}
+	cfg.Database.Driver = "postgres"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "database.dsn") {
+		t.Errorf("Expected postgres without a DSN refused, got %v", err)
+	}
+	cfg.Database.DSN = "postgres://sem@localhost/sem"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected postgres with a DSN valid, got %v", err)
+	}
+	if cfg.Database.Source() != cfg.Database.DSN {
+		t.Errorf("Expected PostgreSQL to open the DSN, got %q", cfg.Database.Source())
+	}
+	cfg.Database.Driver = "mysql"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "database.driver") {
+		t.Errorf("Expected unknown driver refused, got %v", err)
+	}
+}
+
+func TestAccountsDomain(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("MAIL_DOMAIN", "Example.org")
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatal("Load failed:", err)
+	}
+	if !auth.ValidateEmailDomain("user@example.org", cfg.Accounts.Domain) {
+		t.Error("Expected address at configured domain accepted")
+	}
+	if auth.ValidateEmailDomain("user@"+auth.DefaultEmailDomain, cfg.Accounts.Domain) {
+		t.Error("Expected address at default domain rejected")
+	}
+}
+
+func TestExampleFile(t *testing.T) {
+	clearEnv(t)
+	cfg, err := Load("../../config.example.yaml")
+	if err != nil {
+		t.Fatal("Example config failed to load:", err)
+	}
+	if !reflect.DeepEqual(cfg, Default()) {
+		t.Errorf("Expected example config to match the defaults, got %+v", cfg)
+	}
+}
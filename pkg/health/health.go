@@ -0,0 +1,149 @@
+// Package health answers liveness and readiness probes. Liveness only says
+// the process is serving HTTP; readiness runs named checks, such as a
+// database ping, and fails once the server starts draining for shutdown.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Readiness statuses
+const (
+	StatusReady    = "ready"
+	StatusNotReady = "not_ready"
+	StatusDraining = "draining"
+)
+
+// Check is one readiness condition. Run returns a short description of what
+// it found, such as a schema version, or an error if the API cannot serve.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) (detail string, err error)
+}
+
+// Result is the outcome of one Check
+type Result struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Report is the outcome of every check
+type Report struct {
+	Status string   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+// Ready reports whether the API should receive traffic
+func (r Report) Ready() bool {
+	return r.Status == StatusReady
+}
+
+// Checker runs readiness checks
+type Checker struct {
+	checks   []Check
+	timeout  time.Duration
+	draining atomic.Bool
+}
+
+// New returns a Checker that gives each check up to timeout
+func New(timeout time.Duration, checks ...Check) *Checker {
+	return &Checker{checks: checks, timeout: timeout}
+}
+
+// Drain marks the server as shutting down, so readiness fails while
+// in-flight requests finish
+func (c *Checker) Drain() {
+	c.draining.Store(true)
+}
+
+// Run runs every check concurrently and reports them in registration order
+func (c *Checker) Run(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	results := make([]Result, len(c.checks))
+	var wg sync.WaitGroup
+	for i, check := range c.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = run(ctx, check)
+		}()
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusReady, Checks: results}
+	for _, r := range results {
+		if !r.OK {
+			report.Status = StatusNotReady
+		}
+	}
+	if c.draining.Load() {
+		report.Status = StatusDraining
+	}
+	return report
+}
+
+// run runs one check, giving up when ctx expires even if the check ignores it
+func run(ctx context.Context, check Check) Result {
+	type outcome struct {
+		detail string
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		detail, err := check.Run(ctx)
+		done <- outcome{detail, err}
+	}()
+
+	result := Result{Name: check.Name}
+	select {
+	case o := <-done:
+		result.OK = o.err == nil
+		result.Detail = o.detail
+		if o.err != nil {
+			result.Error = o.err.Error()
+		}
+	case <-ctx.Done():
+		result.Error = ctx.Err().Error()
+	}
+	return result
+}
+
+// LiveHandler answers liveness probes with 200 whenever the process can
+// serve HTTP at all
+func LiveHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte(`{"status":"ok"}` + "\n"))
+}
+
+// ReadyHandler answers readiness probes with 200 when every check passes and
+// 503 otherwise. Unless verbose, details and errors are left out so a public
+// probe reveals nothing about the deployment.
+func (c *Checker) ReadyHandler(verbose bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := c.Run(r.Context())
+		if !verbose {
+			for i := range report.Checks {
+				report.Checks[i].Detail = ""
+				report.Checks[i].Error = ""
+			}
+		}
+		code := http.StatusOK
+		if !report.Ready() {
+			code = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(report)
+	}
+}
@@ -0,0 +1,78 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func passing(name, detail string) Check {
+	return Check{Name: name, Run: func(ctx context.Context) (string, error) { return detail, nil }}
+}
+
+func TestRun(t *testing.T) {
+	slow := Check{Name: "slow", Run: func(ctx context.Context) (string, error) {
+		time.Sleep(time.Second) // Ignores ctx; the checker must not wait for it
+		return "", nil
+	}}
+	failing := Check{Name: "keys", Run: func(ctx context.Context) (string, error) { return "", errors.New("no active key") }}
+
+	report := New(time.Second, passing("db", ""), passing("migrations", "version 14")).Run(context.Background())
+	if !report.Ready() || len(report.Checks) != 2 || report.Checks[1].Detail != "version 14" {
+		t.Errorf("Expected ready report in check order, got %+v", report)
+	}
+
+	report = New(time.Second, passing("db", ""), failing).Run(context.Background())
+	if report.Status != StatusNotReady || report.Checks[1].OK || report.Checks[1].Error != "no active key" {
+		t.Errorf("Expected failing check reported, got %+v", report)
+	}
+
+	start := time.Now()
+	report = New(50*time.Millisecond, slow).Run(context.Background())
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected slow check abandoned at the timeout, took %v", elapsed)
+	}
+	if report.Ready() || report.Checks[0].Error != context.DeadlineExceeded.Error() {
+		t.Errorf("Expected timed out check to fail, got %+v", report)
+	}
+}
+
+func TestReadyHandler(t *testing.T) {
+	c := New(time.Second, passing("db", "sqlite"))
+	get := func(verbose bool) (int, Report) {
+		rr := httptest.NewRecorder()
+		c.ReadyHandler(verbose)(rr, httptest.NewRequest("GET", "/readyz", nil))
+		var report Report
+		if err := json.NewDecoder(rr.Body).Decode(&report); err != nil {
+			t.Fatal("Decoding readiness failed:", err)
+		}
+		return rr.Code, report
+	}
+
+	code, report := get(true)
+	if code != http.StatusOK || report.Status != StatusReady || report.Checks[0].Detail != "sqlite" {
+		t.Errorf("Expected 200 with details, got %d %+v", code, report)
+	}
+	if _, report := get(false); report.Checks[0].Detail != "" || !report.Checks[0].OK {
+		t.Errorf("Expected public report without details, got %+v", report)
+	}
+
+	// Draining fails readiness even though every check passes
+	c.Drain()
+	code, report = get(false)
+	if code != http.StatusServiceUnavailable || report.Status != StatusDraining {
+		t.Errorf("Expected 503 draining, got %d %+v", code, report)
+	}
+}
+
+func TestLiveHandler(t *testing.T) {
+	rr := httptest.NewRecorder()
+	LiveHandler(rr, httptest.NewRequest("GET", "/healthz", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "{\"status\":\"ok\"}\n" {
+		t.Errorf("Expected 200 ok, got %d %q", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,154 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"secure-email-mvp/pkg/requestinfo"
+
+	"github.com/gorilla/mux"
+)
+
+func newTestLogger(t *testing.T, redact string) (*slog.Logger, *bytes.Buffer) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, Options{Redact: redact, HashKey: []byte("test-key")})
+	if err != nil {
+		t.Fatal("New failed:", err)
+	}
+	return logger, &buf
+}
+
+// lines decodes each JSON log line
+func lines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var out []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var m map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("Expected JSON log line, got %q", line)
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+func TestRedaction(t *testing.T) {
+	tests := []struct {
+		redact, email, ip string
+	}{
+		{RedactNone, "Alice@example.org", "203.0.113.7"},
+		{RedactMask, "A***@example.org", "203.0.113.0"},
+	}
+	for _, tt := range tests {
+		logger, buf := newTestLogger(t, tt.redact)
+		logger.Info("Login failed", "email", "Alice@example.org", "ip", "203.0.113.7", "user_id", "user-1")
+		line := lines(t, buf)[0]
+		if line["email"] != tt.email || line["ip"] != tt.ip || line["user_id"] != "user-1" {
+			t.Errorf("%s: unexpected line %v", tt.redact, line)
+		}
+	}
+
+	// Hashes hide the address but match for the same person in any case
+	logger, buf := newTestLogger(t, RedactHash)
+	logger.Info("a", "email", "Alice@example.org", "ip", "2001:db8::1")
+	logger.Info("b", "email", "alice@example.org")
+	got := lines(t, buf)
+	email := got[0]["email"].(string)
+	if !strings.HasPrefix(email, "h:") || !strings.HasSuffix(email, "@example.org") || strings.Contains(email, "alice") {
+		t.Errorf("Expected hashed local part, got %q", email)
+	}
+	if got[1]["email"] != email {
+		t.Errorf("Expected same hash for same address, got %q and %q", email, got[1]["email"])
+	}
+	if ip := got[0]["ip"].(string); !strings.HasPrefix(ip, "h:") {
+		t.Errorf("Expected hashed IP, got %q", ip)
+	}
+
+	r, _ := newRedactor(RedactMask, nil)
+	if got := r.IP("2001:db8:1:2::1"); got != "2001:db8:1::" {
+		t.Errorf("Expected IPv6 masked to /48, got %q", got)
+	}
+	if got := r.Email("@example.org"); got != "***@example.org" {
+		t.Errorf("Expected empty local part masked, got %q", got)
+	}
+	if _, err := New(&bytes.Buffer{}, Options{Redact: "scramble"}); err == nil {
+		t.Error("Expected unknown redaction rejected")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	logger, buf := newTestLogger(t, RedactMask)
+	r := mux.NewRouter()
+	r.Use(requestinfo.Route)
+	r.HandleFunc("/api/sessions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		SetUserID(r.Context(), "user-1")
+		FromContext(r.Context()).Warn("Handler line", "email", "bob@example.org")
+		w.WriteHeader(http.StatusNoContent)
+	})
+	handler := Middleware(logger, func(*http.Request) string { return "198.51.100.9" })(r)
+
+	req := httptest.NewRequest("DELETE", "/api/sessions/abc", nil)
+	req.Header.Set(RequestIDHeader, "edge-1234")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Header().Get(RequestIDHeader) != "edge-1234" {
+		t.Errorf("Expected caller's request ID echoed, got %q", rr.Header().Get(RequestIDHeader))
+	}
+
+	got := lines(t, buf)
+	if len(got) != 2 {
+		t.Fatalf("Expected handler and access lines, got %d", len(got))
+	}
+	if got[0]["request_id"] != "edge-1234" || got[0]["email"] != "b***@example.org" {
+		t.Errorf("Unexpected handler line %v", got[0])
+	}
+	access := got[1]
+	if access["msg"] != "request" || access["request_id"] != "edge-1234" || access["route"] != "/api/sessions/{id}" ||
+		access["status"] != float64(204) || access["user_id"] != "user-1" || access["ip"] != "198.51.100.0" {
+		t.Errorf("Unexpected access line %v", access)
+	}
+	if _, ok := access["latency_ms"]; !ok {
+		t.Error("Expected latency_ms in access line")
+	}
+
+	// Unusable IDs are replaced; unmatched requests log their path at error level on 5xx
+	buf.Reset()
+	req = httptest.NewRequest("GET", "/missing", nil)
+	req.Header.Set(RequestIDHeader, "bad id\n{}")
+	rr = httptest.NewRecorder()
+	Middleware(logger, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	})).ServeHTTP(rr, req)
+	id := rr.Header().Get(RequestIDHeader)
+	if id == "" || strings.Contains(id, " ") {
+		t.Errorf("Expected a generated request ID, got %q", id)
+	}
+	access = lines(t, buf)[0]
+	if access["request_id"] != id || access["path"] != "/missing" || access["level"] != "ERROR" {
+		t.Errorf("Unexpected access line %v", access)
+	}
+}
+
+func TestOpenFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api.log")
+	out, err := Open(Options{File: path, MaxSizeMB: 1, MaxBackups: 2})
+	if err != nil {
+		t.Fatal("Open failed:", err)
+	}
+	logger, _ := New(out, Options{Redact: RedactNone})
+	logger.Info("started")
+	out.Close()
+	if data, _ := os.ReadFile(path); !bytes.Contains(data, []byte(`"msg":"started"`)) {
+		t.Errorf("Expected line in log file, got %q", data)
+	}
+
+	if _, err := Open(Options{File: filepath.Join(t.TempDir(), "missing", "api.log")}); err == nil {
+		t.Error("Expected unwritable log file rejected at startup")
+	}
+}
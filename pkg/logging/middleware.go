@@ -0,0 +1,115 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"regexp"
+	"time"
+
+	"secure-email-mvp/pkg/requestinfo"
+
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader carries the request ID in from proxies and back to clients
+const RequestIDHeader = "X-Request-ID"
+
+// validRequestID limits what is accepted from callers, so the ID is safe to
+// log and echo back
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+type contextKey struct{}
+
+// requestLog collects fields that inner handlers learn, such as the signed-in
+// user, for the access log line
+type requestLog struct {
+	logger *slog.Logger
+	id     string
+	userID string
+}
+
+func fromContext(ctx context.Context) *requestLog {
+	rl, _ := ctx.Value(contextKey{}).(*requestLog)
+	return rl
+}
+
+// FromContext returns the request's logger, which adds its request ID to
+// every line, or the default logger outside a request
+func FromContext(ctx context.Context) *slog.Logger {
+	if rl := fromContext(ctx); rl != nil {
+		return rl.logger
+	}
+	return slog.Default()
+}
+
+// RequestID returns the request's ID, or "" outside Middleware
+func RequestID(ctx context.Context) string {
+	if rl := fromContext(ctx); rl != nil {
+		return rl.id
+	}
+	return ""
+}
+
+// SetUserID records the authenticated user for the request's access log line
+func SetUserID(ctx context.Context, userID string) {
+	if rl := fromContext(ctx); rl != nil {
+		rl.userID = userID
+	}
+}
+
+// Middleware gives every request an ID, taken from X-Request-ID when the
+// caller sent a usable one, echoes it in the response and logs one line per
+// request with its route, status, latency and user. The router must use
+// requestinfo.Route for the route to be logged. clientIP finds the caller's
+// address; nil uses the connection's.
+func Middleware(logger *slog.Logger, clientIP func(*http.Request) string) func(http.Handler) http.Handler {
+	if clientIP == nil {
+		clientIP = remoteIP
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			id := r.Header.Get(RequestIDHeader)
+			if !validRequestID.MatchString(id) {
+				id = uuid.New().String()
+			}
+			w.Header().Set(RequestIDHeader, id)
+
+			rl := &requestLog{logger: logger.With("request_id", id), id: id}
+			info, w, r := requestinfo.Track(w, r)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, rl)))
+
+			status := info.Code()
+			attrs := []slog.Attr{
+				slog.String("method", r.Method),
+				slog.Int("status", status),
+				slog.Int("bytes", info.Bytes),
+				slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+				slog.String("ip", clientIP(r)),
+			}
+			if info.Route != "" {
+				attrs = append(attrs, slog.String("route", info.Route))
+			} else {
+				attrs = append(attrs, slog.String("path", r.URL.Path))
+			}
+			if rl.userID != "" {
+				attrs = append(attrs, slog.String("user_id", rl.userID))
+			}
+			level := slog.LevelInfo
+			if status >= 500 {
+				level = slog.LevelError
+			}
+			rl.logger.LogAttrs(r.Context(), level, "request", attrs...)
+		})
+	}
+}
+
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
@@ -0,0 +1,35 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"secure-email-mvp/pkg/requestinfo"
+)
+
+// unmatchedRoute labels requests no route matched, so stray paths cannot
+// create new series
+const unmatchedRoute = "unmatched"
+
+func routeLabel(route string) string {
+	if route == "" {
+		return unmatchedRoute
+	}
+	return route
+}
+
+// Middleware counts and times every request by route template, method and
+// status. The router must use requestinfo.Route for requests to be labelled
+// by route.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info, w, r := requestinfo.Track(w, r)
+		next.ServeHTTP(w, r)
+
+		labels := []string{routeLabel(info.Route), r.Method, strconv.Itoa(info.Code())}
+		httpRequests.WithLabelValues(labels...).Inc()
+		httpDuration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+	})
+}
@@ -0,0 +1,104 @@
+// Package metrics collects the API's Prometheus metrics: HTTP requests by
+// route, authentication outcomes, password hashing time and rate limiting.
+// Everything is registered on Registry, which Handler serves.
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "secure_email"
+
+// Registry holds every metric the API exports, plus Go runtime and process metrics
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route template, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route template, method and status code.",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"route", "method", "code"})
+
+	authAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "auth_attempts_total",
+		Help:      "Login attempts by action and result: success or why they failed.",
+	}, []string{"action", "result"})
+
+	passwordHashDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "password_hash_duration_seconds",
+		Help:      "Time spent computing Argon2 hashes, excluding time queued for a worker.",
+		Buckets:   []float64{.01, .025, .05, .1, .2, .4, .8, 1.6, 3.2},
+	}, []string{"op"})
+
+	rateLimitRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ratelimit_rejections_total",
+		Help:      "Requests refused with 429 by rate limit policy and route template.",
+	}, []string{"policy", "route"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests, httpDuration, authAttempts, passwordHashDuration, rateLimitRejections,
+	)
+}
+
+// Handler serves Registry in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// AuthAttempt counts a login attempt. result is "success" or a short failure
+// reason such as "bad_password" or "unknown_user".
+func AuthAttempt(action, result string) {
+	authAttempts.WithLabelValues(action, result).Inc()
+}
+
+// ObservePasswordHash records how long one Argon2 hash or verification took
+func ObservePasswordHash(op string, d time.Duration) {
+	passwordHashDuration.WithLabelValues(op).Observe(d.Seconds())
+}
+
+// RateLimited counts r as refused by policy, labelled with its mux route
+// template when the limit runs after routing
+func RateLimited(policy string, r *http.Request) {
+	var template string
+	if route := mux.CurrentRoute(r); route != nil {
+		template, _ = route.GetPathTemplate()
+	}
+	rateLimitRejections.WithLabelValues(policy, routeLabel(template)).Inc()
+}
+
+// RegisterDB exports db's connection pool statistics labelled with name
+func RegisterDB(db *sql.DB, name string) error {
+	return Registry.Register(collectors.NewDBStatsCollector(db, name))
+}
+
+// GaugeFunc exports the value f returns at each scrape as a gauge
+func GaugeFunc(name, help string, f func() float64) error {
+	return Registry.Register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{Namespace: namespace, Name: name, Help: help}, f))
+}
+
+// CounterFunc exports the value f returns at each scrape as a counter; f
+// must never decrease
+func CounterFunc(name, help string, f func() float64) error {
+	return Registry.Register(prometheus.NewCounterFunc(prometheus.CounterOpts{Namespace: namespace, Name: name, Help: help}, f))
+}
@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"secure-email-mvp/pkg/requestinfo"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMiddleware(t *testing.T) {
+	r := mux.NewRouter()
+	r.Use(requestinfo.Route)
+	r.HandleFunc("/api/sessions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	handler := Middleware(r)
+
+	for _, path := range []string{"/api/sessions/a", "/api/sessions/b", "/missing/1", "/missing/2"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("DELETE", path, nil))
+	}
+
+	// Requests are grouped by route template; stray paths share one series
+	if got := testutil.ToFloat64(httpRequests.WithLabelValues("/api/sessions/{id}", "DELETE", "204")); got != 2 {
+		t.Errorf("Expected 2 requests for the route, got %v", got)
+	}
+	if got := testutil.ToFloat64(httpRequests.WithLabelValues(unmatchedRoute, "DELETE", "404")); got != 2 {
+		t.Errorf("Expected 2 unmatched requests, got %v", got)
+	}
+	if n := testutil.CollectAndCount(httpDuration); n < 2 {
+		t.Errorf("Expected latency series for both routes, got %d", n)
+	}
+}
+
+func TestRateLimited(t *testing.T) {
+	r := mux.NewRouter()
+	r.HandleFunc("/api/auth/login", func(w http.ResponseWriter, r *http.Request) {
+		RateLimited("auth", r)
+	})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/auth/login", nil))
+	RateLimited("ip", httptest.NewRequest("GET", "/", nil))
+
+	if got := testutil.ToFloat64(rateLimitRejections.WithLabelValues("auth", "/api/auth/login")); got != 1 {
+		t.Errorf("Expected login rejection counted by route, got %v", got)
+	}
+	if got := testutil.ToFloat64(rateLimitRejections.WithLabelValues("ip", unmatchedRoute)); got != 1 {
+		t.Errorf("Expected rejection before routing counted as unmatched, got %v", got)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	AuthAttempt("login", "bad_totp")
+	ObservePasswordHash("verify", 50*time.Millisecond)
+	if err := GaugeFunc("test_gauge", "Test gauge.", func() float64 { return 7 }); err != nil {
+		t.Fatal("GaugeFunc failed:", err)
+	}
+	if err := GaugeFunc("test_gauge", "Test gauge.", func() float64 { return 7 }); err == nil {
+		t.Error("Expected duplicate registration rejected")
+	}
+
+	rr := httptest.NewRecorder()
+	Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rr.Body)
+	for _, want := range []string{
+		`secure_email_auth_attempts_total{action="login",result="bad_totp"} 1`,
+		`secure_email_password_hash_duration_seconds_count{op="verify"} 1`,
+		`secure_email_test_gauge 7`,
+		`go_goroutines`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("Expected %s in metrics output", want)
+		}
+	}
+}
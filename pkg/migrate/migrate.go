@@ -0,0 +1,355 @@
+// Package migrate applies versioned SQL migrations and records which versions
+// a database has seen in schema_migrations. The API's SQLite migrations are
+// embedded here; other databases bring their own Source.
+package migrate
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFS embed.FS
+
+// ErrDatabaseAhead is returned when the database has migrations this binary does not know about
+var ErrDatabaseAhead = errors.New("database schema is newer than this binary")
+
+// Migration is one versioned schema change. Files are named
+// NNNN_description.up.sql and NNNN_description.down.sql.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus reports whether a migration has been applied
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Source is a directory of migrations and the SQL its database speaks
+type Source struct {
+	FS  fs.FS
+	Dir string
+	// Rebind rewrites the ? placeholders in migrate's own queries; nil
+	// keeps them as they are
+	Rebind func(query string) string
+	// HasTable counts the tables in the current schema named by its one
+	// placeholder
+	HasTable string
+}
+
+// SQLite is the embedded migrations for the API's SQLite database
+var SQLite = Source{
+	FS:       migrationFS,
+	Dir:      "migrations",
+	HasTable: "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?",
+}
+
+// Migrations returns the embedded SQLite migrations ordered by version
+func Migrations() ([]Migration, error) {
+	return SQLite.Migrations()
+}
+
+// Migrations returns the source's migrations ordered by version
+func (s Source) Migrations() ([]Migration, error) {
+	return load(s.FS, s.Dir)
+}
+
+func (s Source) rebind(query string) string {
+	if s.Rebind == nil {
+		return query
+	}
+	return s.Rebind(query)
+}
+
+func load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %v", err)
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		name := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		prefix, desc, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("invalid migration file name %s", name)
+		}
+		version, err := strconv.Atoi(prefix)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration version in %s", name)
+		}
+
+		body, err := fs.ReadFile(fsys, path.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", name, err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: desc}
+			byVersion[version] = m
+		} else if m.Name != desc {
+			return nil, fmt.Errorf("conflicting names for migration %d: %s and %s", version, m.Name, desc)
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d has no up script", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Up applies every pending SQLite migration; see Source.Up
+func Up(db *sql.DB) (int, error) {
+	return SQLite.Up(db)
+}
+
+// Down rolls back the most recent steps applied SQLite migrations
+func Down(db *sql.DB, steps int) (int, error) {
+	return SQLite.Down(db, steps)
+}
+
+// Status lists every SQLite migration and whether it has been applied
+func Status(db *sql.DB) ([]MigrationStatus, error) {
+	return SQLite.Status(db)
+}
+
+// Check reports whether db is at the embedded SQLite version; see Source.Check
+func Check(db *sql.DB) error {
+	return SQLite.Check(db)
+}
+
+// Up applies every pending migration in order, each in its own transaction,
+// and returns how many were applied. It refuses to run when the database is
+// ahead of the binary.
+func (s Source) Up(db *sql.DB) (int, error) {
+	migrations, err := s.Migrations()
+	if err != nil {
+		return 0, err
+	}
+	return s.up(db, migrations)
+}
+
+// Down rolls back the most recent steps applied migrations
+func (s Source) Down(db *sql.DB, steps int) (int, error) {
+	migrations, err := s.Migrations()
+	if err != nil {
+		return 0, err
+	}
+	return s.down(db, migrations, steps)
+}
+
+// Status lists every known migration and whether it has been applied
+func (s Source) Status(db *sql.DB) ([]MigrationStatus, error) {
+	migrations, err := s.Migrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := s.appliedVersions(db)
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		at, ok := applied[m.Version]
+		status = append(status, MigrationStatus{Version: m.Version, Name: m.Name, Applied: ok, AppliedAt: at})
+	}
+	return status, nil
+}
+
+// Check returns ErrDatabaseAhead if the database has versions the binary does
+// not know about, or an error listing pending migrations if it is behind
+func (s Source) Check(db *sql.DB) error {
+	migrations, err := s.Migrations()
+	if err != nil {
+		return err
+	}
+	applied, err := s.appliedVersions(db)
+	if err != nil {
+		return err
+	}
+	if err := checkAhead(migrations, applied); err != nil {
+		return err
+	}
+	var pending []string
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; !ok {
+			pending = append(pending, strconv.Itoa(m.Version))
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("pending migrations: %s", strings.Join(pending, ", "))
+	}
+	return nil
+}
+
+func (s Source) up(db *sql.DB, migrations []Migration) (int, error) {
+	if err := ensureTable(db); err != nil {
+		return 0, err
+	}
+	applied, err := s.appliedVersions(db)
+	if err != nil {
+		return 0, err
+	}
+	if err := checkAhead(migrations, applied); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		err := inTx(db, func(tx *sql.Tx) error {
+			if _, err := tx.Exec(m.Up); err != nil {
+				return err
+			}
+			_, err := tx.Exec(s.rebind("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)"),
+				m.Version, m.Name, time.Now().Unix())
+			return err
+		})
+		if err != nil {
+			return count, fmt.Errorf("migration %d (%s) failed: %v", m.Version, m.Name, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+func (s Source) down(db *sql.DB, migrations []Migration, steps int) (int, error) {
+	if err := ensureTable(db); err != nil {
+		return 0, err
+	}
+	applied, err := s.appliedVersions(db)
+	if err != nil {
+		return 0, err
+	}
+	if err := checkAhead(migrations, applied); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i := len(migrations) - 1; i >= 0 && count < steps; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if m.Down == "" {
+			return count, fmt.Errorf("migration %d (%s) has no down script", m.Version, m.Name)
+		}
+		err := inTx(db, func(tx *sql.Tx) error {
+			if _, err := tx.Exec(m.Down); err != nil {
+				return err
+			}
+			_, err := tx.Exec(s.rebind("DELETE FROM schema_migrations WHERE version = ?"), m.Version)
+			return err
+		})
+		if err != nil {
+			return count, fmt.Errorf("rollback of migration %d (%s) failed: %v", m.Version, m.Name, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+func ensureTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at BIGINT NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %v", err)
+	}
+	return nil
+}
+
+// appliedVersions returns applied versions with their timestamps. A database
+// that has never been migrated has no versions.
+func (s Source) appliedVersions(db *sql.DB) (map[int]time.Time, error) {
+	var exists int
+	err := db.QueryRow(s.rebind(s.HasTable), "schema_migrations").Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	applied := map[int]time.Time{}
+	if exists == 0 {
+		return applied, nil
+	}
+
+	rows, err := db.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		var at int64
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, fmt.Errorf("database error: %v", err)
+		}
+		applied[version] = time.Unix(at, 0)
+	}
+	return applied, rows.Err()
+}
+
+func checkAhead(migrations []Migration, applied map[int]time.Time) error {
+	known := map[int]bool{}
+	for _, m := range migrations {
+		known[m.Version] = true
+	}
+	for version := range applied {
+		if !known[version] {
+			return fmt.Errorf("%w: version %d is not embedded", ErrDatabaseAhead, version)
+		}
+	}
+	return nil
+}
+
+func inTx(db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,142 @@
+package migrate
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal("Failed to open database:", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func tableExists(t *testing.T, db *sql.DB, name string) bool {
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&n); err != nil {
+		t.Fatal("Failed to query sqlite_master:", err)
+	}
+	return n > 0
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatalf("Migrations failed: %v", err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("Expected embedded migrations")
+	}
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("Expected contiguous versions, got %d at position %d", m.Version, i)
+		}
+		if m.Down == "" {
+			t.Errorf("Migration %d has no down script", m.Version)
+		}
+	}
+}
+
+func TestUpDownStatus(t *testing.T) {
+	db := openTestDB(t)
+
+	n, err := Up(db)
+	if err != nil {
+		t.Fatalf("Up failed: %v", err)
+	}
+	migrations, _ := Migrations()
+	if n != len(migrations) {
+		t.Errorf("Expected %d migrations applied, got %d", len(migrations), n)
+	}
+	for _, table := range []string{"users", "temp_totp", "emails", "folders", "access_attempts"} {
+		if !tableExists(t, db, table) {
+			t.Errorf("Expected table %s after Up", table)
+		}
+	}
+	if err := Check(db); err != nil {
+		t.Errorf("Check after Up failed: %v", err)
+	}
+
+	// Running again is a no-op
+	if n, err := Up(db); err != nil || n != 0 {
+		t.Errorf("Expected second Up to apply nothing, got %d, %v", n, err)
+	}
+
+	n, err = Down(db, 1)
+	if err != nil || n != 1 {
+		t.Fatalf("Expected one migration rolled back, got %d, %v", n, err)
+	}
+	status, err := Status(db)
+	if err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	last := status[len(status)-1]
+	if last.Applied {
+		t.Errorf("Expected migration %d to be pending after Down", last.Version)
+	}
+	if !status[0].Applied || status[0].AppliedAt.IsZero() {
+		t.Errorf("Expected migration %d to stay applied", status[0].Version)
+	}
+	if err := Check(db); err == nil {
+		t.Error("Expected Check to report pending migrations")
+	}
+
+	if _, err := Down(db, len(migrations)); err != nil {
+		t.Fatalf("Full Down failed: %v", err)
+	}
+	if tableExists(t, db, "users") {
+		t.Error("Expected users table dropped after full Down")
+	}
+}
+
+func TestUpRefusesWhenDatabaseAhead(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := Up(db); err != nil {
+		t.Fatalf("Up failed: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (9999, 'future', 0)"); err != nil {
+		t.Fatal("Failed to insert future version:", err)
+	}
+
+	if _, err := Up(db); !errors.Is(err, ErrDatabaseAhead) {
+		t.Errorf("Expected ErrDatabaseAhead from Up, got %v", err)
+	}
+	if err := Check(db); !errors.Is(err, ErrDatabaseAhead) {
+		t.Errorf("Expected ErrDatabaseAhead from Check, got %v", err)
+	}
+}
+
+func TestFailedMigrationRollsBack(t *testing.T) {
+	db := openTestDB(t)
+	fsys := fstest.MapFS{
+		"m/0001_ok.up.sql":     {Data: []byte("CREATE TABLE a (id INTEGER);")},
+		"m/0002_broken.up.sql": {Data: []byte("CREATE TABLE b (id INTEGER); INSERT INTO missing VALUES (1);")},
+	}
+	migrations, err := load(fsys, "m")
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+
+	n, err := SQLite.up(db, migrations)
+	if err == nil {
+		t.Fatal("Expected broken migration to fail")
+	}
+	if n != 1 {
+		t.Errorf("Expected 1 migration applied before failure, got %d", n)
+	}
+	if tableExists(t, db, "b") {
+		t.Error("Expected partial migration to be rolled back")
+	}
+	applied, _ := SQLite.appliedVersions(db)
+	if _, ok := applied[2]; ok {
+		t.Error("Expected failed migration not recorded")
+	}
+}
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_temp_totp_expires;
+DROP TABLE IF EXISTS temp_totp;
@@ -0,0 +1,10 @@
+-- Pending sign-ups waiting for their first TOTP code
+CREATE TABLE IF NOT EXISTS temp_totp (
+    temp_id TEXT PRIMARY KEY,
+    email TEXT NOT NULL,
+    password_hash BLOB NOT NULL,
+    totp_secret TEXT NOT NULL,
+    expires_at INTEGER NOT NULL             -- Unix seconds
+);
+
+CREATE INDEX IF NOT EXISTS idx_temp_totp_expires ON temp_totp(expires_at);
@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS idx_folders_user;
+DROP INDEX IF EXISTS idx_access_attempts_email;
+DROP INDEX IF EXISTS idx_emails_expires;
+DROP INDEX IF EXISTS idx_emails_recipient;
+DROP INDEX IF EXISTS idx_emails_sender;
+DROP TABLE IF EXISTS email_folders;
+DROP TABLE IF EXISTS folders;
+DROP TABLE IF EXISTS access_attempts;
+DROP TABLE IF EXISTS emails;
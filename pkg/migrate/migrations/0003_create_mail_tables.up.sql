@@ -1,13 +1,3 @@
--- Users table
-CREATE TABLE IF NOT EXISTS users (
-    id TEXT PRIMARY KEY,
-    email TEXT UNIQUE NOT NULL,
-    password_hash TEXT NOT NULL,
-    totp_secret TEXT,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-);
-
 -- Emails table
 CREATE TABLE IF NOT EXISTS emails (
     id TEXT PRIMARY KEY,
@@ -50,10 +40,8 @@ CREATE TABLE IF NOT EXISTS email_folders (
     FOREIGN KEY (folder_id) REFERENCES folders(id)
 );
 
--- Create indexes
-CREATE INDEX IF NOT EXISTS idx_users_email ON users(email);
 CREATE INDEX IF NOT EXISTS idx_emails_sender ON emails(sender_id);
 CREATE INDEX IF NOT EXISTS idx_emails_recipient ON emails(recipient_email);
 CREATE INDEX IF NOT EXISTS idx_emails_expires ON emails(expires_at);
 CREATE INDEX IF NOT EXISTS idx_access_attempts_email ON access_attempts(email_id);
-CREATE INDEX IF NOT EXISTS idx_folders_user ON folders(user_id); 
\ No newline at end of file
+CREATE INDEX IF NOT EXISTS idx_folders_user ON folders(user_id);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS revoked_tokens;
+DROP INDEX IF EXISTS idx_used_refresh_tokens_session;
+DROP TABLE IF EXISTS used_refresh_tokens;
+DROP INDEX IF EXISTS idx_sessions_user;
+DROP TABLE IF EXISTS sessions;
@@ -0,0 +1,33 @@
+-- One row per login. The current refresh token is stored as a SHA-256 hash
+-- and replaced on every refresh; the session ID is the token family.
+CREATE TABLE IF NOT EXISTS sessions (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    refresh_hash TEXT NOT NULL UNIQUE,
+    ip_address TEXT,
+    user_agent TEXT,
+    created_at INTEGER NOT NULL,            -- Unix seconds
+    last_seen_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL,
+    revoked_at INTEGER,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id);
+
+-- Refresh tokens that have been rotated out. Presenting one again means the
+-- token was stolen, so the whole session is revoked.
+CREATE TABLE IF NOT EXISTS used_refresh_tokens (
+    token_hash TEXT PRIMARY KEY,
+    session_id TEXT NOT NULL,
+    used_at INTEGER NOT NULL,
+    FOREIGN KEY (session_id) REFERENCES sessions(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_used_refresh_tokens_session ON used_refresh_tokens(session_id);
+
+-- Access token IDs (jti) revoked before their natural expiry
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    jti TEXT PRIMARY KEY,
+    expires_at INTEGER NOT NULL
+);
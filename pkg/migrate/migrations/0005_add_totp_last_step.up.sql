@@ -0,0 +1,3 @@
+-- Last accepted TOTP time-step (Unix time / 30). Codes for this step or any
+-- earlier one are rejected so each code can only be used once.
+ALTER TABLE users ADD COLUMN totp_last_step INTEGER;
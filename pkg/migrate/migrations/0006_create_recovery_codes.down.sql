@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_recovery_codes_user_hash;
+DROP TABLE IF EXISTS recovery_codes;
@@ -0,0 +1,12 @@
+-- One-time recovery codes, stored as SHA-256 hashes, for users who lost
+-- their authenticator
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    code_hash TEXT NOT NULL,
+    created_at INTEGER NOT NULL,            -- Unix seconds
+    used_at INTEGER,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_recovery_codes_user_hash ON recovery_codes(user_id, code_hash);
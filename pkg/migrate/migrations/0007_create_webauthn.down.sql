@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS webauthn_challenges;
+DROP INDEX IF EXISTS idx_webauthn_credentials_user;
+DROP TABLE IF EXISTS webauthn_credentials;
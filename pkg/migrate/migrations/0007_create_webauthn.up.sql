@@ -0,0 +1,29 @@
+-- Passkeys registered by users. The credential ID is stored base64url-encoded;
+-- the public key is the COSE key returned at registration.
+CREATE TABLE IF NOT EXISTS webauthn_credentials (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    name TEXT NOT NULL,
+    public_key BLOB NOT NULL,
+    attestation_type TEXT NOT NULL,
+    aaguid BLOB,
+    transports TEXT NOT NULL DEFAULT '',    -- Comma-separated
+    sign_count INTEGER NOT NULL DEFAULT 0,
+    backup_eligible INTEGER NOT NULL DEFAULT 0,
+    backup_state INTEGER NOT NULL DEFAULT 0,
+    clone_warning INTEGER NOT NULL DEFAULT 0, -- Set when the sign count went backwards; the credential is disabled
+    created_at INTEGER NOT NULL,            -- Unix seconds
+    last_used_at INTEGER,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_webauthn_credentials_user ON webauthn_credentials(user_id);
+
+-- In-flight registration and login ceremonies. Each challenge is single-use.
+CREATE TABLE IF NOT EXISTS webauthn_challenges (
+    id TEXT PRIMARY KEY,
+    user_id TEXT,                           -- NULL for discoverable (passwordless) logins
+    ceremony TEXT NOT NULL,                 -- 'registration' or 'login'
+    session_data TEXT NOT NULL,             -- JSON-encoded ceremony state
+    expires_at INTEGER NOT NULL
+);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_totp_reenrollments_user;
+DROP TABLE IF EXISTS totp_reenrollments;
@@ -0,0 +1,11 @@
+-- TOTP secrets waiting for their first code before replacing a user's
+-- current secret, mirroring temp_totp for sign-ups
+CREATE TABLE IF NOT EXISTS totp_reenrollments (
+    temp_id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    totp_secret TEXT NOT NULL,
+    expires_at INTEGER NOT NULL,            -- Unix seconds
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_totp_reenrollments_user ON totp_reenrollments(user_id);
@@ -0,0 +1,7 @@
+-- Consecutive failed logins per email address. Keyed by the submitted email
+-- rather than user ID so unknown addresses back off exactly like real ones.
+CREATE TABLE IF NOT EXISTS login_failures (
+    email TEXT PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    last_failure_at INTEGER NOT NULL        -- Unix seconds
+);
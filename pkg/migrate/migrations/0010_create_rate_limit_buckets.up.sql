@@ -0,0 +1,10 @@
+-- Token buckets for ratelimit.SQLStore, shared by every API instance on
+-- this database. A missing row is a full bucket.
+CREATE TABLE IF NOT EXISTS rate_limit_buckets (
+    key TEXT PRIMARY KEY,                   -- Policy name and identity
+    tokens REAL NOT NULL,
+    updated_at INTEGER NOT NULL,            -- Unix milliseconds
+    full_at INTEGER NOT NULL                -- Unix milliseconds; the row can be dropped after this
+);
+
+CREATE INDEX IF NOT EXISTS idx_rate_limit_buckets_full ON rate_limit_buckets(full_at);
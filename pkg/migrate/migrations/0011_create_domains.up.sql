@@ -0,0 +1,9 @@
+-- Mail domains hosted by this deployment. A user belongs to the domain of
+-- their address; sign-up is only possible at a listed domain.
+CREATE TABLE IF NOT EXISTS domains (
+    name TEXT PRIMARY KEY,                  -- Lowercase, e.g. securesystem.email
+    signup TEXT NOT NULL DEFAULT 'open' CHECK (signup IN ('open', 'invite', 'closed')),
+    max_users INTEGER NOT NULL,
+    totp_issuer TEXT NOT NULL,              -- Shown in authenticator apps
+    created_at INTEGER NOT NULL             -- Unix seconds
+);
@@ -0,0 +1,3 @@
+ALTER TABLE temp_totp DROP COLUMN invite_id;
+DROP TABLE IF EXISTS invite_redemptions;
+DROP TABLE IF EXISTS invites;
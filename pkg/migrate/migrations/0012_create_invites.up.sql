@@ -0,0 +1,27 @@
+-- Invite codes minted by admins, stored as SHA-256 hashes. A code lets up to
+-- max_uses people sign up at domain, or only email if it is set.
+CREATE TABLE IF NOT EXISTS invites (
+    id TEXT PRIMARY KEY,
+    code_hash TEXT NOT NULL UNIQUE,
+    domain TEXT NOT NULL,
+    email TEXT,                             -- Only this address may redeem it
+    max_uses INTEGER NOT NULL,
+    uses INTEGER NOT NULL DEFAULT 0,
+    created_by TEXT NOT NULL,               -- User ID of the admin
+    created_at INTEGER NOT NULL,            -- Unix seconds
+    expires_at INTEGER NOT NULL,            -- Unix seconds
+    FOREIGN KEY (domain) REFERENCES domains(name)
+);
+
+-- Who signed up with which invite
+CREATE TABLE IF NOT EXISTS invite_redemptions (
+    invite_id TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    redeemed_at INTEGER NOT NULL,           -- Unix seconds
+    PRIMARY KEY (invite_id, user_id),
+    FOREIGN KEY (invite_id) REFERENCES invites(id),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- The invite a pending sign-up will redeem once its TOTP code is verified
+ALTER TABLE temp_totp ADD COLUMN invite_id TEXT;
@@ -0,0 +1,6 @@
+-- Each user has one role: user, admin or auditor (read-only administration).
+-- Disabled users cannot sign in; their sessions are revoked when disabled.
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'user' CHECK (role IN ('user', 'admin', 'auditor'));
+ALTER TABLE users ADD COLUMN disabled_at INTEGER;   -- Unix seconds
+
+CREATE INDEX IF NOT EXISTS idx_users_role ON users(role);
@@ -0,0 +1,6 @@
+DROP TRIGGER IF EXISTS audit_events_prune_oldest;
+DROP TRIGGER IF EXISTS audit_events_no_update;
+DROP INDEX IF EXISTS idx_audit_events_created_at;
+DROP INDEX IF EXISTS idx_audit_events_target;
+DROP INDEX IF EXISTS idx_audit_events_actor;
+DROP TABLE IF EXISTS audit_events;
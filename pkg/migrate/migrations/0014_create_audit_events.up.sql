@@ -0,0 +1,35 @@
+-- Security audit log. Each event's hash covers its fields and the previous
+-- event's hash, so editing, removing or reordering events breaks the chain.
+CREATE TABLE IF NOT EXISTS audit_events (
+    id INTEGER PRIMARY KEY,                 -- Assigned in order by the writer
+    created_at INTEGER NOT NULL,            -- Unix seconds
+    actor_id TEXT,                          -- User who acted, if signed in or identified
+    actor_email TEXT,                       -- Address given, e.g. for failed logins
+    action TEXT NOT NULL,                   -- e.g. login, signup, signup.verify_totp
+    target TEXT,                            -- User or object acted on
+    ip TEXT,
+    user_agent TEXT,
+    outcome TEXT NOT NULL CHECK (outcome IN ('success', 'failure')),
+    reason TEXT,                            -- Why it failed, or details
+    prev_hash TEXT NOT NULL UNIQUE,         -- Hash of the previous event; a fork cannot be inserted
+    hash TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor ON audit_events(actor_id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_target ON audit_events(target);
+CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events(created_at);
+
+-- Append-only: events are never changed, and retention may only remove the
+-- oldest event, never the newest, so the chain stays contiguous
+CREATE TRIGGER IF NOT EXISTS audit_events_no_update
+    BEFORE UPDATE ON audit_events
+BEGIN
+    SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_events_prune_oldest
+    BEFORE DELETE ON audit_events
+    WHEN OLD.id != (SELECT MIN(id) FROM audit_events) OR OLD.id = (SELECT MAX(id) FROM audit_events)
+BEGIN
+    SELECT RAISE(ABORT, 'audit_events can only be pruned from the oldest event');
+END;
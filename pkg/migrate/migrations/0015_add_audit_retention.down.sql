@@ -0,0 +1,9 @@
+DROP TRIGGER IF EXISTS audit_events_prune_oldest;
+CREATE TRIGGER audit_events_prune_oldest
+    BEFORE DELETE ON audit_events
+    WHEN OLD.id != (SELECT MIN(id) FROM audit_events) OR OLD.id = (SELECT MAX(id) FROM audit_events)
+BEGIN
+    SELECT RAISE(ABORT, 'audit_events can only be pruned from the oldest event');
+END;
+
+DROP TABLE IF EXISTS audit_retention;
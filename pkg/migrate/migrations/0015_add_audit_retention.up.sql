@@ -0,0 +1,17 @@
+-- Retention the audit log is pruned with, written by the API at startup. No
+-- row means events are kept forever.
+CREATE TABLE IF NOT EXISTS audit_retention (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    seconds INTEGER NOT NULL CHECK (seconds > 0)
+);
+
+-- Retention may only remove the oldest event, never the newest, and only
+-- once it is older than the retention period
+DROP TRIGGER IF EXISTS audit_events_prune_oldest;
+CREATE TRIGGER audit_events_prune_oldest
+    BEFORE DELETE ON audit_events
+    WHEN OLD.id != (SELECT MIN(id) FROM audit_events) OR OLD.id = (SELECT MAX(id) FROM audit_events)
+        OR NOT EXISTS (SELECT 1 FROM audit_retention WHERE OLD.created_at < CAST(strftime('%s', 'now') AS INTEGER) - seconds)
+BEGIN
+    SELECT RAISE(ABORT, 'audit_events can only be pruned from the oldest event once it is past retention');
+END;
@@ -0,0 +1,50 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps buckets in process memory. Limits are per instance and
+// reset on restart.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]memoryBucket
+}
+
+type memoryBucket struct {
+	bucket
+	fullAt time.Time
+}
+
+// NewMemoryStore creates an empty in-memory Store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]memoryBucket)}
+}
+
+// Take removes one token from the bucket for key, if there is one
+func (s *MemoryStore) Take(key string, p Policy, now time.Time) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[key]
+	if !ok {
+		b.bucket = p.full(now)
+	}
+	next, res, fullAt := p.take(b.bucket, now)
+	s.buckets[key] = memoryBucket{bucket: next, fullAt: fullAt}
+	return res, nil
+}
+
+// DeleteExpired drops buckets that have refilled completely
+func (s *MemoryStore) DeleteExpired(now time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for key, b := range s.buckets {
+		if !now.Before(b.fullAt) {
+			delete(s.buckets, key)
+			n++
+		}
+	}
+	return n, nil
+}
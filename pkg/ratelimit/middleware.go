@@ -0,0 +1,80 @@
+package ratelimit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"secure-email-mvp/pkg/logging"
+	"secure-email-mvp/pkg/metrics"
+)
+
+// KeyFunc returns the identity a request is limited by. Returning false skips
+// the policy for that request.
+type KeyFunc func(r *http.Request) (string, bool)
+
+// ByIP limits each client address, as seen through the trusted proxies
+func ByIP(proxies *TrustedProxies) KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		return proxies.ClientIP(r), true
+	}
+}
+
+// ByAPIKey limits each API key sent in header. Keys are hashed so they are
+// never stored. Requests without the header are not limited by this policy.
+func ByAPIKey(header string) KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		key := r.Header.Get(header)
+		if key == "" {
+			return "", false
+		}
+		sum := sha256.Sum256([]byte(key))
+		return hex.EncodeToString(sum[:]), true
+	}
+}
+
+// Middleware enforces p for every identity returned by key. Responses carry
+// RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers; rejected
+// requests get 429 with Retry-After. If the store fails, requests are let
+// through rather than taking the API down.
+func Middleware(store Store, p Policy, key KeyFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, ok := key(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			res, err := store.Take(p.Name+":"+id, p, time.Now())
+			if err != nil {
+				logging.FromContext(r.Context()).Error("Rate limit failed", "policy", p.Name, "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			h := w.Header()
+			h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+			h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			h.Set("RateLimit-Reset", ceilSeconds(res.Reset))
+			h.Set("RateLimit-Policy", strconv.Itoa(p.Limit)+";w="+ceilSeconds(p.Per))
+			if !res.Allowed {
+				metrics.RateLimited(p.Name, r)
+				if action, ok := p.AuthActions[r.URL.Path]; ok {
+					metrics.AuthAttempt(action, "rate_limited")
+				}
+				h.Set("Retry-After", ceilSeconds(res.RetryAfter))
+				http.Error(w, `{"error":"Too many requests"}`, http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ceilSeconds formats d as whole seconds, rounded up
+func ceilSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"secure-email-mvp/pkg/metrics"
+)
+
+func TestMiddleware(t *testing.T) {
+	store := NewMemoryStore()
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	p := Policy{Name: "login", Limit: 2, Per: time.Minute, AuthActions: map[string]string{"/api/auth/login": "login"}}
+	h := Middleware(store, p, ByIP(nil))(ok)
+
+	do := func(remote string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/api/auth/login", nil)
+		r.RemoteAddr = remote
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+		return rr
+	}
+
+	rr := do("203.0.113.7:1000")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+	if rr.Header().Get("RateLimit-Limit") != "2" || rr.Header().Get("RateLimit-Remaining") != "1" ||
+		rr.Header().Get("RateLimit-Reset") != "30" || rr.Header().Get("RateLimit-Policy") != "2;w=60" {
+		t.Errorf("Unexpected rate limit headers %v", rr.Header())
+	}
+
+	// The port is not part of the identity
+	do("203.0.113.7:1001")
+	rr = do("203.0.113.7:1002")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429, got %d", rr.Code)
+	}
+	if rr.Header().Get("Retry-After") != "30" || rr.Header().Get("RateLimit-Remaining") != "0" {
+		t.Errorf("Unexpected headers on rejection %v", rr.Header())
+	}
+
+	// The refused login counts as a rate-limited auth attempt
+	mr := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(mr, httptest.NewRequest("GET", "/metrics", nil))
+	if want := `secure_email_auth_attempts_total{action="login",result="rate_limited"} 1`; !strings.Contains(mr.Body.String(), want) {
+		t.Errorf("Expected %s in metrics output", want)
+	}
+
+	if rr := do("198.51.100.1:1000"); rr.Code != http.StatusOK {
+		t.Errorf("Expected another IP unaffected, got %d", rr.Code)
+	}
+}
+
+func TestByAPIKey(t *testing.T) {
+	key := ByAPIKey("X-API-Key")
+	r := httptest.NewRequest("GET", "/", nil)
+	if _, ok := key(r); ok {
+		t.Error("Expected requests without a key to skip the policy")
+	}
+	r.Header.Set("X-API-Key", "secret-key")
+	id, ok := key(r)
+	if !ok || id == "secret-key" || len(id) != 64 {
+		t.Errorf("Expected a hashed key, got %q", id)
+	}
+}
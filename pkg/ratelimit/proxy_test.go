@@ -0,0 +1,51 @@
+package ratelimit
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1", " "})
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		proxies *TrustedProxies
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{"Direct client", proxies, "203.0.113.7:51234", nil, "203.0.113.7"},
+		{"Untrusted peer ignores headers", proxies, "203.0.113.7:51234", map[string]string{"X-Forwarded-For": "198.51.100.1", "CF-Connecting-IP": "198.51.100.2"}, "203.0.113.7"},
+		{"No proxies configured", nil, "10.0.0.1:443", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "10.0.0.1"},
+		{"Cloudflare header", proxies, "10.0.0.1:443", map[string]string{"CF-Connecting-IP": "198.51.100.2", "X-Forwarded-For": "198.51.100.1"}, "198.51.100.2"},
+		{"Forwarded for", proxies, "10.0.0.1:443", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "198.51.100.1"},
+		{"Spoofed left entries skipped", proxies, "10.0.0.1:443", map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.1, 10.0.0.2"}, "198.51.100.1"},
+		{"Single trusted IP", proxies, "192.0.2.1:443", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "198.51.100.1"},
+		{"Garbage stops the walk", proxies, "10.0.0.1:443", map[string]string{"X-Forwarded-For": "198.51.100.1, junk"}, "10.0.0.1"},
+		{"IPv6 peer", proxies, "[2001:db8::1]:443", nil, "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			if got := tt.proxies.ClientIP(r); got != tt.want {
+				t.Errorf("ClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxiesInvalid(t *testing.T) {
+	for _, entry := range []string{"not-an-ip", "10.0.0.0/33"} {
+		if _, err := ParseTrustedProxies([]string{entry}); err == nil {
+			t.Errorf("Expected error for %q", entry)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// Package ratelimit applies token-bucket limits to HTTP requests, keyed by
+// policy and caller identity, with buckets kept in memory or in the database.
+package ratelimit
+
+import (
+	"log/slog"
+	"math"
+	"time"
+)
+
+// Policy allows Limit requests per Per for each identity, refilling
+// continuously, so a caller may burst up to Limit at once
+type Policy struct {
+	Name  string // Prefixes bucket keys so policies never share buckets
+	Limit int
+	Per   time.Duration
+	// AuthActions maps request paths, such as /api/auth/login, to the login
+	// action their refusals are counted under as rate_limited auth attempts
+	AuthActions map[string]string
+}
+
+// Result describes a bucket after one request has been counted against it
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration // Until the bucket is full again
+	RetryAfter time.Duration // Until the next request is allowed; zero if Allowed
+}
+
+// Store keeps buckets between requests
+type Store interface {
+	// Take removes one token from the bucket for key, if there is one
+	Take(key string, p Policy, now time.Time) (Result, error)
+	// DeleteExpired drops buckets that have refilled completely
+	DeleteExpired(now time.Time) (int64, error)
+}
+
+// bucket is the stored state: a missing bucket is a full one
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// perSecond is the refill rate in tokens per second
+func (p Policy) perSecond() float64 {
+	return float64(p.Limit) / p.Per.Seconds()
+}
+
+// full returns a bucket with every token available at now
+func (p Policy) full(now time.Time) bucket {
+	return bucket{tokens: float64(p.Limit), updated: now}
+}
+
+// take refills b up to now, spends a token if one is available and returns
+// the new bucket state along with when it will be full again
+func (p Policy) take(b bucket, now time.Time) (bucket, Result, time.Time) {
+	elapsed := now.Sub(b.updated).Seconds()
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	tokens := math.Min(float64(p.Limit), b.tokens+elapsed*p.perSecond())
+
+	res := Result{Limit: p.Limit}
+	if tokens >= 1 {
+		tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = p.seconds(1 - tokens)
+	}
+	res.Remaining = int(tokens)
+	res.Reset = p.seconds(float64(p.Limit) - tokens)
+	return bucket{tokens: tokens, updated: now}, res, now.Add(res.Reset)
+}
+
+// seconds returns how long refilling the given number of tokens takes
+func (p Policy) seconds(tokens float64) time.Duration {
+	return time.Duration(tokens / p.perSecond() * float64(time.Second))
+}
+
+// StartJanitor drops refilled buckets every interval until the returned stop
+// function is called
+func StartJanitor(store Store, interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				if _, err := store.DeleteExpired(now); err != nil {
+					slog.Error("Rate limit sweep failed", "error", err)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,75 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+var testPolicy = Policy{Name: "test", Limit: 3, Per: 3 * time.Second}
+
+// exerciseStore runs the same bucket checks against any Store
+func exerciseStore(t *testing.T, s Store) {
+	now := time.Unix(1700000000, 0)
+
+	// A fresh identity can burst up to the limit
+	for i := 2; i >= 0; i-- {
+		res, err := s.Take("test:a", testPolicy, now)
+		if err != nil {
+			t.Fatalf("Take failed: %v", err)
+		}
+		if !res.Allowed || res.Remaining != i || res.Limit != 3 {
+			t.Fatalf("Expected allowed with %d remaining, got %+v", i, res)
+		}
+	}
+	res, _ := s.Take("test:a", testPolicy, now)
+	if res.Allowed || res.RetryAfter != time.Second || res.Reset != 3*time.Second {
+		t.Errorf("Expected rejection with 1s retry and 3s reset, got %+v", res)
+	}
+
+	// Other identities have their own bucket
+	if res, _ := s.Take("test:b", testPolicy, now); !res.Allowed {
+		t.Error("Expected another key unaffected")
+	}
+
+	// One token refills per second
+	if res, _ := s.Take("test:a", testPolicy, now.Add(500*time.Millisecond)); res.Allowed {
+		t.Error("Expected rejection before a token refilled")
+	}
+	if res, _ := s.Take("test:a", testPolicy, now.Add(time.Second)); !res.Allowed || res.Remaining != 0 {
+		t.Errorf("Expected one token after a second, got %+v", res)
+	}
+
+	// Buckets are dropped once full again
+	n, err := s.DeleteExpired(now.Add(2 * time.Second))
+	if err != nil {
+		t.Fatalf("DeleteExpired failed: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("Expected only the refilled bucket removed, got %d", n)
+	}
+	if n, _ := s.DeleteExpired(now.Add(10 * time.Second)); n != 1 {
+		t.Errorf("Expected the remaining bucket removed, got %d", n)
+	}
+	if res, _ := s.Take("test:a", testPolicy, now.Add(10*time.Second)); res.Remaining != 2 {
+		t.Errorf("Expected a fresh bucket after sweep, got %+v", res)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	exerciseStore(t, NewMemoryStore())
+}
+
+func TestPolicyTakeClockSkew(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	b := bucket{tokens: 0, updated: now}
+
+	// A clock stepping backwards must not drain or overfill the bucket
+	_, res, _ := testPolicy.take(b, now.Add(-time.Hour))
+	if res.Allowed {
+		t.Error("Expected no refill when the clock goes backwards")
+	}
+	_, res, _ = testPolicy.take(b, now.Add(time.Hour))
+	if !res.Allowed || res.Remaining != 2 {
+		t.Errorf("Expected refill capped at the limit, got %+v", res)
+	}
+}
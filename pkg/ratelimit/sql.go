@@ -0,0 +1,79 @@
+package ratelimit
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// SQLStore keeps buckets in the rate_limit_buckets table so limits hold
+// across restarts and are shared between API instances. Its SQL runs on
+// SQLite and PostgreSQL.
+type SQLStore struct {
+	db     *sql.DB
+	rebind func(query string) string
+}
+
+// NewSQLStore creates a Store backed by rate_limit_buckets. rebind rewrites
+// the ? placeholders in its queries for the driver; nil keeps them.
+func NewSQLStore(db *sql.DB, rebind func(query string) string) *SQLStore {
+	return &SQLStore{db: db, rebind: rebind}
+}
+
+// bind rewrites query's placeholders for the driver
+func (s *SQLStore) bind(query string) string {
+	if s.rebind == nil {
+		return query
+	}
+	return s.rebind(query)
+}
+
+// Take removes one token from the bucket for key, if there is one
+func (s *SQLStore) Take(key string, p Policy, now time.Time) (Result, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return Result{}, fmt.Errorf("database error: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Write first so the transaction holds the write lock before reading;
+	// concurrent takers then queue instead of failing to upgrade a read lock.
+	// On PostgreSQL the no-op update locks the row, so the read below sees
+	// the tokens the previous taker left.
+	full := p.full(now)
+	if _, err := tx.Exec(s.bind(
+		"INSERT INTO rate_limit_buckets (key, tokens, updated_at, full_at) VALUES (?, ?, ?, ?) "+
+			"ON CONFLICT (key) DO UPDATE SET tokens = rate_limit_buckets.tokens"),
+		key, full.tokens, now.UnixMilli(), now.UnixMilli(),
+	); err != nil {
+		return Result{}, fmt.Errorf("database insert error: %v", err)
+	}
+
+	var b bucket
+	var updated int64
+	if err := tx.QueryRow(s.bind("SELECT tokens, updated_at FROM rate_limit_buckets WHERE key = ?"), key).Scan(&b.tokens, &updated); err != nil {
+		return Result{}, fmt.Errorf("database error: %v", err)
+	}
+	b.updated = time.UnixMilli(updated)
+
+	next, res, fullAt := p.take(b, now)
+	if _, err := tx.Exec(s.bind(
+		"UPDATE rate_limit_buckets SET tokens = ?, updated_at = ?, full_at = ? WHERE key = ?"),
+		next.tokens, next.updated.UnixMilli(), fullAt.UnixMilli(), key,
+	); err != nil {
+		return Result{}, fmt.Errorf("database update error: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return Result{}, fmt.Errorf("database commit error: %v", err)
+	}
+	return res, nil
+}
+
+// DeleteExpired drops buckets that have refilled completely
+func (s *SQLStore) DeleteExpired(now time.Time) (int64, error) {
+	res, err := s.db.Exec(s.bind("DELETE FROM rate_limit_buckets WHERE full_at <= ?"), now.UnixMilli())
+	if err != nil {
+		return 0, fmt.Errorf("database delete error: %v", err)
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,43 @@
+package ratelimit
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"secure-email-mvp/pkg/migrate"
+)
+
+func newTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal("Failed to open database:", err)
+	}
+	// Each connection to :memory: is a separate database
+	db.SetMaxOpenConns(1)
+	if _, err := migrate.Up(db); err != nil {
+		t.Fatal("Failed to migrate database:", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestSQLStore(t *testing.T) {
+	exerciseStore(t, NewSQLStore(newTestDB(t), nil))
+}
+
+func TestSQLStoreShared(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Now()
+	policy := Policy{Name: "test", Limit: 1, Per: time.Minute}
+
+	if res, _ := NewSQLStore(db, nil).Take("test:a", policy, now); !res.Allowed {
+		t.Fatal("Expected first request allowed")
+	}
+	// A second instance on the same database sees the spent token
+	if res, _ := NewSQLStore(db, nil).Take("test:a", policy, now); res.Allowed {
+		t.Error("Expected second instance to share the bucket")
+	}
+}
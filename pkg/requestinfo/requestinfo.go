@@ -0,0 +1,79 @@
+// Package requestinfo collects what the access log and the HTTP metrics need
+// to know about a request once it has been served: the matched route template
+// and the response's status and size.
+package requestinfo
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+type contextKey struct{}
+
+// Info describes one request. Route stays empty when no route matched.
+type Info struct {
+	Route  string // Mux route template, such as /api/sessions/{id}
+	Status int    // Zero until the handler writes
+	Bytes  int
+}
+
+// Code returns the response status, which is 200 if the handler never set one
+func (i *Info) Code() int {
+	if i.Status == 0 {
+		return http.StatusOK
+	}
+	return i.Status
+}
+
+// FromContext returns the request's Info, or nil outside Track
+func FromContext(ctx context.Context) *Info {
+	info, _ := ctx.Value(contextKey{}).(*Info)
+	return info
+}
+
+// Track returns r's Info along with w and r wrapped to fill it in. Nested
+// middlewares share the outermost call's Info and recorder.
+func Track(w http.ResponseWriter, r *http.Request) (*Info, http.ResponseWriter, *http.Request) {
+	if info := FromContext(r.Context()); info != nil {
+		return info, w, r
+	}
+	info := &Info{}
+	return info, &recorder{ResponseWriter: w, info: info}, r.WithContext(context.WithValue(r.Context(), contextKey{}, info))
+}
+
+// Route is a mux middleware that records the matched route template in the
+// request's Info, so requests are grouped without their IDs
+func Route(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if info := FromContext(r.Context()); info != nil {
+			if route := mux.CurrentRoute(r); route != nil {
+				info.Route, _ = route.GetPathTemplate()
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// recorder remembers the status and size of a response
+type recorder struct {
+	http.ResponseWriter
+	info *Info
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	if rec.info.Status == 0 {
+		rec.info.Status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	if rec.info.Status == 0 {
+		rec.info.Status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.info.Bytes += n
+	return n, err
+}
@@ -0,0 +1,51 @@
+package requestinfo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestTrack(t *testing.T) {
+	r := mux.NewRouter()
+	r.Use(Route)
+	r.HandleFunc("/api/sessions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("hello"))
+	})
+
+	var outer, inner *Info
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		outer, w, req = Track(w, req)
+		http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			inner, w, req = Track(w, req)
+			r.ServeHTTP(w, req)
+		}).ServeHTTP(w, req)
+	})
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/api/sessions/abc", nil))
+
+	// Nested middlewares see the same Info
+	if outer != inner {
+		t.Fatal("Expected nested Track calls to share one Info")
+	}
+	if outer.Route != "/api/sessions/{id}" || outer.Code() != http.StatusAccepted || outer.Bytes != 5 {
+		t.Errorf("Unexpected info %+v", outer)
+	}
+
+	// Unmatched requests keep an empty route; a body alone means 200
+	var info *Info
+	http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		info, w, req = Track(w, req)
+		Route(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		})).ServeHTTP(w, req)
+	}).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/missing", nil))
+	if info.Route != "" || info.Code() != http.StatusOK {
+		t.Errorf("Unexpected info %+v", info)
+	}
+	if (&Info{}).Code() != http.StatusOK {
+		t.Error("Expected a response with nothing written to count as 200")
+	}
+}
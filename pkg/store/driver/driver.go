@@ -0,0 +1,56 @@
+// Package driver picks the store backend named by database.driver in the
+// configuration: how to open the database, its migrations and the SQL
+// dialect everything on it speaks
+package driver
+
+import (
+	"database/sql"
+	"fmt"
+
+	"secure-email-mvp/pkg/migrate"
+	"secure-email-mvp/pkg/store"
+	"secure-email-mvp/pkg/store/postgres"
+	"secure-email-mvp/pkg/store/sqlite"
+	"secure-email-mvp/pkg/store/sqlstore"
+)
+
+// Driver is a database the API can run on
+type Driver struct {
+	Name       string
+	Migrations migrate.Source
+	Dialect    *sqlstore.Dialect
+	// Open connects to the database at source, a file path or DSN
+	Open func(source string) (*sql.DB, error)
+}
+
+// Drivers by the name database.driver uses
+var (
+	SQLite = &Driver{
+		Name:       "sqlite",
+		Migrations: migrate.SQLite,
+		Dialect:    sqlite.Dialect,
+		Open:       sqlite.Open,
+	}
+	Postgres = &Driver{
+		Name:       "postgres",
+		Migrations: postgres.Migrations,
+		Dialect:    postgres.Dialect,
+		Open:       postgres.Open,
+	}
+)
+
+// Lookup returns the driver called name
+func Lookup(name string) (*Driver, error) {
+	switch name {
+	case SQLite.Name:
+		return SQLite, nil
+	case Postgres.Name:
+		return Postgres, nil
+	}
+	return nil, fmt.Errorf("unknown database driver %q", name)
+}
+
+// New returns every repository on db, which must already be migrated
+func (d *Driver) New(db *sql.DB) *store.Store {
+	return sqlstore.New(db, d.Dialect)
+}
@@ -0,0 +1,37 @@
+package driver
+
+import (
+	"testing"
+
+	"secure-email-mvp/pkg/store"
+)
+
+func TestLookup(t *testing.T) {
+	for _, d := range []*Driver{SQLite, Postgres} {
+		if got, err := Lookup(d.Name); err != nil || got != d {
+			t.Errorf("Expected %s driver, got %v, %v", d.Name, got, err)
+		}
+	}
+	if _, err := Lookup("mysql"); err == nil {
+		t.Error("Expected unknown driver refused")
+	}
+}
+
+func TestSQLite(t *testing.T) {
+	db, err := SQLite.Open(":memory:")
+	if err != nil {
+		t.Fatal("Open failed:", err)
+	}
+	defer db.Close()
+	// Each connection to :memory: is a separate database
+	db.SetMaxOpenConns(1)
+	if _, err := SQLite.Migrations.Up(db); err != nil {
+		t.Fatal("Migrations failed:", err)
+	}
+	if err := SQLite.Migrations.Check(db); err != nil {
+		t.Error("Expected schema current after Up:", err)
+	}
+	if _, err := SQLite.New(db).Users.ByID("nobody"); err != store.ErrNotFound {
+		t.Errorf("Expected ErrNotFound from an empty store, got %v", err)
+	}
+}
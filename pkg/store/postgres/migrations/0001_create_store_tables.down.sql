@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS used_refresh_tokens;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS email_folders;
+DROP TABLE IF EXISTS folders;
+DROP TABLE IF EXISTS access_attempts;
+DROP TABLE IF EXISTS emails;
+DROP TABLE IF EXISTS temp_totp;
+DROP TABLE IF EXISTS users;
+DROP FUNCTION IF EXISTS set_updated_at();
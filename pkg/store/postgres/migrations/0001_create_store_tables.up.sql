@@ -0,0 +1,107 @@
+-- Repository tables for PostgreSQL, mirroring the SQLite schema built by
+-- pkg/migrate. Columns holding Unix seconds in SQLite hold them here too;
+-- DATETIME and TIMESTAMP columns become TIMESTAMPTZ. IF NOT EXISTS lets
+-- databases created from the old unversioned schema.sql adopt this version.
+
+CREATE TABLE IF NOT EXISTS users (
+    id TEXT PRIMARY KEY,
+    email TEXT NOT NULL UNIQUE,
+    password_hash TEXT NOT NULL,            -- Argon2id in PHC format
+    totp_secret TEXT NOT NULL,
+    totp_last_step BIGINT,                  -- Last accepted TOTP time-step
+    role TEXT NOT NULL DEFAULT 'user' CHECK (role IN ('user', 'admin', 'auditor')),
+    disabled_at BIGINT,                     -- Unix seconds
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_users_role ON users(role);
+
+-- Keep updated_at current like the SQLite trigger does
+CREATE OR REPLACE FUNCTION set_updated_at() RETURNS trigger AS $$
+BEGIN
+    NEW.updated_at = now();
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS update_users_updated_at ON users;
+CREATE TRIGGER update_users_updated_at
+    BEFORE UPDATE ON users
+    FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+
+-- Pending sign-ups waiting for their first TOTP code
+CREATE TABLE IF NOT EXISTS temp_totp (
+    temp_id TEXT PRIMARY KEY,
+    email TEXT NOT NULL,
+    password_hash TEXT NOT NULL,
+    totp_secret TEXT NOT NULL,
+    invite_id TEXT,
+    expires_at BIGINT NOT NULL              -- Unix seconds
+);
+
+CREATE INDEX IF NOT EXISTS idx_temp_totp_expires ON temp_totp(expires_at);
+
+CREATE TABLE IF NOT EXISTS emails (
+    id TEXT PRIMARY KEY,
+    sender_id TEXT NOT NULL REFERENCES users(id),
+    recipient_email TEXT NOT NULL,
+    subject TEXT,
+    encrypted_content TEXT NOT NULL,
+    access_password_hash TEXT,
+    geolocation_circles TEXT,
+    expires_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS access_attempts (
+    id TEXT PRIMARY KEY,
+    email_id TEXT NOT NULL REFERENCES emails(id),
+    ip_address TEXT NOT NULL,
+    attempt_count INTEGER NOT NULL DEFAULT 0,
+    last_attempt TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS folders (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users(id),
+    name TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS email_folders (
+    email_id TEXT NOT NULL REFERENCES emails(id),
+    folder_id TEXT NOT NULL REFERENCES folders(id),
+    PRIMARY KEY (email_id, folder_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_emails_sender ON emails(sender_id);
+CREATE INDEX IF NOT EXISTS idx_emails_recipient ON emails(recipient_email);
+CREATE INDEX IF NOT EXISTS idx_emails_expires ON emails(expires_at);
+CREATE INDEX IF NOT EXISTS idx_access_attempts_email ON access_attempts(email_id);
+CREATE INDEX IF NOT EXISTS idx_folders_user ON folders(user_id);
+CREATE INDEX IF NOT EXISTS idx_email_folders_folder ON email_folders(folder_id);
+
+-- One row per login; the current refresh token is stored as a SHA-256 hash
+CREATE TABLE IF NOT EXISTS sessions (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users(id),
+    refresh_hash TEXT NOT NULL UNIQUE,
+    ip_address TEXT,
+    user_agent TEXT,
+    created_at BIGINT NOT NULL,             -- Unix seconds
+    last_seen_at BIGINT NOT NULL,
+    expires_at BIGINT NOT NULL,
+    revoked_at BIGINT
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id);
+
+-- Refresh tokens rotated out of a session; presenting one again revokes it
+CREATE TABLE IF NOT EXISTS used_refresh_tokens (
+    token_hash TEXT PRIMARY KEY,
+    session_id TEXT NOT NULL REFERENCES sessions(id),
+    used_at BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_used_refresh_tokens_session ON used_refresh_tokens(session_id);
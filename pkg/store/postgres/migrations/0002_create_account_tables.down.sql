@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS invite_redemptions;
+DROP TABLE IF EXISTS invites;
+DROP TABLE IF EXISTS domains;
+DROP TABLE IF EXISTS totp_reenrollments;
+DROP TABLE IF EXISTS webauthn_challenges;
+DROP TABLE IF EXISTS webauthn_credentials;
+DROP TABLE IF EXISTS recovery_codes;
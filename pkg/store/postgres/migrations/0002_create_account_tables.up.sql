@@ -0,0 +1,82 @@
+-- Tables outside the repositories that still refer to users, so deleting a
+-- user removes the same rows as on SQLite. Mirrors SQLite migrations 0006
+-- to 0008, 0011 and 0012.
+
+-- One-time recovery codes, stored as SHA-256 hashes
+CREATE TABLE recovery_codes (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users(id),
+    code_hash TEXT NOT NULL,
+    created_at BIGINT NOT NULL,             -- Unix seconds
+    used_at BIGINT
+);
+
+CREATE UNIQUE INDEX idx_recovery_codes_user_hash ON recovery_codes(user_id, code_hash);
+
+-- Passkeys registered by users
+CREATE TABLE webauthn_credentials (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users(id),
+    name TEXT NOT NULL,
+    public_key BYTEA NOT NULL,
+    attestation_type TEXT NOT NULL,
+    aaguid BYTEA,
+    transports TEXT NOT NULL DEFAULT '',    -- Comma-separated
+    sign_count BIGINT NOT NULL DEFAULT 0,
+    backup_eligible INTEGER NOT NULL DEFAULT 0,
+    backup_state INTEGER NOT NULL DEFAULT 0,
+    clone_warning INTEGER NOT NULL DEFAULT 0,
+    created_at BIGINT NOT NULL,             -- Unix seconds
+    last_used_at BIGINT
+);
+
+CREATE INDEX idx_webauthn_credentials_user ON webauthn_credentials(user_id);
+
+-- In-flight registration and login ceremonies
+CREATE TABLE webauthn_challenges (
+    id TEXT PRIMARY KEY,
+    user_id TEXT,                           -- NULL for discoverable logins
+    ceremony TEXT NOT NULL,
+    session_data TEXT NOT NULL,             -- JSON-encoded ceremony state
+    expires_at BIGINT NOT NULL
+);
+
+-- TOTP secrets waiting for their first code before replacing a user's
+-- current secret
+CREATE TABLE totp_reenrollments (
+    temp_id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users(id),
+    totp_secret TEXT NOT NULL,
+    expires_at BIGINT NOT NULL              -- Unix seconds
+);
+
+CREATE INDEX idx_totp_reenrollments_user ON totp_reenrollments(user_id);
+
+-- Mail domains hosted by this deployment
+CREATE TABLE domains (
+    name TEXT PRIMARY KEY,                  -- Lowercase
+    signup TEXT NOT NULL DEFAULT 'open' CHECK (signup IN ('open', 'invite', 'closed')),
+    max_users INTEGER NOT NULL,
+    totp_issuer TEXT NOT NULL,
+    created_at BIGINT NOT NULL              -- Unix seconds
+);
+
+-- Invite codes minted by admins, stored as SHA-256 hashes
+CREATE TABLE invites (
+    id TEXT PRIMARY KEY,
+    code_hash TEXT NOT NULL UNIQUE,
+    domain TEXT NOT NULL REFERENCES domains(name),
+    email TEXT,
+    max_uses INTEGER NOT NULL,
+    uses INTEGER NOT NULL DEFAULT 0,
+    created_by TEXT NOT NULL,
+    created_at BIGINT NOT NULL,
+    expires_at BIGINT NOT NULL
+);
+
+CREATE TABLE invite_redemptions (
+    invite_id TEXT NOT NULL REFERENCES invites(id),
+    user_id TEXT NOT NULL REFERENCES users(id),
+    redeemed_at BIGINT NOT NULL,            -- Unix seconds
+    PRIMARY KEY (invite_id, user_id)
+);
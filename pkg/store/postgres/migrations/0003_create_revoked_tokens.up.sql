@@ -0,0 +1,8 @@
+-- Access token IDs (jti) revoked before their natural expiry. Mirrors the
+-- table in SQLite migration 0004.
+CREATE TABLE revoked_tokens (
+    jti TEXT PRIMARY KEY,
+    expires_at BIGINT NOT NULL              -- Unix seconds
+);
+
+CREATE INDEX idx_revoked_tokens_expires ON revoked_tokens(expires_at);
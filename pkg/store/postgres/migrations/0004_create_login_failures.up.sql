@@ -0,0 +1,7 @@
+-- Consecutive failed logins per lower-case email address. Mirrors SQLite
+-- migration 0009.
+CREATE TABLE login_failures (
+    email TEXT PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    last_failure_at BIGINT NOT NULL         -- Unix seconds
+);
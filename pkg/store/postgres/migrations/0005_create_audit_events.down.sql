@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS audit_retention;
+DROP TABLE IF EXISTS audit_events;
+DROP FUNCTION IF EXISTS audit_events_prune_oldest();
+DROP FUNCTION IF EXISTS audit_events_no_update();
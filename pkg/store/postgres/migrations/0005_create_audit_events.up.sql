@@ -0,0 +1,54 @@
+-- Security audit log and its retention. Mirrors SQLite migrations 0014 and
+-- 0015, with the triggers written as PL/pgSQL functions.
+CREATE TABLE audit_events (
+    id BIGINT PRIMARY KEY,                  -- Assigned in order by the writer
+    created_at BIGINT NOT NULL,             -- Unix seconds
+    actor_id TEXT,
+    actor_email TEXT,
+    action TEXT NOT NULL,
+    target TEXT,
+    ip TEXT,
+    user_agent TEXT,
+    outcome TEXT NOT NULL CHECK (outcome IN ('success', 'failure')),
+    reason TEXT,
+    prev_hash TEXT NOT NULL UNIQUE,         -- Hash of the previous event; a fork cannot be inserted
+    hash TEXT NOT NULL
+);
+
+CREATE INDEX idx_audit_events_actor ON audit_events(actor_id);
+CREATE INDEX idx_audit_events_target ON audit_events(target);
+CREATE INDEX idx_audit_events_created_at ON audit_events(created_at);
+
+-- Retention the audit log is pruned with, written by the API at startup. No
+-- row means events are kept forever.
+CREATE TABLE audit_retention (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    seconds BIGINT NOT NULL CHECK (seconds > 0)
+);
+
+-- Append-only: events are never changed
+CREATE FUNCTION audit_events_no_update() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_no_update
+    BEFORE UPDATE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION audit_events_no_update();
+
+-- Retention may only remove the oldest event, never the newest, and only
+-- once it is older than the retention period
+CREATE FUNCTION audit_events_prune_oldest() RETURNS trigger AS $$
+BEGIN
+    IF OLD.id != (SELECT MIN(id) FROM audit_events) OR OLD.id = (SELECT MAX(id) FROM audit_events)
+        OR NOT EXISTS (SELECT 1 FROM audit_retention WHERE OLD.created_at < EXTRACT(EPOCH FROM now())::BIGINT - seconds) THEN
+        RAISE EXCEPTION 'audit_events can only be pruned from the oldest event once it is past retention';
+    END IF;
+    RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_prune_oldest
+    BEFORE DELETE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION audit_events_prune_oldest();
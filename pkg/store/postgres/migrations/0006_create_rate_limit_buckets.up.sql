@@ -0,0 +1,10 @@
+-- Token buckets for ratelimit.SQLStore, shared by every API instance on
+-- this database. Mirrors SQLite migration 0010.
+CREATE TABLE rate_limit_buckets (
+    key TEXT PRIMARY KEY,                   -- Policy name and identity
+    tokens DOUBLE PRECISION NOT NULL,
+    updated_at BIGINT NOT NULL,             -- Unix milliseconds
+    full_at BIGINT NOT NULL                 -- Unix milliseconds; the row can be dropped after this
+);
+
+CREATE INDEX idx_rate_limit_buckets_full ON rate_limit_buckets(full_at);
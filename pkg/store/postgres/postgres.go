@@ -0,0 +1,71 @@
+// Package postgres implements the store repositories on PostgreSQL through
+// the pgx driver
+package postgres
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"time"
+
+	"secure-email-mvp/pkg/migrate"
+	"secure-email-mvp/pkg/store"
+	"secure-email-mvp/pkg/store/sqlstore"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// uniqueViolation is PostgreSQL's SQLSTATE for a duplicate key
+const uniqueViolation = "23505"
+
+//go:embed migrations/*.sql
+var migrationFS embed.FS
+
+// Migrations are the versioned PostgreSQL migrations, applied and tracked in
+// schema_migrations the same way as the SQLite ones
+var Migrations = migrate.Source{
+	FS:       migrationFS,
+	Dir:      "migrations",
+	Rebind:   sqlstore.Dollar,
+	HasTable: "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = ?",
+}
+
+// Dialect is PostgreSQL's: $n placeholders, TIMESTAMPTZ and split_part
+var Dialect = &sqlstore.Dialect{
+	Rebind:   sqlstore.Dollar,
+	IsUnique: isUnique,
+	// To the second, like SQLite
+	Time:        func(t time.Time) interface{} { return t.UTC().Truncate(time.Second) },
+	EmailDomain: "lower(split_part(email, '@', 2))",
+}
+
+// Open connects to the database at dsn, a postgres:// URL or key=value
+// connection string
+func Open(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Migrate applies every pending migration and returns how many were applied
+func Migrate(db *sql.DB) (int, error) {
+	return Migrations.Up(db)
+}
+
+// New returns every repository on db, which must already be migrated
+func New(db *sql.DB) *store.Store {
+	return sqlstore.New(db, Dialect)
+}
+
+// isUnique reports whether err is a UNIQUE or PRIMARY KEY violation
+func isUnique(err error) bool {
+	var pe *pgconn.PgError
+	return errors.As(err, &pe) && pe.Code == uniqueViolation
+}
@@ -0,0 +1,50 @@
+// Package sqlite implements the store repositories on the SQLite schema
+// applied by package migrate
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"secure-email-mvp/pkg/store"
+	"secure-email-mvp/pkg/store/sqlstore"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// timeLayout matches CURRENT_TIMESTAMP, so DATETIME and TIMESTAMP columns
+// written here and by column defaults sort and compare as text
+const timeLayout = "2006-01-02 15:04:05"
+
+// Dialect is SQLite's: ? placeholders, text timestamps and substr/instr
+var Dialect = &sqlstore.Dialect{
+	IsUnique:    isUnique,
+	Time:        func(t time.Time) interface{} { return t.UTC().Format(timeLayout) },
+	EmailDomain: "lower(substr(email, instr(email, '@') + 1))",
+}
+
+// Open opens the SQLite database file at path, creating it if need be
+func Open(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// New returns every repository on db, which must already be migrated
+func New(db *sql.DB) *store.Store {
+	return sqlstore.New(db, Dialect)
+}
+
+// isUnique reports whether err is a UNIQUE or PRIMARY KEY violation
+func isUnique(err error) bool {
+	var se sqlite3.Error
+	return errors.As(err, &se) &&
+		(se.ExtendedCode == sqlite3.ErrConstraintUnique || se.ExtendedCode == sqlite3.ErrConstraintPrimaryKey)
+}
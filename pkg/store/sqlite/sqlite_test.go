@@ -0,0 +1,26 @@
+package sqlite
+
+import (
+	"database/sql"
+	"testing"
+
+	"secure-email-mvp/pkg/migrate"
+	"secure-email-mvp/pkg/store"
+	"secure-email-mvp/pkg/store/storetest"
+)
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) (*store.Store, *sql.DB) {
+		db, err := sql.Open("sqlite3", ":memory:")
+		if err != nil {
+			t.Fatal("Failed to open database:", err)
+		}
+		// Each connection to :memory: is a separate database
+		db.SetMaxOpenConns(1)
+		if _, err := migrate.Up(db); err != nil {
+			t.Fatal("Failed to migrate database:", err)
+		}
+		t.Cleanup(func() { db.Close() })
+		return New(db), db
+	})
+}
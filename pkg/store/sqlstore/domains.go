@@ -0,0 +1,98 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"secure-email-mvp/pkg/store"
+)
+
+type domains struct {
+	*conn
+}
+
+// columns selects a Domain from domains d, counting the users whose address
+// is at it
+func (s *domains) columns() string {
+	return `d.name, d.signup, d.max_users, d.totp_issuer, d.created_at,
+		(SELECT COUNT(*) FROM users WHERE ` + s.d.EmailDomain + ` = d.name)`
+}
+
+func scanDomain(r row) (store.Domain, error) {
+	var d store.Domain
+	var createdAt sql.NullInt64
+	err := r.Scan(&d.Name, &d.SignUp, &d.MaxUsers, &d.TOTPIssuer, &createdAt, &d.Users)
+	if err == sql.ErrNoRows {
+		return store.Domain{}, store.ErrNotFound
+	}
+	if err != nil {
+		return store.Domain{}, fmt.Errorf("database error: %v", err)
+	}
+	d.CreatedAt = fromUnix(createdAt)
+	return d, nil
+}
+
+func (s *domains) Create(d store.Domain) error {
+	res, err := s.exec(
+		"INSERT INTO domains (name, signup, max_users, totp_issuer, created_at) VALUES (?, ?, ?, ?, ?) ON CONFLICT (name) DO NOTHING",
+		strings.ToLower(d.Name), d.SignUp, d.MaxUsers, d.TOTPIssuer, orNow(d.CreatedAt).Unix(),
+	)
+	if err != nil {
+		return fmt.Errorf("database insert error: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return store.ErrExists
+	}
+	return nil
+}
+
+func (s *domains) ByName(name string) (store.Domain, error) {
+	return scanDomain(s.queryRow("SELECT "+s.columns()+" FROM domains d WHERE d.name = ?", strings.ToLower(name)))
+}
+
+func (s *domains) List() ([]store.Domain, error) {
+	rows, err := s.query("SELECT " + s.columns() + " FROM domains d ORDER BY d.name")
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	list := []store.Domain{}
+	for rows.Next() {
+		d, err := scanDomain(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, d)
+	}
+	return list, rows.Err()
+}
+
+func (s *domains) Update(d store.Domain) error {
+	res, err := s.exec("UPDATE domains SET signup = ?, max_users = ?, totp_issuer = ? WHERE name = ?",
+		d.SignUp, d.MaxUsers, d.TOTPIssuer, strings.ToLower(d.Name))
+	if err != nil {
+		return fmt.Errorf("database update error: %v", err)
+	}
+	return affected(res)
+}
+
+func (s *domains) Delete(name string) error {
+	name = strings.ToLower(name)
+	return s.inTx(func(c *conn) error {
+		for _, q := range []string{
+			"DELETE FROM invite_redemptions WHERE invite_id IN (SELECT id FROM invites WHERE domain = ?)",
+			"DELETE FROM invites WHERE domain = ?",
+		} {
+			if _, err := c.exec(q, name); err != nil {
+				return fmt.Errorf("database delete error: %v", err)
+			}
+		}
+		res, err := c.exec("DELETE FROM domains WHERE name = ?", name)
+		if err != nil {
+			return fmt.Errorf("database delete error: %v", err)
+		}
+		return affected(res)
+	})
+}
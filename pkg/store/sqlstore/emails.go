@@ -0,0 +1,120 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"secure-email-mvp/pkg/store"
+)
+
+type emails struct {
+	*conn
+}
+
+const emailColumns = `e.id, e.sender_id, e.recipient_email, COALESCE(e.subject, ''), e.encrypted_content,
+	COALESCE(e.access_password_hash, ''), COALESCE(e.geolocation_circles, ''), e.expires_at, e.created_at`
+
+func scanEmail(r row) (store.Email, error) {
+	var e store.Email
+	var expiresAt, createdAt sql.NullTime
+	err := r.Scan(&e.ID, &e.SenderID, &e.RecipientEmail, &e.Subject, &e.EncryptedContent,
+		&e.AccessPasswordHash, &e.GeolocationCircles, &expiresAt, &createdAt)
+	if err == sql.ErrNoRows {
+		return store.Email{}, store.ErrNotFound
+	}
+	if err != nil {
+		return store.Email{}, fmt.Errorf("database error: %v", err)
+	}
+	e.ExpiresAt = fromTime(expiresAt)
+	e.CreatedAt = fromTime(createdAt)
+	return e, nil
+}
+
+// scanEmails reads every email from rows
+func scanEmails(rows *sql.Rows, err error) ([]store.Email, error) {
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	list := []store.Email{}
+	for rows.Next() {
+		e, err := scanEmail(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+	}
+	return list, rows.Err()
+}
+
+func (s *emails) Create(e store.Email) error {
+	_, err := s.exec(
+		`INSERT INTO emails (id, sender_id, recipient_email, subject, encrypted_content, access_password_hash,
+			geolocation_circles, expires_at, created_at)
+		VALUES (?, ?, ?, NULLIF(?, ''), ?, NULLIF(?, ''), NULLIF(?, ''), ?, ?)`,
+		e.ID, e.SenderID, e.RecipientEmail, e.Subject, e.EncryptedContent, e.AccessPasswordHash,
+		e.GeolocationCircles, s.time(e.ExpiresAt), s.time(orNow(e.CreatedAt)),
+	)
+	if s.d.IsUnique(err) {
+		return store.ErrExists
+	}
+	if err != nil {
+		return fmt.Errorf("database insert error: %v", err)
+	}
+	return nil
+}
+
+func (s *emails) ByID(id string) (store.Email, error) {
+	return scanEmail(s.queryRow("SELECT "+emailColumns+" FROM emails e WHERE e.id = ?", id))
+}
+
+func (s *emails) ListSent(senderID string, limit, offset int) ([]store.Email, error) {
+	return scanEmails(s.query("SELECT "+emailColumns+" FROM emails e WHERE e.sender_id = ? ORDER BY e.created_at DESC, e.id LIMIT ? OFFSET ?",
+		senderID, limit, offset))
+}
+
+func (s *emails) ListReceived(recipient string, limit, offset int) ([]store.Email, error) {
+	return scanEmails(s.query("SELECT "+emailColumns+" FROM emails e WHERE lower(e.recipient_email) = ? ORDER BY e.created_at DESC, e.id LIMIT ? OFFSET ?",
+		strings.ToLower(recipient), limit, offset))
+}
+
+// emailChildren deletes what refers to the emails matched by a condition on
+// emails, which the statements fill in
+var emailChildren = []string{
+	"DELETE FROM email_folders WHERE email_id IN (SELECT id FROM emails WHERE %s)",
+	"DELETE FROM access_attempts WHERE email_id IN (SELECT id FROM emails WHERE %s)",
+}
+
+// deleteWhere removes the emails matching cond with their children
+func (s *emails) deleteWhere(cond string, args ...interface{}) (int64, error) {
+	var n int64
+	err := s.inTx(func(c *conn) error {
+		for _, q := range emailChildren {
+			if _, err := c.exec(fmt.Sprintf(q, cond), args...); err != nil {
+				return fmt.Errorf("database delete error: %v", err)
+			}
+		}
+		res, err := c.exec("DELETE FROM emails WHERE "+cond, args...)
+		if err != nil {
+			return fmt.Errorf("database delete error: %v", err)
+		}
+		n, err = res.RowsAffected()
+		return err
+	})
+	return n, err
+}
+
+func (s *emails) Delete(id string) error {
+	n, err := s.deleteWhere("id = ?", id)
+	if err == nil && n == 0 {
+		return store.ErrNotFound
+	}
+	return err
+}
+
+func (s *emails) DeleteExpired(now time.Time) (int64, error) {
+	return s.deleteWhere("expires_at IS NOT NULL AND expires_at <= ?", s.time(now))
+}
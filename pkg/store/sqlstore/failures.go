@@ -0,0 +1,72 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"secure-email-mvp/pkg/store"
+)
+
+type failures struct {
+	*conn
+}
+
+func (s *failures) Get(email string) (store.LoginFailure, error) {
+	f := store.LoginFailure{Email: strings.ToLower(email)}
+	var lastAt sql.NullInt64
+	err := s.queryRow("SELECT failures, last_failure_at FROM login_failures WHERE email = ?", f.Email).Scan(&f.Failures, &lastAt)
+	if err == sql.ErrNoRows {
+		return store.LoginFailure{}, store.ErrNotFound
+	}
+	if err != nil {
+		return store.LoginFailure{}, fmt.Errorf("database error: %v", err)
+	}
+	f.LastAt = fromUnix(lastAt)
+	return f, nil
+}
+
+func (s *failures) Create(f store.LoginFailure) error {
+	res, err := s.exec("INSERT INTO login_failures (email, failures, last_failure_at) VALUES (?, ?, ?) ON CONFLICT (email) DO NOTHING",
+		strings.ToLower(f.Email), f.Failures, f.LastAt.Unix())
+	if err != nil {
+		return fmt.Errorf("database insert error: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return store.ErrExists
+	}
+	return nil
+}
+
+func (s *failures) Replace(old, next store.LoginFailure) error {
+	res, err := s.exec("UPDATE login_failures SET failures = ?, last_failure_at = ? WHERE email = ? AND failures = ? AND last_failure_at = ?",
+		next.Failures, next.LastAt.Unix(), strings.ToLower(old.Email), old.Failures, old.LastAt.Unix())
+	if err != nil {
+		return fmt.Errorf("database update error: %v", err)
+	}
+	return affected(res)
+}
+
+func (s *failures) Release(email string) error {
+	if _, err := s.exec("UPDATE login_failures SET failures = failures - 1 WHERE email = ? AND failures > 0", strings.ToLower(email)); err != nil {
+		return fmt.Errorf("database update error: %v", err)
+	}
+	return nil
+}
+
+func (s *failures) Delete(email string) error {
+	res, err := s.exec("DELETE FROM login_failures WHERE email = ?", strings.ToLower(email))
+	if err != nil {
+		return fmt.Errorf("database delete error: %v", err)
+	}
+	return affected(res)
+}
+
+func (s *failures) DeleteBefore(t time.Time) (int64, error) {
+	res, err := s.exec("DELETE FROM login_failures WHERE last_failure_at <= ?", t.Unix())
+	if err != nil {
+		return 0, fmt.Errorf("database delete error: %v", err)
+	}
+	return res.RowsAffected()
+}
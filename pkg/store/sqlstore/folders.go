@@ -0,0 +1,123 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"fmt"
+
+	"secure-email-mvp/pkg/store"
+)
+
+type folders struct {
+	*conn
+}
+
+const folderColumns = "id, user_id, name, created_at"
+
+func scanFolder(r row) (store.Folder, error) {
+	var f store.Folder
+	var createdAt sql.NullTime
+	err := r.Scan(&f.ID, &f.UserID, &f.Name, &createdAt)
+	if err == sql.ErrNoRows {
+		return store.Folder{}, store.ErrNotFound
+	}
+	if err != nil {
+		return store.Folder{}, fmt.Errorf("database error: %v", err)
+	}
+	f.CreatedAt = fromTime(createdAt)
+	return f, nil
+}
+
+func (s *folders) Create(f store.Folder) error {
+	_, err := s.exec("INSERT INTO folders (id, user_id, name, created_at) VALUES (?, ?, ?, ?)",
+		f.ID, f.UserID, f.Name, s.time(orNow(f.CreatedAt)))
+	if s.d.IsUnique(err) {
+		return store.ErrExists
+	}
+	if err != nil {
+		return fmt.Errorf("database insert error: %v", err)
+	}
+	return nil
+}
+
+func (s *folders) ByID(id string) (store.Folder, error) {
+	return scanFolder(s.queryRow("SELECT "+folderColumns+" FROM folders WHERE id = ?", id))
+}
+
+func (s *folders) List(userID string) ([]store.Folder, error) {
+	rows, err := s.query("SELECT "+folderColumns+" FROM folders WHERE user_id = ? ORDER BY name, id", userID)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	list := []store.Folder{}
+	for rows.Next() {
+		f, err := scanFolder(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, f)
+	}
+	return list, rows.Err()
+}
+
+func (s *folders) Rename(id, name string) error {
+	res, err := s.exec("UPDATE folders SET name = ? WHERE id = ?", name, id)
+	if err != nil {
+		return fmt.Errorf("database update error: %v", err)
+	}
+	return affected(res)
+}
+
+func (s *folders) Delete(id string) error {
+	return s.inTx(func(c *conn) error {
+		if _, err := c.exec("DELETE FROM email_folders WHERE folder_id = ?", id); err != nil {
+			return fmt.Errorf("database delete error: %v", err)
+		}
+		res, err := c.exec("DELETE FROM folders WHERE id = ?", id)
+		if err != nil {
+			return fmt.Errorf("database delete error: %v", err)
+		}
+		return affected(res)
+	})
+}
+
+func (s *folders) AddEmail(folderID, emailID string) error {
+	// The join inserts nothing unless both exist, as SQLite does not enforce
+	// the foreign keys
+	res, err := s.exec(
+		`INSERT INTO email_folders (email_id, folder_id)
+		SELECT e.id, f.id FROM emails e, folders f WHERE e.id = ? AND f.id = ?
+		ON CONFLICT (email_id, folder_id) DO NOTHING`,
+		emailID, folderID,
+	)
+	if err != nil {
+		return fmt.Errorf("database insert error: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+	// Nothing inserted: already filed, or the folder or email is missing
+	var filed int
+	if err := s.queryRow("SELECT COUNT(*) FROM email_folders WHERE email_id = ? AND folder_id = ?", emailID, folderID).Scan(&filed); err != nil {
+		return fmt.Errorf("database error: %v", err)
+	}
+	if filed == 0 {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+func (s *folders) RemoveEmail(folderID, emailID string) error {
+	res, err := s.exec("DELETE FROM email_folders WHERE folder_id = ? AND email_id = ?", folderID, emailID)
+	if err != nil {
+		return fmt.Errorf("database delete error: %v", err)
+	}
+	return affected(res)
+}
+
+func (s *folders) Emails(folderID string) ([]store.Email, error) {
+	return scanEmails(s.query(
+		"SELECT "+emailColumns+` FROM emails e JOIN email_folders ef ON ef.email_id = e.id
+		WHERE ef.folder_id = ? ORDER BY e.created_at DESC, e.id`, folderID))
+}
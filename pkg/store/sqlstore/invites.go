@@ -0,0 +1,99 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"secure-email-mvp/pkg/store"
+)
+
+type invites struct {
+	*conn
+}
+
+const inviteColumns = "id, code_hash, domain, COALESCE(email, ''), max_uses, uses, created_by, created_at, expires_at"
+
+func scanInvite(r row) (store.Invite, error) {
+	var inv store.Invite
+	var createdAt, expiresAt sql.NullInt64
+	err := r.Scan(&inv.ID, &inv.CodeHash, &inv.Domain, &inv.Email, &inv.MaxUses, &inv.Uses, &inv.CreatedBy, &createdAt, &expiresAt)
+	if err == sql.ErrNoRows {
+		return store.Invite{}, store.ErrNotFound
+	}
+	if err != nil {
+		return store.Invite{}, fmt.Errorf("database error: %v", err)
+	}
+	inv.CreatedAt = fromUnix(createdAt)
+	inv.ExpiresAt = fromUnix(expiresAt)
+	return inv, nil
+}
+
+func (s *invites) Create(inv store.Invite) error {
+	_, err := s.exec(
+		`INSERT INTO invites (id, code_hash, domain, email, max_uses, uses, created_by, created_at, expires_at)
+		VALUES (?, ?, ?, NULLIF(?, ''), ?, ?, ?, ?, ?)`,
+		inv.ID, inv.CodeHash, strings.ToLower(inv.Domain), inv.Email, inv.MaxUses, inv.Uses, inv.CreatedBy,
+		orNow(inv.CreatedAt).Unix(), inv.ExpiresAt.Unix(),
+	)
+	if s.d.IsUnique(err) {
+		return store.ErrExists
+	}
+	if err != nil {
+		return fmt.Errorf("database insert error: %v", err)
+	}
+	return nil
+}
+
+func (s *invites) ByCodeHash(hash string) (store.Invite, error) {
+	return scanInvite(s.queryRow("SELECT "+inviteColumns+" FROM invites WHERE code_hash = ?", hash))
+}
+
+func (s *invites) ListOutstanding(domain string, now time.Time) ([]store.Invite, error) {
+	q := "SELECT " + inviteColumns + " FROM invites WHERE uses < max_uses AND expires_at > ?"
+	args := []interface{}{now.Unix()}
+	if domain != "" {
+		q += " AND domain = ?"
+		args = append(args, strings.ToLower(domain))
+	}
+	rows, err := s.query(q+" ORDER BY created_at DESC, id", args...)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	list := []store.Invite{}
+	for rows.Next() {
+		inv, err := scanInvite(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, inv)
+	}
+	return list, rows.Err()
+}
+
+func (s *invites) Redeem(id, userID string, now time.Time) error {
+	return s.inTx(func(c *conn) error {
+		res, err := c.exec("UPDATE invites SET uses = uses + 1 WHERE id = ? AND uses < max_uses AND expires_at > ?", id, now.Unix())
+		if err != nil {
+			return fmt.Errorf("database update error: %v", err)
+		}
+		if err := affected(res); err != nil {
+			return err
+		}
+		if _, err := c.exec("INSERT INTO invite_redemptions (invite_id, user_id, redeemed_at) VALUES (?, ?, ?)", id, userID, now.Unix()); err != nil {
+			return fmt.Errorf("database insert error: %v", err)
+		}
+		return nil
+	})
+}
+
+func (s *invites) Revoke(id string, now time.Time) error {
+	res, err := s.exec("UPDATE invites SET expires_at = ? WHERE id = ? AND expires_at > ? AND uses < max_uses", now.Unix(), id, now.Unix())
+	if err != nil {
+		return fmt.Errorf("database update error: %v", err)
+	}
+	return affected(res)
+}
@@ -0,0 +1,150 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"secure-email-mvp/pkg/store"
+)
+
+type passkeys struct {
+	*conn
+}
+
+// flag stores a bool in the INTEGER flag columns both schemas use
+func flag(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+const passkeyColumns = `id, user_id, name, public_key, attestation_type, aaguid, transports, sign_count,
+	backup_eligible, backup_state, clone_warning, created_at, last_used_at`
+
+func (s *passkeys) Create(p store.Passkey) error {
+	_, err := s.exec(
+		`INSERT INTO webauthn_credentials
+			(id, user_id, name, public_key, attestation_type, aaguid, transports, sign_count, backup_eligible, backup_state, clone_warning, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		p.ID, p.UserID, p.Name, p.PublicKey, p.AttestationType, p.AAGUID, p.Transports, int64(p.SignCount),
+		flag(p.BackupEligible), flag(p.BackupState), flag(p.CloneWarning), orNow(p.CreatedAt).Unix(),
+	)
+	if s.d.IsUnique(err) {
+		return store.ErrExists
+	}
+	if err != nil {
+		return fmt.Errorf("database insert error: %v", err)
+	}
+	return nil
+}
+
+func (s *passkeys) List(userID string) ([]store.Passkey, error) {
+	rows, err := s.query("SELECT "+passkeyColumns+" FROM webauthn_credentials WHERE user_id = ? ORDER BY created_at, id", userID)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	list := []store.Passkey{}
+	for rows.Next() {
+		var (
+			p                                      store.Passkey
+			signCount                              int64
+			backupEligible, backupState, cloneFlag int
+			createdAt, lastUsedAt                  sql.NullInt64
+		)
+		err := rows.Scan(&p.ID, &p.UserID, &p.Name, &p.PublicKey, &p.AttestationType, &p.AAGUID, &p.Transports, &signCount,
+			&backupEligible, &backupState, &cloneFlag, &createdAt, &lastUsedAt)
+		if err != nil {
+			return nil, fmt.Errorf("database error: %v", err)
+		}
+		p.SignCount = uint32(signCount)
+		p.BackupEligible = backupEligible != 0
+		p.BackupState = backupState != 0
+		p.CloneWarning = cloneFlag != 0
+		p.CreatedAt = fromUnix(createdAt)
+		p.LastUsedAt = fromUnix(lastUsedAt)
+		list = append(list, p)
+	}
+	return list, rows.Err()
+}
+
+func (s *passkeys) RecordUse(id string, signCount uint32, backupState bool, at time.Time) error {
+	res, err := s.exec("UPDATE webauthn_credentials SET sign_count = ?, backup_state = ?, last_used_at = ? WHERE id = ?",
+		int64(signCount), flag(backupState), at.Unix(), id)
+	if err != nil {
+		return fmt.Errorf("database update error: %v", err)
+	}
+	return affected(res)
+}
+
+func (s *passkeys) Flag(id string) error {
+	res, err := s.exec("UPDATE webauthn_credentials SET clone_warning = 1 WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("database update error: %v", err)
+	}
+	return affected(res)
+}
+
+func (s *passkeys) Delete(userID, id string) error {
+	res, err := s.exec("DELETE FROM webauthn_credentials WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return fmt.Errorf("database delete error: %v", err)
+	}
+	return affected(res)
+}
+
+type challenges struct {
+	*conn
+}
+
+func (s *challenges) Save(c store.Challenge, now time.Time) error {
+	return s.inTx(func(tc *conn) error {
+		if _, err := tc.exec("DELETE FROM webauthn_challenges WHERE expires_at <= ?", now.Unix()); err != nil {
+			return fmt.Errorf("database delete error: %v", err)
+		}
+		var owner interface{}
+		if c.UserID != "" {
+			owner = c.UserID
+		}
+		_, err := tc.exec("INSERT INTO webauthn_challenges (id, user_id, ceremony, session_data, expires_at) VALUES (?, ?, ?, ?, ?)",
+			c.ID, owner, c.Ceremony, c.SessionData, c.ExpiresAt.Unix())
+		if tc.d.IsUnique(err) {
+			return store.ErrExists
+		}
+		if err != nil {
+			return fmt.Errorf("database insert error: %v", err)
+		}
+		return nil
+	})
+}
+
+func (s *challenges) Take(id, ceremony string) (store.Challenge, error) {
+	c := store.Challenge{ID: id, Ceremony: ceremony}
+	err := s.inTx(func(tc *conn) error {
+		var userID sql.NullString
+		var expiresAt sql.NullInt64
+		err := tc.queryRow("SELECT user_id, session_data, expires_at FROM webauthn_challenges WHERE id = ? AND ceremony = ?",
+			id, ceremony).Scan(&userID, &c.SessionData, &expiresAt)
+		if err == sql.ErrNoRows {
+			return store.ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("database error: %v", err)
+		}
+		c.UserID = userID.String
+		c.ExpiresAt = fromUnix(expiresAt)
+		// Only the attempt that deletes it may answer it
+		res, err := tc.exec("DELETE FROM webauthn_challenges WHERE id = ?", id)
+		if err != nil {
+			return fmt.Errorf("database delete error: %v", err)
+		}
+		return affected(res)
+	})
+	if err != nil {
+		return store.Challenge{}, err
+	}
+	return c, nil
+}
@@ -0,0 +1,68 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"secure-email-mvp/pkg/store"
+)
+
+// pending keeps enrollments in temp_totp so they survive restarts and are
+// shared between API instances
+type pending struct {
+	*conn
+}
+
+func (s *pending) Save(tempID string, p store.PendingEnrollment) error {
+	_, err := s.exec(
+		`INSERT INTO temp_totp (temp_id, email, password_hash, totp_secret, invite_id, expires_at)
+		VALUES (?, ?, ?, ?, NULLIF(?, ''), ?)
+		ON CONFLICT (temp_id) DO UPDATE SET email = excluded.email, password_hash = excluded.password_hash,
+			totp_secret = excluded.totp_secret, invite_id = excluded.invite_id, expires_at = excluded.expires_at`,
+		tempID, p.Email, p.PasswordHash, p.TotpSecret, p.InviteID, p.ExpiresAt.Unix(),
+	)
+	if err != nil {
+		return fmt.Errorf("database insert error: %v", err)
+	}
+	return nil
+}
+
+func (s *pending) Load(tempID string) (store.PendingEnrollment, error) {
+	var p store.PendingEnrollment
+	var expiresAt int64
+	err := s.queryRow(
+		"SELECT email, password_hash, totp_secret, COALESCE(invite_id, ''), expires_at FROM temp_totp WHERE temp_id = ?", tempID,
+	).Scan(&p.Email, &p.PasswordHash, &p.TotpSecret, &p.InviteID, &expiresAt)
+	if err == sql.ErrNoRows {
+		return store.PendingEnrollment{}, store.ErrNotFound
+	}
+	if err != nil {
+		return store.PendingEnrollment{}, fmt.Errorf("database error: %v", err)
+	}
+	p.ExpiresAt = time.Unix(expiresAt, 0)
+	return p, nil
+}
+
+func (s *pending) Delete(tempID string) error {
+	if _, err := s.exec("DELETE FROM temp_totp WHERE temp_id = ?", tempID); err != nil {
+		return fmt.Errorf("database delete error: %v", err)
+	}
+	return nil
+}
+
+func (s *pending) DeleteExpired(now time.Time) (int64, error) {
+	res, err := s.exec("DELETE FROM temp_totp WHERE expires_at <= ?", now.Unix())
+	if err != nil {
+		return 0, fmt.Errorf("database delete error: %v", err)
+	}
+	return res.RowsAffected()
+}
+
+func (s *pending) Count(now time.Time) (int, error) {
+	var n int
+	if err := s.queryRow("SELECT COUNT(*) FROM temp_totp WHERE expires_at > ?", now.Unix()).Scan(&n); err != nil {
+		return 0, fmt.Errorf("database error: %v", err)
+	}
+	return n, nil
+}
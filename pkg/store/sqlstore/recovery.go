@@ -0,0 +1,45 @@
+package sqlstore
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type recovery struct {
+	*conn
+}
+
+func (s *recovery) Replace(userID string, hashes []string, at time.Time) error {
+	return s.inTx(func(c *conn) error {
+		if _, err := c.exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+			return fmt.Errorf("database delete error: %v", err)
+		}
+		for _, hash := range hashes {
+			_, err := c.exec("INSERT INTO recovery_codes (id, user_id, code_hash, created_at) VALUES (?, ?, ?, ?)",
+				uuid.New().String(), userID, hash, at.Unix())
+			if err != nil {
+				return fmt.Errorf("database insert error: %v", err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s *recovery) Use(userID, hash string, at time.Time) error {
+	res, err := s.exec("UPDATE recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL",
+		at.Unix(), userID, hash)
+	if err != nil {
+		return fmt.Errorf("database update error: %v", err)
+	}
+	return affected(res)
+}
+
+func (s *recovery) CountUnused(userID string) (int, error) {
+	var n int
+	if err := s.queryRow("SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL", userID).Scan(&n); err != nil {
+		return 0, fmt.Errorf("database error: %v", err)
+	}
+	return n, nil
+}
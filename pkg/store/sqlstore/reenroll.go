@@ -0,0 +1,52 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"secure-email-mvp/pkg/store"
+)
+
+type reenroll struct {
+	*conn
+}
+
+func (s *reenroll) Start(r store.TOTPReenrollment, now time.Time) error {
+	return s.inTx(func(c *conn) error {
+		if _, err := c.exec("DELETE FROM totp_reenrollments WHERE user_id = ? OR expires_at <= ?", r.UserID, now.Unix()); err != nil {
+			return fmt.Errorf("database delete error: %v", err)
+		}
+		_, err := c.exec("INSERT INTO totp_reenrollments (temp_id, user_id, totp_secret, expires_at) VALUES (?, ?, ?, ?)",
+			r.TempID, r.UserID, r.TOTPSecret, r.ExpiresAt.Unix())
+		if c.d.IsUnique(err) {
+			return store.ErrExists
+		}
+		if err != nil {
+			return fmt.Errorf("database insert error: %v", err)
+		}
+		return nil
+	})
+}
+
+func (s *reenroll) Load(tempID, userID string) (store.TOTPReenrollment, error) {
+	r := store.TOTPReenrollment{TempID: tempID, UserID: userID}
+	var expiresAt sql.NullInt64
+	err := s.queryRow("SELECT totp_secret, expires_at FROM totp_reenrollments WHERE temp_id = ? AND user_id = ?",
+		tempID, userID).Scan(&r.TOTPSecret, &expiresAt)
+	if err == sql.ErrNoRows {
+		return store.TOTPReenrollment{}, store.ErrNotFound
+	}
+	if err != nil {
+		return store.TOTPReenrollment{}, fmt.Errorf("database error: %v", err)
+	}
+	r.ExpiresAt = fromUnix(expiresAt)
+	return r, nil
+}
+
+func (s *reenroll) DeleteUser(userID string) error {
+	if _, err := s.exec("DELETE FROM totp_reenrollments WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("database delete error: %v", err)
+	}
+	return nil
+}
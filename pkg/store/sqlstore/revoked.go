@@ -0,0 +1,34 @@
+package sqlstore
+
+import (
+	"fmt"
+	"time"
+)
+
+type revoked struct {
+	*conn
+}
+
+func (s *revoked) Add(jti string, expiresAt time.Time) error {
+	_, err := s.exec("INSERT INTO revoked_tokens (jti, expires_at) VALUES (?, ?) ON CONFLICT (jti) DO NOTHING", jti, expiresAt.Unix())
+	if err != nil {
+		return fmt.Errorf("database insert error: %v", err)
+	}
+	return nil
+}
+
+func (s *revoked) Contains(jti string) (bool, error) {
+	var n int
+	if err := s.queryRow("SELECT COUNT(*) FROM revoked_tokens WHERE jti = ?", jti).Scan(&n); err != nil {
+		return false, fmt.Errorf("database error: %v", err)
+	}
+	return n > 0, nil
+}
+
+func (s *revoked) DeleteExpired(now time.Time) (int64, error) {
+	res, err := s.exec("DELETE FROM revoked_tokens WHERE expires_at <= ?", now.Unix())
+	if err != nil {
+		return 0, fmt.Errorf("database delete error: %v", err)
+	}
+	return res.RowsAffected()
+}
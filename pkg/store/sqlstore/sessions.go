@@ -0,0 +1,157 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"secure-email-mvp/pkg/store"
+)
+
+type sessions struct {
+	*conn
+}
+
+const sessionColumns = `s.id, s.user_id, s.refresh_hash, COALESCE(s.ip_address, ''), COALESCE(s.user_agent, ''),
+	s.created_at, s.last_seen_at, s.expires_at, s.revoked_at`
+
+func scanSession(r row) (store.Session, error) {
+	var s store.Session
+	var created, lastSeen, expires int64
+	var revokedAt sql.NullInt64
+	err := r.Scan(&s.ID, &s.UserID, &s.RefreshHash, &s.IP, &s.UserAgent, &created, &lastSeen, &expires, &revokedAt)
+	if err == sql.ErrNoRows {
+		return store.Session{}, store.ErrNotFound
+	}
+	if err != nil {
+		return store.Session{}, fmt.Errorf("database error: %v", err)
+	}
+	s.CreatedAt = time.Unix(created, 0)
+	s.LastSeenAt = time.Unix(lastSeen, 0)
+	s.ExpiresAt = time.Unix(expires, 0)
+	s.RevokedAt = fromUnix(revokedAt)
+	return s, nil
+}
+
+func (r *sessions) Create(s store.Session) error {
+	// Checked in the insert itself so a concurrent disable cannot slip
+	// between the check and the new session. The casts give PostgreSQL the
+	// types it cannot infer from a SELECT list.
+	res, err := r.exec(
+		`INSERT INTO sessions (id, user_id, refresh_hash, ip_address, user_agent, created_at, last_seen_at, expires_at, revoked_at)
+		SELECT ?, ?, ?, ?, ?, CAST(? AS BIGINT), CAST(? AS BIGINT), CAST(? AS BIGINT), CAST(? AS BIGINT)
+		WHERE NOT EXISTS (SELECT 1 FROM users WHERE id = ? AND disabled_at IS NOT NULL)`,
+		s.ID, s.UserID, s.RefreshHash, s.IP, s.UserAgent, s.CreatedAt.Unix(), s.LastSeenAt.Unix(), s.ExpiresAt.Unix(), nullUnix(s.RevokedAt), s.UserID,
+	)
+	if r.d.IsUnique(err) {
+		return store.ErrExists
+	}
+	if err != nil {
+		return fmt.Errorf("database insert error: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return store.ErrDisabled
+	}
+	return nil
+}
+
+func (r *sessions) ByID(id string) (store.Session, error) {
+	return scanSession(r.queryRow("SELECT "+sessionColumns+" FROM sessions s WHERE s.id = ?", id))
+}
+
+func (r *sessions) ByRefreshHash(hash string) (store.Session, error) {
+	return scanSession(r.queryRow("SELECT "+sessionColumns+" FROM sessions s WHERE s.refresh_hash = ?", hash))
+}
+
+func (r *sessions) ByUsedRefreshHash(hash string) (store.Session, error) {
+	return scanSession(r.queryRow(
+		"SELECT "+sessionColumns+" FROM used_refresh_tokens u JOIN sessions s ON s.id = u.session_id WHERE u.token_hash = ?", hash))
+}
+
+func (r *sessions) Rotate(oldHash string, next store.Session) error {
+	return r.inTx(func(c *conn) error {
+		res, err := c.exec(
+			`UPDATE sessions SET refresh_hash = ?, ip_address = ?, user_agent = ?, last_seen_at = ?, expires_at = ?
+			WHERE id = ? AND refresh_hash = ?`,
+			next.RefreshHash, next.IP, next.UserAgent, next.LastSeenAt.Unix(), next.ExpiresAt.Unix(), next.ID, oldHash,
+		)
+		if c.d.IsUnique(err) {
+			return store.ErrExists
+		}
+		if err != nil {
+			return fmt.Errorf("database update error: %v", err)
+		}
+		if err := affected(res); err != nil {
+			return err
+		}
+		if _, err := c.exec("INSERT INTO used_refresh_tokens (token_hash, session_id, used_at) VALUES (?, ?, ?)",
+			oldHash, next.ID, next.LastSeenAt.Unix()); err != nil {
+			return fmt.Errorf("database insert error: %v", err)
+		}
+		return nil
+	})
+}
+
+func (r *sessions) Touch(id string, at time.Time) error {
+	res, err := r.exec("UPDATE sessions SET last_seen_at = ? WHERE id = ?", at.Unix(), id)
+	if err != nil {
+		return fmt.Errorf("database update error: %v", err)
+	}
+	return affected(res)
+}
+
+func (r *sessions) Revoke(id string, at time.Time) error {
+	res, err := r.exec("UPDATE sessions SET revoked_at = COALESCE(revoked_at, ?) WHERE id = ?", at.Unix(), id)
+	if err != nil {
+		return fmt.Errorf("database update error: %v", err)
+	}
+	return affected(res)
+}
+
+func (r *sessions) RevokeAll(userID, exceptID string, at time.Time) (int64, error) {
+	res, err := r.exec("UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND id != ? AND revoked_at IS NULL",
+		at.Unix(), userID, exceptID)
+	if err != nil {
+		return 0, fmt.Errorf("database update error: %v", err)
+	}
+	return res.RowsAffected()
+}
+
+func (r *sessions) ListActive(userID string, now time.Time) ([]store.Session, error) {
+	rows, err := r.query(
+		`SELECT `+sessionColumns+` FROM sessions s
+		WHERE s.user_id = ? AND s.revoked_at IS NULL AND s.expires_at > ?
+		ORDER BY s.last_seen_at DESC, s.id`,
+		userID, now.Unix(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	list := []store.Session{}
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, s)
+	}
+	return list, rows.Err()
+}
+
+func (r *sessions) DeleteExpired(now time.Time) (int64, error) {
+	var n int64
+	err := r.inTx(func(c *conn) error {
+		if _, err := c.exec("DELETE FROM used_refresh_tokens WHERE session_id IN (SELECT id FROM sessions WHERE expires_at <= ?)", now.Unix()); err != nil {
+			return fmt.Errorf("database delete error: %v", err)
+		}
+		res, err := c.exec("DELETE FROM sessions WHERE expires_at <= ?", now.Unix())
+		if err != nil {
+			return fmt.Errorf("database delete error: %v", err)
+		}
+		n, err = res.RowsAffected()
+		return err
+	})
+	return n, err
+}
@@ -0,0 +1,184 @@
+// Package sqlstore implements the store repositories once for every SQL
+// database. Queries use ? placeholders and SQL both SQLite and PostgreSQL
+// accept; a Dialect rebinds them for the driver and supplies the little
+// that differs.
+package sqlstore
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"secure-email-mvp/pkg/store"
+)
+
+// Dialect is what a backend does differently
+type Dialect struct {
+	// Rebind rewrites the ? placeholders in a query for the driver; nil
+	// keeps them as they are
+	Rebind func(query string) string
+	// IsUnique reports whether err is a UNIQUE or PRIMARY KEY violation
+	IsUnique func(err error) bool
+	// Time converts a non-zero time for a DATETIME or TIMESTAMPTZ column
+	Time func(t time.Time) interface{}
+	// EmailDomain is an SQL expression for the lower-case domain of the
+	// email column
+	EmailDomain string
+}
+
+// Dollar numbers ? placeholders $1, $2, ... as PostgreSQL wants. Question
+// marks inside quoted strings are left alone.
+func Dollar(query string) string {
+	var b strings.Builder
+	n, quoted := 0, false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case r == '?' && !quoted:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// New returns every repository on db, which must already be migrated
+func New(db *sql.DB, d *Dialect) *store.Store {
+	return (&conn{db: db, d: d}).store()
+}
+
+// conn is what every repository shares: the database, the transaction the
+// repositories are bound to if any, and the dialect
+type conn struct {
+	db *sql.DB
+	tx *sql.Tx
+	d  *Dialect
+}
+
+func (c *conn) store() *store.Store {
+	repos := store.Store{
+		Users:      &users{c},
+		Pending:    &pending{c},
+		Sessions:   &sessions{c},
+		Revoked:    &revoked{c},
+		Emails:     &emails{c},
+		Folders:    &folders{c},
+		Domains:    &domains{c},
+		Invites:    &invites{c},
+		Recovery:   &recovery{c},
+		Failures:   &failures{c},
+		Reenroll:   &reenroll{c},
+		Passkeys:   &passkeys{c},
+		Challenges: &challenges{c},
+	}
+	return store.New(repos, func(tx *sql.Tx) *store.Store {
+		return (&conn{db: c.db, tx: tx, d: c.d}).store()
+	})
+}
+
+func (c *conn) rebind(query string) string {
+	if c.d.Rebind == nil {
+		return query
+	}
+	return c.d.Rebind(query)
+}
+
+func (c *conn) exec(query string, args ...interface{}) (sql.Result, error) {
+	if c.tx != nil {
+		return c.tx.Exec(c.rebind(query), args...)
+	}
+	return c.db.Exec(c.rebind(query), args...)
+}
+
+func (c *conn) query(query string, args ...interface{}) (*sql.Rows, error) {
+	if c.tx != nil {
+		return c.tx.Query(c.rebind(query), args...)
+	}
+	return c.db.Query(c.rebind(query), args...)
+}
+
+func (c *conn) queryRow(query string, args ...interface{}) *sql.Row {
+	if c.tx != nil {
+		return c.tx.QueryRow(c.rebind(query), args...)
+	}
+	return c.db.QueryRow(c.rebind(query), args...)
+}
+
+// inTx runs fn in the transaction the repositories are bound to, or in a
+// new one committed if fn succeeds
+func (c *conn) inTx(fn func(c *conn) error) error {
+	if c.tx != nil {
+		return fn(c)
+	}
+	tx, err := c.db.Begin()
+	if err != nil {
+		return fmt.Errorf("database error: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(&conn{db: c.db, tx: tx, d: c.d}); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("database commit error: %v", err)
+	}
+	return nil
+}
+
+// time writes t to a DATETIME or TIMESTAMPTZ column, or NULL if zero
+func (c *conn) time(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return c.d.Time(t)
+}
+
+// row is satisfied by *sql.Row and *sql.Rows
+type row interface {
+	Scan(dest ...interface{}) error
+}
+
+// affected returns store.ErrNotFound when res changed no rows
+func affected(res sql.Result) error {
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+// nullUnix stores a zero time as NULL in a Unix-seconds column
+func nullUnix(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.Unix()
+}
+
+func fromUnix(n sql.NullInt64) time.Time {
+	if !n.Valid {
+		return time.Time{}
+	}
+	return time.Unix(n.Int64, 0)
+}
+
+func fromTime(t sql.NullTime) time.Time {
+	if !t.Valid {
+		return time.Time{}
+	}
+	return t.Time
+}
+
+// orNow defaults an unset creation time to the current second
+func orNow(t time.Time) time.Time {
+	if t.IsZero() {
+		return time.Now()
+	}
+	return t
+}
@@ -0,0 +1,219 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"secure-email-mvp/pkg/store"
+)
+
+type users struct {
+	*conn
+}
+
+const userColumns = "id, email, password_hash, totp_secret, COALESCE(totp_last_step, 0), role, disabled_at, created_at"
+
+func scanUser(r row) (store.User, error) {
+	var u store.User
+	var disabledAt sql.NullInt64
+	var createdAt sql.NullTime
+	err := r.Scan(&u.ID, &u.Email, &u.PasswordHash, &u.TOTPSecret, &u.TOTPLastStep, &u.Role, &disabledAt, &createdAt)
+	if err == sql.ErrNoRows {
+		return store.User{}, store.ErrNotFound
+	}
+	if err != nil {
+		return store.User{}, fmt.Errorf("database error: %v", err)
+	}
+	u.DisabledAt = fromUnix(disabledAt)
+	u.CreatedAt = fromTime(createdAt)
+	return u, nil
+}
+
+func (s *users) Create(u store.User) error {
+	role := u.Role
+	if role == "" {
+		role = "user"
+	}
+	var lastStep interface{}
+	if u.TOTPLastStep != 0 {
+		lastStep = u.TOTPLastStep
+	}
+	_, err := s.exec(
+		`INSERT INTO users (id, email, password_hash, totp_secret, totp_last_step, role, disabled_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		u.ID, u.Email, u.PasswordHash, u.TOTPSecret, lastStep, role, nullUnix(u.DisabledAt), s.time(orNow(u.CreatedAt)),
+	)
+	if s.d.IsUnique(err) {
+		return store.ErrExists
+	}
+	if err != nil {
+		return fmt.Errorf("database insert error: %v", err)
+	}
+	return nil
+}
+
+func (s *users) ByID(id string) (store.User, error) {
+	return scanUser(s.queryRow("SELECT "+userColumns+" FROM users WHERE id = ?", id))
+}
+
+func (s *users) ByEmail(email string) (store.User, error) {
+	return scanUser(s.queryRow("SELECT "+userColumns+" FROM users WHERE lower(email) = ?", strings.ToLower(email)))
+}
+
+// likeEscaper escapes LIKE wildcards so a search matches them literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (s *users) List(f store.UserFilter) ([]store.User, int, error) {
+	var where []string
+	var args []interface{}
+	if f.Query != "" {
+		where = append(where, `lower(email) LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+likeEscaper.Replace(strings.ToLower(f.Query))+"%")
+	}
+	if f.Domain != "" {
+		where = append(where, s.d.EmailDomain+" = ?")
+		args = append(args, strings.ToLower(f.Domain))
+	}
+	if f.Role != "" {
+		where = append(where, "role = ?")
+		args = append(args, f.Role)
+	}
+	if f.Disabled != nil {
+		if *f.Disabled {
+			where = append(where, "disabled_at IS NOT NULL")
+		} else {
+			where = append(where, "disabled_at IS NULL")
+		}
+	}
+	cond := ""
+	if len(where) > 0 {
+		cond = " WHERE " + strings.Join(where, " AND ")
+	}
+
+	var total int
+	if err := s.queryRow("SELECT COUNT(*) FROM users"+cond, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("database error: %v", err)
+	}
+	rows, err := s.query("SELECT "+userColumns+" FROM users"+cond+" ORDER BY lower(email), id LIMIT ? OFFSET ?",
+		append(args, f.Limit, f.Offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	list := []store.User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		list = append(list, u)
+	}
+	return list, total, rows.Err()
+}
+
+// update runs a single-row UPDATE, returning store.ErrNotFound if id is unknown
+func (s *users) update(query string, args ...interface{}) error {
+	res, err := s.exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("database update error: %v", err)
+	}
+	return affected(res)
+}
+
+func (s *users) SetPasswordHash(id, hash string) error {
+	return s.update("UPDATE users SET password_hash = ? WHERE id = ?", hash, id)
+}
+
+func (s *users) SetTOTPSecret(id, secret string) error {
+	return s.update("UPDATE users SET totp_secret = ?, totp_last_step = NULL WHERE id = ?", secret, id)
+}
+
+func (s *users) AdvanceTOTPStep(id string, step int64) (bool, error) {
+	res, err := s.exec("UPDATE users SET totp_last_step = ? WHERE id = ? AND COALESCE(totp_last_step, 0) < ?", step, id, step)
+	if err != nil {
+		return false, fmt.Errorf("database update error: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return true, nil
+	}
+	// Tell a replayed code apart from an unknown user
+	if _, err := s.ByID(id); err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+func (s *users) SetRole(id, role string) error {
+	return s.update("UPDATE users SET role = ? WHERE id = ?", role, id)
+}
+
+func (s *users) SetDisabled(id string, at time.Time) error {
+	return s.update("UPDATE users SET disabled_at = ? WHERE id = ?", nullUnix(at), id)
+}
+
+// userChildren deletes what refers to a user, children before parents so
+// foreign keys hold throughout
+var userChildren = []string{
+	"DELETE FROM email_folders WHERE folder_id IN (SELECT id FROM folders WHERE user_id = ?)",
+	"DELETE FROM email_folders WHERE email_id IN (SELECT id FROM emails WHERE sender_id = ?)",
+	"DELETE FROM access_attempts WHERE email_id IN (SELECT id FROM emails WHERE sender_id = ?)",
+	"DELETE FROM emails WHERE sender_id = ?",
+	"DELETE FROM folders WHERE user_id = ?",
+	"DELETE FROM used_refresh_tokens WHERE session_id IN (SELECT id FROM sessions WHERE user_id = ?)",
+	"DELETE FROM sessions WHERE user_id = ?",
+	"DELETE FROM recovery_codes WHERE user_id = ?",
+	"DELETE FROM totp_reenrollments WHERE user_id = ?",
+	"DELETE FROM webauthn_credentials WHERE user_id = ?",
+	"DELETE FROM webauthn_challenges WHERE user_id = ?",
+	"DELETE FROM invite_redemptions WHERE user_id = ?",
+}
+
+func (s *users) Delete(id string) error {
+	return s.inTx(func(c *conn) error {
+		for _, q := range userChildren {
+			if _, err := c.exec(q, id); err != nil {
+				return fmt.Errorf("database delete error: %v", err)
+			}
+		}
+		res, err := c.exec("DELETE FROM users WHERE id = ?", id)
+		if err != nil {
+			return fmt.Errorf("database delete error: %v", err)
+		}
+		return affected(res)
+	})
+}
+
+func (s *users) Count() (int, error) {
+	var n int
+	if err := s.queryRow("SELECT COUNT(*) FROM users").Scan(&n); err != nil {
+		return 0, fmt.Errorf("database error: %v", err)
+	}
+	return n, nil
+}
+
+func (s *users) CountRoles() (map[string]int, int, error) {
+	rows, err := s.query("SELECT role, COUNT(*), COUNT(disabled_at) FROM users GROUP BY role")
+	if err != nil {
+		return nil, 0, fmt.Errorf("database error: %v", err)
+	}
+	defer rows.Close()
+
+	roles := map[string]int{}
+	disabled := 0
+	for rows.Next() {
+		var role string
+		var n, d int
+		if err := rows.Scan(&role, &n, &d); err != nil {
+			return nil, 0, fmt.Errorf("database error: %v", err)
+		}
+		roles[role] = n
+		disabled += d
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("database error: %v", err)
+	}
+	return roles, disabled, nil
+}
@@ -0,0 +1,400 @@
+// Package store defines the repositories behind the API's core tables:
+// users, pending sign-ups, sessions and revoked access tokens, emails and
+// folders, hosted domains, invites, recovery codes, login failures, TOTP
+// re-enrollments and passkeys. Code written against these interfaces rather
+// than SQL runs on SQLite (package sqlite) or PostgreSQL (package postgres),
+// as package driver picks from the configuration. Every implementation must
+// pass the suite in package storetest.
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when a lookup, update or delete matches nothing
+	ErrNotFound = errors.New("not found")
+	// ErrExists is returned when creating a record whose ID or unique key,
+	// such as a user's email address, is already taken
+	ErrExists = errors.New("already exists")
+	// ErrDisabled is returned when starting a session for a disabled user
+	ErrDisabled = errors.New("user disabled")
+)
+
+// Store groups every repository on one database
+type Store struct {
+	Users      Users
+	Pending    PendingEnrollments
+	Sessions   Sessions
+	Revoked    RevokedTokens
+	Emails     Emails
+	Folders    Folders
+	Domains    Domains
+	Invites    Invites
+	Recovery   RecoveryCodes
+	Failures   LoginFailures
+	Reenroll   TOTPReenrollments
+	Passkeys   Passkeys
+	Challenges Challenges
+
+	bind func(tx *sql.Tx) *Store
+}
+
+// New groups the repositories set in repos; bind returns the same
+// repositories on a transaction for WithTx
+func New(repos Store, bind func(tx *sql.Tx) *Store) *Store {
+	repos.bind = bind
+	return &repos
+}
+
+// WithTx returns the repositories working in tx, so their changes commit or
+// roll back together with each other and anything else done in tx
+func (s *Store) WithTx(tx *sql.Tx) *Store {
+	return s.bind(tx)
+}
+
+// User is an account
+type User struct {
+	ID           string
+	Email        string
+	PasswordHash string // Argon2id in PHC format
+	TOTPSecret   string
+	TOTPLastStep int64     // Last accepted TOTP time-step; 0 before the first code
+	Role         string    // user, admin or auditor; empty means user
+	DisabledAt   time.Time // Zero while the account is enabled
+	CreatedAt    time.Time
+}
+
+// Users stores accounts
+type Users interface {
+	// Create adds u, returning ErrExists if its ID or email is taken
+	Create(u User) error
+	ByID(id string) (User, error)
+	// ByEmail looks the address up ignoring case
+	ByEmail(email string) (User, error)
+	// List returns the users matching f ordered by email ignoring case, at
+	// most f.Limit of them from f.Offset, and how many match in total
+	List(f UserFilter) ([]User, int, error)
+	SetPasswordHash(id, hash string) error
+	// SetTOTPSecret replaces the secret and forgets the last accepted step
+	SetTOTPSecret(id, secret string) error
+	// AdvanceTOTPStep records step as used and reports whether it was later
+	// than the last accepted one, so each code is accepted at most once
+	AdvanceTOTPStep(id string, step int64) (bool, error)
+	SetRole(id, role string) error
+	// SetDisabled disables the account at at, or enables it if at is zero
+	SetDisabled(id string, at time.Time) error
+	// Delete removes the account with everything that refers to it: sent
+	// emails, folders, sessions, recovery codes, TOTP re-enrollments,
+	// passkeys and invite redemptions
+	Delete(id string) error
+	Count() (int, error)
+	// CountRoles counts users per role, and how many are disabled
+	CountRoles() (roles map[string]int, disabled int, err error)
+}
+
+// UserFilter narrows Users.List; zero fields match everything
+type UserFilter struct {
+	Query    string // Case-insensitive substring of the email address
+	Domain   string
+	Role     string
+	Disabled *bool
+	Limit    int
+	Offset   int
+}
+
+// PendingEnrollment is a sign-up waiting for its first TOTP code
+type PendingEnrollment struct {
+	Email        string
+	PasswordHash string
+	TotpSecret   string
+	InviteID     string // Invite to redeem when the user is created, if any
+	ExpiresAt    time.Time
+}
+
+// PendingEnrollments holds sign-ups between the sign-up and verify-totp
+// requests
+type PendingEnrollments interface {
+	// Save inserts or replaces the enrollment for tempID
+	Save(tempID string, p PendingEnrollment) error
+	// Load returns the enrollment for tempID, including an expired one
+	Load(tempID string) (PendingEnrollment, error)
+	Delete(tempID string) error
+	// DeleteExpired removes every enrollment that expired at or before now
+	DeleteExpired(now time.Time) (int64, error)
+	// Count returns how many enrollments are still waiting at now
+	Count(now time.Time) (int, error)
+}
+
+// Session is one login, identified across refreshes by its ID
+type Session struct {
+	ID          string
+	UserID      string
+	RefreshHash string // SHA-256 of the current refresh token
+	IP          string
+	UserAgent   string
+	CreatedAt   time.Time
+	LastSeenAt  time.Time
+	ExpiresAt   time.Time
+	RevokedAt   time.Time // Zero while the session is active
+}
+
+// Sessions stores logins and the refresh tokens rotated out of them
+type Sessions interface {
+	// Create adds s, returning ErrExists if its ID or refresh hash is taken
+	// and ErrDisabled if its user is disabled
+	Create(s Session) error
+	ByID(id string) (Session, error)
+	// ByRefreshHash returns the session whose current token hashes to hash
+	ByRefreshHash(hash string) (Session, error)
+	// ByUsedRefreshHash returns the session a rotated-out token belonged to,
+	// so presenting it again can revoke the session
+	ByUsedRefreshHash(hash string) (Session, error)
+	// Rotate replaces the refresh token oldHash with next.RefreshHash and
+	// updates the client and timestamps from next, remembering oldHash as
+	// used. It returns ErrNotFound if oldHash is no longer current.
+	Rotate(oldHash string, next Session) error
+	// Touch updates the session's last use
+	Touch(id string, at time.Time) error
+	// Revoke ends the session; revoking it again is not an error
+	Revoke(id string, at time.Time) error
+	// RevokeAll ends every active session of the user except exceptID and
+	// returns how many were revoked
+	RevokeAll(userID, exceptID string, at time.Time) (int64, error)
+	// ListActive returns unrevoked, unexpired sessions, most recently used first
+	ListActive(userID string, now time.Time) ([]Session, error)
+	// DeleteExpired removes sessions that expired at or before now, with
+	// their used refresh tokens
+	DeleteExpired(now time.Time) (int64, error)
+}
+
+// RevokedTokens lists access tokens refused before they expire, such as the
+// one used to log out
+type RevokedTokens interface {
+	// Add revokes the token with ID jti until expiresAt; adding it again is
+	// not an error
+	Add(jti string, expiresAt time.Time) error
+	Contains(jti string) (bool, error)
+	// DeleteExpired removes entries for tokens that expired at or before now
+	DeleteExpired(now time.Time) (int64, error)
+}
+
+// Email is a message sent by a user, stored encrypted
+type Email struct {
+	ID                 string
+	SenderID           string
+	RecipientEmail     string
+	Subject            string
+	EncryptedContent   string
+	AccessPasswordHash string    // Empty if opening it needs no password
+	GeolocationCircles string    // JSON-encoded areas it may be opened in; empty for anywhere
+	ExpiresAt          time.Time // Zero if it never expires
+	CreatedAt          time.Time
+}
+
+// Emails stores sent messages
+type Emails interface {
+	// Create adds e, returning ErrExists if its ID is taken
+	Create(e Email) error
+	ByID(id string) (Email, error)
+	// ListSent returns emails sent by the user, newest first, at most limit
+	// of them from offset
+	ListSent(senderID string, limit, offset int) ([]Email, error)
+	// ListReceived returns emails addressed to recipient, ignoring case,
+	// newest first, at most limit of them from offset
+	ListReceived(recipient string, limit, offset int) ([]Email, error)
+	// Delete removes the email with its access attempts and folder entries
+	Delete(id string) error
+	// DeleteExpired removes emails that expired at or before now, as Delete does
+	DeleteExpired(now time.Time) (int64, error)
+}
+
+// Folder is a user's named collection of emails
+type Folder struct {
+	ID        string
+	UserID    string
+	Name      string
+	CreatedAt time.Time
+}
+
+// Folders stores users' folders and the emails filed in them
+type Folders interface {
+	// Create adds f, returning ErrExists if its ID is taken
+	Create(f Folder) error
+	ByID(id string) (Folder, error)
+	// List returns the user's folders ordered by name
+	List(userID string) ([]Folder, error)
+	Rename(id, name string) error
+	// Delete removes the folder; the emails filed in it are kept
+	Delete(id string) error
+	// AddEmail files an email in a folder; filing it again is not an error.
+	// It returns ErrNotFound if the folder or email does not exist.
+	AddEmail(folderID, emailID string) error
+	// RemoveEmail takes an email out of a folder, returning ErrNotFound if
+	// it was not filed there
+	RemoveEmail(folderID, emailID string) error
+	// Emails returns the emails filed in a folder, newest first
+	Emails(folderID string) ([]Email, error)
+}
+
+// Domain is a mail domain hosted by this deployment
+type Domain struct {
+	Name       string // Lower-case
+	SignUp     string // open, invite or closed
+	MaxUsers   int
+	TOTPIssuer string
+	Users      int // Users with an address at the domain; ignored on writes
+	CreatedAt  time.Time
+}
+
+// Domains stores the mail domains users can sign up at
+type Domains interface {
+	// Create adds d, returning ErrExists if it is already hosted
+	Create(d Domain) error
+	// ByName returns the domain with its user count
+	ByName(name string) (Domain, error)
+	// List returns every domain with its user count, ordered by name
+	List() ([]Domain, error)
+	// Update replaces the sign-up policy, user cap and TOTP issuer
+	Update(d Domain) error
+	// Delete removes the domain with its invites and their redemptions
+	Delete(name string) error
+}
+
+// Invite lets up to MaxUses people sign up at Domain until ExpiresAt
+type Invite struct {
+	ID        string
+	CodeHash  string // SHA-256 of the code
+	Domain    string
+	Email     string // Empty if anyone at Domain may redeem it
+	MaxUses   int
+	Uses      int
+	CreatedBy string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// Invites stores the invite codes admins mint and who redeemed them
+type Invites interface {
+	// Create adds inv, returning ErrExists if its ID or code hash is taken
+	Create(inv Invite) error
+	// ByCodeHash returns the invite whose code hashes to hash, including
+	// one used up or expired
+	ByCodeHash(hash string) (Invite, error)
+	// ListOutstanding returns the invites neither used up nor expired at
+	// now, newest first. An empty domain lists every domain's.
+	ListOutstanding(domain string, now time.Time) ([]Invite, error)
+	// Redeem spends one use of an outstanding invite for the user at now,
+	// returning ErrNotFound if it expired or was used up
+	Redeem(id, userID string, now time.Time) error
+	// Revoke expires an outstanding invite at now, returning ErrNotFound if
+	// it is not outstanding. Its redemptions are kept.
+	Revoke(id string, now time.Time) error
+}
+
+// RecoveryCodes stores users' one-time recovery codes as SHA-256 hashes
+type RecoveryCodes interface {
+	// Replace swaps every code the user has for unused ones with hashes
+	Replace(userID string, hashes []string, at time.Time) error
+	// Use marks the user's unused code with hash as used at at, returning
+	// ErrNotFound if there is none
+	Use(userID, hash string, at time.Time) error
+	// CountUnused counts the user's codes not used yet
+	CountUnused(userID string) (int, error)
+}
+
+// LoginFailure counts consecutive failed logins for one email address
+type LoginFailure struct {
+	Email    string // Lower-case
+	Failures int
+	LastAt   time.Time // When the latest failure was counted
+}
+
+// LoginFailures holds the counts behind login backoff and lockout
+type LoginFailures interface {
+	Get(email string) (LoginFailure, error)
+	// Create starts a count, returning ErrExists if the address has one
+	Create(f LoginFailure) error
+	// Replace stores next if the address's count is still old, returning
+	// ErrNotFound if another attempt changed it first
+	Replace(old, next LoginFailure) error
+	// Release takes back one failure, never going below zero
+	Release(email string) error
+	// Delete clears the address's count, returning ErrNotFound if it had none
+	Delete(email string) error
+	// DeleteBefore removes counts whose latest failure is at or before t
+	DeleteBefore(t time.Time) (int64, error)
+}
+
+// TOTPReenrollment is a new TOTP secret waiting for its first code before it
+// replaces the user's current one
+type TOTPReenrollment struct {
+	TempID     string
+	UserID     string
+	TOTPSecret string
+	ExpiresAt  time.Time
+}
+
+// TOTPReenrollments holds re-enrollments between the start and confirm
+// requests
+type TOTPReenrollments interface {
+	// Start stores r as the user's only re-enrollment, dropping any that
+	// expired at or before now on the way
+	Start(r TOTPReenrollment, now time.Time) error
+	// Load returns the user's re-enrollment tempID, including an expired one
+	Load(tempID, userID string) (TOTPReenrollment, error)
+	// DeleteUser removes every re-enrollment of the user
+	DeleteUser(userID string) error
+}
+
+// Passkey is a WebAuthn credential registered by a user
+type Passkey struct {
+	ID              string // Base64url credential ID
+	UserID          string
+	Name            string
+	PublicKey       []byte
+	AttestationType string
+	AAGUID          []byte
+	Transports      string // Comma-separated
+	SignCount       uint32
+	BackupEligible  bool
+	BackupState     bool
+	CloneWarning    bool // Set once a possible clone was detected; the passkey is no longer offered
+	CreatedAt       time.Time
+	LastUsedAt      time.Time // Zero until the first login
+}
+
+// Passkeys stores users' WebAuthn credentials
+type Passkeys interface {
+	// Create adds p, returning ErrExists if its ID is already registered
+	Create(p Passkey) error
+	// List returns the user's passkeys, oldest first, including flagged ones
+	List(userID string) ([]Passkey, error)
+	// RecordUse stores the sign count and backup state of a login at at
+	RecordUse(id string, signCount uint32, backupState bool, at time.Time) error
+	// Flag sets CloneWarning on a passkey
+	Flag(id string) error
+	// Delete removes one of the user's passkeys, returning ErrNotFound if
+	// the user has none with that ID
+	Delete(userID, id string) error
+}
+
+// Challenge is the state of a WebAuthn ceremony until the client answers it
+type Challenge struct {
+	ID          string
+	UserID      string // Empty for discoverable logins
+	Ceremony    string
+	SessionData string // JSON-encoded
+	ExpiresAt   time.Time
+}
+
+// Challenges holds WebAuthn ceremonies in flight
+type Challenges interface {
+	// Save stores c, dropping challenges that expired at or before now
+	Save(c Challenge, now time.Time) error
+	// Take returns and deletes the challenge with id for the ceremony, so it
+	// is answered at most once. An expired challenge is returned too.
+	Take(id, ceremony string) (Challenge, error)
+}
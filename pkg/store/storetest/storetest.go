@@ -0,0 +1,1043 @@
+// Package storetest is the conformance suite every store implementation must
+// pass, so handlers behave the same on SQLite and PostgreSQL
+package storetest
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"secure-email-mvp/pkg/store"
+)
+
+// now is a fixed clock; stores keep whole seconds
+var now = time.Unix(1700000000, 0)
+
+// Run runs the suite. open must return a store on an empty, migrated
+// database each time it is called, and the database itself so the suite can
+// fill tables outside the store.
+func Run(t *testing.T, open func(t *testing.T) (*store.Store, *sql.DB)) {
+	for _, tt := range []struct {
+		name string
+		test func(t *testing.T, s *store.Store, db *sql.DB)
+	}{
+		{"Users", testUsers},
+		{"ListUsers", testListUsers},
+		{"DeleteUser", testDeleteUser},
+		{"Pending", testPending},
+		{"Sessions", testSessions},
+		{"Revoked", testRevoked},
+		{"Emails", testEmails},
+		{"Folders", testFolders},
+		{"Domains", testDomains},
+		{"Invites", testInvites},
+		{"Recovery", testRecovery},
+		{"Failures", testFailures},
+		{"Reenroll", testReenroll},
+		{"Passkeys", testPasskeys},
+		{"Challenges", testChallenges},
+		{"WithTx", testWithTx},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, db := open(t)
+			tt.test(t, s, db)
+		})
+	}
+}
+
+func newUser(id, email string) store.User {
+	return store.User{
+		ID:           id,
+		Email:        email,
+		PasswordHash: "$argon2id$v=19$m=65536,t=1,p=4$c2FsdA$aGFzaA",
+		TOTPSecret:   "JBSWY3DPEHPK3PXP",
+		CreatedAt:    now,
+	}
+}
+
+// mustCreateUser adds a user for tests of tables that reference users
+func mustCreateUser(t *testing.T, s *store.Store, id string) {
+	t.Helper()
+	if err := s.Users.Create(newUser(id, id+"@securesystem.email")); err != nil {
+		t.Fatalf("Creating user %s failed: %v", id, err)
+	}
+}
+
+func testUsers(t *testing.T, s *store.Store, _ *sql.DB) {
+	u := newUser("user-1", "test@securesystem.email")
+	if err := s.Users.Create(u); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := s.Users.Create(newUser("user-2", u.Email)); !errors.Is(err, store.ErrExists) {
+		t.Errorf("Expected ErrExists for a taken email, got %v", err)
+	}
+	if err := s.Users.Create(newUser(u.ID, "other@securesystem.email")); !errors.Is(err, store.ErrExists) {
+		t.Errorf("Expected ErrExists for a taken ID, got %v", err)
+	}
+
+	got, err := s.Users.ByEmail(u.Email)
+	if err != nil {
+		t.Fatalf("ByEmail failed: %v", err)
+	}
+	if got.ID != u.ID || got.PasswordHash != u.PasswordHash || got.TOTPSecret != u.TOTPSecret ||
+		got.Role != "user" || got.TOTPLastStep != 0 || !got.DisabledAt.IsZero() || !got.CreatedAt.Equal(now) {
+		t.Errorf("Loaded user %+v does not match created %+v", got, u)
+	}
+	if _, err := s.Users.ByID("missing"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for an unknown user, got %v", err)
+	}
+
+	// Each TOTP step is accepted once, and never an earlier one
+	for _, step := range []struct {
+		step int64
+		want bool
+	}{{5, true}, {5, false}, {4, false}, {6, true}} {
+		ok, err := s.Users.AdvanceTOTPStep(u.ID, step.step)
+		if err != nil || ok != step.want {
+			t.Errorf("AdvanceTOTPStep(%d) = %v, %v; want %v", step.step, ok, err, step.want)
+		}
+	}
+	if _, err := s.Users.AdvanceTOTPStep("missing", 1); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound advancing an unknown user, got %v", err)
+	}
+	// A new secret starts over
+	if err := s.Users.SetTOTPSecret(u.ID, "NEWSECRETNEWSECR"); err != nil {
+		t.Fatalf("SetTOTPSecret failed: %v", err)
+	}
+	if ok, err := s.Users.AdvanceTOTPStep(u.ID, 1); err != nil || !ok {
+		t.Errorf("Expected step 1 accepted after re-enrolling, got %v, %v", ok, err)
+	}
+
+	disabledAt := now.Add(time.Hour)
+	for name, err := range map[string]error{
+		"SetPasswordHash": s.Users.SetPasswordHash(u.ID, "$argon2id$new"),
+		"SetRole":         s.Users.SetRole(u.ID, "auditor"),
+		"SetDisabled":     s.Users.SetDisabled(u.ID, disabledAt),
+	} {
+		if err != nil {
+			t.Fatalf("%s failed: %v", name, err)
+		}
+	}
+	got, err = s.Users.ByID(u.ID)
+	if err != nil {
+		t.Fatalf("ByID failed: %v", err)
+	}
+	if got.PasswordHash != "$argon2id$new" || got.TOTPSecret != "NEWSECRETNEWSECR" || got.TOTPLastStep != 1 ||
+		got.Role != "auditor" || !got.DisabledAt.Equal(disabledAt) {
+		t.Errorf("Updates not stored, got %+v", got)
+	}
+	if err := s.Users.SetDisabled(u.ID, time.Time{}); err != nil {
+		t.Fatalf("Enabling failed: %v", err)
+	}
+	if got, _ := s.Users.ByID(u.ID); !got.DisabledAt.IsZero() {
+		t.Errorf("Expected user enabled, disabled at %v", got.DisabledAt)
+	}
+	if err := s.Users.SetRole("missing", "admin"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound updating an unknown user, got %v", err)
+	}
+
+	if n, err := s.Users.Count(); err != nil || n != 1 {
+		t.Errorf("Expected 1 user, got %d, %v", n, err)
+	}
+	if err := s.Users.Delete(u.ID); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := s.Users.ByID(u.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected deleted user gone, got %v", err)
+	}
+	if err := s.Users.Delete(u.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound deleting twice, got %v", err)
+	}
+}
+
+func testListUsers(t *testing.T, s *store.Store, _ *sql.DB) {
+	for _, u := range []store.User{
+		newUser("user-1", "alice@securesystem.email"),
+		newUser("user-2", "Bob@Example.org"),
+		newUser("user-3", "carol@example.org"),
+		newUser("user-4", "dave_x@example.org"),
+	} {
+		if err := s.Users.Create(u); err != nil {
+			t.Fatalf("Create %s failed: %v", u.ID, err)
+		}
+	}
+	s.Users.SetRole("user-3", "admin")
+	s.Users.SetDisabled("user-4", now)
+
+	if got, err := s.Users.ByEmail("bob@example.ORG"); err != nil || got.ID != "user-2" {
+		t.Errorf("Expected ByEmail to ignore case, got %+v, %v", got, err)
+	}
+
+	yes, no := true, false
+	tests := []struct {
+		filter store.UserFilter
+		want   []string
+		total  int
+	}{
+		{store.UserFilter{Limit: 10}, []string{"user-1", "user-2", "user-3", "user-4"}, 4},
+		{store.UserFilter{Domain: "EXAMPLE.org", Limit: 10}, []string{"user-2", "user-3", "user-4"}, 3},
+		{store.UserFilter{Query: "BOB", Limit: 10}, []string{"user-2"}, 1},
+		{store.UserFilter{Query: "_", Limit: 10}, []string{"user-4"}, 1},
+		{store.UserFilter{Role: "admin", Limit: 10}, []string{"user-3"}, 1},
+		{store.UserFilter{Disabled: &yes, Limit: 10}, []string{"user-4"}, 1},
+		{store.UserFilter{Disabled: &no, Domain: "example.org", Limit: 10}, []string{"user-2", "user-3"}, 2},
+		{store.UserFilter{Domain: "example.org", Limit: 1, Offset: 1}, []string{"user-3"}, 3},
+	}
+	for _, tt := range tests {
+		users, total, err := s.Users.List(tt.filter)
+		if err != nil {
+			t.Fatalf("List(%+v) failed: %v", tt.filter, err)
+		}
+		var ids []string
+		for _, u := range users {
+			ids = append(ids, u.ID)
+		}
+		if total != tt.total || len(ids) != len(tt.want) {
+			t.Errorf("List(%+v) = %v of %d, want %v of %d", tt.filter, ids, total, tt.want, tt.total)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != tt.want[i] {
+				t.Errorf("List(%+v) = %v, want %v", tt.filter, ids, tt.want)
+				break
+			}
+		}
+	}
+
+	roles, disabled, err := s.Users.CountRoles()
+	if err != nil || roles["user"] != 3 || roles["admin"] != 1 || disabled != 1 {
+		t.Errorf("Expected 3 users, 1 admin and 1 disabled, got %v, %d, %v", roles, disabled, err)
+	}
+}
+
+// userRows lists, for each table a user's data reaches, a query counting the
+// rows left behind for user-1
+var userRows = map[string]string{
+	"emails":               "SELECT COUNT(*) FROM emails WHERE sender_id = 'user-1'",
+	"access_attempts":      "SELECT COUNT(*) FROM access_attempts WHERE email_id = 'email-1'",
+	"folders":              "SELECT COUNT(*) FROM folders WHERE user_id = 'user-1'",
+	"email_folders":        "SELECT COUNT(*) FROM email_folders",
+	"sessions":             "SELECT COUNT(*) FROM sessions WHERE user_id = 'user-1'",
+	"used_refresh_tokens":  "SELECT COUNT(*) FROM used_refresh_tokens WHERE session_id = 'session-1'",
+	"recovery_codes":       "SELECT COUNT(*) FROM recovery_codes WHERE user_id = 'user-1'",
+	"totp_reenrollments":   "SELECT COUNT(*) FROM totp_reenrollments WHERE user_id = 'user-1'",
+	"webauthn_credentials": "SELECT COUNT(*) FROM webauthn_credentials WHERE user_id = 'user-1'",
+	"webauthn_challenges":  "SELECT COUNT(*) FROM webauthn_challenges WHERE user_id = 'user-1'",
+	"invite_redemptions":   "SELECT COUNT(*) FROM invite_redemptions WHERE user_id = 'user-1'",
+}
+
+func testDeleteUser(t *testing.T, s *store.Store, db *sql.DB) {
+	mustCreateUser(t, s, "user-1")
+	mustCreateUser(t, s, "user-2")
+	session := store.Session{ID: "session-1", UserID: "user-1", RefreshHash: "hash-1", CreatedAt: now, LastSeenAt: now, ExpiresAt: now.Add(time.Hour)}
+	if err := s.Sessions.Create(session); err != nil {
+		t.Fatalf("Creating session failed: %v", err)
+	}
+	if err := s.Sessions.Rotate("hash-1", store.Session{ID: "session-1", RefreshHash: "hash-2", LastSeenAt: now, ExpiresAt: now.Add(time.Hour)}); err != nil {
+		t.Fatalf("Rotating session failed: %v", err)
+	}
+	// Literal values keep these portable across placeholder styles
+	for _, q := range []string{
+		"INSERT INTO emails (id, sender_id, recipient_email, encrypted_content) VALUES ('email-1', 'user-1', 'user-2@securesystem.email', 'c')",
+		"INSERT INTO access_attempts (id, email_id, ip_address) VALUES ('attempt-1', 'email-1', '203.0.113.7')",
+		"INSERT INTO folders (id, user_id, name) VALUES ('folder-1', 'user-1', 'Sent')",
+		"INSERT INTO email_folders (email_id, folder_id) VALUES ('email-1', 'folder-1')",
+		"INSERT INTO recovery_codes (id, user_id, code_hash, created_at) VALUES ('code-1', 'user-1', 'h', 1700000000)",
+		"INSERT INTO totp_reenrollments (temp_id, user_id, totp_secret, expires_at) VALUES ('temp-1', 'user-1', 's', 1700000000)",
+		"INSERT INTO webauthn_credentials (id, user_id, name, public_key, attestation_type, created_at) VALUES ('cred-1', 'user-1', 'Key', 'k', 'none', 1700000000)",
+		"INSERT INTO webauthn_challenges (id, user_id, ceremony, session_data, expires_at) VALUES ('challenge-1', 'user-1', 'registration', '{}', 1700000000)",
+		"INSERT INTO domains (name, max_users, totp_issuer, created_at) VALUES ('securesystem.email', 10, 'Secure', 1700000000)",
+		"INSERT INTO invites (id, code_hash, domain, max_uses, created_by, created_at, expires_at) VALUES ('invite-1', 'h', 'securesystem.email', 1, 'user-2', 1700000000, 1700000000)",
+		"INSERT INTO invite_redemptions (invite_id, user_id, redeemed_at) VALUES ('invite-1', 'user-1', 1700000000)",
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatalf("Filling tables failed: %v\n%s", err, q)
+		}
+	}
+
+	if err := s.Users.Delete("user-1"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	for table, q := range userRows {
+		var n int
+		if err := db.QueryRow(q).Scan(&n); err != nil {
+			t.Fatalf("Counting %s failed: %v", table, err)
+		}
+		if n != 0 {
+			t.Errorf("Expected %s deleted with the user, %d left", table, n)
+		}
+	}
+	if n, err := s.Users.Count(); err != nil || n != 1 {
+		t.Errorf("Expected 1 user left, got %d, %v", n, err)
+	}
+}
+
+func testPending(t *testing.T, s *store.Store, _ *sql.DB) {
+	p := store.PendingEnrollment{
+		Email:        "test@securesystem.email",
+		PasswordHash: "$argon2id$v=19$m=65536,t=1,p=4$c2FsdA$aGFzaA",
+		TotpSecret:   "JBSWY3DPEHPK3PXP",
+		InviteID:     "invite-1",
+		ExpiresAt:    now.Add(5 * time.Minute),
+	}
+	if err := s.Pending.Save("temp-1", p); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	got, err := s.Pending.Load("temp-1")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if got.Email != p.Email || got.PasswordHash != p.PasswordHash || got.TotpSecret != p.TotpSecret ||
+		got.InviteID != p.InviteID || !got.ExpiresAt.Equal(p.ExpiresAt) {
+		t.Errorf("Loaded enrollment %+v does not match saved %+v", got, p)
+	}
+
+	// Saving again replaces the enrollment
+	p.TotpSecret = "NEWSECRETNEWSECR"
+	p.InviteID = ""
+	if err := s.Pending.Save("temp-1", p); err != nil {
+		t.Fatalf("Replacing failed: %v", err)
+	}
+	if got, _ := s.Pending.Load("temp-1"); got.TotpSecret != p.TotpSecret || got.InviteID != "" {
+		t.Errorf("Expected enrollment replaced, got %+v", got)
+	}
+
+	if err := s.Pending.Save("expired", store.PendingEnrollment{Email: "a@securesystem.email", PasswordHash: "x", TotpSecret: "s", ExpiresAt: now.Add(-time.Minute)}); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if n, err := s.Pending.Count(now); err != nil || n != 1 {
+		t.Errorf("Expected only the live enrollment counted, got %d, %v", n, err)
+	}
+	if n, err := s.Pending.DeleteExpired(now); err != nil || n != 1 {
+		t.Errorf("Expected 1 expired enrollment removed, got %d, %v", n, err)
+	}
+	if _, err := s.Pending.Load("expired"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected expired enrollment removed, got %v", err)
+	}
+
+	if err := s.Pending.Delete("temp-1"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := s.Pending.Load("temp-1"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound after delete, got %v", err)
+	}
+}
+
+func testSessions(t *testing.T, s *store.Store, _ *sql.DB) {
+	mustCreateUser(t, s, "alice")
+	first := store.Session{
+		ID: "session-1", UserID: "alice", RefreshHash: "hash-1", IP: "203.0.113.7", UserAgent: "Firefox",
+		CreatedAt: now, LastSeenAt: now, ExpiresAt: now.Add(time.Hour),
+	}
+	second := store.Session{
+		ID: "session-2", UserID: "alice", RefreshHash: "hash-b", CreatedAt: now, LastSeenAt: now.Add(time.Minute), ExpiresAt: now.Add(time.Hour),
+	}
+	expired := store.Session{
+		ID: "session-3", UserID: "alice", RefreshHash: "hash-c", CreatedAt: now.Add(-2 * time.Hour), LastSeenAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour),
+	}
+	for _, sess := range []store.Session{first, second, expired} {
+		if err := s.Sessions.Create(sess); err != nil {
+			t.Fatalf("Create %s failed: %v", sess.ID, err)
+		}
+	}
+	if err := s.Sessions.Create(store.Session{ID: "session-4", UserID: "alice", RefreshHash: "hash-1", ExpiresAt: now}); !errors.Is(err, store.ErrExists) {
+		t.Errorf("Expected ErrExists for a taken refresh hash, got %v", err)
+	}
+	mustCreateUser(t, s, "mallory")
+	s.Users.SetDisabled("mallory", now)
+	if err := s.Sessions.Create(store.Session{ID: "session-6", UserID: "mallory", RefreshHash: "hash-m", ExpiresAt: now}); !errors.Is(err, store.ErrDisabled) {
+		t.Errorf("Expected ErrDisabled for a disabled user, got %v", err)
+	}
+
+	got, err := s.Sessions.ByRefreshHash("hash-1")
+	if err != nil {
+		t.Fatalf("ByRefreshHash failed: %v", err)
+	}
+	if got.ID != first.ID || got.UserID != "alice" || got.IP != first.IP || got.UserAgent != first.UserAgent ||
+		!got.CreatedAt.Equal(now) || !got.ExpiresAt.Equal(first.ExpiresAt) || !got.RevokedAt.IsZero() {
+		t.Errorf("Loaded session %+v does not match created %+v", got, first)
+	}
+
+	// Rotation replaces the token and remembers the old one
+	later := now.Add(10 * time.Minute)
+	next := store.Session{ID: first.ID, RefreshHash: "hash-2", IP: "198.51.100.1", UserAgent: "Safari", LastSeenAt: later, ExpiresAt: later.Add(time.Hour)}
+	if err := s.Sessions.Rotate("hash-1", next); err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	if err := s.Sessions.Rotate("hash-1", next); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound rotating a used token, got %v", err)
+	}
+	if _, err := s.Sessions.ByRefreshHash("hash-1"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected old token no longer current, got %v", err)
+	}
+	if used, err := s.Sessions.ByUsedRefreshHash("hash-1"); err != nil || used.ID != first.ID {
+		t.Errorf("Expected old token traced to its session, got %+v, %v", used, err)
+	}
+	got, err = s.Sessions.ByRefreshHash("hash-2")
+	if err != nil || got.IP != next.IP || got.UserAgent != next.UserAgent || !got.LastSeenAt.Equal(later) ||
+		!got.ExpiresAt.Equal(next.ExpiresAt) || !got.CreatedAt.Equal(now) {
+		t.Errorf("Expected rotated session updated, got %+v, %v", got, err)
+	}
+
+	active, err := s.Sessions.ListActive("alice", now)
+	if err != nil || len(active) != 2 || active[0].ID != first.ID || active[1].ID != second.ID {
+		t.Errorf("Expected active sessions most recently used first, got %+v, %v", active, err)
+	}
+	if err := s.Sessions.Touch(second.ID, later.Add(time.Minute)); err != nil {
+		t.Fatalf("Touch failed: %v", err)
+	}
+	if active, _ := s.Sessions.ListActive("alice", now); len(active) != 2 || active[0].ID != second.ID {
+		t.Errorf("Expected touched session first, got %+v", active)
+	}
+
+	// Revoking keeps the first revocation time
+	if err := s.Sessions.Revoke(second.ID, later); err != nil {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+	if err := s.Sessions.Revoke(second.ID, later.Add(time.Hour)); err != nil {
+		t.Errorf("Expected revoking twice to succeed, got %v", err)
+	}
+	if got, _ := s.Sessions.ByID(second.ID); !got.RevokedAt.Equal(later) {
+		t.Errorf("Expected revoked at %v, got %v", later, got.RevokedAt)
+	}
+	if err := s.Sessions.Revoke("missing", later); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound revoking an unknown session, got %v", err)
+	}
+	if active, _ := s.Sessions.ListActive("alice", now); len(active) != 1 || active[0].ID != first.ID {
+		t.Errorf("Expected revoked session hidden, got %+v", active)
+	}
+
+	if err := s.Sessions.Create(store.Session{ID: "session-5", UserID: "alice", RefreshHash: "hash-e", CreatedAt: now, LastSeenAt: now, ExpiresAt: now.Add(time.Hour)}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if n, err := s.Sessions.RevokeAll("alice", first.ID, later); err != nil || n != 2 {
+		t.Errorf("Expected the other 2 unrevoked sessions revoked, got %d, %v", n, err)
+	}
+	if active, _ := s.Sessions.ListActive("alice", now); len(active) != 1 || active[0].ID != first.ID {
+		t.Errorf("Expected only the kept session active, got %+v", active)
+	}
+
+	if n, err := s.Sessions.DeleteExpired(now); err != nil || n != 1 {
+		t.Errorf("Expected 1 expired session removed, got %d, %v", n, err)
+	}
+	if _, err := s.Sessions.ByID(expired.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected expired session removed, got %v", err)
+	}
+	if n, err := s.Sessions.DeleteExpired(later.Add(2 * time.Hour)); err != nil || n != 3 {
+		t.Errorf("Expected the remaining sessions removed once expired, got %d, %v", n, err)
+	}
+	if _, err := s.Sessions.ByUsedRefreshHash("hash-1"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected used tokens removed with their session, got %v", err)
+	}
+}
+
+func testRevoked(t *testing.T, s *store.Store, _ *sql.DB) {
+	for _, jti := range []string{"jti-old", "jti-live", "jti-live"} {
+		expires := now.Add(time.Hour)
+		if jti == "jti-old" {
+			expires = now.Add(-time.Minute)
+		}
+		if err := s.Revoked.Add(jti, expires); err != nil {
+			t.Fatalf("Add(%s) failed: %v", jti, err)
+		}
+	}
+	for jti, want := range map[string]bool{"jti-old": true, "jti-live": true, "jti-other": false} {
+		if got, err := s.Revoked.Contains(jti); err != nil || got != want {
+			t.Errorf("Contains(%s) = %v, %v; want %v", jti, got, err, want)
+		}
+	}
+	if n, err := s.Revoked.DeleteExpired(now); err != nil || n != 1 {
+		t.Errorf("Expected 1 expired entry removed, got %d, %v", n, err)
+	}
+	if got, _ := s.Revoked.Contains("jti-old"); got {
+		t.Error("Expected expired entry removed")
+	}
+	if got, _ := s.Revoked.Contains("jti-live"); !got {
+		t.Error("Expected live entry kept")
+	}
+}
+
+func newEmail(id, sender, recipient string, created time.Time) store.Email {
+	return store.Email{ID: id, SenderID: sender, RecipientEmail: recipient, Subject: "Hello " + id, EncryptedContent: "ciphertext", CreatedAt: created}
+}
+
+func testEmails(t *testing.T, s *store.Store, db *sql.DB) {
+	mustCreateUser(t, s, "alice")
+	e := store.Email{
+		ID: "email-1", SenderID: "alice", RecipientEmail: "Bob@Example.org", Subject: "Hello",
+		EncryptedContent: "ciphertext", AccessPasswordHash: "$argon2id$x", GeolocationCircles: `[{"lat":1,"lon":2,"r":3}]`,
+		ExpiresAt: now.Add(time.Hour), CreatedAt: now,
+	}
+	if err := s.Emails.Create(e); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := s.Emails.Create(e); !errors.Is(err, store.ErrExists) {
+		t.Errorf("Expected ErrExists for a taken ID, got %v", err)
+	}
+	got, err := s.Emails.ByID(e.ID)
+	if err != nil {
+		t.Fatalf("ByID failed: %v", err)
+	}
+	if got.SenderID != e.SenderID || got.RecipientEmail != e.RecipientEmail || got.Subject != e.Subject ||
+		got.EncryptedContent != e.EncryptedContent || got.AccessPasswordHash != e.AccessPasswordHash ||
+		got.GeolocationCircles != e.GeolocationCircles || !got.ExpiresAt.Equal(e.ExpiresAt) || !got.CreatedAt.Equal(now) {
+		t.Errorf("Loaded email %+v does not match created %+v", got, e)
+	}
+	if _, err := s.Emails.ByID("missing"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for an unknown email, got %v", err)
+	}
+
+	// Optional fields come back empty, and a zero expiry never expires
+	for _, e := range []store.Email{
+		newEmail("email-2", "alice", "bob@example.org", now.Add(time.Minute)),
+		newEmail("email-3", "alice", "carol@example.org", now.Add(2*time.Minute)),
+	} {
+		if err := s.Emails.Create(e); err != nil {
+			t.Fatalf("Create %s failed: %v", e.ID, err)
+		}
+	}
+	if got, _ := s.Emails.ByID("email-2"); got.AccessPasswordHash != "" || got.GeolocationCircles != "" || !got.ExpiresAt.IsZero() {
+		t.Errorf("Expected optional fields empty, got %+v", got)
+	}
+
+	ids := func(list []store.Email) []string {
+		var ids []string
+		for _, e := range list {
+			ids = append(ids, e.ID)
+		}
+		return ids
+	}
+	if sent, err := s.Emails.ListSent("alice", 2, 0); err != nil || len(sent) != 2 || sent[0].ID != "email-3" || sent[1].ID != "email-2" {
+		t.Errorf("Expected the 2 newest sent emails, got %v, %v", ids(sent), err)
+	}
+	if sent, err := s.Emails.ListSent("alice", 10, 2); err != nil || len(sent) != 1 || sent[0].ID != "email-1" {
+		t.Errorf("Expected the oldest sent email from offset 2, got %v, %v", ids(sent), err)
+	}
+	if received, err := s.Emails.ListReceived("BOB@example.org", 10, 0); err != nil || len(received) != 2 || received[0].ID != "email-2" {
+		t.Errorf("Expected emails to bob ignoring case, newest first, got %v, %v", ids(received), err)
+	}
+
+	// Deleting takes the email's access attempts and folder entries with it
+	for _, q := range []string{
+		"INSERT INTO access_attempts (id, email_id, ip_address) VALUES ('attempt-1', 'email-2', '203.0.113.7')",
+		"INSERT INTO folders (id, user_id, name) VALUES ('folder-1', 'alice', 'Sent')",
+		"INSERT INTO email_folders (email_id, folder_id) VALUES ('email-2', 'folder-1')",
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatalf("Filling tables failed: %v\n%s", err, q)
+		}
+	}
+	if err := s.Emails.Delete("email-2"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	for table, q := range map[string]string{
+		"access_attempts": "SELECT COUNT(*) FROM access_attempts",
+		"email_folders":   "SELECT COUNT(*) FROM email_folders",
+	} {
+		var n int
+		if err := db.QueryRow(q).Scan(&n); err != nil || n != 0 {
+			t.Errorf("Expected %s deleted with the email, got %d, %v", table, n, err)
+		}
+	}
+	if err := s.Emails.Delete("email-2"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound deleting twice, got %v", err)
+	}
+
+	if n, err := s.Emails.DeleteExpired(now.Add(30 * time.Minute)); err != nil || n != 0 {
+		t.Errorf("Expected nothing expired yet, got %d, %v", n, err)
+	}
+	if n, err := s.Emails.DeleteExpired(now.Add(time.Hour)); err != nil || n != 1 {
+		t.Errorf("Expected the expiring email removed, got %d, %v", n, err)
+	}
+	if _, err := s.Emails.ByID("email-3"); err != nil {
+		t.Errorf("Expected an email without expiry kept, got %v", err)
+	}
+}
+
+func testFolders(t *testing.T, s *store.Store, _ *sql.DB) {
+	mustCreateUser(t, s, "alice")
+	mustCreateUser(t, s, "bob")
+	for _, f := range []store.Folder{
+		{ID: "folder-1", UserID: "alice", Name: "Work", CreatedAt: now},
+		{ID: "folder-2", UserID: "alice", Name: "Archive", CreatedAt: now},
+		{ID: "folder-3", UserID: "bob", Name: "Inbox", CreatedAt: now},
+	} {
+		if err := s.Folders.Create(f); err != nil {
+			t.Fatalf("Create %s failed: %v", f.ID, err)
+		}
+	}
+	if err := s.Folders.Create(store.Folder{ID: "folder-1", UserID: "bob", Name: "Taken"}); !errors.Is(err, store.ErrExists) {
+		t.Errorf("Expected ErrExists for a taken ID, got %v", err)
+	}
+	got, err := s.Folders.ByID("folder-1")
+	if err != nil || got.UserID != "alice" || got.Name != "Work" || !got.CreatedAt.Equal(now) {
+		t.Errorf("Unexpected folder %+v, %v", got, err)
+	}
+
+	if list, err := s.Folders.List("alice"); err != nil || len(list) != 2 || list[0].ID != "folder-2" || list[1].ID != "folder-1" {
+		t.Errorf("Expected alice's folders by name, got %+v, %v", list, err)
+	}
+	if err := s.Folders.Rename("folder-2", "Old"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if got, _ := s.Folders.ByID("folder-2"); got.Name != "Old" {
+		t.Errorf("Expected folder renamed, got %q", got.Name)
+	}
+	if err := s.Folders.Rename("missing", "x"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound renaming an unknown folder, got %v", err)
+	}
+
+	for _, e := range []store.Email{
+		newEmail("email-1", "alice", "bob@securesystem.email", now),
+		newEmail("email-2", "alice", "bob@securesystem.email", now.Add(time.Minute)),
+	} {
+		if err := s.Emails.Create(e); err != nil {
+			t.Fatalf("Creating email failed: %v", err)
+		}
+	}
+	for _, id := range []string{"email-1", "email-2", "email-1"} {
+		if err := s.Folders.AddEmail("folder-1", id); err != nil {
+			t.Errorf("AddEmail(%s) failed: %v", id, err)
+		}
+	}
+	if err := s.Folders.AddEmail("folder-1", "missing"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound filing an unknown email, got %v", err)
+	}
+	if err := s.Folders.AddEmail("missing", "email-1"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound filing in an unknown folder, got %v", err)
+	}
+	if filed, err := s.Folders.Emails("folder-1"); err != nil || len(filed) != 2 || filed[0].ID != "email-2" {
+		t.Errorf("Expected both emails filed once, newest first, got %+v, %v", filed, err)
+	}
+
+	if err := s.Folders.RemoveEmail("folder-1", "email-2"); err != nil {
+		t.Fatalf("RemoveEmail failed: %v", err)
+	}
+	if err := s.Folders.RemoveEmail("folder-1", "email-2"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound removing an email not filed, got %v", err)
+	}
+
+	// Deleting a folder keeps its emails
+	if err := s.Folders.Delete("folder-1"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := s.Folders.ByID("folder-1"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected deleted folder gone, got %v", err)
+	}
+	if filed, _ := s.Folders.Emails("folder-1"); len(filed) != 0 {
+		t.Errorf("Expected no emails left in a deleted folder, got %+v", filed)
+	}
+	if _, err := s.Emails.ByID("email-1"); err != nil {
+		t.Errorf("Expected emails kept when their folder is deleted, got %v", err)
+	}
+	if err := s.Folders.Delete("folder-1"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound deleting twice, got %v", err)
+	}
+}
+
+func testDomains(t *testing.T, s *store.Store, _ *sql.DB) {
+	for _, d := range []store.Domain{
+		{Name: "securesystem.email", SignUp: "open", MaxUsers: 10, TOTPIssuer: "Secure", CreatedAt: now},
+		{Name: "Example.COM", SignUp: "invite", MaxUsers: 2, TOTPIssuer: "Example", CreatedAt: now},
+	} {
+		if err := s.Domains.Create(d); err != nil {
+			t.Fatalf("Create %s failed: %v", d.Name, err)
+		}
+	}
+	if err := s.Domains.Create(store.Domain{Name: "example.com", SignUp: "open", MaxUsers: 1, TOTPIssuer: "Taken"}); !errors.Is(err, store.ErrExists) {
+		t.Errorf("Expected ErrExists for a hosted domain, got %v", err)
+	}
+
+	// Users are counted at their address's domain, ignoring case
+	mustCreateUser(t, s, "alice")
+	if err := s.Users.Create(newUser("bob", "Bob@EXAMPLE.com")); err != nil {
+		t.Fatalf("Creating user failed: %v", err)
+	}
+	got, err := s.Domains.ByName("EXAMPLE.com")
+	if err != nil || got.Name != "example.com" || got.SignUp != "invite" || got.MaxUsers != 2 ||
+		got.TOTPIssuer != "Example" || got.Users != 1 || !got.CreatedAt.Equal(now) {
+		t.Errorf("Unexpected domain %+v, %v", got, err)
+	}
+	if _, err := s.Domains.ByName("missing.example"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for an unknown domain, got %v", err)
+	}
+	list, err := s.Domains.List()
+	if err != nil || len(list) != 2 || list[0].Name != "example.com" || list[1].Name != "securesystem.email" || list[1].Users != 1 {
+		t.Errorf("Expected both domains by name with their users, got %+v, %v", list, err)
+	}
+
+	if err := s.Domains.Update(store.Domain{Name: "example.com", SignUp: "closed", MaxUsers: 5, TOTPIssuer: "Renamed"}); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if got, _ := s.Domains.ByName("example.com"); got.SignUp != "closed" || got.MaxUsers != 5 || got.TOTPIssuer != "Renamed" {
+		t.Errorf("Expected domain updated, got %+v", got)
+	}
+	if err := s.Domains.Update(store.Domain{Name: "missing.example", SignUp: "open", MaxUsers: 1, TOTPIssuer: "x"}); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound updating an unknown domain, got %v", err)
+	}
+
+	// Deleting a domain takes its invites and their redemptions with it
+	if err := s.Invites.Create(store.Invite{ID: "invite-1", CodeHash: "h", Domain: "example.com", MaxUses: 1, CreatedBy: "alice", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}); err != nil {
+		t.Fatalf("Creating invite failed: %v", err)
+	}
+	if err := s.Invites.Redeem("invite-1", "bob", now); err != nil {
+		t.Fatalf("Redeeming invite failed: %v", err)
+	}
+	if err := s.Domains.Delete("example.com"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := s.Domains.ByName("example.com"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected deleted domain gone, got %v", err)
+	}
+	if _, err := s.Invites.ByCodeHash("h"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected the domain's invites deleted, got %v", err)
+	}
+	if err := s.Domains.Delete("example.com"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound deleting twice, got %v", err)
+	}
+}
+
+func testInvites(t *testing.T, s *store.Store, db *sql.DB) {
+	mustCreateUser(t, s, "alice")
+	mustCreateUser(t, s, "bob")
+	mustCreateUser(t, s, "carol")
+	for _, d := range []string{"securesystem.email", "example.com"} {
+		if err := s.Domains.Create(store.Domain{Name: d, SignUp: "invite", MaxUsers: 10, TOTPIssuer: "Secure", CreatedAt: now}); err != nil {
+			t.Fatalf("Creating domain failed: %v", err)
+		}
+	}
+	inv := store.Invite{
+		ID:        "invite-1",
+		CodeHash:  "hash-1",
+		Domain:    "securesystem.email",
+		Email:     "dave@securesystem.email",
+		MaxUses:   2,
+		CreatedBy: "alice",
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Hour),
+	}
+	for _, i := range []store.Invite{
+		inv,
+		{ID: "invite-2", CodeHash: "hash-2", Domain: "example.com", MaxUses: 1, CreatedBy: "alice", CreatedAt: now.Add(time.Minute), ExpiresAt: now.Add(time.Hour)},
+		{ID: "invite-3", CodeHash: "hash-3", Domain: "example.com", MaxUses: 1, CreatedBy: "alice", CreatedAt: now, ExpiresAt: now},
+	} {
+		if err := s.Invites.Create(i); err != nil {
+			t.Fatalf("Create %s failed: %v", i.ID, err)
+		}
+	}
+	if err := s.Invites.Create(store.Invite{ID: "invite-4", CodeHash: "hash-1", Domain: "example.com", MaxUses: 1, CreatedBy: "alice", ExpiresAt: now}); !errors.Is(err, store.ErrExists) {
+		t.Errorf("Expected ErrExists for a taken code hash, got %v", err)
+	}
+	if got, err := s.Invites.ByCodeHash("hash-1"); err != nil || got != inv {
+		t.Errorf("Expected %+v, got %+v, %v", inv, got, err)
+	}
+	if got, err := s.Invites.ByCodeHash("hash-3"); err != nil || got.Email != "" {
+		t.Errorf("Expected an expired invite with no email still found, got %+v, %v", got, err)
+	}
+	if _, err := s.Invites.ByCodeHash("missing"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for an unknown code, got %v", err)
+	}
+
+	if list, err := s.Invites.ListOutstanding("", now); err != nil || len(list) != 2 || list[0].ID != "invite-2" || list[1].ID != "invite-1" {
+		t.Errorf("Expected both outstanding invites, newest first, got %+v, %v", list, err)
+	}
+	if list, err := s.Invites.ListOutstanding("Example.com", now); err != nil || len(list) != 1 || list[0].ID != "invite-2" {
+		t.Errorf("Expected example.com's outstanding invite, got %+v, %v", list, err)
+	}
+
+	// Each use is recorded until the invite is used up
+	for _, user := range []string{"bob", "carol"} {
+		if err := s.Invites.Redeem("invite-1", user, now); err != nil {
+			t.Fatalf("Redeem for %s failed: %v", user, err)
+		}
+	}
+	if err := s.Invites.Redeem("invite-1", "alice", now); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound redeeming a used-up invite, got %v", err)
+	}
+	if err := s.Invites.Redeem("invite-3", "alice", now); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound redeeming an expired invite, got %v", err)
+	}
+	if got, _ := s.Invites.ByCodeHash("hash-1"); got.Uses != 2 {
+		t.Errorf("Expected 2 uses, got %d", got.Uses)
+	}
+	var redeemed int
+	if err := db.QueryRow("SELECT COUNT(*) FROM invite_redemptions WHERE invite_id = 'invite-1'").Scan(&redeemed); err != nil || redeemed != 2 {
+		t.Errorf("Expected 2 redemptions recorded, got %d, %v", redeemed, err)
+	}
+
+	if err := s.Invites.Revoke("invite-2", now); err != nil {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+	if list, _ := s.Invites.ListOutstanding("", now); len(list) != 0 {
+		t.Errorf("Expected no outstanding invites, got %+v", list)
+	}
+	if err := s.Invites.Revoke("invite-2", now); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound revoking twice, got %v", err)
+	}
+}
+
+func testRecovery(t *testing.T, s *store.Store, _ *sql.DB) {
+	mustCreateUser(t, s, "alice")
+	mustCreateUser(t, s, "bob")
+	if err := s.Recovery.Replace("alice", []string{"a1", "a2", "a3"}, now); err != nil {
+		t.Fatalf("Replace failed: %v", err)
+	}
+	if err := s.Recovery.Replace("bob", []string{"b1"}, now); err != nil {
+		t.Fatalf("Replace failed: %v", err)
+	}
+	if err := s.Recovery.Use("alice", "a2", now); err != nil {
+		t.Fatalf("Use failed: %v", err)
+	}
+	for _, tc := range []struct{ user, hash string }{{"alice", "a2"}, {"alice", "b1"}, {"alice", "missing"}} {
+		if err := s.Recovery.Use(tc.user, tc.hash, now); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("Use(%s, %s): expected ErrNotFound, got %v", tc.user, tc.hash, err)
+		}
+	}
+	if n, err := s.Recovery.CountUnused("alice"); err != nil || n != 2 {
+		t.Errorf("Expected 2 unused codes, got %d, %v", n, err)
+	}
+
+	// Replacing drops used and unused codes alike, and only the user's
+	if err := s.Recovery.Replace("alice", []string{"a4", "a2"}, now); err != nil {
+		t.Fatalf("Replace failed: %v", err)
+	}
+	if n, _ := s.Recovery.CountUnused("alice"); n != 2 {
+		t.Errorf("Expected 2 new unused codes, got %d", n)
+	}
+	if err := s.Recovery.Use("alice", "a1", now); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected replaced code gone, got %v", err)
+	}
+	if err := s.Recovery.Use("alice", "a2", now); err != nil {
+		t.Errorf("Expected a reissued code usable, got %v", err)
+	}
+	if n, _ := s.Recovery.CountUnused("bob"); n != 1 {
+		t.Errorf("Expected bob's code kept, got %d", n)
+	}
+}
+
+func testFailures(t *testing.T, s *store.Store, _ *sql.DB) {
+	first := store.LoginFailure{Email: "alice@securesystem.email", Failures: 1, LastAt: now}
+	if err := s.Failures.Create(first); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := s.Failures.Create(first); !errors.Is(err, store.ErrExists) {
+		t.Errorf("Expected ErrExists for a counted address, got %v", err)
+	}
+	if got, err := s.Failures.Get("Alice@SecureSystem.email"); err != nil || got != first {
+		t.Errorf("Expected %+v ignoring case, got %+v, %v", first, got, err)
+	}
+	if _, err := s.Failures.Get("bob@securesystem.email"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for an address without failures, got %v", err)
+	}
+
+	// Replace only succeeds against the count it read
+	second := store.LoginFailure{Email: first.Email, Failures: 2, LastAt: now.Add(time.Minute)}
+	if err := s.Failures.Replace(first, second); err != nil {
+		t.Fatalf("Replace failed: %v", err)
+	}
+	if err := s.Failures.Replace(first, second); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound replacing a stale count, got %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := s.Failures.Release(first.Email); err != nil {
+			t.Fatalf("Release failed: %v", err)
+		}
+	}
+	if got, _ := s.Failures.Get(first.Email); got.Failures != 0 || !got.LastAt.Equal(second.LastAt) {
+		t.Errorf("Expected releases to stop at zero, got %+v", got)
+	}
+
+	if err := s.Failures.Create(store.LoginFailure{Email: "bob@securesystem.email", Failures: 1, LastAt: now.Add(time.Hour)}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if n, err := s.Failures.DeleteBefore(now.Add(time.Minute)); err != nil || n != 1 {
+		t.Errorf("Expected 1 stale count removed, got %d, %v", n, err)
+	}
+	if err := s.Failures.Delete("Bob@securesystem.email"); err != nil {
+		t.Errorf("Delete failed: %v", err)
+	}
+	if err := s.Failures.Delete("bob@securesystem.email"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound deleting twice, got %v", err)
+	}
+}
+
+func testReenroll(t *testing.T, s *store.Store, _ *sql.DB) {
+	mustCreateUser(t, s, "alice")
+	mustCreateUser(t, s, "bob")
+	for _, r := range []store.TOTPReenrollment{
+		{TempID: "temp-old", UserID: "bob", TOTPSecret: "OLD", ExpiresAt: now},
+		{TempID: "temp-1", UserID: "alice", TOTPSecret: "FIRST", ExpiresAt: now.Add(time.Hour)},
+		{TempID: "temp-2", UserID: "alice", TOTPSecret: "SECOND", ExpiresAt: now.Add(time.Hour)},
+	} {
+		if err := s.Reenroll.Start(r, now.Add(-time.Minute)); err != nil {
+			t.Fatalf("Start %s failed: %v", r.TempID, err)
+		}
+	}
+	want := store.TOTPReenrollment{TempID: "temp-2", UserID: "alice", TOTPSecret: "SECOND", ExpiresAt: now.Add(time.Hour)}
+	if got, err := s.Reenroll.Load("temp-2", "alice"); err != nil || got != want {
+		t.Errorf("Expected %+v, got %+v, %v", want, got, err)
+	}
+	for _, tc := range []struct{ tempID, userID string }{{"temp-1", "alice"}, {"temp-2", "bob"}} {
+		if _, err := s.Reenroll.Load(tc.tempID, tc.userID); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("Load(%s, %s): expected ErrNotFound, got %v", tc.tempID, tc.userID, err)
+		}
+	}
+	if _, err := s.Reenroll.Load("temp-old", "bob"); err != nil {
+		t.Errorf("Expected an expired re-enrollment still loaded, got %v", err)
+	}
+
+	// Starting another user's re-enrollment drops the expired ones
+	if err := s.Reenroll.Start(store.TOTPReenrollment{TempID: "temp-3", UserID: "alice", TOTPSecret: "THIRD", ExpiresAt: now.Add(time.Hour)}, now); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if _, err := s.Reenroll.Load("temp-old", "bob"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected expired re-enrollment dropped, got %v", err)
+	}
+	if err := s.Reenroll.DeleteUser("alice"); err != nil {
+		t.Fatalf("DeleteUser failed: %v", err)
+	}
+	if _, err := s.Reenroll.Load("temp-3", "alice"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected re-enrollment deleted, got %v", err)
+	}
+}
+
+func testPasskeys(t *testing.T, s *store.Store, _ *sql.DB) {
+	mustCreateUser(t, s, "alice")
+	mustCreateUser(t, s, "bob")
+	key := store.Passkey{
+		ID:              "cred-1",
+		UserID:          "alice",
+		Name:            "Laptop",
+		PublicKey:       []byte{0x01, 0x02, 0x00, 0xff},
+		AttestationType: "none",
+		AAGUID:          []byte{0xad, 0x00, 0x01},
+		Transports:      "internal,hybrid",
+		SignCount:       4000000000,
+		BackupEligible:  true,
+		CreatedAt:       now,
+	}
+	for _, p := range []store.Passkey{
+		key,
+		{ID: "cred-2", UserID: "alice", Name: "Phone", PublicKey: []byte{0x03}, AttestationType: "none", CreatedAt: now.Add(time.Minute)},
+		{ID: "cred-3", UserID: "bob", Name: "Key", PublicKey: []byte{0x04}, AttestationType: "packed", CreatedAt: now},
+	} {
+		if err := s.Passkeys.Create(p); err != nil {
+			t.Fatalf("Create %s failed: %v", p.ID, err)
+		}
+	}
+	if err := s.Passkeys.Create(store.Passkey{ID: "cred-1", UserID: "bob", Name: "Taken", PublicKey: []byte{0x05}, AttestationType: "none"}); !errors.Is(err, store.ErrExists) {
+		t.Errorf("Expected ErrExists for a registered ID, got %v", err)
+	}
+
+	list, err := s.Passkeys.List("alice")
+	if err != nil || len(list) != 2 || list[0].ID != "cred-1" || list[1].ID != "cred-2" {
+		t.Fatalf("Expected alice's passkeys oldest first, got %+v, %v", list, err)
+	}
+	got := list[0]
+	if got.UserID != key.UserID || got.Name != key.Name || string(got.PublicKey) != string(key.PublicKey) ||
+		got.AttestationType != key.AttestationType || string(got.AAGUID) != string(key.AAGUID) ||
+		got.Transports != key.Transports || got.SignCount != key.SignCount || !got.BackupEligible ||
+		got.BackupState || got.CloneWarning || !got.CreatedAt.Equal(now) || !got.LastUsedAt.IsZero() {
+		t.Errorf("Expected %+v, got %+v", key, got)
+	}
+	if list[1].AAGUID != nil {
+		t.Errorf("Expected no AAGUID, got %x", list[1].AAGUID)
+	}
+
+	if err := s.Passkeys.RecordUse("cred-1", 4000000001, true, now.Add(time.Hour)); err != nil {
+		t.Fatalf("RecordUse failed: %v", err)
+	}
+	if err := s.Passkeys.Flag("cred-2"); err != nil {
+		t.Fatalf("Flag failed: %v", err)
+	}
+	list, _ = s.Passkeys.List("alice")
+	if list[0].SignCount != 4000000001 || !list[0].BackupState || !list[0].LastUsedAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("Expected use recorded, got %+v", list[0])
+	}
+	if !list[1].CloneWarning || list[0].CloneWarning {
+		t.Errorf("Expected only cred-2 flagged, got %+v", list)
+	}
+	if err := s.Passkeys.RecordUse("missing", 1, false, now); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound recording an unknown passkey, got %v", err)
+	}
+
+	if err := s.Passkeys.Delete("bob", "cred-1"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound deleting another user's passkey, got %v", err)
+	}
+	if err := s.Passkeys.Delete("alice", "cred-1"); err != nil {
+		t.Errorf("Delete failed: %v", err)
+	}
+	if list, _ := s.Passkeys.List("alice"); len(list) != 1 {
+		t.Errorf("Expected one passkey left, got %+v", list)
+	}
+}
+
+func testChallenges(t *testing.T, s *store.Store, _ *sql.DB) {
+	mustCreateUser(t, s, "alice")
+	for _, c := range []store.Challenge{
+		{ID: "challenge-old", Ceremony: "login", SessionData: "{}", ExpiresAt: now},
+		{ID: "challenge-1", UserID: "alice", Ceremony: "registration", SessionData: `{"challenge":"abc"}`, ExpiresAt: now.Add(time.Minute)},
+	} {
+		if err := s.Challenges.Save(c, now.Add(-time.Minute)); err != nil {
+			t.Fatalf("Save %s failed: %v", c.ID, err)
+		}
+	}
+	if err := s.Challenges.Save(store.Challenge{ID: "challenge-2", Ceremony: "login", SessionData: "{}", ExpiresAt: now.Add(time.Minute)}, now); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if _, err := s.Challenges.Take("challenge-old", "login"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected expired challenge dropped on save, got %v", err)
+	}
+
+	if _, err := s.Challenges.Take("challenge-1", "login"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for the wrong ceremony, got %v", err)
+	}
+	want := store.Challenge{ID: "challenge-1", UserID: "alice", Ceremony: "registration", SessionData: `{"challenge":"abc"}`, ExpiresAt: now.Add(time.Minute)}
+	if got, err := s.Challenges.Take("challenge-1", "registration"); err != nil || got != want {
+		t.Errorf("Expected %+v, got %+v, %v", want, got, err)
+	}
+	if _, err := s.Challenges.Take("challenge-1", "registration"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected a challenge answered only once, got %v", err)
+	}
+	if got, err := s.Challenges.Take("challenge-2", "login"); err != nil || got.UserID != "" {
+		t.Errorf("Expected a discoverable login challenge, got %+v, %v", got, err)
+	}
+}
+
+func testWithTx(t *testing.T, s *store.Store, db *sql.DB) {
+	// Rolled back changes are gone, including ones that run their own
+	// transaction outside WithTx
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal("Begin failed:", err)
+	}
+	repos := s.WithTx(tx)
+	mustCreateUser(t, repos, "alice")
+	if err := repos.Users.Delete("alice"); err != nil {
+		t.Fatalf("Delete in a transaction failed: %v", err)
+	}
+	mustCreateUser(t, repos, "bob")
+	if err := tx.Rollback(); err != nil {
+		t.Fatal("Rollback failed:", err)
+	}
+	if n, _ := s.Users.Count(); n != 0 {
+		t.Errorf("Expected rolled back users gone, got %d", n)
+	}
+
+	tx, err = db.Begin()
+	if err != nil {
+		t.Fatal("Begin failed:", err)
+	}
+	repos = s.WithTx(tx)
+	mustCreateUser(t, repos, "carol")
+	if err := repos.Sessions.Create(store.Session{ID: "session-1", UserID: "carol", RefreshHash: "hash-1", CreatedAt: now, LastSeenAt: now, ExpiresAt: now.Add(time.Hour)}); err != nil {
+		t.Fatalf("Create session in a transaction failed: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal("Commit failed:", err)
+	}
+	if _, err := s.Sessions.ByID("session-1"); err != nil {
+		t.Errorf("Expected committed session stored, got %v", err)
+	}
+}
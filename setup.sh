@@ -53,12 +53,6 @@ if [ ! -f certs/cert.pem ] || [ ! -f certs/key.pem ]; then
     openssl req -x509 -newkey rsa:4096 -keyout certs/key.pem -out certs/cert.pem -days 365 -nodes -subj "/CN=localhost"
 fi
 
-# Initialize SQLite database
-echo "Initializing SQLite database..."
-if [ ! -f data/secure_email.db ]; then
-    sqlite3 data/secure_email.db < schema.sql
-fi
-
 # Set up environment
 echo "Setting up environment..."
 if [ ! -f .env ]; then
@@ -66,6 +60,10 @@ if [ ! -f .env ]; then
     echo "Please update .env with your configuration"
 fi
 
+# Initialize SQLite database
+echo "Initializing SQLite database..."
+SQLITE_DB=data/secure_email.db go run ./cmd/semadmin migrate up
+
 # Note: Geolocation is handled by HTML5 Geolocation API in the browser
 # and OpenStreetMap Nominatim for reverse geocoding (no setup needed)
 
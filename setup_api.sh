@@ -51,23 +51,22 @@ cd /opt/secure-email-mvp
 echo "Installing Go dependencies..."
 go mod tidy
 
-# Apply database schema
-echo "Setting up database schema..."
-sqlite3 /var/db/secure-email.db < schema/users.sql
-
-# Generate JWT secret if not exists
+# Create .env if not exists
 if [ ! -f .env ]; then
     echo "Creating .env file..."
     cp env.example .env
-    
-    # Generate JWT secret
-    JWT_SECRET=$(openssl rand -base64 32)
-    sed -i "s/your_32_byte_jwt_secret_here_generate_with_openssl_rand_base64_32/$JWT_SECRET/" .env
-    
-    echo "Generated JWT secret and updated .env file"
     echo "Please edit .env file with your Cloudflare R2 credentials"
 fi
 
+# JWT signing keys are generated here by the API on first start
+sudo mkdir -p /var/lib/secure-email/keys
+sudo chown $USER:$USER /var/lib/secure-email/keys
+chmod 700 /var/lib/secure-email/keys
+
+# Apply database migrations (also applied automatically when the API starts)
+echo "Applying database migrations..."
+go run ./cmd/semadmin migrate up
+
 # Create systemd service
 echo "Creating systemd service..."
 sudo tee /etc/systemd/system/secure-email-api.service > /dev/null <<EOF